@@ -16838,6 +16838,7 @@ components: ${componentsToDebugString(components)}
 class Metrika {
   constructor(options) {
     this.baseUrl = options.baseUrl;
+    this.siteKey = options.siteKey;
 
     // Синхронная инициализация
     this.queue = [];
@@ -16854,6 +16855,11 @@ class Metrika {
     this.userId = s.userId;
 
     this.flushInterval = setInterval(() => this.flush(), 5000);
+    // пока вкладка на переднем плане, шлем heartbeat - по ним сервер считает вовлеченное время
+    this.heartbeatInterval = setInterval(() => {
+      if (document.visibilityState === 'visible') this.Track('heartbeat');
+    }, 15000);
+    window.addEventListener('pagehide', () => this.flushBeacon());
     this.setupSPATracking();
     this.trackPageView();
 
@@ -16882,18 +16888,29 @@ class Metrika {
     });
   }
 
+  // Сессию спрашиваем у сервера на каждой инициализации: он вернет текущую
+  // или начнет новую по настройкам домена (таймаут, полночь, смена кампании)
   async getSession() {
     const data = {
       userId: +localStorage.getItem('m_u_id'),
       sessionId: +localStorage.getItem('m_s_id'),
     };
 
-    if (!data.userId || !data.sessionId) {
-      const fp = await this.getFp();
-      console.log(fp);
+    try {
+      let fp = localStorage.getItem('m_fp');
+      if (!fp) {
+        fp = await this.getFp();
+        localStorage.setItem('m_fp', fp);
+      }
+
       const res = await fetch(`${this.baseUrl}/analytics/sessions`, {
         method: 'POST',
-        body: JSON.stringify({ f_id: fp }),
+        body: JSON.stringify({
+          f_id: fp,
+          site_key: this.siteKey,
+          url: window.location.href,
+          referrer: document.referrer,
+        }),
         headers: {
           'Content-Type': 'application/json',
         },
@@ -16902,6 +16919,9 @@ class Metrika {
       data.userId = res.m_u_id;
       localStorage.setItem('m_u_id', data.userId);
       localStorage.setItem('m_s_id', data.sessionId);
+    } catch (e) {
+      // сервер недоступен - продолжаем с сохраненной сессией, если она есть
+      console.warn('Metrika: failed to refresh session', e);
     }
     return data;
   }
@@ -16933,29 +16953,83 @@ class Metrika {
   async flush() {
     if (this.queue.length == 0 || !this.sessionId) return;
 
-    await fetch(`${this.baseUrl}/analytics/events`, {
+    const events = this.queue;
+    this.queue = [];
+
+    const res = await fetch(`${this.baseUrl}/analytics/events`, {
       keepalive: true,
       method: 'POST',
       headers: {
         'Content-Type': 'application/json',
       },
-      body: JSON.stringify({ events: this.queue }),
+      body: JSON.stringify({ site_key: this.siteKey, events: events }),
     });
-    this.queue = [];
+
+    // сервер уже закрыл сессию (таймаут, полночь) - берем новую и переотправляем ивенты в нее
+    if (res.status === 409) {
+      await this.renewSession();
+      this.queue = events.map((e) => ({ ...e, session_id: this.sessionId })).concat(this.queue);
+    }
+  }
+
+  // renewSession - переходит на новую сессию. накопленная запись уходит в старую,
+  // а новая начинается с полного снапшота, иначе ее нельзя будет воспроизвести
+  async renewSession() {
+    const previous = this.sessionId;
+    await this.flushRecord();
+
+    const s = await this.getSession();
+    this.sessionId = s.sessionId;
+    this.userId = s.userId;
+
+    if (this.recording && this.sessionId !== previous) {
+      rrweb.record.takeFullSnapshot(true);
+    }
   }
 
   async flushRecord() {
     if (this.records.length == 0 || !this.sessionId) return;
 
+    const records = this.records;
+    this.records = [];
+
+    // снапшоты rrweb бывают по несколько мегабайт, поэтому жмем их, если браузер умеет
+    const body = JSON.stringify({ site_key: this.siteKey, events: records });
+    const headers = { 'Content-Type': 'application/json' };
+    let payload = body;
+    if (typeof CompressionStream !== 'undefined') {
+      const stream = new Blob([body]).stream().pipeThrough(new CompressionStream('gzip'));
+      payload = await new Response(stream).blob();
+      headers['Content-Encoding'] = 'gzip';
+    }
+
     await fetch(`${this.baseUrl}/analytics/${this.sessionId}/record`, {
       method: 'POST',
-      headers: {
-        'Content-Type': 'application/json',
-      },
-      body: JSON.stringify({ events: this.records }),
+      headers: headers,
+      body: payload,
     });
-    console.log('FLUSH RECORD ');
-    this.records = [];
+  }
+
+  // flushBeacon - отправка остатков при уходе со страницы, fetch в этот момент может не успеть
+  flushBeacon() {
+    if (!this.sessionId || !navigator.sendBeacon) return;
+
+    // строка уходит как text/plain, сервер принимает такой json без preflight
+    if (this.queue.length > 0) {
+      const sent = navigator.sendBeacon(
+        `${this.baseUrl}/analytics/events`,
+        JSON.stringify({ site_key: this.siteKey, events: this.queue }),
+      );
+      if (sent) this.queue = [];
+    }
+
+    if (this.records.length > 0) {
+      const sent = navigator.sendBeacon(
+        `${this.baseUrl}/analytics/${this.sessionId}/record`,
+        JSON.stringify({ site_key: this.siteKey, events: this.records }),
+      );
+      if (sent) this.records = [];
+    }
   }
 
   trackPageView() {
@@ -16963,6 +17037,8 @@ class Metrika {
       referrer: document.referrer,
       title: document.title,
       load_time: performance.now(),
+      // страница могла открыться в фоновой вкладке
+      hidden: document.hidden,
     });
   }
 
@@ -17014,8 +17090,14 @@ const getElementSelector = (element) => {
   return path.join(' > ');
 };
 
+const currentScript = document.currentScript;
+
 const setupListeners = async () => {
-  const mm = new Metrika({ baseUrl: 'http://localhost:8081/api/v1' });
+  const mm = new Metrika({
+    baseUrl: currentScript?.dataset.baseUrl || 'http://localhost:8081/api/v1',
+    // публичный ключ сайта, выдается при добавлении домена
+    siteKey: currentScript?.dataset.siteKey,
+  });
   await mm.init();
 
   mm.startRecording();
@@ -17032,6 +17114,11 @@ const setupListeners = async () => {
       y: e.clientY,
       page_x: e.pageX,
       page_y: e.pageY,
+      // размеры нужны для нормализации координат в тепловой карте
+      viewport_width: window.innerWidth,
+      viewport_height: window.innerHeight,
+      page_width: document.documentElement.scrollWidth,
+      page_height: document.documentElement.scrollHeight,
     });
   });
 
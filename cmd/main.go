@@ -1,20 +1,35 @@
 package main
 
 import (
+	"context"
+	"errors"
+	"io"
 	"log/slog"
 	"metrika/internal/config"
 	"metrika/internal/domain/analytics"
 	"metrika/internal/domain/auth"
 	"metrika/internal/infrastructure/jwt"
+	"metrika/internal/infrastructure/live"
 	"metrika/internal/infrastructure/logger"
 	"metrika/internal/infrastructure/mock"
 	"metrika/internal/infrastructure/postgres"
+	"metrika/internal/infrastructure/pubsub"
+	replaymigrator "metrika/internal/infrastructure/replay_migrator"
 	sessionworker "metrika/internal/infrastructure/session_worker"
 	"metrika/internal/infrastructure/tracker"
 	analhandler "metrika/internal/transport/http/v1/analytics"
 	authhandler "metrika/internal/transport/http/v1/auth"
+	domainshandler "metrika/internal/transport/http/v1/domains"
+	eventshandler "metrika/internal/transport/http/v1/events"
+	funnelshandler "metrika/internal/transport/http/v1/funnels"
+	goalshandler "metrika/internal/transport/http/v1/goals"
+	healthhandler "metrika/internal/transport/http/v1/health"
+	livehandler "metrika/internal/transport/http/v1/live"
+	membershandler "metrika/internal/transport/http/v1/members"
 	methandler "metrika/internal/transport/http/v1/metrika"
 	mid "metrika/internal/transport/http/v1/middleware"
+	privacyhandler "metrika/internal/transport/http/v1/privacy"
+	replayshandler "metrika/internal/transport/http/v1/replays"
 	analuc "metrika/internal/usecase/analytics"
 	authuc "metrika/internal/usecase/auth"
 	"metrika/internal/usecase/metrika"
@@ -22,7 +37,11 @@ import (
 
 	"net/http"
 	"os"
+	"os/signal"
+	"syscall"
 	"time"
+	//база часовых поясов для настроек сессий доменов, в минимальных образах ее может не быть
+	_ "time/tzdata"
 
 	"github.com/go-chi/chi/v5"
 	"github.com/go-chi/chi/v5/middleware"
@@ -38,21 +57,31 @@ type repos struct {
 	guest_sessions analytics.GuestSessionRepository
 	sessions       auth.SessionRepository
 	users          auth.UserRepository
+	members        analytics.MembersRepository
+	goals          analytics.GoalRepository
+	funnels        analytics.FunnelRepository
+	timeseries     analytics.TimeseriesRepository
+	rollups        analytics.RollupRepository
 }
 
 func main() {
 	cfg := config.MustLoad()
 
-	log, _, rotate, err := logger.SetupLogger(cfg.Env, cfg.LogFilePath)
+	log, closeLog, rotate, err := logger.SetupLogger(cfg.Env, cfg.LogFilePath)
 	if err != nil {
 		panic(err)
 	}
+	defer closeLog()
+
+	//контекст отменяется по SIGINT/SIGTERM и запускает graceful shutdown
+	ctx, stop := signal.NotifyContext(context.Background(), syscall.SIGINT, syscall.SIGTERM)
+	defer stop()
 
 	log.Info("starting metrika_server", slog.String("env", cfg.Env))
 
 	log.Debug("debug messages are enabled")
 
-	setupLogRotation(rotate)
+	scheduler := setupLogRotation(rotate)
 
 	log.Info("logs rotation are enabled")
 
@@ -62,13 +91,25 @@ func main() {
 		os.Exit(1)
 	}
 
+	sqlDB, err := db.DB()
+	if err != nil {
+		log.Error("failed to get db pool", sl.Err(err))
+		os.Exit(1)
+	}
+
 	events := postgres.NewEventsRepository(db)
-	record_events := postgres.NewRecordEventRepository(db)
+	record_events := postgres.NewRecordEventRepository(db, cfg.Replay.ChunkSize)
 	guest_sessions := postgres.NewGuestSessionRepository(db)
 	domains := postgres.NewDomainRepository(db)
 	sessions := postgres.NewSessionRepository(db)
 	guests := postgres.NewGuestsRepository(db)
 	users := postgres.NewAuthRepository(db)
+	members := postgres.NewMembersRepository(db)
+	goals := postgres.NewGoalRepository(db)
+	funnels := postgres.NewFunnelRepository(db)
+	timeseries := postgres.NewTimeseriesRepository(db)
+	rollups := postgres.NewRollupRepository(db)
+	retention := postgres.NewRetentionRepository(db)
 	tx := postgres.NewTxManager(db)
 
 	repos := repos{
@@ -79,23 +120,207 @@ func main() {
 		guest_sessions: guest_sessions,
 		users:          users,
 		record_events:  record_events,
+		members:        members,
+		goals:          goals,
+		funnels:        funnels,
+		timeseries:     timeseries,
+		rollups:        rollups,
 	}
 
-	tracker := tracker.New(1000, time.Second*15, 10000, events)
+	goal_evaluator := analuc.NewGoalEvaluator(log, goals)
+
+	persist_events_uc := analuc.NewPersistEventsUseCase(events, guest_sessions, goals, goal_evaluator, tx)
+
+	tracker, err := tracker.New(log, cfg.Tracker, persist_events_uc)
+	if err != nil {
+		log.Error("failed to open tracker spool", sl.Err(err))
+		os.Exit(1)
+	}
 
 	cleanup_stale_sessions_uc := analuc.NewCleanupBatchSessionsUseCase(log, guest_sessions, tx)
 
-	sessions_worker := sessionworker.NewSessionsWorker(log, time.Second*15, cleanup_stale_sessions_uc, make(chan struct{}))
+	sessions_worker := sessionworker.NewSessionsWorker(log, cfg.Sessions.WorkerInterval, cfg.Sessions.WorkerBatch, cleanup_stale_sessions_uc, make(chan struct{}))
 
 	go sessions_worker.StartSessionManager()
 
+	replay_migrator := replaymigrator.NewReplayMigrator(log, cfg.Replay.MigrationInterval, cfg.Replay.MigrationBatch, record_events)
+
+	go replay_migrator.Start()
+
+	bus, err := pubsub.New(log, cfg.PubSub)
+	if err != nil {
+		log.Error("failed to setup pubsub", sl.Err(err))
+		os.Exit(1)
+	}
+
+	live_broker, err := live.NewBroker(log, bus, cfg.PubSub.ChannelPrefix, cfg.Live.SubscriberBuffer)
+	if err != nil {
+		log.Error("failed to subscribe live broker to pubsub", sl.Err(err), slog.String("backend", cfg.PubSub.Backend))
+		os.Exit(1)
+	}
+
+	rollups_uc := analuc.NewRollupsUseCase(log, rollups, tx, cfg.Rollups.Lookback, cfg.Rollups.Batch)
+
+	//роллапы считаются на том же планировщике, что и ротация логов
+	if _, err := scheduler.AddFunc(cfg.Rollups.Schedule, func() {
+		rollups_uc.Run(ctx)
+	}); err != nil {
+		log.Error("bad rollups schedule", sl.Err(err), slog.String("schedule", cfg.Rollups.Schedule))
+		os.Exit(1)
+	}
+
+	retention_uc := analuc.NewRetentionUseCase(log, retention, cfg.Retention.PartitionsAhead, cfg.Retention.Batch)
+
+	if _, err := scheduler.AddFunc(cfg.Retention.Schedule, func() {
+		retention_uc.Run(ctx)
+	}); err != nil {
+		log.Error("bad retention schedule", sl.Err(err), slog.String("schedule", cfg.Retention.Schedule))
+		os.Exit(1)
+	}
+
+	compact_replays_uc := analuc.NewCompactReplaysUseCase(log, record_events, cfg.Replay.CompactionBatch)
+
+	if _, err := scheduler.AddFunc(cfg.Replay.CompactionSchedule, func() {
+		compact_replays_uc.Run(ctx)
+	}); err != nil {
+		log.Error("bad replay compaction schedule", sl.Err(err), slog.String("schedule", cfg.Replay.CompactionSchedule))
+		os.Exit(1)
+	}
+
+	live_feed := analuc.NewLiveFeed(log, live_broker, guest_sessions)
+
+	go live_feed.Run(ctx)
+
 	// setupMockGenerator(log, tracker, cfg, repos)
 
 	log.Info("db connect succesful")
 
 	log.Info("scheduler start succesful")
 
-	setupRouter(cfg, log, tracker, tx, repos)
+	healthHandler := healthhandler.NewHandler(log, sqlDB, metrika.NewIngestionStatsUseCase(tracker))
+
+	srv := setupRouter(cfg, log, tracker, tx, repos, healthHandler, live_feed, goal_evaluator)
+	internalSrv := setupInternalRouter(cfg, healthHandler)
+
+	//srv.Shutdown не прерывает открытые соединения, живые ленты закрываются сами по сигналу фида
+	srv.RegisterOnShutdown(live_feed.Close)
+
+	serverErr := make(chan error, 1)
+	go func() {
+		log.Info("starting server", slog.String("address", srv.Addr))
+
+		if err := srv.ListenAndServe(); err != nil && !errors.Is(err, http.ErrServerClosed) {
+			serverErr <- err
+		}
+		close(serverErr)
+	}()
+
+	//служебный адрес не влияет на прием ивентов, поэтому его ошибка не останавливает процесс
+	go func() {
+		log.Info("starting internal server", slog.String("address", internalSrv.Addr))
+
+		if err := internalSrv.ListenAndServe(); err != nil && !errors.Is(err, http.ErrServerClosed) {
+			log.Error("failed to start internal server", sl.Err(err))
+		}
+	}()
+
+	healthHandler.SetReady(true)
+
+	select {
+	case <-ctx.Done():
+		log.Info("shutdown signal received")
+	case err := <-serverErr:
+		if err != nil {
+			log.Error("failed to start server", sl.Err(err))
+		}
+	}
+
+	healthHandler.SetReady(false)
+
+	shutdownCtx, cancel := context.WithTimeout(context.Background(), cfg.HTTPServer.ShutdownTimeout)
+	defer cancel()
+
+	shutdown(shutdownCtx, log, srv, internalSrv, tracker, sessions_worker, replay_migrator, live_broker, bus, scheduler, sqlDB)
+}
+
+// shutdown - останавливает процесс по порядку: перестаем принимать запросы,
+// сохраняем ивенты из трекера, останавливаем воркеры и только потом закрываем пул соединений с базой.
+// все шаги ограничены общим таймаутом ctx
+func shutdown(
+	ctx context.Context,
+	log *slog.Logger,
+	srv *http.Server,
+	internalSrv *http.Server,
+	tracker *tracker.Tracker,
+	sessions_worker *sessionworker.SessionsWorker,
+	replay_migrator *replaymigrator.ReplayMigrator,
+	live_broker io.Closer,
+	bus io.Closer,
+	scheduler *cron.Cron,
+	db io.Closer,
+) {
+	if err := srv.Shutdown(ctx); err != nil {
+		log.Error("failed to shutdown http server", sl.Err(err))
+	}
+	log.Info("http server stopped")
+
+	if err := internalSrv.Shutdown(ctx); err != nil {
+		log.Error("failed to shutdown internal http server", sl.Err(err))
+	}
+
+	if err := tracker.Close(ctx); err != nil {
+		log.Error("failed to flush tracker", sl.Err(err))
+	}
+	log.Info("tracker flushed", slog.Any("stats", tracker.Stats()))
+
+	if err := sessions_worker.Stop(ctx); err != nil {
+		log.Error("failed to stop sessions worker", sl.Err(err))
+	}
+
+	if err := replay_migrator.Stop(ctx); err != nil {
+		log.Error("failed to stop replay migrator", sl.Err(err))
+	}
+
+	if err := live_broker.Close(); err != nil {
+		log.Error("failed to close live broker", sl.Err(err))
+	}
+
+	if err := bus.Close(); err != nil {
+		log.Error("failed to close pubsub", sl.Err(err))
+	}
+
+	select {
+	case <-scheduler.Stop().Done():
+	case <-ctx.Done():
+		log.Error("failed to stop scheduler", sl.Err(ctx.Err()))
+	}
+	log.Info("workers stopped")
+
+	if err := db.Close(); err != nil {
+		log.Error("failed to close db pool", sl.Err(err))
+	}
+
+	log.Info("server stopped")
+}
+
+// setupInternalRouter - служебный сервер для своей инфраструктуры: пробы и счетчики всего процесса,
+// которые нельзя показывать пользователям api
+func setupInternalRouter(cfg *config.Config, healthHandler *healthhandler.Handler) *http.Server {
+	r := chi.NewRouter()
+
+	r.Use(middleware.Recoverer)
+
+	r.Get("/healthz", healthHandler.Healthz)
+	r.Get("/readyz", healthHandler.Readyz)
+	r.Get("/ingestion/stats", healthHandler.IngestionStats)
+
+	return &http.Server{
+		Addr:         cfg.HTTPServer.InternalAddress,
+		Handler:      r,
+		ReadTimeout:  cfg.HTTPServer.Timeout,
+		WriteTimeout: cfg.HTTPServer.Timeout,
+		IdleTimeout:  cfg.HTTPServer.IdleTimeout,
+	}
 }
 
 func setupMockGenerator(log *slog.Logger, tracker *tracker.Tracker, cfg *config.Config, repos repos) {
@@ -109,7 +334,7 @@ func setupMockGenerator(log *slog.Logger, tracker *tracker.Tracker, cfg *config.
 	go mockService.StartEventsGenerator()
 }
 
-func setupLogRotation(rotate func()) {
+func setupLogRotation(rotate func()) *cron.Cron {
 	//запускаем ротацию логов каждые сутки
 	c := cron.New(cron.WithLocation(time.Local))
 
@@ -118,9 +343,11 @@ func setupLogRotation(rotate func()) {
 	})
 
 	c.Start()
+
+	return c
 }
 
-func setupRouter(cfg *config.Config, log *slog.Logger, tracker *tracker.Tracker, tx *postgres.TxManager, repos repos) {
+func setupRouter(cfg *config.Config, log *slog.Logger, tracker *tracker.Tracker, tx *postgres.TxManager, repos repos, healthHandler *healthhandler.Handler, live_feed *analuc.LiveFeed, goal_evaluator *analuc.GoalEvaluator) *http.Server {
 	r := chi.NewRouter()
 
 	r.Use(middleware.RequestID)
@@ -137,7 +364,7 @@ func setupRouter(cfg *config.Config, log *slog.Logger, tracker *tracker.Tracker,
 	}
 
 	r.Use(cors.Handler(cors.Options{
-		AllowedOrigins: []string{"http://*, https://", "http://localhost:3000", "http://localhost:3001", "http://127.0.0.1:5500"},
+		AllowedOrigins: []string{"http://*", "https://*", "http://localhost:3000", "http://localhost:3001", "http://127.0.0.1:5500"},
 		AllowedMethods: []string{
 			http.MethodHead,
 			http.MethodGet,
@@ -151,13 +378,14 @@ func setupRouter(cfg *config.Config, log *slog.Logger, tracker *tracker.Tracker,
 		Debug:            true,
 	}))
 
-	evuc := analuc.NewCollectEventsUseCase(repos.events, tracker, repos.guest_sessions, tx)
-	recordevuc := analuc.NewCollectRecordEventsUseCase(repos.record_events)
-	getguestse := analuc.NewGetGuestSessionUseCase(repos.guests, repos.guest_sessions, repos.domains, log)
+	evuc := analuc.NewCollectEventsUseCase(tracker, repos.guest_sessions, repos.domains, live_feed)
+	recordMasker := analuc.NewRecordMasker(repos.domains)
+	recordevuc := analuc.NewCollectRecordEventsUseCase(repos.record_events, repos.guest_sessions, repos.domains, recordMasker)
+	getguestse := analuc.NewGetGuestSessionUseCase(repos.guests, repos.guest_sessions, repos.domains, log, live_feed)
 	getGuestsuc := analuc.NewGetGuestsUseCase(repos.guests, log)
 	getGuestuc := analuc.NewGetGuestUseCase(repos.guests, log)
 
-	getRecordEventsUc := analuc.NewGetRecordEventsUseCase(repos.record_events)
+	getRecordEventsUc := analuc.NewGetRecordEventsUseCase(repos.record_events, repos.guest_sessions, repos.members)
 
 	tokens := jwt.NewJwtProvider(cfg.JWTSecret)
 
@@ -169,22 +397,130 @@ func setupRouter(cfg *config.Config, log *slog.Logger, tracker *tracker.Tracker,
 	logoutuc := authuc.NewLogoutUseCase(repos.sessions, log, *jwtProvider)
 	guestSessionsByRangeDateuc := metrika.NewSessionsByRangeDateUseCase(repos.guest_sessions)
 	activeSessionsuc := metrika.NewAciveSessionsUseCase(log, repos.guest_sessions)
-	guestSessionByIntervaluc := metrika.NewSessionsByIntervalUseCase(repos.guest_sessions)
+	timeseriesuc := metrika.NewTimeseriesUseCase(repos.timeseries, repos.domains, repos.rollups)
+	domainRoleuc := metrika.NewGetDomainRoleUseCase(repos.members)
+	pagesReportuc := metrika.NewPagesReportUseCase(repos.events, repos.domains, repos.rollups)
+	sourcesReportuc := metrika.NewSourcesReportUseCase(repos.guest_sessions, repos.domains, repos.rollups)
+	heatmapuc := metrika.NewHeatmapUseCase(repos.events)
+	cohortsuc := metrika.NewCohortsReportUseCase(repos.guests, repos.domains)
+	replayuc := metrika.NewReplayUseCase(repos.record_events, repos.guest_sessions)
+	getMaskingRulesuc := metrika.NewGetMaskingRulesUseCase(repos.domains)
+	updateMaskingRulesuc := metrika.NewUpdateMaskingRulesUseCase(repos.domains, recordMasker)
+	dryRunMaskinguc := metrika.NewDryRunMaskingUseCase(getMaskingRulesuc, repos.record_events, repos.guest_sessions)
+	createDomainuc := metrika.NewCreateDomainUseCase(log, repos.domains, repos.members, tx)
+	getDomainsuc := metrika.NewGetDomainsUseCase(repos.domains)
+	renameDomainuc := metrika.NewRenameDomainUseCase(repos.domains)
+	rotateSiteKeyuc := metrika.NewRotateSiteKeyUseCase(repos.domains)
+	deleteDomainuc := metrika.NewDeleteDomainUseCase(repos.domains, tx)
+	updateSessionSettingsuc := metrika.NewUpdateSessionSettingsUseCase(repos.domains)
+	updateRetentionSettingsuc := metrika.NewUpdateRetentionSettingsUseCase(repos.domains)
+	getMembersuc := metrika.NewGetMembersUseCase(repos.members)
+	inviteMemberuc := metrika.NewInviteMemberUseCase(log, repos.members)
+	acceptInvitationuc := metrika.NewAcceptInvitationUseCase(repos.members, tx)
+	updateMemberRoleuc := metrika.NewUpdateMemberRoleUseCase(repos.members)
+	removeMemberuc := metrika.NewRemoveMemberUseCase(repos.members)
+	revokeInvitationuc := metrika.NewRevokeInvitationUseCase(repos.members)
+	getGoalsuc := metrika.NewGetGoalsUseCase(repos.goals)
+	createGoaluc := metrika.NewCreateGoalUseCase(repos.goals, goal_evaluator)
+	updateGoaluc := metrika.NewUpdateGoalUseCase(repos.goals, goal_evaluator)
+	deleteGoaluc := metrika.NewDeleteGoalUseCase(repos.goals, goal_evaluator, tx)
+	conversionsReportuc := metrika.NewConversionsReportUseCase(repos.goals)
+	getFunnelsuc := metrika.NewGetFunnelsUseCase(repos.funnels)
+	createFunneluc := metrika.NewCreateFunnelUseCase(repos.funnels)
+	updateFunneluc := metrika.NewUpdateFunnelUseCase(repos.funnels)
+	deleteFunneluc := metrika.NewDeleteFunnelUseCase(repos.funnels)
+	funnelReportuc := metrika.NewFunnelReportUseCase(repos.funnels, repos.events)
+	exploreEventsuc := metrika.NewExploreEventsUseCase(repos.events)
+	eventCataloguc := metrika.NewEventCatalogUseCase(repos.events)
 
 	analyticsHandler := analhandler.NewHandler(log, evuc, getguestse, recordevuc, getRecordEventsUc)
 	authorizationHandler := authhandler.NewHandler(log, loginuc, refreshuc, registeruc, logoutuc, jwtProvider)
-	metrikaHandler := methandler.NewHandler(log, guestSessionsByRangeDateuc, activeSessionsuc, guestSessionByIntervaluc, getGuestsuc, getGuestuc)
+	metrikaHandler := methandler.NewHandler(log, guestSessionsByRangeDateuc, activeSessionsuc, timeseriesuc, getGuestsuc, getGuestuc, domainRoleuc, pagesReportuc, sourcesReportuc, heatmapuc, cohortsuc)
+	domainsHandler := domainshandler.NewHandler(log, createDomainuc, getDomainsuc, renameDomainuc, rotateSiteKeyuc, deleteDomainuc, updateSessionSettingsuc, updateRetentionSettingsuc)
+	membersHandler := membershandler.NewHandler(log, cfg.Frontend.AppUrl, getMembersuc, inviteMemberuc, acceptInvitationuc, updateMemberRoleuc, removeMemberuc, revokeInvitationuc)
+	replaysHandler := replayshandler.NewHandler(log, replayuc)
+	privacyHandler := privacyhandler.NewHandler(log, getMaskingRulesuc, updateMaskingRulesuc, dryRunMaskinguc)
+	goalsHandler := goalshandler.NewHandler(log, getGoalsuc, createGoaluc, updateGoaluc, deleteGoaluc, conversionsReportuc)
+	funnelsHandler := funnelshandler.NewHandler(log, getFunnelsuc, createFunneluc, updateFunneluc, deleteFunneluc, funnelReportuc)
+	eventsHandler := eventshandler.NewHandler(log, exploreEventsuc, eventCataloguc)
+	liveHandler := livehandler.NewHandler(log, live_feed, cfg.Live.Heartbeat, jwtProvider, cfg.Frontend.AppUrl)
+
+	viewer := mid.DomainRoleMiddleware(log, domainRoleuc, analytics.RoleViewer)
+	admin := mid.DomainRoleMiddleware(log, domainRoleuc, analytics.RoleAdmin)
+	owner := mid.DomainRoleMiddleware(log, domainRoleuc, analytics.RoleOwner)
+
+	r.Get("/healthz", healthHandler.Healthz)
+	r.Get("/readyz", healthHandler.Readyz)
 
 	r.Route("/api/v1", func(r chi.Router) {
 		r.Group(func(r chi.Router) {
 			r.Use(mid.AuthMiddleware(log, cfg.JWTSecret, *cfg, *jwtProvider))
 			r.Route("/metrika", func(r chi.Router) {
+				r.Route("/domains", func(r chi.Router) {
+					r.Get("/", domainsHandler.GetDomains)
+					r.Post("/", domainsHandler.CreateDomain)
+					r.Route("/{domain_id}", func(r chi.Router) {
+						r.With(admin).Patch("/", domainsHandler.RenameDomain)
+						r.With(admin).Post("/site-key", domainsHandler.RotateSiteKey)
+						r.With(admin).Put("/session-settings", domainsHandler.UpdateSessionSettings)
+						r.With(owner).Put("/retention", domainsHandler.UpdateRetentionSettings)
+						r.With(owner).Delete("/", domainsHandler.DeleteDomain)
+						r.With(admin).Get("/masking", privacyHandler.GetMaskingRules)
+						r.With(admin).Put("/masking", privacyHandler.UpdateMaskingRules)
+						r.With(admin).Post("/masking/dry-run", privacyHandler.DryRun)
+					})
+				})
+				r.Post("/invitations/{token}/accept", membersHandler.AcceptInvitation)
 				r.Get("/guests/{id}", metrikaHandler.GetGuest)
 				r.Route("/{domain_id}", func(r chi.Router) {
-					r.Get("/guests", metrikaHandler.GetGuests)
-					r.Get("/guests/visits", metrikaHandler.GetGuestSessionByRangeDate)
-					r.Get("/guests/byinterval", metrikaHandler.GetGuestSessionsByInterval)
-					r.Get("/guests/online", metrikaHandler.GetCountActiveSessions)
+					r.With(viewer).Get("/guests", metrikaHandler.GetGuests)
+					r.With(viewer).Get("/guests/visits", metrikaHandler.GetGuestSessionByRangeDate)
+					r.With(viewer).Get("/guests/online", metrikaHandler.GetCountActiveSessions)
+					r.With(viewer).Get("/guests/cohorts", metrikaHandler.GetCohorts)
+					r.With(viewer).Get("/timeseries", metrikaHandler.GetTimeseries)
+					r.With(viewer).Get("/pages", metrikaHandler.GetPages)
+					r.With(viewer).Get("/sources", metrikaHandler.GetSources)
+					r.With(viewer).Get("/heatmap", metrikaHandler.GetHeatmap)
+					r.With(viewer).Get("/live", liveHandler.Stream)
+					r.With(viewer).Post("/live/ticket", liveHandler.Ticket)
+					r.With(viewer).Get("/conversions", goalsHandler.GetConversions)
+
+					r.Route("/goals", func(r chi.Router) {
+						r.With(viewer).Get("/", goalsHandler.GetGoals)
+						r.With(admin).Post("/", goalsHandler.CreateGoal)
+						r.With(admin).Put("/{goal_id}", goalsHandler.UpdateGoal)
+						r.With(admin).Delete("/{goal_id}", goalsHandler.DeleteGoal)
+					})
+
+					r.Route("/funnels", func(r chi.Router) {
+						r.With(viewer).Get("/", funnelsHandler.GetFunnels)
+						r.With(admin).Post("/", funnelsHandler.CreateFunnel)
+						r.With(admin).Put("/{funnel_id}", funnelsHandler.UpdateFunnel)
+						r.With(admin).Delete("/{funnel_id}", funnelsHandler.DeleteFunnel)
+						r.With(viewer).Get("/{funnel_id}/report", funnelsHandler.GetReport)
+					})
+
+					r.Route("/events", func(r chi.Router) {
+						r.Use(viewer)
+						r.Post("/explore", eventsHandler.Explore)
+						r.Get("/catalog", eventsHandler.GetCatalog)
+					})
+
+					r.Route("/replays/{session_id}", func(r chi.Router) {
+						r.Use(viewer)
+						r.Get("/", replaysHandler.GetMeta)
+						r.Get("/events", replaysHandler.GetEvents)
+						r.Get("/stream", replaysHandler.Stream)
+					})
+
+					r.Route("/members", func(r chi.Router) {
+						r.Use(admin)
+						r.Get("/", membersHandler.GetMembers)
+						r.Post("/invitations", membersHandler.InviteMember)
+						r.Delete("/invitations/{invitation_id}", membersHandler.RevokeInvitation)
+						r.Patch("/{user_id}", membersHandler.UpdateMemberRole)
+						r.Delete("/{user_id}", membersHandler.RemoveMember)
+					})
 				})
 			})
 		})
@@ -196,22 +532,17 @@ func setupRouter(cfg *config.Config, log *slog.Logger, tracker *tracker.Tracker,
 		})
 
 		r.Route("/analytics", func(r chi.Router) {
-			r.Post("/events", analyticsHandler.AddEvent)
-			r.Post("/{session_id}/record", analyticsHandler.AddRecordEvents)
-			r.Get("/{session_id}/record", analyticsHandler.GetRecordEvents)
+			//MaxIngestionSize в мегабайтах, тело читается потоком, а не целиком в память
+			ingestionBody := mid.IngestionBodyMiddleware(log, int64(cfg.MaxIngestionSize)<<20)
+
+			r.With(ingestionBody).Post("/events", analyticsHandler.AddEvent)
+			r.With(ingestionBody).Post("/{session_id}/record", analyticsHandler.AddRecordEvents)
+			//запись читает только дашборд, поэтому она за авторизацией, в отличие от приема ивентов
+			r.With(mid.AuthMiddleware(log, cfg.JWTSecret, *cfg, *jwtProvider)).Get("/{session_id}/record", analyticsHandler.GetRecordEvents)
 			r.Post("/sessions", analyticsHandler.CreateGuestSession)
 		})
 
 	})
 
-	log.Info("starting server", slog.String("address", srv.Addr))
-
-	if err := srv.ListenAndServe(); err != nil {
-		log.Error("failed to start server", sl.Err(err))
-
-		os.Exit(1)
-	}
-
-	log.Error("server stopped")
-
+	return srv
 }
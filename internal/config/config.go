@@ -14,6 +14,7 @@ type Config struct {
 	LogFilePath               string        `yaml:"log_file_path" env-required:"true" env-default:".logs/apm_server.log" env:"LOG_FILE_PATH"`
 	JWTSecret                 string        `yaml:"jwt_secret" env-required:"true" env:"JWT_SECRET"`
 	MaxRequestSize            int           `yaml:"max_request_size" env-default:"64" env:"MAX_REQUEST_SIZE"`
+	MaxIngestionSize          int           `yaml:"max_ingestion_size" env-default:"4" env:"MAX_INGESTION_SIZE"` //в мегабайтах, для приема ивентов и записей
 	FilesStoragePath          string        `yaml:"files_storage_path" env-default:"./var/uploads" env:"FILES_STORAGE_PATH"`
 	AllowedFilesExtensionsRaw string        `yaml:"allowed_file_extensions" env:"ALLOWED_FILES_EXTENSIONS_RAW"`
 	AllowedFilesExtension     []string      //парсится в MustLoad
@@ -21,6 +22,13 @@ type Config struct {
 	HTTPServer                HTTPServer    `yaml:"http_server"`
 	DBServer                  DBServer      `yaml:"db_server"`
 	MockConfig                MockGenerator `yamp:"mock_generator"`
+	Tracker                   Tracker       `yaml:"tracker"`
+	Replay                    Replay        `yaml:"replay"`
+	Sessions                  Sessions      `yaml:"sessions"`
+	Live                      Live          `yaml:"live"`
+	PubSub                    PubSub        `yaml:"pubsub"`
+	Rollups                   Rollups       `yaml:"rollups"`
+	Retention                 Retention     `yaml:"retention"`
 	// SMTPServer                SMTPServer `yaml:"smtp_server"`
 	Frontend Frontend `yaml:"frontend"`
 }
@@ -29,6 +37,10 @@ type HTTPServer struct {
 	Address     string        `yaml:"address" env-default:"localhost:8080" env:"HTTP_SERVER_ADDRESS"`
 	Timeout     time.Duration `yaml:"timeout" env-default:"4s" env:"HTTP_SERVER_TIMEOUT"`
 	IdleTimeout time.Duration `yaml:"idle_timeout" env-default:"60s" env:"HTTP_SERVER_IDLE_TIMEOUT"`
+	//сколько максимум ждать graceful shutdown: дослать ответы, сохранить ивенты, остановить воркеры
+	ShutdownTimeout time.Duration `yaml:"shutdown_timeout" env-default:"30s" env:"HTTP_SERVER_SHUTDOWN_TIMEOUT"`
+	//служебный адрес с пробами и счетчиками процесса, наружу не публикуется
+	InternalAddress string `yaml:"internal_address" env-default:"127.0.0.1:8082" env:"HTTP_SERVER_INTERNAL_ADDRESS"`
 }
 
 type DBServer struct {
@@ -50,6 +62,79 @@ type MockGenerator struct {
 	MaxMockUsersInDomain int64 `yaml:"max_mock_users_in_domain"  env-default:"100" env:"MAX_MOCK_USERS_IN_DOMAIN"`
 }
 
+type Tracker struct {
+	//директория write-ahead спула ивентов
+	SpoolDir string `yaml:"spool_dir" env-default:"./var/spool/events" env:"TRACKER_SPOOL_DIR"`
+	//сколько ивентов сохраняется в базу одной пачкой
+	BatchSize     int           `yaml:"batch_size" env-default:"1000" env:"TRACKER_BATCH_SIZE"`
+	FlushInterval time.Duration `yaml:"flush_interval" env-default:"5s" env:"TRACKER_FLUSH_INTERVAL"`
+	//максимум принятых, но еще не сохраненных ивентов. при переполнении прием ждет EnqueueTimeout
+	BufferSize     int64         `yaml:"buffer_size" env-default:"100000" env:"TRACKER_BUFFER_SIZE"`
+	EnqueueTimeout time.Duration `yaml:"enqueue_timeout" env-default:"2s" env:"TRACKER_ENQUEUE_TIMEOUT"`
+	//пока хранилище недоступно, пачка повторяется бесконечно, после MaxRetries повторов подряд - с логом уровня error.
+	//в .failed файл откладываются только ивенты, которые хранилище отвергло
+	MaxRetries     int           `yaml:"max_retries" env-default:"20" env:"TRACKER_MAX_RETRIES"`
+	RetryBaseDelay time.Duration `yaml:"retry_base_delay" env-default:"500ms" env:"TRACKER_RETRY_BASE_DELAY"`
+	RetryMaxDelay  time.Duration `yaml:"retry_max_delay" env-default:"1m" env:"TRACKER_RETRY_MAX_DELAY"`
+}
+
+type Replay struct {
+	//сколько rrweb-ивентов сжимается в один чанк
+	ChunkSize int `yaml:"chunk_size" env-default:"500" env:"REPLAY_CHUNK_SIZE"`
+	//перенос старых построчных ивентов в чанки: сколько сессий за проход и пауза между проходами
+	MigrationBatch    int           `yaml:"migration_batch" env-default:"50" env:"REPLAY_MIGRATION_BATCH"`
+	MigrationInterval time.Duration `yaml:"migration_interval" env-default:"10s" env:"REPLAY_MIGRATION_INTERVAL"`
+	//склейка мелких чанков закрытых сессий: расписание в формате cron и сколько сессий за запрос
+	CompactionSchedule string `yaml:"compaction_schedule" env-default:"@every 10m" env:"REPLAY_COMPACTION_SCHEDULE"`
+	CompactionBatch    int    `yaml:"compaction_batch" env-default:"50" env:"REPLAY_COMPACTION_BATCH"`
+}
+
+type Sessions struct {
+	//как часто воркер закрывает неактивные сессии и сколько сессий закрывается за проход.
+	//сам таймаут неактивности настраивается для каждого домена
+	WorkerInterval time.Duration `yaml:"worker_interval" env-default:"15s" env:"SESSIONS_WORKER_INTERVAL"`
+	WorkerBatch    int           `yaml:"worker_batch" env-default:"1000" env:"SESSIONS_WORKER_BATCH"`
+}
+
+type Live struct {
+	//сколько сообщений живой ленты копится для медленного подписчика, дальше выбрасываются самые старые
+	SubscriberBuffer int `yaml:"subscriber_buffer" env-default:"256" env:"LIVE_SUBSCRIBER_BUFFER"`
+	//как часто слать пустое сообщение, чтобы прокси не закрывали простаивающее соединение
+	Heartbeat time.Duration `yaml:"heartbeat" env-default:"15s" env:"LIVE_HEARTBEAT"`
+}
+
+type PubSub struct {
+	//memory - только внутри процесса, redis - общая шина для нескольких инстансов
+	Backend       string `yaml:"backend" env-default:"memory" env:"PUBSUB_BACKEND"`
+	RedisAddr     string `yaml:"redis_addr" env-default:"localhost:6379" env:"PUBSUB_REDIS_ADDR"`
+	RedisPassword string `yaml:"redis_password" env:"PUBSUB_REDIS_PASSWORD"`
+	//префикс каналов, чтобы несколько окружений могли делить один redis
+	ChannelPrefix string        `yaml:"channel_prefix" env-default:"metrika" env:"PUBSUB_CHANNEL_PREFIX"`
+	DialTimeout   time.Duration `yaml:"dial_timeout" env-default:"5s" env:"PUBSUB_DIAL_TIMEOUT"`
+	PingInterval  time.Duration `yaml:"ping_interval" env-default:"30s" env:"PUBSUB_PING_INTERVAL"`
+	//сколько входящих сообщений шины копится до выбрасывания самых старых
+	SubscriberBuffer int `yaml:"subscriber_buffer" env-default:"4096" env:"PUBSUB_SUBSCRIBER_BUFFER"`
+}
+
+type Rollups struct {
+	//расписание прохода воркера роллапов в формате cron
+	Schedule string `yaml:"schedule" env-default:"@every 5m" env:"ROLLUPS_SCHEDULE"`
+	//сколько последних часов пересчитывается каждый проход: туда еще доходят ивенты открытых сессий и пачки из спула.
+	//длительность и отказы сессий, которые идут дольше, в роллапах не обновятся
+	Lookback time.Duration `yaml:"lookback" env-default:"6h" env:"ROLLUPS_LOOKBACK"`
+	//на сколько интервалов(часов или суток) вперед продвигается досчет истории за проход
+	Batch int `yaml:"batch" env-default:"168" env:"ROLLUPS_BATCH"`
+}
+
+type Retention struct {
+	//расписание обслуживания партиций и удаления устаревших данных в формате cron
+	Schedule string `yaml:"schedule" env-default:"@every 1h" env:"RETENTION_SCHEDULE"`
+	//на сколько месяцев вперед заранее создаются партиции
+	PartitionsAhead int `yaml:"partitions_ahead" env-default:"2" env:"RETENTION_PARTITIONS_AHEAD"`
+	//сколько строк удаляется за один запрос при построчной очистке
+	Batch int `yaml:"batch" env-default:"5000" env:"RETENTION_BATCH"`
+}
+
 // type SMTPServer struct {
 //     Host     string `yaml:"host" env-required:"true" env:"SMTP_HOST"`
 //     Port     int    `yaml:"port" env-required:"true" env:"SMTP_PORT"`
@@ -0,0 +1,120 @@
+package analytics
+
+import "time"
+
+// период когорт
+const (
+	CohortByDay   = "day"
+	CohortByWeek  = "week"
+	CohortByMonth = "month"
+)
+
+const (
+	// сколько следующих периодов считать по умолчанию и максимум
+	CohortDefaultPeriods = 12
+	CohortMaxPeriods     = 90
+)
+
+type CohortOptions struct {
+	DomainID uint
+	// в когорту попадают гости с первым визитом в этом интервале
+	StartDate time.Time
+	EndDate   time.Time
+	Period    string
+	// сколько периодов после периода первого визита считать
+	Periods int
+	// часовой пояс, в котором режутся дни, недели и месяцы
+	Timezone string
+}
+
+// AddPeriods - начало периода, отстоящего от start на n периодов
+func (o CohortOptions) AddPeriods(start time.Time, n int) time.Time {
+	switch o.Period {
+	case CohortByWeek:
+		return start.AddDate(0, 0, 7*n)
+	case CohortByMonth:
+		return start.AddDate(0, n, 0)
+	default:
+		return start.AddDate(0, 0, n)
+	}
+}
+
+// CohortCell - сколько гостей когорты были на сайте в периоде с номером Offset, 0 - период первого визита.
+// Start - начало когорты в часовом поясе отчета без зоны, как его возвращает база
+type CohortCell struct {
+	Start  time.Time
+	Offset int
+	Guests int64
+}
+
+type Cohort struct {
+	Start  time.Time `json:"start"`
+	Guests int64     `json:"guests"`
+	// Returning[i] - сколько гостей когорты вернулись в i+1-й период после первого визита.
+	// периоды, которые еще не начались, не включаются
+	Returning []int64   `json:"returning"`
+	Retention []float64 `json:"retention"`
+}
+
+type CohortReport struct {
+	Period   string   `json:"period"`
+	Periods  int      `json:"periods"`
+	Timezone string   `json:"timezone"`
+	Cohorts  []Cohort `json:"cohorts"`
+}
+
+// BuildCohortReport - матрица удержания из ячеек, которые вернула база
+func BuildCohortReport(opts CohortOptions, cells []CohortCell, now time.Time) CohortReport {
+	loc, err := time.LoadLocation(opts.Timezone)
+	if err != nil {
+		loc = time.UTC
+	}
+
+	report := CohortReport{
+		Period:   opts.Period,
+		Periods:  opts.Periods,
+		Timezone: opts.Timezone,
+		Cohorts:  []Cohort{},
+	}
+
+	index := make(map[time.Time]int)
+	for _, cell := range cells {
+		s := cell.Start
+		start := time.Date(s.Year(), s.Month(), s.Day(), s.Hour(), s.Minute(), s.Second(), 0, loc)
+
+		i, ok := index[start]
+		if !ok {
+			available := 0
+			for available < opts.Periods && !opts.AddPeriods(start, available+1).After(now) {
+				available++
+			}
+
+			report.Cohorts = append(report.Cohorts, Cohort{
+				Start:     start,
+				Returning: make([]int64, available),
+				Retention: make([]float64, available),
+			})
+			i = len(report.Cohorts) - 1
+			index[start] = i
+		}
+
+		c := &report.Cohorts[i]
+		if cell.Offset == 0 {
+			c.Guests = cell.Guests
+		} else if cell.Offset <= len(c.Returning) {
+			c.Returning[cell.Offset-1] = cell.Guests
+		}
+	}
+
+	for i := range report.Cohorts {
+		c := &report.Cohorts[i]
+		if c.Guests == 0 {
+			continue
+		}
+		for j, returning := range c.Returning {
+			c.Retention[j] = float64(returning) / float64(c.Guests)
+		}
+	}
+
+	return report
+}
@@ -1,6 +1,66 @@
 package analytics
 
+import (
+	"crypto/rand"
+	"encoding/hex"
+	"net/url"
+	"strings"
+	"time"
+)
+
 type Domain struct {
-	ID uint
-	SiteURL string
-}
\ No newline at end of file
+	ID        uint      `json:"id"`
+	OwnerID   uint      `json:"owner_id"`
+	Name      string    `json:"name"`
+	SiteURL   string    `json:"site_url"`
+	SiteKey   string    `json:"site_key"`
+	CreatedAt time.Time `json:"created_at"`
+
+	SessionSettings SessionSettings   `json:"session_settings"`
+	Retention       RetentionSettings `json:"retention"`
+}
+
+// GenerateSiteKey - генерирует публичный ключ сайта, который вставляется в mm.js
+func GenerateSiteKey() (string, error) {
+	return randomHex(16)
+}
+
+func randomHex(n int) (string, error) {
+	buf := make([]byte, n)
+	if _, err := rand.Read(buf); err != nil {
+		return "", err
+	}
+	return hex.EncodeToString(buf), nil
+}
+
+// Host - хост сайта без схемы, порта и www
+func (d Domain) Host() string {
+	return normalizeHost(d.SiteURL)
+}
+
+// AllowsOrigin - проверяет, что запрос пришел с сайта домена(по Origin или Referer)
+func (d Domain) AllowsOrigin(origin string) bool {
+	host := normalizeHost(origin)
+	if host == "" {
+		return false
+	}
+	return host == d.Host()
+}
+
+func normalizeHost(raw string) string {
+	raw = strings.TrimSpace(raw)
+	if raw == "" {
+		return ""
+	}
+	//site_url может быть сохранен без схемы
+	if !strings.Contains(raw, "://") {
+		raw = "http://" + raw
+	}
+
+	u, err := url.Parse(raw)
+	if err != nil {
+		return ""
+	}
+
+	return strings.TrimPrefix(strings.ToLower(u.Hostname()), "www.")
+}
@@ -0,0 +1,86 @@
+package analytics
+
+import (
+	"sort"
+	"time"
+)
+
+// типы ивентов, по которым считается вовлеченность
+const (
+	EventHeartbeat        = "heartbeat"
+	EventVisibilityChange = "visibility_change"
+)
+
+// EngagementMaxGap - больше этого промежутка между ивентами вкладка считается брошенной,
+// даже если она видима. mm.js шлет heartbeat раз в 15 секунд, пока вкладка на переднем плане
+const EngagementMaxGap = 30 * time.Second
+
+// EngagementState - состояние подсчета вовлеченности сессии между пачками ивентов
+type EngagementState struct {
+	//время последнего учтенного ивента
+	LastEventAt *time.Time
+	//вкладка на переднем плане после последнего ивента
+	Visible bool
+	//id текущего pageview, на который записывается время
+	PageviewID uint
+}
+
+// EngagementResult - сколько вовлеченного времени добавить сессии и ее просмотрам страниц
+type EngagementResult struct {
+	State     EngagementState
+	Engaged   time.Duration
+	Pageviews map[uint]time.Duration
+}
+
+// CalculateEngagement - время на переднем плане: промежутки между соседними ивентами сессии,
+// пока вкладка видима, но не больше EngagementMaxGap каждый. скрытие вкладки(visibility_change)
+// останавливает счет до следующего ивента с видимой вкладкой.
+// ивенты старее уже учтенных пропускаются, пачка должна быть одной сессии и с проставленными id
+func CalculateEngagement(state EngagementState, events []Event) EngagementResult {
+	res := EngagementResult{Pageviews: make(map[uint]time.Duration)}
+
+	sorted := make([]Event, len(events))
+	copy(sorted, events)
+	sort.SliceStable(sorted, func(i, j int) bool {
+		if sorted[i].Timestamp.Equal(sorted[j].Timestamp) {
+			return sorted[i].ID < sorted[j].ID
+		}
+		return sorted[i].Timestamp.Before(sorted[j].Timestamp)
+	})
+
+	for _, e := range sorted {
+		if state.LastEventAt != nil {
+			if e.Timestamp.Before(*state.LastEventAt) {
+				continue
+			}
+			if state.Visible {
+				gap := min(e.Timestamp.Sub(*state.LastEventAt), EngagementMaxGap)
+				res.Engaged += gap
+				if state.PageviewID != 0 {
+					res.Pageviews[state.PageviewID] += gap
+				}
+			}
+		}
+
+		ts := e.Timestamp
+		state.LastEventAt = &ts
+		state.Visible = eventVisible(e)
+		if e.Type == "pageview" {
+			state.PageviewID = e.ID
+		}
+	}
+
+	res.State = state
+	return res
+}
+
+// eventVisible - была ли вкладка видима после ивента. любое действие гостя означает видимую вкладку,
+// если клиент явно не прислал hidden
+func eventVisible(e Event) bool {
+	if e.Type == EventVisibilityChange {
+		state, _ := e.Data["state"].(string)
+		return state == "visible"
+	}
+	hidden, _ := e.Data["hidden"].(bool)
+	return !hidden
+}
@@ -3,14 +3,42 @@ package analytics
 import "errors"
 
 var (
-	ErrDomainNotFound            = errors.New("domain not found")
-	ErrDomainAlreadyExists       = errors.New("domain already exists")
-	ErrStaleSessionsNotFound     = errors.New("stale sessions not found")
-	ErrLastActiveSessionNotFound = errors.New("last active session not found")
-	ErrSessionsNotFound          = errors.New("sessions not found")
-	ErrSessionInvalid            = errors.New("session invalid")
-	ErrRecordEventsNotFound      = errors.New("record events not found")
-	ErrGuestsNotFound            = errors.New("guests not found")
-	ErrGuestNotFound             = errors.New("guest not found")
-	ErrFindGuestsOrderNotAllowed = errors.New("invalid order")
+	ErrDomainNotFound             = errors.New("domain not found")
+	ErrDomainAlreadyExists        = errors.New("domain already exists")
+	ErrOriginNotAllowed           = errors.New("origin not allowed")
+	ErrDomainAccessDenied         = errors.New("domain access denied")
+	ErrIngestionUnavailable       = errors.New("ingestion unavailable")
+	ErrMemberNotFound             = errors.New("member not found")
+	ErrMemberAlreadyExists        = errors.New("member already exists")
+	ErrOwnerRoleImmutable         = errors.New("owner role can not be changed")
+	ErrInvalidRole                = errors.New("invalid role")
+	ErrInvitationNotFound         = errors.New("invitation not found")
+	ErrInvitationExpired          = errors.New("invitation expired")
+	ErrInvitationEmailMismatch    = errors.New("invitation was sent to another email")
+	ErrStaleSessionsNotFound      = errors.New("stale sessions not found")
+	ErrLastActiveSessionNotFound  = errors.New("last active session not found")
+	ErrSessionsNotFound           = errors.New("sessions not found")
+	ErrSessionInvalid             = errors.New("session invalid")
+	ErrSessionClosed              = errors.New("session closed")
+	ErrRecordEventsNotFound       = errors.New("record events not found")
+	ErrReplayCursorInvalid        = errors.New("invalid replay cursor")
+	ErrInvalidMaskingRules        = errors.New("invalid masking rules")
+	ErrInvalidSessionSettings     = errors.New("invalid session settings")
+	ErrInvalidRetentionSettings   = errors.New("invalid retention settings")
+	ErrGuestsNotFound             = errors.New("guests not found")
+	ErrGuestNotFound              = errors.New("guest not found")
+	ErrFindGuestsOrderNotAllowed  = errors.New("invalid order")
+	ErrFindPagesOrderNotAllowed   = errors.New("invalid pages order")
+	ErrFindSourcesOrderNotAllowed = errors.New("invalid sources order")
+	ErrSourcesGroupNotAllowed     = errors.New("invalid sources group")
+	ErrOnlineBreakdownNotAllowed  = errors.New("invalid online breakdown")
+	ErrInvalidGoal                = errors.New("invalid goal")
+	ErrGoalNotFound               = errors.New("goal not found")
+	ErrConversionsIntervalInvalid = errors.New("invalid conversions interval")
+	ErrInvalidFunnel              = errors.New("invalid funnel")
+	ErrFunnelNotFound             = errors.New("funnel not found")
+	ErrCohortPeriodNotAllowed     = errors.New("invalid cohort period")
+	ErrInvalidExplorerQuery       = errors.New("invalid explorer query")
+	ErrInvalidTimeseriesQuery     = errors.New("invalid timeseries query")
+	ErrRollupGranularityInvalid   = errors.New("invalid rollup granularity")
 )
@@ -0,0 +1,349 @@
+package analytics
+
+import (
+	"fmt"
+	"sort"
+	"strings"
+	"time"
+)
+
+// операторы фильтра по свойствам в обозревателе ивентов
+const (
+	FilterEq       = "eq"
+	FilterNeq      = "neq"
+	FilterIn       = "in"
+	FilterContains = "contains"
+	FilterGt       = "gt"
+	FilterGte      = "gte"
+	FilterLt       = "lt"
+	FilterLte      = "lte"
+	FilterExists   = "exists"
+)
+
+// интервалы рядов обозревателя
+const (
+	ExplorerByHour = "hour"
+	ExplorerByDay  = "day"
+	ExplorerByWeek = "week"
+)
+
+const (
+	ExplorerMaxGroupBy = 2
+	ExplorerMaxFilters = 10
+	// сколько групп отдавать по умолчанию и максимум, группы выбираются по числу ивентов за период
+	ExplorerDefaultGroups = 10
+	ExplorerMaxGroups     = 50
+	// ограничение на число точек в ряду
+	ExplorerMaxBuckets = 1000
+	// значение группы, если у ивента нет свойства
+	ExplorerNone = "(none)"
+	// каталог домена ограничен, чтобы ивенты со случайными ключами не раздували его.
+	// типы и свойства сверх лимита и слишком длинные ключи в каталог не попадают
+	CatalogMaxKeys      = 1000
+	CatalogMaxKeyLength = 128
+)
+
+// PropertyFilter - условие на свойство data. Value - значение из json запроса:
+// для eq и neq строка, число или bool, для in - массив, для gt/gte/lt/lte - число
+type PropertyFilter struct {
+	Property string `json:"property"`
+	Op       string `json:"op"`
+	Value    any    `json:"value,omitempty"`
+}
+
+type ExplorerOptions struct {
+	DomainID  uint
+	EventType string
+	Filters   []PropertyFilter
+	GroupBy   []string
+	Interval  string
+	StartDate time.Time
+	EndDate   time.Time
+	Groups    int
+}
+
+func (o ExplorerOptions) Validate() error {
+	if strings.TrimSpace(o.EventType) == "" {
+		return fmt.Errorf("%w: empty event_type", ErrInvalidExplorerQuery)
+	}
+
+	if len(o.GroupBy) > ExplorerMaxGroupBy {
+		return fmt.Errorf("%w: at most %d group_by keys", ErrInvalidExplorerQuery, ExplorerMaxGroupBy)
+	}
+	for _, key := range o.GroupBy {
+		if key == "" {
+			return fmt.Errorf("%w: empty group_by key", ErrInvalidExplorerQuery)
+		}
+	}
+
+	if len(o.Filters) > ExplorerMaxFilters {
+		return fmt.Errorf("%w: at most %d filters", ErrInvalidExplorerQuery, ExplorerMaxFilters)
+	}
+	for _, f := range o.Filters {
+		if err := f.validate(); err != nil {
+			return err
+		}
+	}
+
+	var step time.Duration
+	switch o.Interval {
+	case ExplorerByHour:
+		step = time.Hour
+	case ExplorerByDay:
+		step = 24 * time.Hour
+	case ExplorerByWeek:
+		step = 7 * 24 * time.Hour
+	default:
+		return fmt.Errorf("%w: unknown interval %q", ErrInvalidExplorerQuery, o.Interval)
+	}
+	if o.EndDate.Sub(o.StartDate)/step > ExplorerMaxBuckets {
+		return fmt.Errorf("%w: too many %s buckets in range, max %d", ErrInvalidExplorerQuery, o.Interval, ExplorerMaxBuckets)
+	}
+
+	return nil
+}
+
+func (f PropertyFilter) validate() error {
+	if f.Property == "" {
+		return fmt.Errorf("%w: empty filter property", ErrInvalidExplorerQuery)
+	}
+
+	switch f.Op {
+	case FilterExists:
+	case FilterEq, FilterNeq:
+		if !isScalar(f.Value) {
+			return fmt.Errorf("%w: %s filter on %q needs a scalar value", ErrInvalidExplorerQuery, f.Op, f.Property)
+		}
+	case FilterIn:
+		values, ok := f.Value.([]any)
+		if !ok || len(values) == 0 {
+			return fmt.Errorf("%w: in filter on %q needs a non-empty array", ErrInvalidExplorerQuery, f.Property)
+		}
+		for _, v := range values {
+			if !isScalar(v) {
+				return fmt.Errorf("%w: in filter on %q needs scalar values", ErrInvalidExplorerQuery, f.Property)
+			}
+		}
+	case FilterContains:
+		if _, ok := f.Value.(string); !ok {
+			return fmt.Errorf("%w: contains filter on %q needs a string", ErrInvalidExplorerQuery, f.Property)
+		}
+	case FilterGt, FilterGte, FilterLt, FilterLte:
+		if _, ok := f.Value.(float64); !ok {
+			return fmt.Errorf("%w: %s filter on %q needs a number", ErrInvalidExplorerQuery, f.Op, f.Property)
+		}
+	default:
+		return fmt.Errorf("%w: unknown filter op %q", ErrInvalidExplorerQuery, f.Op)
+	}
+
+	return nil
+}
+
+func isScalar(v any) bool {
+	switch v.(type) {
+	case string, float64, bool:
+		return true
+	}
+	return false
+}
+
+// ExplorerRow - число ивентов группы в интервале
+type ExplorerRow struct {
+	Bucket time.Time
+	Group1 string
+	Group2 string
+	Count  int64
+}
+
+type ExplorerPoint struct {
+	Time  time.Time `json:"time"`
+	Count int64     `json:"count"`
+}
+
+type ExplorerSeries struct {
+	// значения свойств из group_by по порядку, пустой массив без группировки
+	Group  []string        `json:"group"`
+	Total  int64           `json:"total"`
+	Points []ExplorerPoint `json:"points"`
+}
+
+type ExplorerResult struct {
+	EventType string           `json:"event_type"`
+	GroupBy   []string         `json:"group_by"`
+	Interval  string           `json:"interval"`
+	Series    []ExplorerSeries `json:"series"`
+}
+
+// BuildExplorerResult - собирает строки базы в ряды по группам, самые крупные группы первыми
+func BuildExplorerResult(opts ExplorerOptions, rows []ExplorerRow) ExplorerResult {
+	result := ExplorerResult{
+		EventType: opts.EventType,
+		GroupBy:   opts.GroupBy,
+		Interval:  opts.Interval,
+		Series:    []ExplorerSeries{},
+	}
+	if result.GroupBy == nil {
+		result.GroupBy = []string{}
+	}
+
+	index := make(map[[2]string]int)
+	for _, row := range rows {
+		key := [2]string{row.Group1, row.Group2}
+		i, ok := index[key]
+		if !ok {
+			group := []string{row.Group1, row.Group2}[:len(opts.GroupBy)]
+			result.Series = append(result.Series, ExplorerSeries{Group: group, Points: []ExplorerPoint{}})
+			i = len(result.Series) - 1
+			index[key] = i
+		}
+
+		s := &result.Series[i]
+		s.Total += row.Count
+		s.Points = append(s.Points, ExplorerPoint{Time: row.Bucket, Count: row.Count})
+	}
+
+	sort.SliceStable(result.Series, func(i, j int) bool {
+		return result.Series[i].Total > result.Series[j].Total
+	})
+
+	return result
+}
+
+// EventCatalogEntry - тип ивента или его свойство, встречавшиеся в домене.
+// у записи самого типа Property пустой
+type EventCatalogEntry struct {
+	DomainID  uint      `json:"-"`
+	EventType string    `json:"event_type"`
+	Property  string    `json:"property,omitempty"`
+	ValueType string    `json:"value_type,omitempty"`
+	Events    int64     `json:"events"`
+	FirstSeen time.Time `json:"first_seen"`
+	LastSeen  time.Time `json:"last_seen"`
+}
+
+type EventCatalogProperty struct {
+	Property  string    `json:"property"`
+	ValueType string    `json:"value_type"`
+	Events    int64     `json:"events"`
+	FirstSeen time.Time `json:"first_seen"`
+	LastSeen  time.Time `json:"last_seen"`
+}
+
+type EventCatalogType struct {
+	EventType  string                 `json:"event_type"`
+	Events     int64                  `json:"events"`
+	FirstSeen  time.Time              `json:"first_seen"`
+	LastSeen   time.Time              `json:"last_seen"`
+	Properties []EventCatalogProperty `json:"properties"`
+}
+
+// CatalogFromEvents - записи каталога по пачке ивентов. domains - домен каждой сессии пачки, ключи длиннее CatalogMaxKeyLength пропускаются.
+// записи отсортированы, чтобы параллельные пачки обновляли строки каталога в одном порядке
+func CatalogFromEvents(domains map[uint]uint, events []Event) []EventCatalogEntry {
+	type key struct {
+		domainID  uint
+		eventType string
+		property  string
+	}
+	entries := make(map[key]*EventCatalogEntry)
+
+	add := func(k key, valueType string, at time.Time) {
+		entry, ok := entries[k]
+		if !ok {
+			entry = &EventCatalogEntry{DomainID: k.domainID, EventType: k.eventType, Property: k.property, FirstSeen: at, LastSeen: at}
+			entries[k] = entry
+		}
+		entry.Events++
+		entry.ValueType = valueType
+		if at.Before(entry.FirstSeen) {
+			entry.FirstSeen = at
+		}
+		if at.After(entry.LastSeen) {
+			entry.LastSeen = at
+		}
+	}
+
+	for _, e := range events {
+		domain_id, ok := domains[e.SessionID]
+		if !ok || e.Type == "" || len(e.Type) > CatalogMaxKeyLength {
+			continue
+		}
+
+		add(key{domain_id, e.Type, ""}, "", e.Timestamp)
+		for property, value := range e.Data {
+			if len(property) > CatalogMaxKeyLength {
+				continue
+			}
+			add(key{domain_id, e.Type, property}, jsonValueType(value), e.Timestamp)
+		}
+	}
+
+	catalog := make([]EventCatalogEntry, 0, len(entries))
+	for _, entry := range entries {
+		catalog = append(catalog, *entry)
+	}
+	sort.Slice(catalog, func(i, j int) bool {
+		a, b := catalog[i], catalog[j]
+		if a.DomainID != b.DomainID {
+			return a.DomainID < b.DomainID
+		}
+		if a.EventType != b.EventType {
+			return a.EventType < b.EventType
+		}
+		return a.Property < b.Property
+	})
+
+	return catalog
+}
+
+// BuildEventCatalog - каталог домена сгруппированный по типам ивентов
+func BuildEventCatalog(entries []EventCatalogEntry) []EventCatalogType {
+	catalog := []EventCatalogType{}
+	index := make(map[string]int)
+
+	typeOf := func(eventType string) *EventCatalogType {
+		i, ok := index[eventType]
+		if !ok {
+			catalog = append(catalog, EventCatalogType{EventType: eventType, Properties: []EventCatalogProperty{}})
+			i = len(catalog) - 1
+			index[eventType] = i
+		}
+		return &catalog[i]
+	}
+
+	for _, entry := range entries {
+		t := typeOf(entry.EventType)
+		if entry.Property == "" {
+			t.Events, t.FirstSeen, t.LastSeen = entry.Events, entry.FirstSeen, entry.LastSeen
+			continue
+		}
+		t.Properties = append(t.Properties, EventCatalogProperty{
+			Property:  entry.Property,
+			ValueType: entry.ValueType,
+			Events:    entry.Events,
+			FirstSeen: entry.FirstSeen,
+			LastSeen:  entry.LastSeen,
+		})
+	}
+
+	return catalog
+}
+
+// jsonValueType - тип значения в терминах jsonb_typeof
+func jsonValueType(v any) string {
+	switch v.(type) {
+	case nil:
+		return "null"
+	case string:
+		return "string"
+	case bool:
+		return "boolean"
+	case float64, float32, int, int64, int32, uint, uint64, uint32:
+		return "number"
+	case []any:
+		return "array"
+	case map[string]any:
+		return "object"
+	}
+	return "string"
+}
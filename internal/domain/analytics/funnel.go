@@ -0,0 +1,196 @@
+package analytics
+
+import (
+	"fmt"
+	"regexp"
+	"strings"
+	"time"
+)
+
+type FunnelScope string
+
+const (
+	// все шаги должны быть пройдены в одной сессии
+	FunnelScopeSession FunnelScope = "session"
+	// шаги могут быть пройдены гостем в разных сессиях
+	FunnelScopeGuest FunnelScope = "guest"
+)
+
+type FunnelStepType string
+
+const (
+	// просмотр страницы, путь которой подходит под шаблон
+	FunnelStepPage FunnelStepType = "page"
+	// ивент заданного типа с условиями на свойства
+	FunnelStepEvent FunnelStepType = "event"
+)
+
+const (
+	FunnelMinSteps = 2
+	FunnelMaxSteps = 10
+	// окно конверсии по умолчанию и максимальное, в минутах
+	FunnelDefaultWindow = 24 * 60
+	FunnelMaxWindow     = 90 * 24 * 60
+)
+
+type FunnelStep struct {
+	Name string         `json:"name"`
+	Type FunnelStepType `json:"type"`
+	// шаблон пути страницы, * - любая последовательность символов
+	URLPattern string            `json:"url_pattern,omitempty"`
+	EventType  string            `json:"event_type,omitempty"`
+	Properties []PropertyMatcher `json:"properties,omitempty"`
+}
+
+// Funnel - упорядоченные шаги. шаг засчитывается, если после предыдущего шага и не позже
+// WindowMinutes от первого шага был подходящий ивент
+type Funnel struct {
+	ID            uint         `json:"id"`
+	DomainID      uint         `json:"domain_id"`
+	Name          string       `json:"name"`
+	Scope         FunnelScope  `json:"scope"`
+	WindowMinutes int          `json:"window_minutes"`
+	Steps         []FunnelStep `json:"steps"`
+	CreatedAt     time.Time    `json:"created_at"`
+}
+
+func (f Funnel) Window() time.Duration {
+	return time.Duration(f.WindowMinutes) * time.Minute
+}
+
+func (f Funnel) Validate() error {
+	if strings.TrimSpace(f.Name) == "" {
+		return fmt.Errorf("%w: empty name", ErrInvalidFunnel)
+	}
+
+	if f.Scope != FunnelScopeSession && f.Scope != FunnelScopeGuest {
+		return fmt.Errorf("%w: unknown scope %q", ErrInvalidFunnel, f.Scope)
+	}
+
+	if f.WindowMinutes < 1 || f.WindowMinutes > FunnelMaxWindow {
+		return fmt.Errorf("%w: window_minutes must be between 1 and %d", ErrInvalidFunnel, FunnelMaxWindow)
+	}
+
+	if len(f.Steps) < FunnelMinSteps || len(f.Steps) > FunnelMaxSteps {
+		return fmt.Errorf("%w: funnel must have from %d to %d steps", ErrInvalidFunnel, FunnelMinSteps, FunnelMaxSteps)
+	}
+
+	for i, s := range f.Steps {
+		switch s.Type {
+		case FunnelStepPage:
+			if strings.TrimSpace(s.URLPattern) == "" {
+				return fmt.Errorf("%w: step %d: empty url_pattern", ErrInvalidFunnel, i+1)
+			}
+		case FunnelStepEvent:
+			if strings.TrimSpace(s.EventType) == "" {
+				return fmt.Errorf("%w: step %d: empty event_type", ErrInvalidFunnel, i+1)
+			}
+		default:
+			return fmt.Errorf("%w: step %d: unknown type %q", ErrInvalidFunnel, i+1, s.Type)
+		}
+
+		for _, p := range s.Properties {
+			if p.Property == "" {
+				return fmt.Errorf("%w: step %d: empty property", ErrInvalidFunnel, i+1)
+			}
+			switch p.Op {
+			case PropertyEquals, PropertyNotEquals, PropertyContains, PropertyExists:
+			case PropertyRegex:
+				if _, err := regexp.Compile(p.Value); err != nil {
+					return fmt.Errorf("%w: step %d: property %q: %v", ErrInvalidFunnel, i+1, p.Property, err)
+				}
+			default:
+				return fmt.Errorf("%w: step %d: unknown op %q", ErrInvalidFunnel, i+1, p.Op)
+			}
+		}
+	}
+
+	return nil
+}
+
+type FunnelQueryOptions struct {
+	Funnel    Funnel
+	StartDate time.Time
+	EndDate   time.Time
+}
+
+// FunnelStepCount - сколько сессий или гостей дошли до шага и медиана времени от предыдущего шага
+type FunnelStepCount struct {
+	Step          int
+	Count         int64
+	MedianSeconds *float64
+}
+
+type FunnelStepResult struct {
+	Step int    `json:"step"`
+	Name string `json:"name"`
+	// сколько сессий или гостей дошли до шага
+	Count int64 `json:"count"`
+	// доля от первого шага
+	ConversionRate float64 `json:"conversion_rate"`
+	// доля от предыдущего шага
+	StepConversionRate float64 `json:"step_conversion_rate"`
+	// сколько не дошли до шага с предыдущего
+	DropOff     int64   `json:"drop_off"`
+	DropOffRate float64 `json:"drop_off_rate"`
+	// медиана времени от предыдущего шага в секундах, у первого шага null
+	MedianSecondsFromPrevious *float64 `json:"median_seconds_from_previous"`
+}
+
+type FunnelReport struct {
+	Funnel    Funnel             `json:"funnel"`
+	StartDate time.Time          `json:"start_date"`
+	EndDate   time.Time          `json:"end_date"`
+	Steps     []FunnelStepResult `json:"steps"`
+}
+
+// BuildFunnelReport - считает доли и отвал по количествам на шагах.
+// шаги, до которых никто не дошел, в counts может не быть
+func BuildFunnelReport(opts FunnelQueryOptions, counts []FunnelStepCount) FunnelReport {
+	byStep := make(map[int]FunnelStepCount, len(counts))
+	for _, c := range counts {
+		byStep[c.Step] = c
+	}
+
+	report := FunnelReport{
+		Funnel:    opts.Funnel,
+		StartDate: opts.StartDate,
+		EndDate:   opts.EndDate,
+		Steps:     make([]FunnelStepResult, 0, len(opts.Funnel.Steps)),
+	}
+
+	var first, prev int64
+	for i, s := range opts.Funnel.Steps {
+		c := byStep[i+1]
+		res := FunnelStepResult{
+			Step:  i + 1,
+			Name:  s.Name,
+			Count: c.Count,
+		}
+		if res.Name == "" {
+			res.Name = s.URLPattern + s.EventType
+		}
+
+		if i == 0 {
+			first = c.Count
+			if c.Count > 0 {
+				res.ConversionRate, res.StepConversionRate = 1, 1
+			}
+		} else {
+			res.MedianSecondsFromPrevious = c.MedianSeconds
+			res.DropOff = prev - c.Count
+			if first > 0 {
+				res.ConversionRate = float64(c.Count) / float64(first)
+			}
+			if prev > 0 {
+				res.StepConversionRate = float64(c.Count) / float64(prev)
+				res.DropOffRate = float64(res.DropOff) / float64(prev)
+			}
+		}
+
+		prev = c.Count
+		report.Steps = append(report.Steps, res)
+	}
+
+	return report
+}
@@ -0,0 +1,300 @@
+package analytics
+
+import (
+	"fmt"
+	"regexp"
+	"strings"
+	"time"
+)
+
+type GoalType string
+
+const (
+	// просмотр страницы, путь которой подходит под шаблон
+	GoalPageview GoalType = "pageview"
+	// ивент произвольного типа, присланный клиентом
+	GoalEvent GoalType = "event"
+	// клик по элементу, подходящему под css-селектор, или по его потомку
+	GoalClick GoalType = "click"
+	// отправка формы с заданным id
+	GoalFormSubmit GoalType = "form_submit"
+)
+
+// операторы сравнения свойства ивента
+const (
+	PropertyEquals    = "equals"
+	PropertyNotEquals = "not_equals"
+	PropertyContains  = "contains"
+	PropertyRegex     = "regex"
+	PropertyExists    = "exists"
+)
+
+// PropertyMatcher - условие на поле data ивента
+type PropertyMatcher struct {
+	Property string `json:"property"`
+	Op       string `json:"op"`
+	Value    string `json:"value,omitempty"`
+}
+
+// Goal - цель домена. какое из полей URLPattern, EventType, Selector, FormID используется,
+// зависит от типа, условия на свойства дополнительно проверяются для любого типа
+type Goal struct {
+	ID       uint     `json:"id"`
+	DomainID uint     `json:"domain_id"`
+	Name     string   `json:"name"`
+	Type     GoalType `json:"type"`
+	// шаблон пути страницы, * - любая последовательность символов
+	URLPattern string            `json:"url_pattern,omitempty"`
+	EventType  string            `json:"event_type,omitempty"`
+	Selector   string            `json:"selector,omitempty"`
+	FormID     string            `json:"form_id,omitempty"`
+	Properties []PropertyMatcher `json:"properties,omitempty"`
+	CreatedAt  time.Time         `json:"created_at"`
+}
+
+// Conversion - достижение цели в сессии, засчитывается один раз на сессию
+type Conversion struct {
+	GoalID    uint
+	SessionID uint
+	EventID   uint
+	Timestamp time.Time
+}
+
+// GoalMatcher - скомпилированная цель
+type GoalMatcher struct {
+	goal       Goal
+	path       *regexp.Regexp
+	selector   maskSelector
+	properties []propertyMatcher
+}
+
+type propertyMatcher struct {
+	PropertyMatcher
+	re *regexp.Regexp
+}
+
+// Compile - проверяет цель и готовит ее к сопоставлению с ивентами
+func (g Goal) Compile() (*GoalMatcher, error) {
+	if strings.TrimSpace(g.Name) == "" {
+		return nil, fmt.Errorf("%w: empty name", ErrInvalidGoal)
+	}
+
+	m := &GoalMatcher{goal: g}
+
+	switch g.Type {
+	case GoalPageview:
+		if strings.TrimSpace(g.URLPattern) == "" {
+			return nil, fmt.Errorf("%w: empty url_pattern", ErrInvalidGoal)
+		}
+		m.path = globToRegexp(NormalizePagePath(g.URLPattern))
+	case GoalEvent:
+		if strings.TrimSpace(g.EventType) == "" {
+			return nil, fmt.Errorf("%w: empty event_type", ErrInvalidGoal)
+		}
+	case GoalClick:
+		sel, err := parseMaskSelector(g.Selector)
+		if err != nil {
+			return nil, fmt.Errorf("%w: selector %q: %v", ErrInvalidGoal, g.Selector, err)
+		}
+		m.selector = sel
+	case GoalFormSubmit:
+		if strings.TrimSpace(g.FormID) == "" {
+			return nil, fmt.Errorf("%w: empty form_id", ErrInvalidGoal)
+		}
+	default:
+		return nil, fmt.Errorf("%w: unknown type %q", ErrInvalidGoal, g.Type)
+	}
+
+	for _, p := range g.Properties {
+		if p.Property == "" {
+			return nil, fmt.Errorf("%w: empty property", ErrInvalidGoal)
+		}
+		pm := propertyMatcher{PropertyMatcher: p}
+		switch p.Op {
+		case PropertyEquals, PropertyNotEquals, PropertyContains, PropertyExists:
+		case PropertyRegex:
+			re, err := regexp.Compile(p.Value)
+			if err != nil {
+				return nil, fmt.Errorf("%w: property %q: %v", ErrInvalidGoal, p.Property, err)
+			}
+			pm.re = re
+		default:
+			return nil, fmt.Errorf("%w: unknown op %q", ErrInvalidGoal, p.Op)
+		}
+		m.properties = append(m.properties, pm)
+	}
+
+	return m, nil
+}
+
+func (m *GoalMatcher) Goal() Goal {
+	return m.goal
+}
+
+// Match - ивент засчитывается как достижение цели
+func (m *GoalMatcher) Match(e Event) bool {
+	switch m.goal.Type {
+	case GoalPageview:
+		if e.Type != "pageview" || !m.path.MatchString(NormalizePagePath(e.PageURL)) {
+			return false
+		}
+	case GoalEvent:
+		if e.Type != m.goal.EventType {
+			return false
+		}
+	case GoalClick:
+		if e.Type != "click" || !m.matchSelector(e) {
+			return false
+		}
+	case GoalFormSubmit:
+		formID, _ := e.Data["form_id"].(string)
+		if e.Type != "form_submit" || formID != m.goal.FormID {
+			return false
+		}
+	}
+
+	for _, p := range m.properties {
+		if !p.match(e.Data) {
+			return false
+		}
+	}
+
+	return true
+}
+
+// matchSelector - клиент присылает путь до элемента вида "div.a > button.b:nth-child(2)" или "#id".
+// цель срабатывает, если селектор подходит самому элементу или одному из его предков, как element.closest
+func (m *GoalMatcher) matchSelector(e Event) bool {
+	raw, _ := e.Data["selector"].(string)
+	if raw == "" {
+		raw = e.Element
+	}
+	if raw == "" {
+		return false
+	}
+	if raw == m.selector.raw {
+		return true
+	}
+
+	path := parseClickPath(raw)
+	for i := len(path) - 1; i >= 0; i-- {
+		if m.selector.match(path[i], path[:i]) {
+			return true
+		}
+	}
+
+	return false
+}
+
+func (p propertyMatcher) match(data map[string]any) bool {
+	raw, ok := data[p.Property]
+	if p.Op == PropertyExists {
+		return ok && raw != nil
+	}
+
+	var value string
+	if ok && raw != nil {
+		value = fmt.Sprint(raw)
+	}
+
+	switch p.Op {
+	case PropertyEquals:
+		return ok && value == p.Value
+	case PropertyNotEquals:
+		return value != p.Value
+	case PropertyContains:
+		return ok && strings.Contains(value, p.Value)
+	case PropertyRegex:
+		return ok && p.re.MatchString(value)
+	}
+
+	return false
+}
+
+// parseClickPath - элементы пути из селектора клика, от корня к самому элементу
+func parseClickPath(raw string) []maskElement {
+	segments := strings.Split(raw, ">")
+	path := make([]maskElement, 0, len(segments))
+
+	for _, s := range segments {
+		s = strings.TrimSpace(s)
+		if i := strings.IndexByte(s, ':'); i >= 0 {
+			s = s[:i]
+		}
+
+		el := maskElement{attrs: make(map[string]string)}
+		if id, ok := strings.CutPrefix(s, "#"); ok {
+			el.attrs["id"] = id
+		} else {
+			tag, classes, _ := strings.Cut(s, ".")
+			el.tag = strings.ToLower(tag)
+			if classes != "" {
+				el.attrs["class"] = strings.ReplaceAll(classes, ".", " ")
+			}
+		}
+		path = append(path, el)
+	}
+
+	return path
+}
+
+// globToRegexp - шаблон пути, где * матчит любые символы, в том числе /
+func globToRegexp(pattern string) *regexp.Regexp {
+	parts := strings.Split(pattern, "*")
+	for i, part := range parts {
+		parts[i] = regexp.QuoteMeta(part)
+	}
+	return regexp.MustCompile("^" + strings.Join(parts, ".*") + "$")
+}
+
+// интервалы графика конверсий
+const (
+	ConversionsByHour = "hour"
+	ConversionsByDay  = "day"
+	ConversionsByWeek = "week"
+)
+
+type ConversionsReportOptions struct {
+	DomainID uint
+	// nil - все цели домена, сессия считается сконвертированной при достижении любой
+	GoalID    *uint
+	StartDate *time.Time
+	EndDate   *time.Time
+	Interval  string
+	// группировка атрибуции, см. SourcesGroupBy*
+	GroupBy string
+	Limit   *int
+}
+
+type GoalConversions struct {
+	GoalID         uint     `json:"goal_id"`
+	Name           string   `json:"name"`
+	Type           GoalType `json:"type"`
+	Conversions    int64    `json:"conversions"`
+	ConversionRate float64  `json:"conversion_rate"`
+}
+
+type ConversionPoint struct {
+	Time           time.Time `json:"time"`
+	Sessions       int64     `json:"sessions"`
+	Conversions    int64     `json:"conversions"`
+	ConversionRate float64   `json:"conversion_rate"`
+}
+
+type ConversionSource struct {
+	Source         string  `json:"source"`
+	Sessions       int64   `json:"sessions"`
+	Conversions    int64   `json:"conversions"`
+	ConversionRate float64 `json:"conversion_rate"`
+}
+
+// ConversionsReport - конверсии за период. конверсия - сессия, в которой цель была достигнута,
+// доля считается от всех сессий домена, начатых за период
+type ConversionsReport struct {
+	Sessions       int64              `json:"sessions"`
+	Conversions    int64              `json:"conversions"`
+	ConversionRate float64            `json:"conversion_rate"`
+	Goals          []GoalConversions  `json:"goals"`
+	Timeline       []ConversionPoint  `json:"timeline"`
+	Sources        []ConversionSource `json:"sources"`
+}
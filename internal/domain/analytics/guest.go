@@ -7,6 +7,7 @@ type Guest struct {
 	DomainID           uint      `json:"domain_id"`
 	Fingerprint        string    `json:"f_id"`
 	TotalSecondsOnSite string    `json:"total_seconds_on_site"`
+	EngagedSeconds     float64   `json:"engaged_seconds"`
 	FirstVisit         time.Time `json:"first_visit"`
 	LastVisit          time.Time `json:"last_visit"`
 	IsOnline           bool      `json:"is_online"`
@@ -9,10 +9,10 @@ type GuestSession struct {
 	Active     bool       `json:"active"`
 	EndTime    *time.Time `json:"end_time"`
 	LastActive time.Time  `json:"last_active"`
+	//класс устройства по User-Agent и страна из заголовка прокси, определяются при создании сессии
+	Device  DeviceClass `json:"device"`
+	Country string      `json:"country"`
+	//время с вкладкой на переднем плане, считается по heartbeat и visibility_change
+	EngagedSeconds float64 `json:"engaged_seconds"`
+	SessionSource
 }
-
-type GuestSessionsByTimeBucket struct {
-	TimeBucket time.Time `json:"time_bucket"`
-	Visits     int       `json:"visits"`
-	Uniques    int       `json:"uniques"`
-}
\ No newline at end of file
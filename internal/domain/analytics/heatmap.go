@@ -0,0 +1,71 @@
+package analytics
+
+import "time"
+
+type DeviceClass string
+
+const (
+	DeviceMobile  DeviceClass = "mobile"
+	DeviceTablet  DeviceClass = "tablet"
+	DeviceDesktop DeviceClass = "desktop"
+)
+
+// границы классов устройств по ширине вьюпорта
+const (
+	tabletMinWidth  = 768
+	desktopMinWidth = 1024
+)
+
+func (d DeviceClass) Valid() bool {
+	switch d {
+	case DeviceMobile, DeviceTablet, DeviceDesktop:
+		return true
+	}
+	return false
+}
+
+// WidthRange - диапазон ширины вьюпорта класса устройства, max == 0 значит без верхней границы
+func (d DeviceClass) WidthRange() (min int, max int) {
+	switch d {
+	case DeviceMobile:
+		return 0, tabletMinWidth - 1
+	case DeviceTablet:
+		return tabletMinWidth, desktopMinWidth - 1
+	default:
+		return desktopMinWidth, 0
+	}
+}
+
+type HeatmapOptions struct {
+	DomainID  uint
+	PagePath  string
+	StartDate *time.Time
+	EndDate   *time.Time
+	MinWidth  *int
+	MaxWidth  *int
+	//кол-во ячеек сетки по каждой оси
+	GridSize int
+	//сколько самых кликабельных селекторов отдавать
+	SelectorsLimit int
+}
+
+// HeatmapCell - ячейка сетки: x по ширине вьюпорта, y по высоте всей страницы
+type HeatmapCell struct {
+	X      int   `json:"x"`
+	Y      int   `json:"y"`
+	Clicks int64 `json:"clicks"`
+}
+
+type SelectorClicks struct {
+	Selector string `json:"selector"`
+	Clicks   int64  `json:"clicks"`
+}
+
+type Heatmap struct {
+	PagePath string `json:"page_path"`
+	GridSize int    `json:"grid_size"`
+	//все клики по странице, в т.ч. без размеров вьюпорта, которые не попали в сетку
+	TotalClicks int64            `json:"total_clicks"`
+	Cells       []HeatmapCell    `json:"cells"`
+	Selectors   []SelectorClicks `json:"selectors"`
+}
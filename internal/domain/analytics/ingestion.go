@@ -0,0 +1,11 @@
+package analytics
+
+// IngestionStats - счетчики приема ивентов с момента запуска
+type IngestionStats struct {
+	Accepted  int64 `json:"accepted"`
+	Persisted int64 `json:"persisted"`
+	Retried   int64 `json:"retried"`
+	Dropped   int64 `json:"dropped"`
+	// принято, но еще не сохранено в базу
+	Pending int64 `json:"pending"`
+}
@@ -0,0 +1,69 @@
+package analytics
+
+import "time"
+
+// типы сообщений живой ленты дашборда
+const (
+	LiveOnline    = "online"
+	LiveSession   = "session"
+	LivePageviews = "pageviews"
+	LiveEvent     = "event"
+	//подписчик не успевал читать и часть сообщений была выброшена
+	LiveDropped = "dropped"
+)
+
+type LiveMessage struct {
+	Type     string    `json:"type"`
+	DomainID uint      `json:"domain_id"`
+	At       time.Time `json:"at"`
+	Data     any       `json:"data"`
+}
+
+// LiveOnlineData - Online - незакрытые сессии из базы, Active - сессии, от которых ивенты или heartbeat
+// приходили за последнюю минуту на любой инстанс
+type LiveOnlineData struct {
+	Online int64 `json:"online"`
+	Active int64 `json:"active"`
+}
+
+type LivePageviewsData struct {
+	PerSecond int64 `json:"per_second"`
+}
+
+type LiveDroppedData struct {
+	Dropped uint64 `json:"dropped"`
+}
+
+type LiveEventData struct {
+	SessionID uint      `json:"session_id"`
+	Type      string    `json:"type"`
+	PageURL   string    `json:"page_url"`
+	Element   string    `json:"element,omitempty"`
+	Timestamp time.Time `json:"timestamp"`
+}
+
+// LiveSubscription - подписка на ленту домена. при переполнении буфера выбрасываются самые старые сообщения
+type LiveSubscription interface {
+	Messages() <-chan LiveMessage
+	Dropped() uint64
+	Close()
+}
+
+// LiveBroker - доставка ленты подписчикам всех инстансов
+type LiveBroker interface {
+	//сообщение для подписчиков домена на всех инстансах
+	Publish(domain_id uint, msg LiveMessage)
+	//сообщение только для подписчиков этого инстанса
+	Deliver(domain_id uint, msg LiveMessage)
+	//приращение счетчика, суммируется по всем инстансам
+	Count(domain_id uint, counter string, delta int64)
+	//накопленные с прошлого вызова суммы счетчика по доменам
+	TakeCounter(counter string) map[uint]int64
+	//отметка активности сессий домена, доходит до всех инстансов
+	Touch(domain_id uint, session_ids []uint)
+	//сколько сессий домена отмечались после since на любом инстансе
+	Active(domain_id uint, since time.Time) int64
+	Subscribe(domain_id uint) LiveSubscription
+	//домены, у которых сейчас есть подписчики
+	Domains() []uint
+}
@@ -0,0 +1,359 @@
+package analytics
+
+import (
+	"fmt"
+	"regexp"
+	"strings"
+	"unicode"
+)
+
+// типы узлов и источники инкрементальных ивентов rrweb, которые нужны маскированию
+const (
+	RecordIncrementalSnapshot = 3
+
+	rrwebNodeElement = 2
+	rrwebNodeText    = 3
+
+	rrwebSourceMutation = 0
+	rrwebSourceInput    = 5
+)
+
+// встроенные паттерны, в правилах указываются по имени
+const (
+	MaskPatternEmail = "email"
+	MaskPatternPhone = "phone"
+	MaskPatternCard  = "card"
+)
+
+var builtinMaskPatterns = map[string]*regexp.Regexp{
+	MaskPatternEmail: regexp.MustCompile(`[A-Za-z0-9._%+-]+@[A-Za-z0-9.-]+\.[A-Za-z]{2,}`),
+	MaskPatternPhone: regexp.MustCompile(`(?:\+\d{1,3}[\s.-]?)?\(?\d{3}\)?[\s.-]?\d{3}[\s.-]?\d{2}[\s.-]?\d{2}\b`),
+	MaskPatternCard:  regexp.MustCompile(`\b(?:\d[ -]?){12,18}\d\b`),
+}
+
+// MaskingRules - что вырезать из записей сессий домена до сохранения
+type MaskingRules struct {
+	//css-селекторы: тег, #id, .class, [attr], [attr=value] и их сочетания через пробел.
+	//все тексты и значения внутри подходящего элемента маскируются
+	Selectors []string `json:"selectors"`
+	//типы input, значения которых маскируются всегда: password, email, tel...
+	InputTypes []string `json:"input_types"`
+	//регулярки или имена встроенных паттернов(email, phone, card), применяются ко всем текстам и вводу
+	Patterns []string `json:"patterns"`
+}
+
+// DefaultMaskingRules - правила для доменов, которые свои еще не настроили
+func DefaultMaskingRules() MaskingRules {
+	return MaskingRules{
+		Selectors:  []string{},
+		InputTypes: []string{"password", "email", "tel"},
+		Patterns:   []string{MaskPatternEmail, MaskPatternPhone, MaskPatternCard},
+	}
+}
+
+// причина маски, когда снапшот страницы не проходил через состояние маскирования
+const maskReasonUnknownState = "unknown snapshot"
+
+// MaskFinding - что и почему было замаскировано, для dry-run
+type MaskFinding struct {
+	EventIndex int    `json:"event_index"`
+	NodeID     int    `json:"node_id,omitempty"`
+	Reason     string `json:"reason"`
+}
+
+// MaskState - какие узлы страницы замаскированы. инкрементальные ивенты ссылаются на узлы по id,
+// поэтому состояние живет между запросами одной сессии и сбрасывается на полном снапшоте.
+// пока полный снапшот не пройден через это состояние(например, после рестарта или на другом инстансе),
+// неизвестно, какие узлы замаскированы, и все тексты и ввод маскируются целиком
+type MaskState struct {
+	masked map[int]bool
+	known  bool
+}
+
+func NewMaskState() *MaskState {
+	return &MaskState{masked: make(map[int]bool)}
+}
+
+type namedPattern struct {
+	name string
+	re   *regexp.Regexp
+}
+
+// Masker - скомпилированные правила маскирования
+type Masker struct {
+	selectors  []maskSelector
+	inputTypes map[string]bool
+	patterns   []namedPattern
+}
+
+// Compile - проверяет правила и готовит их к применению
+func (r MaskingRules) Compile() (*Masker, error) {
+	m := &Masker{inputTypes: make(map[string]bool)}
+
+	for _, raw := range r.Selectors {
+		for _, part := range strings.Split(raw, ",") {
+			sel, err := parseMaskSelector(part)
+			if err != nil {
+				return nil, fmt.Errorf("%w: selector %q: %v", ErrInvalidMaskingRules, part, err)
+			}
+			m.selectors = append(m.selectors, sel)
+		}
+	}
+
+	for _, t := range r.InputTypes {
+		if t = strings.ToLower(strings.TrimSpace(t)); t != "" {
+			m.inputTypes[t] = true
+		}
+	}
+
+	for _, p := range r.Patterns {
+		if re, ok := builtinMaskPatterns[p]; ok {
+			m.patterns = append(m.patterns, namedPattern{p, re})
+			continue
+		}
+		re, err := regexp.Compile(p)
+		if err != nil {
+			return nil, fmt.Errorf("%w: pattern %q: %v", ErrInvalidMaskingRules, p, err)
+		}
+		m.patterns = append(m.patterns, namedPattern{p, re})
+	}
+
+	return m, nil
+}
+
+// Apply - маскирует ивенты на месте и возвращает, что было замаскировано
+func (m *Masker) Apply(events []RecordEvent, state *MaskState) []MaskFinding {
+	var findings []MaskFinding
+
+	for i := range events {
+		report := func(nodeID int, reason string) {
+			findings = append(findings, MaskFinding{EventIndex: i, NodeID: nodeID, Reason: reason})
+		}
+
+		data := events[i].Data
+		switch events[i].Type {
+		case RecordFullSnapshot:
+			//новый снапшот - новая страница, старые id узлов больше не встретятся
+			clear(state.masked)
+			state.known = true
+			if node, ok := data["node"].(map[string]any); ok {
+				m.walk(node, nil, "", state, report)
+			}
+		case RecordIncrementalSnapshot:
+			switch jsonInt(data["source"]) {
+			case rrwebSourceMutation:
+				m.applyMutation(data, state, report)
+			case rrwebSourceInput:
+				id := jsonInt(data["id"])
+				if text, ok := data["text"].(string); ok {
+					data["text"] = m.maskValue(text, id, state, report)
+				}
+			}
+		}
+	}
+
+	return findings
+}
+
+func (m *Masker) applyMutation(data map[string]any, state *MaskState, report func(int, string)) {
+	for _, item := range jsonList(data["removes"]) {
+		delete(state.masked, jsonInt(item["id"]))
+	}
+
+	//для добавленных узлов предки неизвестны, поэтому селекторы с потомками
+	//срабатывают только через унаследованную маску родителя
+	for _, item := range jsonList(data["adds"]) {
+		node, ok := item["node"].(map[string]any)
+		if !ok {
+			continue
+		}
+		inherited := ""
+		if state.masked[jsonInt(item["parentId"])] {
+			inherited = "masked parent"
+		} else if !state.known {
+			inherited = maskReasonUnknownState
+		}
+		m.walk(node, nil, inherited, state, report)
+	}
+
+	for _, item := range jsonList(data["texts"]) {
+		if value, ok := item["value"].(string); ok {
+			item["value"] = m.maskValue(value, jsonInt(item["id"]), state, report)
+		}
+	}
+
+	for _, item := range jsonList(data["attributes"]) {
+		attrs, ok := item["attributes"].(map[string]any)
+		if !ok {
+			continue
+		}
+		if value, ok := attrs["value"].(string); ok {
+			attrs["value"] = m.maskValue(value, jsonInt(item["id"]), state, report)
+		}
+	}
+}
+
+// maskValue - значение узла целиком, если узел замаскирован, иначе только совпадения паттернов
+func (m *Masker) maskValue(value string, nodeID int, state *MaskState, report func(int, string)) string {
+	if state.masked[nodeID] || !state.known {
+		if value != "" {
+			reason := "masked node"
+			if !state.masked[nodeID] {
+				reason = maskReasonUnknownState
+			}
+			report(nodeID, reason)
+		}
+		return maskText(value)
+	}
+	return m.maskPatterns(value, nodeID, report)
+}
+
+func (m *Masker) maskPatterns(value string, nodeID int, report func(int, string)) string {
+	for _, p := range m.patterns {
+		masked := p.re.ReplaceAllStringFunc(value, func(match string) string {
+			if p.name == MaskPatternCard && !luhnValid(match) {
+				return match
+			}
+			return maskText(match)
+		})
+		if masked != value {
+			report(nodeID, "pattern:"+p.name)
+			value = masked
+		}
+	}
+	return value
+}
+
+// walk - обход сериализованного rrweb дерева. inherited - причина маски, унаследованная от предка
+func (m *Masker) walk(node map[string]any, ancestors []maskElement, inherited string, state *MaskState, report func(int, string)) {
+	id := jsonInt(node["id"])
+
+	switch jsonInt(node["type"]) {
+	case rrwebNodeElement:
+		el := newMaskElement(node)
+
+		reason := inherited
+		if reason == "" {
+			for _, sel := range m.selectors {
+				if sel.match(el, ancestors) {
+					reason = "selector:" + sel.raw
+					break
+				}
+			}
+		}
+
+		attrs, _ := node["attributes"].(map[string]any)
+		if reason == "" && (el.tag == "input" || el.tag == "textarea") {
+			inputType := strings.ToLower(el.attrs["type"])
+			if inputType == "" {
+				inputType = "text"
+			}
+			if m.inputTypes[inputType] {
+				state.masked[id] = true
+				if value, ok := attrs["value"].(string); ok && value != "" {
+					attrs["value"] = maskText(value)
+					report(id, "input_type:"+inputType)
+				}
+			}
+		}
+
+		if reason != "" {
+			state.masked[id] = true
+			for _, attr := range []string{"value", "placeholder", "title", "alt"} {
+				if value, ok := attrs[attr].(string); ok && value != "" {
+					attrs[attr] = maskText(value)
+					report(id, reason)
+				}
+			}
+		} else if value, ok := attrs["value"].(string); ok {
+			attrs["value"] = m.maskPatterns(value, id, report)
+		}
+
+		ancestors = append(ancestors, el)
+		for _, child := range jsonList(node["childNodes"]) {
+			m.walk(child, ancestors, reason, state, report)
+		}
+
+	case rrwebNodeText:
+		text, ok := node["textContent"].(string)
+		if !ok {
+			return
+		}
+		if inherited != "" {
+			state.masked[id] = true
+			if strings.TrimSpace(text) != "" {
+				node["textContent"] = maskText(text)
+				report(id, inherited)
+			}
+			return
+		}
+		//css и скрипты не трогаем, паттерны телефонов там ловят числа
+		if isStyle, _ := node["isStyle"].(bool); isStyle {
+			return
+		}
+		if len(ancestors) > 0 {
+			if tag := ancestors[len(ancestors)-1].tag; tag == "style" || tag == "script" {
+				return
+			}
+		}
+		node["textContent"] = m.maskPatterns(text, id, report)
+
+	default:
+		for _, child := range jsonList(node["childNodes"]) {
+			m.walk(child, ancestors, inherited, state, report)
+		}
+	}
+}
+
+// maskText - заменяет все символы кроме пробельных на *, длина текста сохраняется
+func maskText(s string) string {
+	return strings.Map(func(r rune) rune {
+		if unicode.IsSpace(r) {
+			return r
+		}
+		return '*'
+	}, s)
+}
+
+func luhnValid(s string) bool {
+	sum, n := 0, 0
+	for i := len(s) - 1; i >= 0; i-- {
+		c := s[i]
+		if c < '0' || c > '9' {
+			continue
+		}
+		d := int(c - '0')
+		if n%2 == 1 {
+			d *= 2
+			if d > 9 {
+				d -= 9
+			}
+		}
+		sum += d
+		n++
+	}
+	return n >= 13 && sum%10 == 0
+}
+
+func jsonInt(v any) int {
+	switch n := v.(type) {
+	case float64:
+		return int(n)
+	case int:
+		return n
+	case int64:
+		return int(n)
+	}
+	return 0
+}
+
+func jsonList(v any) []map[string]any {
+	items, _ := v.([]any)
+	list := make([]map[string]any, 0, len(items))
+	for _, item := range items {
+		if m, ok := item.(map[string]any); ok {
+			list = append(list, m)
+		}
+	}
+	return list
+}
@@ -0,0 +1,188 @@
+package analytics
+
+import (
+	"errors"
+	"fmt"
+	"strings"
+)
+
+// maskElement - то, что из элемента rrweb нужно для проверки селектора
+type maskElement struct {
+	tag   string
+	attrs map[string]string
+}
+
+func newMaskElement(node map[string]any) maskElement {
+	el := maskElement{attrs: make(map[string]string)}
+	el.tag, _ = node["tagName"].(string)
+	el.tag = strings.ToLower(el.tag)
+
+	attrs, _ := node["attributes"].(map[string]any)
+	for k, v := range attrs {
+		switch value := v.(type) {
+		case string:
+			el.attrs[strings.ToLower(k)] = value
+		case bool:
+			if value {
+				el.attrs[strings.ToLower(k)] = ""
+			}
+		default:
+			el.attrs[strings.ToLower(k)] = fmt.Sprint(value)
+		}
+	}
+
+	return el
+}
+
+// maskCompound - простой селектор без комбинаторов: tag#id.class[attr=value]
+type maskCompound struct {
+	tag     string
+	id      string
+	classes []string
+	attrs   []maskAttr
+}
+
+type maskAttr struct {
+	name     string
+	value    string
+	hasValue bool
+}
+
+// maskSelector - последовательность простых селекторов через пробел(потомок)
+type maskSelector struct {
+	raw   string
+	parts []maskCompound
+}
+
+func parseMaskSelector(raw string) (maskSelector, error) {
+	raw = strings.TrimSpace(raw)
+	sel := maskSelector{raw: raw}
+
+	fields := strings.Fields(raw)
+	if len(fields) == 0 {
+		return sel, errors.New("empty selector")
+	}
+
+	for _, f := range fields {
+		if strings.ContainsAny(f, ">+~:") {
+			return sel, errors.New("only descendant combinator is supported")
+		}
+		part, err := parseMaskCompound(f)
+		if err != nil {
+			return sel, err
+		}
+		sel.parts = append(sel.parts, part)
+	}
+
+	return sel, nil
+}
+
+func parseMaskCompound(s string) (maskCompound, error) {
+	var c maskCompound
+
+	readName := func() string {
+		n := 0
+		for n < len(s) && (isNameChar(s[n])) {
+			n++
+		}
+		name := s[:n]
+		s = s[n:]
+		return name
+	}
+
+	if strings.HasPrefix(s, "*") {
+		s = s[1:]
+	} else {
+		c.tag = strings.ToLower(readName())
+	}
+
+	for s != "" {
+		switch s[0] {
+		case '#':
+			s = s[1:]
+			if c.id = readName(); c.id == "" {
+				return c, errors.New("empty id")
+			}
+		case '.':
+			s = s[1:]
+			class := readName()
+			if class == "" {
+				return c, errors.New("empty class")
+			}
+			c.classes = append(c.classes, class)
+		case '[':
+			end := strings.IndexByte(s, ']')
+			if end < 0 {
+				return c, errors.New("unclosed attribute")
+			}
+			body := s[1:end]
+			s = s[end+1:]
+
+			name, value, hasValue := strings.Cut(body, "=")
+			attr := maskAttr{name: strings.ToLower(strings.TrimSpace(name)), hasValue: hasValue}
+			if attr.name == "" {
+				return c, errors.New("empty attribute name")
+			}
+			if hasValue {
+				attr.value = strings.Trim(strings.TrimSpace(value), `"'`)
+			}
+			c.attrs = append(c.attrs, attr)
+		default:
+			return c, fmt.Errorf("unexpected %q", s[0])
+		}
+	}
+
+	return c, nil
+}
+
+func isNameChar(b byte) bool {
+	return b == '-' || b == '_' || (b >= '0' && b <= '9') || (b >= 'a' && b <= 'z') || (b >= 'A' && b <= 'Z')
+}
+
+func (c maskCompound) match(el maskElement) bool {
+	if c.tag != "" && c.tag != el.tag {
+		return false
+	}
+	if c.id != "" && el.attrs["id"] != c.id {
+		return false
+	}
+	if len(c.classes) > 0 {
+		classes := strings.Fields(el.attrs["class"])
+		for _, want := range c.classes {
+			found := false
+			for _, class := range classes {
+				if class == want {
+					found = true
+					break
+				}
+			}
+			if !found {
+				return false
+			}
+		}
+	}
+	for _, a := range c.attrs {
+		value, ok := el.attrs[a.name]
+		if !ok || (a.hasValue && value != a.value) {
+			return false
+		}
+	}
+	return true
+}
+
+// match - последний простой селектор должен подойти элементу, остальные по порядку его предкам
+func (s maskSelector) match(el maskElement, ancestors []maskElement) bool {
+	last := len(s.parts) - 1
+	if !s.parts[last].match(el) {
+		return false
+	}
+
+	part := last - 1
+	for i := len(ancestors) - 1; i >= 0 && part >= 0; i-- {
+		if s.parts[part].match(ancestors[i]) {
+			part--
+		}
+	}
+
+	return part < 0
+}
@@ -0,0 +1,58 @@
+package analytics
+
+import "time"
+
+type Role string
+
+const (
+	RoleOwner  Role = "owner"
+	RoleAdmin  Role = "admin"
+	RoleViewer Role = "viewer"
+)
+
+// InvitationTTL - сколько живет приглашение в команду домена
+const InvitationTTL = time.Hour * 24 * 7
+
+var roleRanks = map[Role]int{
+	RoleViewer: 1,
+	RoleAdmin:  2,
+	RoleOwner:  3,
+}
+
+func (r Role) Valid() bool {
+	_, ok := roleRanks[r]
+	return ok
+}
+
+// Allows - покрывает ли роль требуемую(owner > admin > viewer)
+func (r Role) Allows(required Role) bool {
+	return roleRanks[r] >= roleRanks[required]
+}
+
+type DomainMember struct {
+	DomainID  uint      `json:"domain_id"`
+	UserID    uint      `json:"user_id"`
+	Email     string    `json:"email"`
+	Role      Role      `json:"role"`
+	CreatedAt time.Time `json:"created_at"`
+}
+
+type DomainInvitation struct {
+	ID        uint      `json:"id"`
+	DomainID  uint      `json:"domain_id"`
+	Email     string    `json:"email"`
+	Role      Role      `json:"role"`
+	Token     string    `json:"-"`
+	InvitedBy uint      `json:"invited_by"`
+	ExpiresAt time.Time `json:"expires_at"`
+	CreatedAt time.Time `json:"created_at"`
+}
+
+// GenerateInvitationToken - токен приглашения, уходит в ссылке на почту
+func GenerateInvitationToken() (string, error) {
+	return randomHex(32)
+}
+
+func (i DomainInvitation) Expired(now time.Time) bool {
+	return now.After(i.ExpiresAt)
+}
@@ -0,0 +1,76 @@
+package analytics
+
+import (
+	"strings"
+	"time"
+)
+
+// разрезы онлайна
+const (
+	OnlineByPage    = "page"
+	OnlineBySource  = "source"
+	OnlineByDevice  = "device"
+	OnlineByCountry = "country"
+)
+
+// OnlineUnknown - значение разреза, когда признак сессии еще не известен
+const OnlineUnknown = "unknown"
+
+// за сколько последних минут строится спарклайн онлайна
+const OnlineSparklineWindow = 30 * time.Minute
+
+type OnlineBreakdownItem struct {
+	Key    string `json:"key"`
+	Online int64  `json:"online"`
+}
+
+type OnlinePoint struct {
+	Minute time.Time `json:"minute"`
+	Online int64     `json:"online"`
+}
+
+type OnlineNow struct {
+	Online    int64                 `json:"online"`
+	Pages     []OnlineBreakdownItem `json:"pages"`
+	Sources   []OnlineBreakdownItem `json:"sources"`
+	Devices   []OnlineBreakdownItem `json:"devices"`
+	Countries []OnlineBreakdownItem `json:"countries"`
+	Sparkline []OnlinePoint         `json:"sparkline"`
+}
+
+// DeviceFromUserAgent - класс устройства по User-Agent, пустая строка если UA не передан
+func DeviceFromUserAgent(ua string) DeviceClass {
+	ua = strings.ToLower(ua)
+	if ua == "" {
+		return ""
+	}
+
+	switch {
+	case strings.Contains(ua, "ipad"),
+		strings.Contains(ua, "tablet"),
+		strings.Contains(ua, "android") && !strings.Contains(ua, "mobile"):
+		return DeviceTablet
+	case strings.Contains(ua, "mobi"),
+		strings.Contains(ua, "iphone"),
+		strings.Contains(ua, "ipod"),
+		strings.Contains(ua, "android"):
+		return DeviceMobile
+	default:
+		return DeviceDesktop
+	}
+}
+
+// NormalizeCountry - ISO 3166-1 alpha-2 код страны из заголовка прокси.
+// служебные коды(XX - неизвестно, T1 - tor) и мусор превращаются в пустую строку
+func NormalizeCountry(code string) string {
+	code = strings.ToUpper(strings.TrimSpace(code))
+	if len(code) != 2 || code == "XX" || code == "T1" {
+		return ""
+	}
+	for _, c := range code {
+		if c < 'A' || c > 'Z' {
+			return ""
+		}
+	}
+	return code
+}
@@ -0,0 +1,58 @@
+package analytics
+
+import (
+	"net/url"
+	"strings"
+	"time"
+)
+
+// PageStats - если часть периода читалась из дневных роллапов, unique_guests за нее - сумма уникальных по дням
+type PageStats struct {
+	Path         string `json:"path"`
+	Title        string `json:"title"`
+	Views        int64  `json:"views"`
+	UniqueGuests int64  `json:"unique_guests"`
+	// среднее время на странице в секундах
+	AvgTimeOnPage float64 `json:"avg_time_on_page"`
+	// среднее время на странице с вкладкой на переднем плане, в секундах
+	AvgEngagedTime float64 `json:"avg_engaged_time"`
+	Entrances      int64   `json:"entrances"`
+	Exits          int64   `json:"exits"`
+	// доля входов на страницу, после которых сессия закончилась без других просмотров
+	BounceRate float64 `json:"bounce_rate"`
+}
+
+type FindPagesOptions struct {
+	DomainID  uint
+	StartDate *time.Time
+	EndDate   *time.Time
+	Limit     *int
+	Offset    *int
+	Order     *string
+	OrderType *string
+
+	// [StartDate, RollupUntil) читается из дневных роллапов, остаток - из сырых ивентов
+	RollupUntil *time.Time
+}
+
+// NormalizePagePath - приводит адрес страницы к пути без схемы, хоста, query, якоря и слэша в конце
+func NormalizePagePath(raw string) string {
+	raw = strings.TrimSpace(raw)
+
+	if u, err := url.Parse(raw); err == nil {
+		raw = u.Path
+	} else {
+		raw, _, _ = strings.Cut(raw, "?")
+		raw, _, _ = strings.Cut(raw, "#")
+	}
+
+	raw = strings.TrimRight(raw, "/")
+	if raw == "" {
+		return "/"
+	}
+	if !strings.HasPrefix(raw, "/") {
+		raw = "/" + raw
+	}
+
+	return raw
+}
@@ -0,0 +1,84 @@
+package analytics
+
+import (
+	"fmt"
+	"strconv"
+	"strings"
+)
+
+// типы ивентов rrweb, которые важны для сборки реплея
+const (
+	RecordFullSnapshot = 2
+	RecordMeta         = 4
+)
+
+// ReplayCheckpoint - полный снапшот DOM, с которого плеер может начать воспроизведение
+type ReplayCheckpoint struct {
+	EventID   uint  `json:"event_id"`
+	Timestamp int64 `json:"timestamp"`
+}
+
+// ReplayPage - страница реплея, берется из meta-ивентов rrweb
+type ReplayPage struct {
+	URL       string `json:"url"`
+	Timestamp int64  `json:"timestamp"`
+	Width     int    `json:"width"`
+	Height    int    `json:"height"`
+}
+
+type ReplayMeta struct {
+	SessionID uint `json:"session_id"`
+	//таймстемпы и длительность в миллисекундах, как у rrweb
+	StartedAt       int64              `json:"started_at"`
+	EndedAt         int64              `json:"ended_at"`
+	Duration        int64              `json:"duration"`
+	EventsCount     int64              `json:"events_count"`
+	EventsByType    map[int]int64      `json:"events_by_type"`
+	Pages           []ReplayPage       `json:"pages"`
+	Checkpoints     []ReplayCheckpoint `json:"checkpoints"`
+	HasFullSnapshot bool               `json:"has_full_snapshot"`
+}
+
+// ReplayCursor - позиция в реплее, ивенты упорядочены по (timestamp, id)
+type ReplayCursor struct {
+	Timestamp int64
+	ID        uint
+}
+
+func (c ReplayCursor) String() string {
+	return fmt.Sprintf("%d_%d", c.Timestamp, c.ID)
+}
+
+func ParseReplayCursor(raw string) (ReplayCursor, error) {
+	ts, id, ok := strings.Cut(raw, "_")
+	if !ok {
+		return ReplayCursor{}, ErrReplayCursorInvalid
+	}
+
+	timestamp, err := strconv.ParseInt(ts, 10, 64)
+	if err != nil {
+		return ReplayCursor{}, ErrReplayCursorInvalid
+	}
+
+	eventID, err := strconv.ParseUint(id, 10, 64)
+	if err != nil {
+		return ReplayCursor{}, ErrReplayCursorInvalid
+	}
+
+	return ReplayCursor{Timestamp: timestamp, ID: uint(eventID)}, nil
+}
+
+type ReplayWindowOptions struct {
+	SessionID uint
+	//ивенты строго после курсора, а если Inclusive - начиная с него
+	After     ReplayCursor
+	Inclusive bool
+	//верхняя граница по времени в мс, 0 - до конца сессии
+	To    int64
+	Limit int
+}
+
+type ReplayWindow struct {
+	Events     []RecordEvent `json:"events"`
+	NextCursor string        `json:"next_cursor,omitempty"`
+}
@@ -7,6 +7,14 @@ import (
 
 type EventsRepository interface {
 	SaveEvents(ctx context.Context, events *[]Event) error
+	FindPages(ctx context.Context, opts FindPagesOptions) ([]PageStats, int64, error)
+	Heatmap(ctx context.Context, opts HeatmapOptions) (*Heatmap, error)
+	AddEngagedTime(ctx context.Context, pageviews map[uint]time.Duration) error
+	Funnel(ctx context.Context, opts FunnelQueryOptions) ([]FunnelStepCount, error)
+	Explore(ctx context.Context, opts ExplorerOptions) ([]ExplorerRow, error)
+	//прибавляет счетчики к каталогу типов ивентов и свойств, новые записи создаются
+	UpdateCatalog(ctx context.Context, entries []EventCatalogEntry) error
+	Catalog(ctx context.Context, domain_id uint) ([]EventCatalogEntry, error)
 }
 
 var FindGuestAllowedOrders = map[string]bool{
@@ -16,6 +24,7 @@ var FindGuestAllowedOrders = map[string]bool{
 	"total_second_on_site": true,
 	"is_online":            true,
 	"session_count":        true,
+	"engaged_seconds":      true,
 }
 
 type FindGuestsOptions struct {
@@ -33,6 +42,8 @@ type GuestsRepository interface {
 	CreateGuests(ctx context.Context, guests *[]Guest) ([]Guest, error)
 	Find(ctx context.Context, opts FindGuestsOptions) ([]Guest, int64, error)
 	ByID(ctx context.Context, guest_id uint) (*Guest, error) 
+	//ячейки матрицы удержания, отсортированы по началу когорты и номеру периода
+	Cohorts(ctx context.Context, opts CohortOptions) ([]CohortCell, error)
 }
 
 type GuestSessionRepositoryByRangeDateOptions struct {
@@ -44,31 +55,89 @@ type GuestSessionRepositoryByRangeDateOptions struct {
 	WithoutActive *bool
 }
 
-type GetVisitsByIntervalOptions struct {
-	Start           time.Time
-	End             time.Time
-	IntervalMinutes int
-	IntervalDiviser int
-}
-
 type GuestSessionRepository interface {
 	Create(ctx context.Context, session *GuestSession) error
 	GetCountActiveSessions(ctx context.Context, domain_id uint) (int64, error)
-	SetLastActive(ctx context.Context, session_ids []uint, last_active time.Time) error
+	OnlineBreakdown(ctx context.Context, domain_id uint, by string, limit int) ([]OnlineBreakdownItem, error)
+	OnlineSparkline(ctx context.Context, domain_id uint, since time.Time) ([]OnlinePoint, error)
+	SetLastActive(ctx context.Context, last_active map[uint]time.Time) error
 	GetStaleSessions(ctx context.Context, limit int) (*[]GuestSession, error)
 	CloseSessions(ctx context.Context, session_ids []uint) error
 	ByRangeDate(ctx context.Context, opts GuestSessionRepositoryByRangeDateOptions) (*[]GuestSession, error)
-	GetVisitsByInterval(
-		ctx context.Context,
-		domain_id uint,
-		opts GetVisitsByIntervalOptions,
-	) (*[]GuestSessionsByTimeBucket, error)
 	CreateSessions(ctx context.Context, sessions *[]GuestSession) ([]GuestSession, error)
 	LastActiveByGuestId(ctx context.Context, guest_id uint) (*GuestSession, error)
+	FilterByDomain(ctx context.Context, domain_id uint, session_ids []uint) ([]uint, error)
+	FilterActive(ctx context.Context, session_ids []uint) ([]uint, error)
+	SetSources(ctx context.Context, sources map[uint]SessionSource) error
+	FindSources(ctx context.Context, opts FindSourcesOptions) ([]SourceStats, int64, error)
+	//состояния подсчета вовлеченности, строки сессий блокируются до конца транзакции
+	EngagementStates(ctx context.Context, session_ids []uint) (map[uint]EngagementState, error)
+	AddEngagement(ctx context.Context, results map[uint]EngagementResult) error
+	//домены сессий, session_id -> domain_id
+	DomainsBySessions(ctx context.Context, session_ids []uint) (map[uint]uint, error)
+}
+
+type TimeseriesRepository interface {
+	//точки по интервалам периода, интервалы без данных заполнены нулями
+	Timeseries(ctx context.Context, opts TimeseriesOptions) ([]TimeseriesRow, error)
+	//итоги за весь период
+	Totals(ctx context.Context, opts TimeseriesOptions) (TimeseriesRow, error)
+	//то же по роллапам гранулярности granularity, период на границах ее интервалов
+	RollupTimeseries(ctx context.Context, opts TimeseriesOptions, granularity string) ([]TimeseriesRow, error)
+	RollupTotals(ctx context.Context, opts TimeseriesOptions, granularity string) (TimeseriesRow, error)
+}
+
+type RollupRepository interface {
+	//домены, по которым ведутся роллапы, с их часовым поясом
+	Targets(ctx context.Context) ([]RollupTarget, error)
+	States(ctx context.Context, granularity string) (map[uint]RollupState, error)
+	//nil, если роллапы домена еще не считались
+	State(ctx context.Context, domain_id uint, granularity string) (*RollupState, error)
+	//удаляет все роллапы домена этой гранулярности вместе с состоянием
+	Reset(ctx context.Context, domain_id uint, granularity string) error
+	//пересчитывает роллапы диапазона по сырым данным
+	Rebuild(ctx context.Context, r RollupRange) error
+	SaveState(ctx context.Context, state RollupState) error
+}
+
+type RetentionRepository interface {
+	//настройки хранения всех доменов, domain_id -> настройки
+	Settings(ctx context.Context) (map[uint]RetentionSettings, error)
+	//помесячные партиции данных по возрастанию, без партиции по умолчанию
+	Partitions(ctx context.Context, data string) ([]Partition, error)
+	//создает партицию месяца, уже попавшие в партицию по умолчанию строки месяца переносятся в нее
+	CreatePartition(ctx context.Context, data string, month time.Time) error
+	//удаляет партицию вместе со строками, которые ссылаются на ее сессии
+	DropPartition(ctx context.Context, p Partition) error
+	//удаляет не больше limit строк данных домена старше before, возвращает сколько удалено
+	DeleteExpired(ctx context.Context, domain_id uint, data string, before time.Time, limit int) (int64, error)
+}
+
+type GoalRepository interface {
+	ByDomain(ctx context.Context, domain_id uint) ([]Goal, error)
+	ByID(ctx context.Context, domain_id uint, goal_id uint) (*Goal, error)
+	Create(ctx context.Context, goal *Goal) error
+	Update(ctx context.Context, goal *Goal) error
+	Delete(ctx context.Context, domain_id uint, goal_id uint) error
+	//повторная конверсия той же цели в той же сессии игнорируется
+	SaveConversions(ctx context.Context, conversions []Conversion) error
+	ConversionsReport(ctx context.Context, opts ConversionsReportOptions) (*ConversionsReport, error)
 }
 
 type DomainRepository interface {
+	ByID(ctx context.Context, domain_id uint) (*Domain, error)
+	BySessionID(ctx context.Context, session_id uint) (*Domain, error)
 	ByURL(ctx context.Context, url string) (*Domain, error)
+	ByUser(ctx context.Context, user_id uint) ([]Domain, error)
+	Create(ctx context.Context, dom *Domain) error
+	Rename(ctx context.Context, domain_id uint, name string) error
+	UpdateSiteKey(ctx context.Context, domain_id uint, site_key string) error
+	Delete(ctx context.Context, domain_id uint) error
+	BySiteKey(ctx context.Context, site_key string) (*Domain, error)
+	MaskingRules(ctx context.Context, domain_id uint) (*MaskingRules, error)
+	SetMaskingRules(ctx context.Context, domain_id uint, rules MaskingRules) error
+	SetSessionSettings(ctx context.Context, domain_id uint, settings SessionSettings) error
+	SetRetentionSettings(ctx context.Context, domain_id uint, settings RetentionSettings) error
 	AddDomain(ctx context.Context, site_url string) (*Domain, error)
 	GetDomainGuests(ctx context.Context, domainId uint) (*[]Guest, error)
 	GetDomainGuestsByFingerprints(ctx context.Context, domainId uint, fingerprints []string) (*[]Guest, error)
@@ -78,4 +147,30 @@ type DomainRepository interface {
 type RecordEventRepository interface {
 	SaveEvents(ctx context.Context, events *[]RecordEvent) error
 	GetBySessionId(ctx context.Context, session_id uint) (*[]RecordEvent, error)
+	Meta(ctx context.Context, session_id uint) (*ReplayMeta, error)
+	LastBefore(ctx context.Context, session_id uint, event_type int, timestamp int64) (*RecordEvent, error)
+	Window(ctx context.Context, opts ReplayWindowOptions) ([]RecordEvent, error)
+	Stream(ctx context.Context, opts ReplayWindowOptions, fn func(RecordEvent) error) error
+	//склеивает мелкие чанки закрытых сессий, возвращает сколько сессий сжато
+	CompactChunks(ctx context.Context, sessions int) (int, error)
+}
+
+type FunnelRepository interface {
+	ByDomain(ctx context.Context, domain_id uint) ([]Funnel, error)
+	ByID(ctx context.Context, domain_id uint, funnel_id uint) (*Funnel, error)
+	Create(ctx context.Context, funnel *Funnel) error
+	Update(ctx context.Context, funnel *Funnel) error
+	Delete(ctx context.Context, domain_id uint, funnel_id uint) error
+}
+
+type MembersRepository interface {
+	Role(ctx context.Context, domain_id uint, user_id uint) (Role, error)
+	ByDomain(ctx context.Context, domain_id uint) ([]DomainMember, error)
+	Add(ctx context.Context, member *DomainMember) error
+	UpdateRole(ctx context.Context, domain_id uint, user_id uint, role Role) error
+	Remove(ctx context.Context, domain_id uint, user_id uint) error
+	CreateInvitation(ctx context.Context, invitation *DomainInvitation) error
+	InvitationByToken(ctx context.Context, token string) (*DomainInvitation, error)
+	InvitationsByDomain(ctx context.Context, domain_id uint) ([]DomainInvitation, error)
+	DeleteInvitation(ctx context.Context, domain_id uint, invitation_id uint) error
 }
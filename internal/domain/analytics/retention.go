@@ -0,0 +1,120 @@
+package analytics
+
+import (
+	"fmt"
+	"time"
+)
+
+const maxRetentionDays = 10 * 365
+
+// виды данных домена со своим сроком хранения
+const (
+	RetainedEvents   = "events"
+	RetainedSessions = "sessions"
+	RetainedReplays  = "replays"
+	RetainedRollups  = "rollups"
+)
+
+// PartitionedData - данные, которые режутся на помесячные партиции. роллапы небольшие и удаляются построчно
+var PartitionedData = []string{RetainedEvents, RetainedSessions, RetainedReplays}
+
+// RetentionSettings - сколько дней хранятся данные домена, 0 - бессрочно.
+// сессии нужны ивентам и записям, а роллапы заменяют в отчетах сырые данные, поэтому хранятся не меньше их
+type RetentionSettings struct {
+	EventsDays   int `json:"events_days"`
+	SessionsDays int `json:"sessions_days"`
+	ReplaysDays  int `json:"replays_days"`
+	RollupsDays  int `json:"rollups_days"`
+}
+
+func DefaultRetentionSettings() RetentionSettings {
+	return RetentionSettings{
+		EventsDays:   90,
+		SessionsDays: 90,
+		ReplaysDays:  30,
+		RollupsDays:  0,
+	}
+}
+
+func (s RetentionSettings) Validate() error {
+	for _, field := range []struct {
+		name string
+		days int
+	}{
+		{"events_days", s.EventsDays},
+		{"sessions_days", s.SessionsDays},
+		{"replays_days", s.ReplaysDays},
+		{"rollups_days", s.RollupsDays},
+	} {
+		if field.days < 0 || field.days > maxRetentionDays {
+			return fmt.Errorf("%w: %s must be between 0 and %d", ErrInvalidRetentionSettings, field.name, maxRetentionDays)
+		}
+	}
+
+	if s.SessionsDays != 0 && (!coversRetention(s.SessionsDays, s.EventsDays) || !coversRetention(s.SessionsDays, s.ReplaysDays)) {
+		return fmt.Errorf("%w: sessions_days must not be shorter than events_days and replays_days", ErrInvalidRetentionSettings)
+	}
+	if s.RollupsDays != 0 && !coversRetention(s.RollupsDays, s.SessionsDays) {
+		return fmt.Errorf("%w: rollups_days must not be shorter than sessions_days", ErrInvalidRetentionSettings)
+	}
+
+	return nil
+}
+
+// coversRetention - хранение в days не короче, чем в other
+func coversRetention(days int, other int) bool {
+	return other != 0 && other <= days
+}
+
+// RetentionCutoff - данные старше этого момента удаляются, false если они хранятся бессрочно
+func RetentionCutoff(days int, now time.Time) (time.Time, bool) {
+	if days == 0 {
+		return time.Time{}, false
+	}
+	return now.AddDate(0, 0, -days), true
+}
+
+// Partition - помесячная партиция, строки с ключом в [From, To). границы месяцев по UTC
+type Partition struct {
+	Data string
+	Name string
+	From time.Time
+	To   time.Time
+}
+
+// PartitionMonth - начало месяца, в который попадает t
+func PartitionMonth(t time.Time) time.Time {
+	y, m, _ := t.UTC().Date()
+	return time.Date(y, m, 1, 0, 0, 0, 0, time.UTC)
+}
+
+// Days - сколько дней хранятся данные этого вида
+func (s RetentionSettings) Days(data string) int {
+	switch data {
+	case RetainedEvents:
+		return s.EventsDays
+	case RetainedSessions:
+		return s.SessionsDays
+	case RetainedReplays:
+		return s.ReplaysDays
+	case RetainedRollups:
+		return s.RollupsDays
+	}
+	return 0
+}
+
+// LongestRetention - самое долгое хранение данных среди доменов, false если кто-то хранит их бессрочно.
+// партиции старше него уже не нужны ни одному домену и удаляются целиком
+func LongestRetention(settings map[uint]RetentionSettings, data string) (int, bool) {
+	longest := 0
+	for _, s := range settings {
+		days := s.Days(data)
+		if days == 0 {
+			return 0, false
+		}
+		if days > longest {
+			longest = days
+		}
+	}
+	return longest, longest > 0
+}
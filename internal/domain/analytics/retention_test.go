@@ -0,0 +1,34 @@
+package analytics
+
+import (
+	"errors"
+	"testing"
+)
+
+func TestRetentionSettingsValidate(t *testing.T) {
+	tests := []struct {
+		name     string
+		settings RetentionSettings
+		valid    bool
+	}{
+		{"default", DefaultRetentionSettings(), true},
+		{"everything forever", RetentionSettings{}, true},
+		{"sessions shorter than events", RetentionSettings{EventsDays: 90, SessionsDays: 30, ReplaysDays: 30}, false},
+		{"events forever, sessions expire", RetentionSettings{SessionsDays: 90, ReplaysDays: 30}, false},
+		{"sessions shorter than replays", RetentionSettings{EventsDays: 30, SessionsDays: 30, ReplaysDays: 60}, false},
+		{"rollups shorter than sessions", RetentionSettings{EventsDays: 30, SessionsDays: 90, ReplaysDays: 30, RollupsDays: 60}, false},
+		{"negative", RetentionSettings{EventsDays: -1}, false},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			err := tt.settings.Validate()
+			if tt.valid && err != nil {
+				t.Fatalf("validate = %v, want nil", err)
+			}
+			if !tt.valid && !errors.Is(err, ErrInvalidRetentionSettings) {
+				t.Fatalf("validate = %v, want ErrInvalidRetentionSettings", err)
+			}
+		})
+	}
+}
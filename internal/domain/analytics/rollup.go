@@ -0,0 +1,139 @@
+package analytics
+
+import "time"
+
+// гранулярности роллапов. часовые режутся по UTC, дневные - по часовому поясу домена
+const (
+	RollupHour = "hour"
+	RollupDay  = "day"
+)
+
+// RollupState - роллапы домена посчитаны для интервалов до RolledUntil.
+// у дневных Timezone - пояс, в котором резались сутки
+type RollupState struct {
+	DomainID    uint
+	Granularity string
+	Timezone    string
+	RolledUntil time.Time
+}
+
+// RollupTarget - домен, по которому ведутся роллапы. с CreatedAt начинается досчет истории
+type RollupTarget struct {
+	DomainID  uint
+	Timezone  string
+	CreatedAt time.Time
+}
+
+// RollupRange - пересчет роллапов домена за [StartDate, EndDate), границы на границах интервалов.
+// у часовых Timezone всегда UTC
+type RollupRange struct {
+	DomainID    uint
+	Granularity string
+	Timezone    string
+	StartDate   time.Time
+	EndDate     time.Time
+}
+
+// RollupLocation - часовой пояс, в котором режутся интервалы роллапа
+func RollupLocation(granularity string, timezone string) *time.Location {
+	if granularity == RollupHour {
+		return time.UTC
+	}
+	loc, err := time.LoadLocation(timezone)
+	if err != nil {
+		return time.UTC
+	}
+	return loc
+}
+
+// TruncateRollup - начало интервала роллапа, в который попадает t
+func TruncateRollup(t time.Time, granularity string, loc *time.Location) time.Time {
+	if granularity == RollupHour {
+		return t.UTC().Truncate(time.Hour)
+	}
+	y, m, d := t.In(loc).Date()
+	return time.Date(y, m, d, 0, 0, 0, 0, loc)
+}
+
+// AddRollup - сдвиг на n интервалов роллапа. сутки считаются по календарю, а не по 24 часа
+func AddRollup(t time.Time, n int, granularity string, loc *time.Location) time.Time {
+	if granularity == RollupHour {
+		return t.Add(time.Duration(n) * time.Hour)
+	}
+	y, m, d := t.In(loc).Date()
+	return time.Date(y, m, d+n, 0, 0, 0, 0, loc)
+}
+
+// PlanRollup - что пересчитать за проход воркера. текущий интервал не трогается, он читается из сырых данных.
+// каждый проход заново считает последние lookback, куда еще доходят ивенты открытых сессий и пачки из спула,
+// и продвигается вперед не больше чем на batch интервалов, чтобы досчет истории шел порциями.
+// state nil или со старым часовым поясом - роллапы считаются заново с создания домена
+func PlanRollup(granularity string, target RollupTarget, state *RollupState, now time.Time, lookback time.Duration, batch int) (RollupRange, bool) {
+	timezone := target.Timezone
+	if granularity == RollupHour {
+		timezone = "UTC"
+	}
+
+	loc := RollupLocation(granularity, timezone)
+	limit := TruncateRollup(now, granularity, loc)
+
+	from := TruncateRollup(target.CreatedAt, granularity, loc)
+	base := from
+	if state != nil && state.Timezone == timezone {
+		if recent := TruncateRollup(state.RolledUntil.Add(-lookback), granularity, loc); recent.After(from) {
+			from = recent
+		}
+		if state.RolledUntil.After(base) {
+			base = state.RolledUntil
+		}
+	}
+
+	to := AddRollup(base, batch, granularity, loc)
+	if to.After(limit) {
+		to = limit
+	}
+	if !to.After(from) {
+		return RollupRange{}, false
+	}
+
+	return RollupRange{
+		DomainID:    target.DomainID,
+		Granularity: granularity,
+		Timezone:    timezone,
+		StartDate:   from,
+		EndDate:     to,
+	}, true
+}
+
+// ReadableUntil - до какого момента диапазон с началом start и концом end читается из роллапов.
+// false, если роллапов нет, они нарезаны в другом часовом поясе или start не на границе интервала
+func (s *RollupState) ReadableUntil(start time.Time, end time.Time, timezone string) (time.Time, bool) {
+	if s == nil {
+		return time.Time{}, false
+	}
+	if s.Granularity == RollupDay && s.Timezone != timezone {
+		return time.Time{}, false
+	}
+
+	loc := RollupLocation(s.Granularity, s.Timezone)
+	if !TruncateRollup(start, s.Granularity, loc).Equal(start) {
+		return time.Time{}, false
+	}
+
+	//часы по UTC не совпадают с местными в поясах со смещением не в целый час
+	if s.Granularity == RollupHour {
+		if _, offset := start.In(RollupLocation(RollupDay, timezone)).Zone(); offset%3600 != 0 {
+			return time.Time{}, false
+		}
+	}
+
+	until := s.RolledUntil
+	if end.Before(until) {
+		until = TruncateRollup(end, s.Granularity, loc)
+	}
+	if !until.After(start) {
+		return time.Time{}, false
+	}
+
+	return until, true
+}
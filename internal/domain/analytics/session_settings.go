@@ -0,0 +1,105 @@
+package analytics
+
+import (
+	"fmt"
+	"time"
+)
+
+// причины, по которым активная сессия завершается и начинается новая
+const (
+	SessionSplitTimeout  = "timeout"
+	SessionSplitMidnight = "midnight"
+	SessionSplitCampaign = "campaign"
+)
+
+const (
+	minSessionTimeoutMinutes = 1
+	maxSessionTimeoutMinutes = 24 * 60
+)
+
+// SessionSettings - правила нарезки визитов на сессии для домена, как в GA:
+// сессия заканчивается после TimeoutMinutes без активности, в полночь по часовому поясу сайта
+// и при заходе с другой рекламной кампании или внешнего источника
+type SessionSettings struct {
+	TimeoutMinutes        int    `json:"timeout_minutes"`
+	Timezone              string `json:"timezone"`
+	SplitAtMidnight       bool   `json:"split_at_midnight"`
+	SplitOnCampaignChange bool   `json:"split_on_campaign_change"`
+}
+
+func DefaultSessionSettings() SessionSettings {
+	return SessionSettings{
+		TimeoutMinutes:        30,
+		Timezone:              "UTC",
+		SplitAtMidnight:       true,
+		SplitOnCampaignChange: true,
+	}
+}
+
+func (s SessionSettings) Validate() error {
+	if s.TimeoutMinutes < minSessionTimeoutMinutes || s.TimeoutMinutes > maxSessionTimeoutMinutes {
+		return fmt.Errorf("%w: timeout_minutes must be between %d and %d", ErrInvalidSessionSettings, minSessionTimeoutMinutes, maxSessionTimeoutMinutes)
+	}
+	if _, err := time.LoadLocation(s.Timezone); err != nil || s.Timezone == "" {
+		return fmt.Errorf("%w: unknown timezone %q", ErrInvalidSessionSettings, s.Timezone)
+	}
+	return nil
+}
+
+func (s SessionSettings) Timeout() time.Duration {
+	return time.Duration(s.TimeoutMinutes) * time.Minute
+}
+
+// Location - часовой пояс сайта, UTC если пояс не задан или неизвестен
+func (s SessionSettings) Location() *time.Location {
+	loc, err := time.LoadLocation(s.Timezone)
+	if err != nil {
+		return time.UTC
+	}
+	return loc
+}
+
+// SplitReason - почему активную сессию пора закончить и начать новую, пустая строка - продолжаем ее.
+// source - источник текущего захода, nil если клиент его не прислал
+func (s SessionSettings) SplitReason(session GuestSession, now time.Time, source *SessionSource) string {
+	if now.Sub(session.LastActive) > s.Timeout() {
+		return SessionSplitTimeout
+	}
+
+	if s.SplitAtMidnight {
+		loc := s.Location()
+		ly, lm, ld := session.LastActive.In(loc).Date()
+		ny, nm, nd := now.In(loc).Date()
+		if ly != ny || lm != nm || ld != nd {
+			return SessionSplitMidnight
+		}
+	}
+
+	if s.SplitOnCampaignChange && source != nil && session.SourceType != "" &&
+		source.IsCampaign() && !source.SameCampaign(session.SessionSource) {
+		return SessionSplitCampaign
+	}
+
+	return ""
+}
+
+// IsCampaign - заход размечен utm-метками или пришел с внешнего сайта.
+// прямые заходы и переходы внутри сайта продолжают текущую сессию
+func (s SessionSource) IsCampaign() bool {
+	if s.UTMSource != "" || s.UTMMedium != "" || s.UTMCampaign != "" {
+		return true
+	}
+	switch s.SourceType {
+	case SourceSearch, SourceSocial, SourceReferral:
+		return true
+	}
+	return false
+}
+
+func (s SessionSource) SameCampaign(other SessionSource) bool {
+	return s.SourceType == other.SourceType &&
+		s.ReferrerHost == other.ReferrerHost &&
+		s.UTMSource == other.UTMSource &&
+		s.UTMMedium == other.UTMMedium &&
+		s.UTMCampaign == other.UTMCampaign
+}
@@ -0,0 +1,150 @@
+package analytics
+
+import (
+	"net/url"
+	"strings"
+	"time"
+)
+
+type SourceType string
+
+const (
+	SourceDirect   SourceType = "direct"
+	SourceSearch   SourceType = "search"
+	SourceSocial   SourceType = "social"
+	SourceInternal SourceType = "internal"
+	SourceReferral SourceType = "referral"
+)
+
+// SessionSource - откуда пришел гость, берется из первого просмотра страницы в сессии
+type SessionSource struct {
+	LandingPage  string     `json:"landing_page,omitempty"`
+	ReferrerHost string     `json:"referrer_host,omitempty"`
+	SourceType   SourceType `json:"source_type,omitempty"`
+	UTMSource    string     `json:"utm_source,omitempty"`
+	UTMMedium    string     `json:"utm_medium,omitempty"`
+	UTMCampaign  string     `json:"utm_campaign,omitempty"`
+	UTMTerm      string     `json:"utm_term,omitempty"`
+	UTMContent   string     `json:"utm_content,omitempty"`
+}
+
+// поисковики, у которых много национальных доменов, матчатся по имени без зоны
+var searchEngines = []string{
+	"google.*", "bing.com", "yandex.*", "ya.ru", "duckduckgo.com", "yahoo.*", "baidu.com",
+	"ecosia.org", "search.brave.com", "go.mail.ru", "nova.rambler.ru", "startpage.com", "qwant.com",
+}
+
+var socialNetworks = []string{
+	"facebook.com", "fb.com", "instagram.com", "t.co", "twitter.com", "x.com", "linkedin.com",
+	"lnkd.in", "vk.com", "vk.ru", "ok.ru", "t.me", "telegram.org", "reddit.com", "youtube.com",
+	"youtu.be", "pinterest.com", "tiktok.com", "dzen.ru", "threads.net", "whatsapp.com",
+}
+
+// SourceFromPageview - собирает источник сессии из ивента pageview.
+// адрес страницы берется из data.url, если клиент его прислал, иначе из page_url
+func SourceFromPageview(e Event) SessionSource {
+	pageURL, _ := e.Data["url"].(string)
+	if pageURL == "" {
+		pageURL = e.PageURL
+	}
+
+	referrer, _ := e.Data["referrer"].(string)
+
+	return SourceFromURL(pageURL, referrer)
+}
+
+// SourceFromURL - источник захода по адресу страницы и document.referrer
+func SourceFromURL(pageURL, referrer string) SessionSource {
+	source := SessionSource{LandingPage: NormalizePagePath(pageURL)}
+
+	if u, err := url.Parse(pageURL); err == nil {
+		q := u.Query()
+		source.UTMSource = q.Get("utm_source")
+		source.UTMMedium = q.Get("utm_medium")
+		source.UTMCampaign = q.Get("utm_campaign")
+		source.UTMTerm = q.Get("utm_term")
+		source.UTMContent = q.Get("utm_content")
+	}
+
+	source.ReferrerHost = normalizeHost(referrer)
+	source.SourceType = ClassifyReferrer(source.ReferrerHost, normalizeHost(pageURL))
+
+	return source
+}
+
+// ClassifyReferrer - относит хост реферера к типу источника по встроенным спискам
+func ClassifyReferrer(referrerHost, pageHost string) SourceType {
+	referrerHost = normalizeHost(referrerHost)
+	if referrerHost == "" {
+		return SourceDirect
+	}
+
+	if pageHost != "" && referrerHost == normalizeHost(pageHost) {
+		return SourceInternal
+	}
+
+	for _, pattern := range searchEngines {
+		if matchHost(referrerHost, pattern) {
+			return SourceSearch
+		}
+	}
+
+	for _, pattern := range socialNetworks {
+		if matchHost(referrerHost, pattern) {
+			return SourceSocial
+		}
+	}
+
+	return SourceReferral
+}
+
+// matchHost - хост совпадает с шаблоном или является его поддоменом.
+// шаблон вида "google.*" матчит любую зону из одной-двух частей: google.com, google.co.uk
+func matchHost(host, pattern string) bool {
+	if name, ok := strings.CutSuffix(pattern, ".*"); ok {
+		labels := strings.Split(host, ".")
+		for i, label := range labels {
+			if label != name {
+				continue
+			}
+			zone := len(labels) - i - 1
+			if zone >= 1 && zone <= 2 {
+				return true
+			}
+		}
+		return false
+	}
+
+	return host == pattern || strings.HasSuffix(host, "."+pattern)
+}
+
+// SourceStats - если часть периода читалась из дневных роллапов, uniques за нее - сумма уникальных по дням
+type SourceStats struct {
+	Source     string  `json:"source"`
+	Visits     int64   `json:"visits"`
+	Uniques    int64   `json:"uniques"`
+	BounceRate float64 `json:"bounce_rate"`
+}
+
+// группировки отчета по источникам
+const (
+	SourcesGroupBySource   = "source"
+	SourcesGroupByType     = "type"
+	SourcesGroupByReferrer = "referrer"
+	SourcesGroupByMedium   = "medium"
+	SourcesGroupByCampaign = "campaign"
+)
+
+type FindSourcesOptions struct {
+	DomainID  uint
+	StartDate *time.Time
+	EndDate   *time.Time
+	GroupBy   string
+	Limit     *int
+	Offset    *int
+	Order     *string
+	OrderType *string
+
+	// [StartDate, RollupUntil) читается из дневных роллапов, остаток - из сырых сессий
+	RollupUntil *time.Time
+}
@@ -0,0 +1,254 @@
+package analytics
+
+import (
+	"fmt"
+	"time"
+)
+
+// метрики временного ряда
+const (
+	// сессии, начатые в интервале
+	MetricVisits = "visits"
+	// гости, начавшие сессию в интервале
+	MetricUniques   = "uniques"
+	MetricPageviews = "pageviews"
+	// все ивенты трекера
+	MetricEvents      = "events"
+	MetricConversions = "conversions"
+	// средняя длительность сессий, начатых в интервале, в секундах
+	MetricAvgDuration = "avg_duration"
+)
+
+var TimeseriesMetrics = []string{MetricVisits, MetricUniques, MetricPageviews, MetricEvents, MetricConversions, MetricAvgDuration}
+
+// шаг временного ряда
+const (
+	GranularityMinute = "minute"
+	GranularityHour   = "hour"
+	GranularityDay    = "day"
+	GranularityWeek   = "week"
+	GranularityMonth  = "month"
+)
+
+// ограничение на число точек в ряду
+const TimeseriesMaxPoints = 1500
+
+type TimeseriesOptions struct {
+	DomainID    uint
+	Metrics     []string
+	Granularity string
+	// период [StartDate, EndDate)
+	StartDate time.Time
+	EndDate   time.Time
+	// интервалы режутся в часовом поясе домена
+	Timezone string
+	// добавить ряд за такой же по длине предыдущий период
+	Compare bool
+}
+
+func (o TimeseriesOptions) Validate() error {
+	if len(o.Metrics) == 0 {
+		return fmt.Errorf("%w: no metrics", ErrInvalidTimeseriesQuery)
+	}
+	for _, m := range o.Metrics {
+		if !isTimeseriesMetric(m) {
+			return fmt.Errorf("%w: unknown metric %q", ErrInvalidTimeseriesQuery, m)
+		}
+	}
+
+	if !o.EndDate.After(o.StartDate) {
+		return fmt.Errorf("%w: end date must be after start date", ErrInvalidTimeseriesQuery)
+	}
+
+	//месяц и неделя оцениваются снизу, для проверки лимита точности хватает
+	var step time.Duration
+	switch o.Granularity {
+	case GranularityMinute:
+		step = time.Minute
+	case GranularityHour:
+		step = time.Hour
+	case GranularityDay:
+		step = 24 * time.Hour
+	case GranularityWeek:
+		step = 7 * 24 * time.Hour
+	case GranularityMonth:
+		step = 28 * 24 * time.Hour
+	default:
+		return fmt.Errorf("%w: unknown granularity %q", ErrInvalidTimeseriesQuery, o.Granularity)
+	}
+	if o.EndDate.Sub(o.StartDate)/step > TimeseriesMaxPoints {
+		return fmt.Errorf("%w: too many %s points in range, max %d", ErrInvalidTimeseriesQuery, o.Granularity, TimeseriesMaxPoints)
+	}
+
+	return nil
+}
+
+// Previous - те же параметры для предыдущего периода такой же длины
+func (o TimeseriesOptions) Previous() TimeseriesOptions {
+	prev := o
+	prev.EndDate = o.StartDate
+	prev.StartDate = o.StartDate.Add(-o.EndDate.Sub(o.StartDate))
+	prev.Compare = false
+	return prev
+}
+
+func (o TimeseriesOptions) Has(metric string) bool {
+	for _, m := range o.Metrics {
+		if m == metric {
+			return true
+		}
+	}
+	return false
+}
+
+func isTimeseriesMetric(metric string) bool {
+	for _, m := range TimeseriesMetrics {
+		if m == metric {
+			return true
+		}
+	}
+	return false
+}
+
+// RollupGranularity - из каких роллапов читается ряд. уникальные берутся только из роллапов того же шага,
+// события и конверсии в роллапах не хранятся
+func (o TimeseriesOptions) RollupGranularity() (string, bool) {
+	if o.Has(MetricEvents) || o.Has(MetricConversions) {
+		return "", false
+	}
+
+	switch o.Granularity {
+	case GranularityHour:
+		return RollupHour, true
+	case GranularityDay:
+		return RollupDay, true
+	case GranularityWeek, GranularityMonth:
+		return RollupDay, !o.Has(MetricUniques)
+	}
+	return "", false
+}
+
+// TimeseriesRow - значения метрик в интервале. незапрошенные метрики нулевые
+type TimeseriesRow struct {
+	Bucket      time.Time
+	Visits      int64
+	Uniques     int64
+	Pageviews   int64
+	Events      int64
+	Conversions int64
+	AvgDuration float64
+	// сумма длительностей сессий, чтобы средняя считалась при склейке рядов
+	DurationSeconds float64
+}
+
+// Add - сумма значений двух частей одного интервала. уникальные складываются,
+// поэтому склеивать можно только части, где уникальные не запрашивались или одна из частей пустая
+func (r TimeseriesRow) Add(o TimeseriesRow) TimeseriesRow {
+	r.Visits += o.Visits
+	r.Uniques += o.Uniques
+	r.Pageviews += o.Pageviews
+	r.Events += o.Events
+	r.Conversions += o.Conversions
+	r.DurationSeconds += o.DurationSeconds
+	r.AvgDuration = 0
+	if r.Visits > 0 {
+		r.AvgDuration = r.DurationSeconds / float64(r.Visits)
+	}
+	return r
+}
+
+// MergeTimeseriesRows - склеивает ряд из роллапов и продолжение из сырых данных,
+// интервал на стыке складывается
+func MergeTimeseriesRows(head []TimeseriesRow, tail []TimeseriesRow) []TimeseriesRow {
+	rows := append([]TimeseriesRow{}, head...)
+	for _, r := range tail {
+		if n := len(rows); n > 0 && rows[n-1].Bucket.Equal(r.Bucket) {
+			rows[n-1] = rows[n-1].Add(r)
+			continue
+		}
+		rows = append(rows, r)
+	}
+	return rows
+}
+
+func (r TimeseriesRow) values(opts TimeseriesOptions) map[string]float64 {
+	all := map[string]float64{
+		MetricVisits:      float64(r.Visits),
+		MetricUniques:     float64(r.Uniques),
+		MetricPageviews:   float64(r.Pageviews),
+		MetricEvents:      float64(r.Events),
+		MetricConversions: float64(r.Conversions),
+		MetricAvgDuration: r.AvgDuration,
+	}
+
+	values := make(map[string]float64, len(opts.Metrics))
+	for _, m := range opts.Metrics {
+		values[m] = all[m]
+	}
+	return values
+}
+
+type TimeseriesPoint struct {
+	Time   time.Time          `json:"time"`
+	Values map[string]float64 `json:"values"`
+}
+
+type TimeseriesPeriod struct {
+	StartDate time.Time         `json:"start_date"`
+	EndDate   time.Time         `json:"end_date"`
+	Points    []TimeseriesPoint `json:"points"`
+	// итоги за период. uniques и avg_duration считаются по всему периоду, а не суммой точек
+	Totals map[string]float64 `json:"totals"`
+}
+
+type TimeseriesReport struct {
+	Metrics     []string         `json:"metrics"`
+	Granularity string           `json:"granularity"`
+	Timezone    string           `json:"timezone"`
+	Current     TimeseriesPeriod `json:"current"`
+	// предыдущий период, точки идут в том же порядке, что и в текущем
+	Previous *TimeseriesPeriod `json:"previous,omitempty"`
+	// относительное изменение итогов к предыдущему периоду, null если в предыдущем был ноль
+	Change map[string]*float64 `json:"change,omitempty"`
+}
+
+func BuildTimeseriesPeriod(opts TimeseriesOptions, rows []TimeseriesRow, totals TimeseriesRow) TimeseriesPeriod {
+	period := TimeseriesPeriod{
+		StartDate: opts.StartDate,
+		EndDate:   opts.EndDate,
+		Points:    make([]TimeseriesPoint, 0, len(rows)),
+		Totals:    totals.values(opts),
+	}
+	for _, r := range rows {
+		period.Points = append(period.Points, TimeseriesPoint{Time: r.Bucket, Values: r.values(opts)})
+	}
+	return period
+}
+
+// BuildTimeseriesReport - previous nil, если сравнение не запрашивалось
+func BuildTimeseriesReport(opts TimeseriesOptions, current TimeseriesPeriod, previous *TimeseriesPeriod) TimeseriesReport {
+	report := TimeseriesReport{
+		Metrics:     opts.Metrics,
+		Granularity: opts.Granularity,
+		Timezone:    opts.Timezone,
+		Current:     current,
+		Previous:    previous,
+	}
+
+	if previous == nil {
+		return report
+	}
+
+	report.Change = make(map[string]*float64, len(opts.Metrics))
+	for _, m := range opts.Metrics {
+		prev := previous.Totals[m]
+		if prev == 0 {
+			report.Change[m] = nil
+			continue
+		}
+		change := (current.Totals[m] - prev) / prev
+		report.Change[m] = &change
+	}
+
+	return report
+}
@@ -0,0 +1,7 @@
+package tx
+
+import "errors"
+
+// ErrRejected - хранилище отвергло сами данные(нарушение ограничений, невалидные значения),
+// повтор той же транзакции не поможет. обрыв соединения и таймауты так не помечаются
+var ErrRejected = errors.New("rejected by storage")
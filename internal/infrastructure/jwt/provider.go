@@ -20,6 +20,9 @@ var (
 	RefreshTokenMaxAge = int(RefreshTokenTTL.Seconds())
 	AccessTokenJson    = "access_token"
 	RefreshTokenJson   = "refresh_token"
+	//тикет только открывает соединение живой ленты, поэтому живет секунды
+	StreamTicketTTL      = time.Second * 30
+	streamTicketAudience = "stream"
 )
 
 func NewJwtProvider(jwt_secret string) *JWTProvider {
@@ -70,7 +73,55 @@ func (p *JWTProvider) GeneratePair(email string, userID uint, session_id uint) (
 	return &domain.Tokens{Access: access, Refresh: refresh}, nil
 }
 
+// GenerateStreamTicket - короткий тикет для EventSource и WebSocket, которые не умеют ставить заголовки.
+// тикет привязан к пути стрима и не принимается как access токен
+func (p *JWTProvider) GenerateStreamTicket(claims *domain.JWTClaims, path string) (string, error) {
+	ticket := domain.JWTClaims{
+		UserID:    claims.UserID,
+		Email:     claims.Email,
+		SessionID: claims.SessionID,
+		RegisteredClaims: jwt.RegisteredClaims{
+			Subject:   path,
+			Audience:  jwt.ClaimStrings{streamTicketAudience},
+			ExpiresAt: jwt.NewNumericDate(time.Now().Add(StreamTicketTTL)),
+			IssuedAt:  jwt.NewNumericDate(time.Now()),
+			NotBefore: jwt.NewNumericDate(time.Now()),
+		},
+	}
+
+	token := jwt.NewWithClaims(jwt.SigningMethodHS256, ticket)
+	return token.SignedString([]byte(p.jwt_secret))
+}
+
+// ValidateStreamTicket - проверяет тикет стрима, выданный для пути path
+func (p JWTProvider) ValidateStreamTicket(ticket string, path string) (*domain.JWTClaims, error) {
+	claims, err := p.parse(ticket, jwt.WithAudience(streamTicketAudience))
+	if err != nil {
+		return nil, err
+	}
+
+	if claims.Subject != path {
+		return nil, fmt.Errorf("ticket issued for another stream")
+	}
+
+	return claims, nil
+}
+
 func (p JWTProvider) Validate(tokenString string) (*domain.JWTClaims, error) {
+	claims, err := p.parse(tokenString)
+	if err != nil {
+		return nil, err
+	}
+
+	//у access токена нет audience, тикеты стримов сюда не проходят
+	if len(claims.Audience) > 0 {
+		return nil, fmt.Errorf("token is not an access token")
+	}
+
+	return claims, nil
+}
+
+func (p JWTProvider) parse(tokenString string, opts ...jwt.ParserOption) (*domain.JWTClaims, error) {
 	if tokenString == "" || p.jwt_secret == "" {
 		return nil, fmt.Errorf("empty token or secret")
 	}
@@ -81,7 +132,7 @@ func (p JWTProvider) Validate(tokenString string) (*domain.JWTClaims, error) {
 			return nil, fmt.Errorf("unexpected signing method: %v", token.Header["alg"])
 		}
 		return []byte(p.jwt_secret), nil
-	})
+	}, opts...)
 
 	if err != nil {
 		return nil, fmt.Errorf("failed to parse token: %w", err)
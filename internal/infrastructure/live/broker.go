@@ -0,0 +1,389 @@
+package live
+
+import (
+	"context"
+	"encoding/json"
+	"log/slog"
+	domain "metrika/internal/domain/analytics"
+	"metrika/internal/infrastructure/pubsub"
+	"metrika/pkg/logger/sl"
+	"sync"
+	"sync/atomic"
+	"time"
+)
+
+const (
+	//очередь исходящих сообщений в шину, при переполнении выбрасываются самые старые
+	outboxSize = 4096
+	//как часто накопленные приращения счетчиков уходят в шину одним сообщением на домен
+	countersFlushInterval = 250 * time.Millisecond
+	publishTimeout        = 5 * time.Second
+	//сколько помнить отметки активности сессий
+	touchesTTL = 10 * time.Minute
+)
+
+// envelope - сообщение шины: сообщение ленты, приращение счетчика или отметка активности сессий
+type envelope struct {
+	DomainID uint                `json:"domain_id"`
+	Message  *domain.LiveMessage `json:"message,omitempty"`
+	Counter  string              `json:"counter,omitempty"`
+	Delta    int64               `json:"delta,omitempty"`
+	Sessions []uint              `json:"sessions,omitempty"`
+}
+
+// Broker - живая лента поверх pubsub. Publish, Count и Touch уходят в шину и доходят до всех инстансов,
+// каждый инстанс раздает полученное своим подписчикам. Deliver - только локальным подписчикам,
+// для агрегатов, которые каждый инстанс считает сам
+type Broker struct {
+	log     *slog.Logger
+	bus     pubsub.PubSub
+	channel string
+	sub     pubsub.Subscription
+
+	mu     sync.RWMutex
+	subs   map[uint]map[*subscription]struct{}
+	buffer int
+
+	countersMu sync.Mutex
+	counters   map[string]map[uint]int64
+	pending    map[string]map[uint]int64
+
+	//отметки активности: еще не отправленные в шину и полученные со всех инстансов, domain_id -> session_id
+	touchesMu      sync.Mutex
+	pendingTouches map[uint]map[uint]struct{}
+	touches        map[uint]map[uint]time.Time
+	lastSweep      time.Time
+
+	outbox chan envelope
+	done   chan struct{}
+	wg     sync.WaitGroup
+}
+
+func NewBroker(log *slog.Logger, bus pubsub.PubSub, channelPrefix string, buffer int) (*Broker, error) {
+	if buffer <= 0 {
+		buffer = 1
+	}
+
+	b := &Broker{
+		log:      log.With(slog.String("component", "infrastructure/live/broker")),
+		bus:      bus,
+		channel:  channelPrefix + ":live",
+		subs:     make(map[uint]map[*subscription]struct{}),
+		buffer:   buffer,
+		counters: make(map[string]map[uint]int64),
+		pending:  make(map[string]map[uint]int64),
+		outbox:   make(chan envelope, outboxSize),
+		done:     make(chan struct{}),
+
+		pendingTouches: make(map[uint]map[uint]struct{}),
+		touches:        make(map[uint]map[uint]time.Time),
+		lastSweep:      time.Now(),
+	}
+
+	sub, err := bus.Subscribe(context.Background(), b.channel)
+	if err != nil {
+		return nil, err
+	}
+	b.sub = sub
+
+	b.wg.Add(2)
+	go b.receive()
+	go b.send()
+
+	return b, nil
+}
+
+func (b *Broker) Publish(domain_id uint, msg domain.LiveMessage) {
+	b.enqueue(envelope{DomainID: domain_id, Message: &msg})
+}
+
+func (b *Broker) Deliver(domain_id uint, msg domain.LiveMessage) {
+	b.mu.RLock()
+	defer b.mu.RUnlock()
+
+	for sub := range b.subs[domain_id] {
+		sub.push(msg)
+	}
+}
+
+func (b *Broker) Count(domain_id uint, counter string, delta int64) {
+	b.countersMu.Lock()
+	defer b.countersMu.Unlock()
+
+	if b.pending[counter] == nil {
+		b.pending[counter] = make(map[uint]int64)
+	}
+	b.pending[counter][domain_id] += delta
+}
+
+// TakeCounter - сумма приращений счетчика со всех инстансов с прошлого вызова
+func (b *Broker) TakeCounter(counter string) map[uint]int64 {
+	b.countersMu.Lock()
+	defer b.countersMu.Unlock()
+
+	counts := b.counters[counter]
+	delete(b.counters, counter)
+	return counts
+}
+
+func (b *Broker) Touch(domain_id uint, session_ids []uint) {
+	b.touchesMu.Lock()
+	defer b.touchesMu.Unlock()
+
+	if b.pendingTouches[domain_id] == nil {
+		b.pendingTouches[domain_id] = make(map[uint]struct{})
+	}
+	for _, id := range session_ids {
+		b.pendingTouches[domain_id][id] = struct{}{}
+	}
+}
+
+func (b *Broker) Active(domain_id uint, since time.Time) int64 {
+	b.touchesMu.Lock()
+	defer b.touchesMu.Unlock()
+
+	var active int64
+	for _, at := range b.touches[domain_id] {
+		if at.After(since) {
+			active++
+		}
+	}
+	return active
+}
+
+func (b *Broker) Subscribe(domain_id uint) domain.LiveSubscription {
+	sub := &subscription{
+		ch:       make(chan domain.LiveMessage, b.buffer),
+		broker:   b,
+		domainID: domain_id,
+	}
+
+	b.mu.Lock()
+	defer b.mu.Unlock()
+
+	if b.subs[domain_id] == nil {
+		b.subs[domain_id] = make(map[*subscription]struct{})
+	}
+	b.subs[domain_id][sub] = struct{}{}
+
+	return sub
+}
+
+func (b *Broker) Domains() []uint {
+	b.mu.RLock()
+	defer b.mu.RUnlock()
+
+	domains := make([]uint, 0, len(b.subs))
+	for id := range b.subs {
+		domains = append(domains, id)
+	}
+	return domains
+}
+
+// Close - досылает накопленные счетчики и отписывается от шины. саму шину закрывает владелец
+func (b *Broker) Close() error {
+	close(b.done)
+	err := b.sub.Close()
+	b.wg.Wait()
+	return err
+}
+
+func (b *Broker) enqueue(env envelope) {
+	for {
+		select {
+		case b.outbox <- env:
+			return
+		default:
+		}
+
+		select {
+		case <-b.outbox:
+		default:
+		}
+	}
+}
+
+// send - публикует очередь в шину, чтобы сетевой вызов не тормозил прием ивентов
+func (b *Broker) send() {
+	defer b.wg.Done()
+
+	ticker := time.NewTicker(countersFlushInterval)
+	defer ticker.Stop()
+
+	failing := false
+	publish := func(env envelope) {
+		payload, err := json.Marshal(env)
+		if err != nil {
+			b.log.Error("ошибка сериализации сообщения живой ленты", sl.Err(err))
+			return
+		}
+
+		ctx, cancel := context.WithTimeout(context.Background(), publishTimeout)
+		defer cancel()
+
+		//пока шина недоступна, пишем в лог только первую ошибку
+		if err := b.bus.Publish(ctx, b.channel, payload); err != nil {
+			if !failing {
+				b.log.Error("ошибка публикации в pubsub", sl.Err(err))
+			}
+			failing = true
+			return
+		}
+		if failing {
+			b.log.Info("публикация в pubsub восстановлена")
+		}
+		failing = false
+	}
+
+	for {
+		select {
+		case env := <-b.outbox:
+			publish(env)
+		case <-ticker.C:
+			for _, env := range b.takePending() {
+				publish(env)
+			}
+		case <-b.done:
+			for _, env := range b.takePending() {
+				publish(env)
+			}
+			return
+		}
+	}
+}
+
+func (b *Broker) takePending() []envelope {
+	var envs []envelope
+
+	b.countersMu.Lock()
+	for counter, domains := range b.pending {
+		for domain_id, delta := range domains {
+			envs = append(envs, envelope{DomainID: domain_id, Counter: counter, Delta: delta})
+		}
+	}
+	b.pending = make(map[string]map[uint]int64)
+	b.countersMu.Unlock()
+
+	b.touchesMu.Lock()
+	for domain_id, sessions := range b.pendingTouches {
+		ids := make([]uint, 0, len(sessions))
+		for id := range sessions {
+			ids = append(ids, id)
+		}
+		envs = append(envs, envelope{DomainID: domain_id, Sessions: ids})
+	}
+	b.pendingTouches = make(map[uint]map[uint]struct{})
+	b.touchesMu.Unlock()
+
+	return envs
+}
+
+// touch - запоминает активность сессий со всех инстансов и забывает отметки старше touchesTTL
+func (b *Broker) touch(domain_id uint, session_ids []uint) {
+	b.touchesMu.Lock()
+	defer b.touchesMu.Unlock()
+
+	now := time.Now()
+	if b.touches[domain_id] == nil {
+		b.touches[domain_id] = make(map[uint]time.Time)
+	}
+	for _, id := range session_ids {
+		b.touches[domain_id][id] = now
+	}
+
+	if now.Sub(b.lastSweep) < touchesTTL {
+		return
+	}
+	b.lastSweep = now
+	for domain_id, sessions := range b.touches {
+		for id, at := range sessions {
+			if now.Sub(at) > touchesTTL {
+				delete(sessions, id)
+			}
+		}
+		if len(sessions) == 0 {
+			delete(b.touches, domain_id)
+		}
+	}
+}
+
+// receive - раздает сообщения шины локальным подписчикам и суммирует счетчики
+func (b *Broker) receive() {
+	defer b.wg.Done()
+
+	for msg := range b.sub.Messages() {
+		var env envelope
+		if err := json.Unmarshal(msg.Payload, &env); err != nil {
+			b.log.Warn("некорректное сообщение живой ленты в pubsub", sl.Err(err))
+			continue
+		}
+
+		if env.Counter != "" {
+			b.countersMu.Lock()
+			if b.counters[env.Counter] == nil {
+				b.counters[env.Counter] = make(map[uint]int64)
+			}
+			b.counters[env.Counter][env.DomainID] += env.Delta
+			b.countersMu.Unlock()
+			continue
+		}
+
+		if len(env.Sessions) > 0 {
+			b.touch(env.DomainID, env.Sessions)
+			continue
+		}
+
+		if env.Message != nil {
+			b.Deliver(env.DomainID, *env.Message)
+		}
+	}
+}
+
+func (b *Broker) unsubscribe(sub *subscription) {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+
+	delete(b.subs[sub.domainID], sub)
+	if len(b.subs[sub.domainID]) == 0 {
+		delete(b.subs, sub.domainID)
+	}
+	//Deliver держит RLock, поэтому после удаления из map в канал уже никто не пишет
+	close(sub.ch)
+}
+
+type subscription struct {
+	ch        chan domain.LiveMessage
+	dropped   atomic.Uint64
+	broker    *Broker
+	domainID  uint
+	closeOnce sync.Once
+}
+
+// push - не блокирует издателя: если буфер полон, выбрасывает самое старое сообщение
+func (s *subscription) push(msg domain.LiveMessage) {
+	for {
+		select {
+		case s.ch <- msg:
+			return
+		default:
+		}
+
+		select {
+		case <-s.ch:
+			s.dropped.Add(1)
+		default:
+		}
+	}
+}
+
+func (s *subscription) Messages() <-chan domain.LiveMessage {
+	return s.ch
+}
+
+func (s *subscription) Dropped() uint64 {
+	return s.dropped.Load()
+}
+
+func (s *subscription) Close() {
+	s.closeOnce.Do(func() { s.broker.unsubscribe(s) })
+}
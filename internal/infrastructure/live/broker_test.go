@@ -0,0 +1,107 @@
+package live
+
+import (
+	"io"
+	"log/slog"
+	domain "metrika/internal/domain/analytics"
+	"metrika/internal/infrastructure/pubsub"
+	"testing"
+	"time"
+)
+
+// два брокера на одной шине ведут себя как два инстанса
+func newInstances(t *testing.T) (*Broker, *Broker) {
+	t.Helper()
+
+	bus := pubsub.NewMemory(64)
+	log := slog.New(slog.NewTextHandler(io.Discard, nil))
+
+	a, err := NewBroker(log, bus, "test", 8)
+	if err != nil {
+		t.Fatalf("broker a: %v", err)
+	}
+	b, err := NewBroker(log, bus, "test", 8)
+	if err != nil {
+		t.Fatalf("broker b: %v", err)
+	}
+	t.Cleanup(func() {
+		a.Close()
+		b.Close()
+		bus.Close()
+	})
+
+	return a, b
+}
+
+func waitFor(t *testing.T, what string, cond func() bool) {
+	t.Helper()
+
+	deadline := time.Now().Add(5 * time.Second)
+	for !cond() {
+		if time.Now().After(deadline) {
+			t.Fatalf("timed out waiting for %s", what)
+		}
+		time.Sleep(10 * time.Millisecond)
+	}
+}
+
+func TestBrokerPublishReachesOtherInstance(t *testing.T) {
+	a, b := newInstances(t)
+
+	sub := b.Subscribe(1)
+	defer sub.Close()
+	other := b.Subscribe(2)
+	defer other.Close()
+
+	a.Publish(1, domain.LiveMessage{Type: domain.LiveSession, DomainID: 1})
+
+	select {
+	case msg := <-sub.Messages():
+		if msg.Type != domain.LiveSession || msg.DomainID != 1 {
+			t.Fatalf("got %+v", msg)
+		}
+	case <-time.After(5 * time.Second):
+		t.Fatal("message did not reach the other instance")
+	}
+
+	select {
+	case msg := <-other.Messages():
+		t.Fatalf("subscriber of another domain got %+v", msg)
+	default:
+	}
+}
+
+func TestBrokerCountersSumAcrossInstances(t *testing.T) {
+	a, b := newInstances(t)
+
+	a.Count(1, domain.LivePageviews, 2)
+	b.Count(1, domain.LivePageviews, 3)
+	b.Count(2, domain.LivePageviews, 1)
+
+	got := make(map[uint]int64)
+	waitFor(t, "counters", func() bool {
+		for domain_id, n := range a.TakeCounter(domain.LivePageviews) {
+			got[domain_id] += n
+		}
+		return got[1] == 5 && got[2] == 1
+	})
+}
+
+func TestBrokerTouchesAcrossInstances(t *testing.T) {
+	a, b := newInstances(t)
+	since := time.Now()
+
+	a.Touch(1, []uint{10, 11})
+	b.Touch(1, []uint{11, 12})
+	b.Touch(2, []uint{20})
+
+	for _, broker := range []*Broker{a, b} {
+		waitFor(t, "touches", func() bool {
+			return broker.Active(1, since) == 3 && broker.Active(2, since) == 1
+		})
+	}
+
+	if active := a.Active(1, time.Now()); active != 0 {
+		t.Fatalf("active after now = %d, want 0", active)
+	}
+}
@@ -169,14 +169,17 @@ func (m MockService) StartEventsGenerator() {
 		case <-ticker.C:
 			//генерируем размер пачки
 			bucketSize := m.generator.GenerateBucketSize(m.mcfg.MinEventInLoop, m.mcfg.MaxEventInLoop)
+			events := make([]domain.Event, 0, bucketSize)
 			for range bucketSize {
 				//отбираем случайный id сессии
 				sessionId := rand.Intn(int(m.mcfg.MaxMockUsersInDomain))
 				//генерируем ивент
-				event := m.generator.GenerateMockEvent(uint(sessionId))
+				events = append(events, *m.generator.GenerateMockEvent(uint(sessionId)))
+			}
 
-				//отправляем на обработку
-				go m.tracker.TrackEvent(*event)
+			//отправляем на обработку
+			if err := m.tracker.TrackEvents(context.Background(), events); err != nil {
+				m.log.Error("ошибка отправки моковых ивентов в трекер", sl.Err(err))
 			}
 		case <-m.closeChan:
 			return
@@ -3,6 +3,7 @@ package postgres
 import (
 	"fmt"
 	"metrika/internal/config"
+	domain "metrika/internal/domain/analytics"
 
 	"gorm.io/driver/postgres"
 	"gorm.io/gorm"
@@ -25,8 +26,42 @@ func New(cfg *config.Config) (*gorm.DB, error) {
 		return nil, fmt.Errorf("%s: %w", fn, err)
 	}
 
-	//миграции
-	GormDB.AutoMigrate(&Event{}, &User{}, &Guest{}, &GuestSession{}, &UserSession{}, &Domain{}, &RecordEvent{})
+	if err := migrate(GormDB); err != nil {
+		return nil, fmt.Errorf("%s: %w", fn, err)
+	}
 
 	return GormDB, err
 }
+
+// migrate - миграции схемы
+func migrate(db *gorm.DB) error {
+	db.AutoMigrate(&Event{}, &User{}, &Guest{}, &GuestSession{}, &UserSession{}, &Domain{}, &RecordEvent{}, &RecordChunk{}, &DomainMember{}, &DomainInvitation{}, &Goal{}, &Conversion{}, &Funnel{}, &EventCatalog{}, &RollupStat{}, &RollupPage{}, &RollupSource{}, &RollupState{})
+
+	if err := backfillSiteKeys(db); err != nil {
+		return err
+	}
+
+	//ивенты, сессии и записи хранятся в помесячных партициях, см. partitions.go
+	return migratePartitions(db)
+}
+
+// backfillSiteKeys - выдает ключ сайта доменам, созданным до появления ключей,
+// иначе их трекер не сможет отправлять ивенты
+func backfillSiteKeys(db *gorm.DB) error {
+	var ids []uint
+	if err := db.Model(&Domain{}).Where("site_key IS NULL").Pluck("id", &ids).Error; err != nil {
+		return err
+	}
+
+	for _, id := range ids {
+		site_key, err := domain.GenerateSiteKey()
+		if err != nil {
+			return err
+		}
+		if err := db.Model(&Domain{}).Where("id = ? AND site_key IS NULL", id).Update("site_key", site_key).Error; err != nil {
+			return err
+		}
+	}
+
+	return nil
+}
@@ -2,6 +2,7 @@ package postgres
 
 import (
 	"context"
+	"encoding/json"
 	"errors"
 	domain "metrika/internal/domain/analytics"
 
@@ -16,6 +17,162 @@ func NewDomainRepository(db *gorm.DB) *DomainRepository {
 	return &DomainRepository{db}
 }
 
+func (d *DomainRepository) ByID(ctx context.Context, domain_id uint) (*domain.Domain, error) {
+	db := getDB(ctx, d.db)
+
+	var mdomain Domain
+
+	if err := db.Model(Domain{}).Where("id = ?", domain_id).First(&mdomain).Error; err != nil {
+		if errors.Is(err, gorm.ErrRecordNotFound) {
+			return nil, domain.ErrDomainNotFound
+		}
+		return nil, err
+	}
+
+	return mdomain.ToDomain(), nil
+}
+
+func (d *DomainRepository) ByUser(ctx context.Context, user_id uint) ([]domain.Domain, error) {
+	db := getDB(ctx, d.db)
+
+	var mDomains []Domain
+
+	if err := db.Model(Domain{}).
+		Where("owner_id = ? OR id IN (?)", user_id, db.Model(&DomainMember{}).Select("domain_id").Where("user_id = ?", user_id)).
+		Order("id ASC").
+		Find(&mDomains).Error; err != nil {
+		return nil, err
+	}
+
+	domains := make([]domain.Domain, 0, len(mDomains))
+	for _, mdomain := range mDomains {
+		domains = append(domains, *mdomain.ToDomain())
+	}
+
+	return domains, nil
+}
+
+func (d *DomainRepository) Create(ctx context.Context, dom *domain.Domain) error {
+	db := getDB(ctx, d.db)
+
+	mdomain := Domain{
+		OwnerID: &dom.OwnerID,
+		Name:    dom.Name,
+		SiteURL: dom.SiteURL,
+		SiteKey: &dom.SiteKey,
+
+		SessionTimeout:  dom.SessionSettings.TimeoutMinutes,
+		Timezone:        dom.SessionSettings.Timezone,
+		SplitAtMidnight: dom.SessionSettings.SplitAtMidnight,
+		SplitOnCampaign: dom.SessionSettings.SplitOnCampaignChange,
+
+		RetentionEventsDays:   dom.Retention.EventsDays,
+		RetentionSessionsDays: dom.Retention.SessionsDays,
+		RetentionReplaysDays:  dom.Retention.ReplaysDays,
+		RetentionRollupsDays:  dom.Retention.RollupsDays,
+	}
+
+	if err := db.Model(&Domain{}).Create(&mdomain).Error; err != nil {
+		if errors.Is(err, gorm.ErrDuplicatedKey) {
+			return domain.ErrDomainAlreadyExists
+		}
+		return err
+	}
+
+	dom.ID = mdomain.ID
+	dom.CreatedAt = mdomain.CreatedAt
+
+	return nil
+}
+
+func (d *DomainRepository) Rename(ctx context.Context, domain_id uint, name string) error {
+	db := getDB(ctx, d.db)
+
+	res := db.Model(&Domain{}).Where("id = ?", domain_id).Update("name", name)
+	if res.Error != nil {
+		return res.Error
+	}
+	if res.RowsAffected == 0 {
+		return domain.ErrDomainNotFound
+	}
+
+	return nil
+}
+
+func (d *DomainRepository) UpdateSiteKey(ctx context.Context, domain_id uint, site_key string) error {
+	db := getDB(ctx, d.db)
+
+	res := db.Model(&Domain{}).Where("id = ?", domain_id).Update("site_key", site_key)
+	if res.Error != nil {
+		return res.Error
+	}
+	if res.RowsAffected == 0 {
+		return domain.ErrDomainNotFound
+	}
+
+	return nil
+}
+
+// Delete - удаляет домен вместе со всеми гостями, сессиями и ивентами
+func (d *DomainRepository) Delete(ctx context.Context, domain_id uint) error {
+	db := getDB(ctx, d.db)
+
+	sessions := db.Table("guest_sessions s").
+		Select("s.id").
+		Joins("JOIN guests g ON g.id = s.guest_id").
+		Where("g.domain_id = ?", domain_id)
+
+	if err := db.Where("session_id IN (?)", sessions).Delete(&Event{}).Error; err != nil {
+		return err
+	}
+	if err := db.Where("session_id IN (?)", sessions).Delete(&Conversion{}).Error; err != nil {
+		return err
+	}
+	if err := db.Where("session_id IN (?)", sessions).Delete(&RecordChunk{}).Error; err != nil {
+		return err
+	}
+	if err := db.Where("session_id IN (?)", sessions).Delete(&RecordEvent{}).Error; err != nil {
+		return err
+	}
+	if err := db.Where("guest_id IN (?)", db.Model(&Guest{}).Select("id").Where("domain_id = ?", domain_id)).
+		Delete(&GuestSession{}).Error; err != nil {
+		return err
+	}
+	if err := db.Where("domain_id = ?", domain_id).Delete(&Guest{}).Error; err != nil {
+		return err
+	}
+	if err := db.Where("domain_id = ?", domain_id).Delete(&DomainMember{}).Error; err != nil {
+		return err
+	}
+	if err := db.Where("domain_id = ?", domain_id).Delete(&DomainInvitation{}).Error; err != nil {
+		return err
+	}
+	if err := db.Where("domain_id = ?", domain_id).Delete(&Goal{}).Error; err != nil {
+		return err
+	}
+	if err := db.Where("domain_id = ?", domain_id).Delete(&Funnel{}).Error; err != nil {
+		return err
+	}
+	if err := db.Where("domain_id = ?", domain_id).Delete(&EventCatalog{}).Error; err != nil {
+		return err
+	}
+	for _, rollup := range []interface{}{&RollupStat{}, &RollupPage{}, &RollupSource{}, &RollupState{}} {
+		if err := db.Where("domain_id = ?", domain_id).Delete(rollup).Error; err != nil {
+			return err
+		}
+	}
+
+	res := db.Where("id = ?", domain_id).Delete(&Domain{})
+	if res.Error != nil {
+		return res.Error
+	}
+	if res.RowsAffected == 0 {
+		return domain.ErrDomainNotFound
+	}
+
+	return nil
+}
+
 func (d *DomainRepository) ByURL(ctx context.Context, url string) (*domain.Domain, error) {
 	db := getDB(ctx, d.db)
 
@@ -28,23 +185,146 @@ func (d *DomainRepository) ByURL(ctx context.Context, url string) (*domain.Domai
 		return nil, err
 	}
 
-	return &domain.Domain{ID: mdomain.ID, SiteURL: mdomain.SiteURL}, nil
+	return mdomain.ToDomain(), nil
+}
+
+func (d *DomainRepository) BySiteKey(ctx context.Context, site_key string) (*domain.Domain, error) {
+	db := getDB(ctx, d.db)
+
+	var mdomain Domain
+
+	if err := db.Model(Domain{}).Where("site_key = ?", site_key).First(&mdomain).Error; err != nil {
+		if errors.Is(err, gorm.ErrRecordNotFound) {
+			return nil, domain.ErrDomainNotFound
+		}
+		return nil, err
+	}
+
+	return mdomain.ToDomain(), nil
+}
+
+func (d *DomainRepository) BySessionID(ctx context.Context, session_id uint) (*domain.Domain, error) {
+	db := getDB(ctx, d.db)
+
+	var mdomain Domain
+
+	if err := db.Model(Domain{}).
+		Joins("JOIN guests g ON g.domain_id = domains.id").
+		Joins("JOIN guest_sessions s ON s.guest_id = g.id").
+		Where("s.id = ?", session_id).
+		First(&mdomain).Error; err != nil {
+		if errors.Is(err, gorm.ErrRecordNotFound) {
+			return nil, domain.ErrDomainNotFound
+		}
+		return nil, err
+	}
+
+	return mdomain.ToDomain(), nil
+}
+
+// MaskingRules - сохраненные правила маскирования домена, nil если домен их не настраивал
+func (d *DomainRepository) MaskingRules(ctx context.Context, domain_id uint) (*domain.MaskingRules, error) {
+	db := getDB(ctx, d.db)
+
+	var mdomain Domain
+
+	if err := db.Model(Domain{}).Select("id, masking_rules").Where("id = ?", domain_id).First(&mdomain).Error; err != nil {
+		if errors.Is(err, gorm.ErrRecordNotFound) {
+			return nil, domain.ErrDomainNotFound
+		}
+		return nil, err
+	}
+
+	if mdomain.MaskingRules == nil {
+		return nil, nil
+	}
+
+	var rules domain.MaskingRules
+	if err := json.Unmarshal([]byte(*mdomain.MaskingRules), &rules); err != nil {
+		return nil, err
+	}
+
+	return &rules, nil
+}
+
+func (d *DomainRepository) SetMaskingRules(ctx context.Context, domain_id uint, rules domain.MaskingRules) error {
+	db := getDB(ctx, d.db)
+
+	raw, err := json.Marshal(rules)
+	if err != nil {
+		return err
+	}
+
+	res := db.Model(&Domain{}).Where("id = ?", domain_id).Update("masking_rules", string(raw))
+	if res.Error != nil {
+		return res.Error
+	}
+	if res.RowsAffected == 0 {
+		return domain.ErrDomainNotFound
+	}
+
+	return nil
+}
+
+func (d *DomainRepository) SetSessionSettings(ctx context.Context, domain_id uint, settings domain.SessionSettings) error {
+	db := getDB(ctx, d.db)
+
+	res := db.Model(&Domain{}).Where("id = ?", domain_id).Updates(map[string]interface{}{
+		"session_timeout":   settings.TimeoutMinutes,
+		"timezone":          settings.Timezone,
+		"split_at_midnight": settings.SplitAtMidnight,
+		"split_on_campaign": settings.SplitOnCampaignChange,
+	})
+	if res.Error != nil {
+		return res.Error
+	}
+	if res.RowsAffected == 0 {
+		return domain.ErrDomainNotFound
+	}
+
+	return nil
+}
+
+func (d *DomainRepository) SetRetentionSettings(ctx context.Context, domain_id uint, settings domain.RetentionSettings) error {
+	db := getDB(ctx, d.db)
+
+	res := db.Model(&Domain{}).Where("id = ?", domain_id).Updates(map[string]interface{}{
+		"retention_events_days":   settings.EventsDays,
+		"retention_sessions_days": settings.SessionsDays,
+		"retention_replays_days":  settings.ReplaysDays,
+		"retention_rollups_days":  settings.RollupsDays,
+	})
+	if res.Error != nil {
+		return res.Error
+	}
+	if res.RowsAffected == 0 {
+		return domain.ErrDomainNotFound
+	}
+
+	return nil
 }
 
 func (d *DomainRepository) AddDomain(ctx context.Context, site_url string) (*domain.Domain, error) {
 	db := getDB(ctx, d.db)
 
+	site_key, err := domain.GenerateSiteKey()
+	if err != nil {
+		return nil, err
+	}
+
 	dom := Domain{
 		SiteURL: site_url,
+		SiteKey: &site_key,
 	}
 
 	if err := db.Model(&Domain{}).Create(&dom).Error; err != nil {
-		if errors.Is(err, gorm.ErrRecordNotFound) {
+		if errors.Is(err, gorm.ErrDuplicatedKey) {
 			return nil, domain.ErrDomainAlreadyExists
 		}
+		return nil, err
 	}
 
-	return &domain.Domain{SiteURL: site_url, ID: dom.ID}, nil
+	return dom.ToDomain(), nil
 }
 
 func (d *DomainRepository) GetDomainGuests(ctx context.Context, domainId uint) (*[]domain.Guest, error) {
@@ -10,6 +10,7 @@ type GuestDTO struct {
 	DomainID           uint      `gorm:"column:domain_id;NOT NULL"`
 	Fingerprint        string    `gorm:"column:f_id"`
 	TotalSecondsOnSite string    `gorm:"column:total_seconds_on_site"`
+	EngagedSeconds     float64   `gorm:"column:engaged_seconds"`
 	FirstVisit         time.Time `gorm:"column:first_visit"`
 	LastVisit          time.Time `gorm:"column:last_visit"`
 	SessionsCount      int       `gorm:"column:sessions_count"`
@@ -22,9 +23,36 @@ func (d GuestDTO) ToDomain() analytics.Guest {
 		DomainID:           d.DomainID,
 		Fingerprint:        d.Fingerprint,
 		TotalSecondsOnSite: d.TotalSecondsOnSite,
+		EngagedSeconds:     d.EngagedSeconds,
 		LastVisit:          d.LastVisit,
 		FirstVisit:         d.FirstVisit,
 		IsOnline:           d.IsOnline,
 		SessionsCount:      d.SessionsCount,
 	}
 }
+
+type PageStatsDTO struct {
+	Path           string  `gorm:"column:path"`
+	Title          string  `gorm:"column:title"`
+	Views          int64   `gorm:"column:views"`
+	UniqueGuests   int64   `gorm:"column:unique_guests"`
+	AvgTimeOnPage  float64 `gorm:"column:avg_time_on_page"`
+	AvgEngagedTime float64 `gorm:"column:avg_engaged_time"`
+	Entrances      int64   `gorm:"column:entrances"`
+	Exits          int64   `gorm:"column:exits"`
+	BounceRate     float64 `gorm:"column:bounce_rate"`
+}
+
+func (d PageStatsDTO) ToDomain() analytics.PageStats {
+	return analytics.PageStats{
+		Path:           d.Path,
+		Title:          d.Title,
+		Views:          d.Views,
+		UniqueGuests:   d.UniqueGuests,
+		AvgTimeOnPage:  d.AvgTimeOnPage,
+		AvgEngagedTime: d.AvgEngagedTime,
+		Entrances:      d.Entrances,
+		Exits:          d.Exits,
+		BounceRate:     d.BounceRate,
+	}
+}
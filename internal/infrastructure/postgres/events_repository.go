@@ -2,7 +2,10 @@ package postgres
 
 import (
 	"context"
+	"encoding/json"
+	"fmt"
 	domain "metrika/internal/domain/analytics"
+	"strings"
 	"time"
 
 	"gorm.io/gorm"
@@ -35,14 +38,561 @@ func (d *EventsRepository) SaveEvents(ctx context.Context, events *[]domain.Even
 		return err
 	}
 
-	var ids []uint
-	for _, e := range *events {
-		ids = append(ids, e.ID)
+	//проставляем id сохраненных ивентов
+	for i := range *events {
+		(*events)[i].ID = mEvents[i].ID
 	}
 
-	if err := db.Model(&GuestSession{}).Where("active = true AND id IN ?", ids).Updates(&GuestSession{LastActive: time.Now()}).Error; err != nil {
-		return err
+	return nil
+}
+
+// AddEngagedTime - прибавляет вовлеченное время к ивентам pageview по их id
+func (d *EventsRepository) AddEngagedTime(ctx context.Context, pageviews map[uint]time.Duration) error {
+	if len(pageviews) == 0 {
+		return nil
 	}
 
-	return nil
+	db := getDB(ctx, d.db)
+
+	values := make([]string, 0, len(pageviews))
+	args := make([]interface{}, 0, len(pageviews)*2)
+	for id, engaged := range pageviews {
+		values = append(values, "(?::bigint, ?::bigint)")
+		args = append(args, id, engaged.Milliseconds())
+	}
+
+	query := `
+	UPDATE events e SET engaged_ms = e.engaged_ms + v.engaged_ms
+	FROM (VALUES ` + strings.Join(values, ", ") + `) AS v(id, engaged_ms)
+	WHERE e.id = v.id
+	`
+
+	return db.Exec(query, args...).Error
+}
+
+// нормализованный путь страницы: без схемы, хоста, query, якоря и слэша в конце.
+// знак вопроса записан как chr(63), иначе gorm примет его за плейсхолдер в запросах с аргументами
+const normalizedPagePathSQL = `COALESCE(NULLIF(rtrim(split_part(split_part(regexp_replace(e.page_url, '^[a-zA-Z][a-zA-Z0-9+.-]*://[^/]*', ''), chr(63), 1), '#', 1), '/'), ''), '/')`
+
+func (d *EventsRepository) FindPages(ctx context.Context, opts domain.FindPagesOptions) ([]domain.PageStats, int64, error) {
+	var allowedOrders = map[string]string{
+		"path":             "path",
+		"views":            "views",
+		"unique_guests":    "unique_guests",
+		"avg_time_on_page": "avg_time_on_page",
+		"avg_engaged_time": "avg_engaged_time",
+		"entrances":        "entrances",
+		"exits":            "exits",
+		"bounce_rate":      "bounce_rate",
+	}
+
+	db := getDB(ctx, d.db)
+
+	pageViews := db.Table("events e").
+		Select(`
+	e.session_id,
+	gs.guest_id,
+	e.timestamp AS viewed_at,
+	gs.last_active,
+	`+normalizedPagePathSQL+` AS path,
+	e.data::jsonb->>'title' AS title,
+	e.engaged_ms,
+	ROW_NUMBER() OVER (PARTITION BY e.session_id ORDER BY e.timestamp, e.id) AS position,
+	COUNT(*) OVER (PARTITION BY e.session_id) AS session_views,
+	LEAD(e.timestamp) OVER (PARTITION BY e.session_id ORDER BY e.timestamp, e.id) AS next_viewed_at
+	`).
+		Joins("JOIN guest_sessions gs ON gs.id=e.session_id").
+		Joins("JOIN guests g ON g.id=gs.guest_id").
+		Where("g.domain_id=? AND e.type=?", opts.DomainID, "pageview")
+
+	from := opts.StartDate
+	if opts.RollupUntil != nil {
+		from = opts.RollupUntil
+	}
+
+	//окна считаются по всем просмотрам сессий периода, а сам период фильтруется уже над ними,
+	//иначе у сессии на границе периода неверные вход, выход и отказ
+	if from != nil || opts.EndDate != nil {
+		inRange := db.Table("events p").Select("p.session_id").Where("p.type = ?", "pageview")
+		if from != nil {
+			inRange = inRange.Where("p.timestamp >= ?", from)
+		}
+		if opts.EndDate != nil {
+			inRange = inRange.Where("p.timestamp <= ?", opts.EndDate)
+		}
+		pageViews = pageViews.Where("e.session_id IN (?)", inRange)
+	}
+
+	//суммы по страницам, средние считаются после склейки с роллапами
+	parts := db.Table("(?) pv", pageViews).
+		Select(`
+	path,
+	MAX(title) AS title,
+	COUNT(*) AS views,
+	COALESCE(SUM(EXTRACT(EPOCH FROM (COALESCE(next_viewed_at, GREATEST(last_active, viewed_at)) - viewed_at))), 0) AS time_on_page_seconds,
+	COALESCE(SUM(engaged_ms), 0) AS engaged_ms,
+	COUNT(*) FILTER (WHERE position = 1) AS entrances,
+	COUNT(*) FILTER (WHERE position = session_views) AS exits,
+	COUNT(*) FILTER (WHERE position = 1 AND session_views = 1) AS bounces
+	`)
+	if from != nil {
+		parts = parts.Where("viewed_at >= ?", from)
+	}
+	if opts.EndDate != nil {
+		parts = parts.Where("viewed_at <= ?", opts.EndDate)
+	}
+	parts = parts.Group("path")
+
+	if opts.RollupUntil != nil {
+		rolled := db.Table("rollup_pages").
+			Select("path, title, views, time_on_page_seconds, engaged_ms, entrances, exits, bounces").
+			Where("domain_id = ? AND granularity = ? AND bucket >= ? AND bucket < ?", opts.DomainID, domain.RollupDay, opts.StartDate, opts.RollupUntil)
+		parts = db.Raw("(?) UNION ALL (?)", rolled, parts)
+	}
+
+	totals := db.Table("(?) pp", parts).
+		Select(`
+	path,
+	COALESCE(MAX(title), '') AS title,
+	SUM(views)::bigint AS views,
+	COALESCE(SUM(time_on_page_seconds) / NULLIF(SUM(views), 0), 0) AS avg_time_on_page,
+	COALESCE(SUM(engaged_ms) / NULLIF(SUM(views), 0), 0) / 1000.0 AS avg_engaged_time,
+	SUM(entrances)::bigint AS entrances,
+	SUM(exits)::bigint AS exits,
+	COALESCE(SUM(bounces)::float / NULLIF(SUM(entrances), 0), 0) AS bounce_rate
+	`).
+		Group("path")
+
+	//уникальные гости не складываются из роллапов по дням, поэтому считаются по сырым ивентам за весь период
+	uniques := db.Table("events e").
+		Select(normalizedPagePathSQL+" AS path, COUNT(DISTINCT gs.guest_id) AS unique_guests").
+		Joins("JOIN guest_sessions gs ON gs.id=e.session_id").
+		Joins("JOIN guests g ON g.id=gs.guest_id").
+		Where("g.domain_id=? AND e.type=?", opts.DomainID, "pageview")
+	if opts.StartDate != nil {
+		uniques = uniques.Where("e.timestamp >= ?", opts.StartDate)
+	}
+	if opts.EndDate != nil {
+		uniques = uniques.Where("e.timestamp <= ?", opts.EndDate)
+	}
+	uniques = uniques.Group("path")
+
+	query := db.Table("(?) t", totals).
+		Select("t.*, COALESCE(u.unique_guests, 0) AS unique_guests").
+		Joins("LEFT JOIN (?) u ON u.path = t.path", uniques)
+
+	var count int64
+	if err := db.Table("(?) p", query).Count(&count).Error; err != nil {
+		return nil, 0, err
+	}
+
+	if opts.Limit != nil {
+		query = query.Limit(*opts.Limit)
+	}
+	if opts.Offset != nil {
+		query = query.Offset(*opts.Offset)
+	}
+
+	if opts.Order != nil && opts.OrderType != nil {
+		columnName, ok := allowedOrders[*opts.Order]
+		if !ok {
+			return nil, 0, domain.ErrFindPagesOrderNotAllowed
+		}
+		orderType := strings.ToUpper(*opts.OrderType)
+		if orderType != "ASC" && orderType != "DESC" {
+			return nil, 0, domain.ErrFindPagesOrderNotAllowed
+		}
+		query = query.Order(fmt.Sprintf("%s %s, path ASC", columnName, orderType))
+	} else {
+		query = query.Order("views DESC, path ASC")
+	}
+
+	var mPages []PageStatsDTO
+	if err := query.Scan(&mPages).Error; err != nil {
+		return nil, 0, err
+	}
+
+	pages := make([]domain.PageStats, 0, len(mPages))
+	for _, page := range mPages {
+		pages = append(pages, page.ToDomain())
+	}
+
+	return pages, count, nil
+}
+
+// jsonNumberSQL - числовое поле из data ивента, NULL если клиент прислал не число
+func jsonNumberSQL(key string) string {
+	return fmt.Sprintf(`(CASE WHEN jsonb_typeof(e.data::jsonb->'%[1]s') = 'number' THEN (e.data::jsonb->>'%[1]s')::numeric END)`, key)
+}
+
+// clicksQuery - клики по странице домена с учетом периода и ширины вьюпорта
+func (d *EventsRepository) clicksQuery(db *gorm.DB, opts domain.HeatmapOptions) *gorm.DB {
+	query := db.Table("events e").
+		Joins("JOIN guest_sessions gs ON gs.id=e.session_id").
+		Joins("JOIN guests g ON g.id=gs.guest_id").
+		Where("g.domain_id=? AND e.type=?", opts.DomainID, "click").
+		Where(normalizedPagePathSQL+" = ?", opts.PagePath)
+
+	if opts.StartDate != nil {
+		query = query.Where("e.timestamp >= ?", opts.StartDate)
+	}
+	if opts.EndDate != nil {
+		query = query.Where("e.timestamp <= ?", opts.EndDate)
+	}
+	if opts.MinWidth != nil {
+		query = query.Where(jsonNumberSQL("viewport_width")+" >= ?", *opts.MinWidth)
+	}
+	if opts.MaxWidth != nil {
+		query = query.Where(jsonNumberSQL("viewport_width")+" <= ?", *opts.MaxWidth)
+	}
+
+	return query
+}
+
+func (d *EventsRepository) Heatmap(ctx context.Context, opts domain.HeatmapOptions) (*domain.Heatmap, error) {
+	db := getDB(ctx, d.db)
+
+	heatmap := domain.Heatmap{
+		PagePath:  opts.PagePath,
+		GridSize:  opts.GridSize,
+		Cells:     []domain.HeatmapCell{},
+		Selectors: []domain.SelectorClicks{},
+	}
+
+	if err := d.clicksQuery(db, opts).Count(&heatmap.TotalClicks).Error; err != nil {
+		return nil, err
+	}
+	if heatmap.TotalClicks == 0 {
+		return &heatmap, nil
+	}
+
+	//x нормализуем по ширине вьюпорта, y по высоте всей страницы,
+	//а для старых клиентов без page_height - по высоте вьюпорта
+	points := d.clicksQuery(db, opts).Select(
+		jsonNumberSQL("x") + " / NULLIF(" + jsonNumberSQL("viewport_width") + ", 0) AS nx, " +
+			"COALESCE(" +
+			jsonNumberSQL("page_y") + " / NULLIF(" + jsonNumberSQL("page_height") + ", 0), " +
+			jsonNumberSQL("y") + " / NULLIF(" + jsonNumberSQL("viewport_height") + ", 0)" +
+			") AS ny")
+
+	if err := db.Table("(?) p", points).
+		Select(`
+	FLOOR(LEAST(GREATEST(nx, 0), 0.999999) * ?)::int AS x,
+	FLOOR(LEAST(GREATEST(ny, 0), 0.999999) * ?)::int AS y,
+	COUNT(*) AS clicks
+	`, opts.GridSize, opts.GridSize).
+		Where("nx IS NOT NULL AND ny IS NOT NULL").
+		Group("1, 2").
+		Order("y ASC, x ASC").
+		Scan(&heatmap.Cells).Error; err != nil {
+		return nil, err
+	}
+
+	if err := d.clicksQuery(db, opts).
+		Select("e.data::jsonb->>'selector' AS selector, COUNT(*) AS clicks").
+		Where("COALESCE(e.data::jsonb->>'selector', '') <> ''").
+		Group("1").
+		Order("clicks DESC, selector ASC").
+		Limit(opts.SelectorsLimit).
+		Scan(&heatmap.Selectors).Error; err != nil {
+		return nil, err
+	}
+
+	return &heatmap, nil
+}
+
+// Funnel - сколько сессий или гостей прошли каждый шаг воронки по порядку.
+// шаг 1 - самый ранний подходящий ивент за период, каждый следующий - самый ранний подходящий ивент
+// после предыдущего шага, но не позже окна конверсии от шага 1
+func (d *EventsRepository) Funnel(ctx context.Context, opts domain.FunnelQueryOptions) ([]domain.FunnelStepCount, error) {
+	db := getDB(ctx, d.db)
+
+	actor := "e.session_id"
+	if opts.Funnel.Scope == domain.FunnelScopeGuest {
+		actor = "gs.guest_id"
+	}
+
+	var query strings.Builder
+	args := []interface{}{opts.Funnel.DomainID, opts.StartDate, opts.EndDate.Add(opts.Funnel.Window())}
+
+	query.WriteString(`
+	WITH ev AS (
+		SELECT ` + actor + ` AS actor, e.timestamp, e.type, ` + normalizedPagePathSQL + ` AS path, e.data::jsonb AS data
+		FROM events e
+		JOIN guest_sessions gs ON gs.id = e.session_id
+		JOIN guests g ON g.id = gs.guest_id
+		WHERE g.domain_id = ? AND e.timestamp >= ? AND e.timestamp <= ?
+	)`)
+
+	for i, step := range opts.Funnel.Steps {
+		cond, condArgs := funnelStepSQL(step)
+
+		if i == 0 {
+			//первый шаг должен попасть в период, следующие могут выйти за его конец в пределах окна
+			query.WriteString(`,
+	s1 AS (
+		SELECT ev.actor, MIN(ev.timestamp) AS start_at, MIN(ev.timestamp) AS at, NULL::timestamptz AS prev_at
+		FROM ev
+		WHERE ev.timestamp <= ? AND ` + cond + `
+		GROUP BY ev.actor
+	)`)
+			args = append(args, opts.EndDate)
+			args = append(args, condArgs...)
+			continue
+		}
+
+		query.WriteString(fmt.Sprintf(`,
+	s%d AS (
+		SELECT p.actor, p.start_at, MIN(ev.timestamp) AS at, p.at AS prev_at
+		FROM s%d p
+		JOIN ev ON ev.actor = p.actor AND ev.timestamp > p.at AND ev.timestamp <= p.start_at + make_interval(mins => ?)
+		WHERE `+cond+`
+		GROUP BY p.actor, p.start_at, p.at
+	)`, i+1, i))
+		args = append(args, opts.Funnel.WindowMinutes)
+		args = append(args, condArgs...)
+	}
+
+	for i := range opts.Funnel.Steps {
+		if i > 0 {
+			query.WriteString("\n\tUNION ALL")
+		}
+		query.WriteString(fmt.Sprintf(`
+	SELECT %d AS step, COUNT(*) AS count,
+		percentile_cont(0.5) WITHIN GROUP (ORDER BY EXTRACT(EPOCH FROM (at - prev_at))) AS median_seconds
+	FROM s%d`, i+1, i+1))
+	}
+	query.WriteString("\n\tORDER BY step\n")
+
+	var counts []domain.FunnelStepCount
+	if err := db.Raw(query.String(), args...).Scan(&counts).Error; err != nil {
+		return nil, err
+	}
+
+	return counts, nil
+}
+
+// funnelStepSQL - условие шага воронки на строку ev
+func funnelStepSQL(step domain.FunnelStep) (string, []interface{}) {
+	var conds []string
+	var args []interface{}
+
+	switch step.Type {
+	case domain.FunnelStepPage:
+		conds = append(conds, "ev.type = 'pageview'", `ev.path LIKE ? ESCAPE '\'`)
+		args = append(args, globToLike(domain.NormalizePagePath(step.URLPattern)))
+	case domain.FunnelStepEvent:
+		conds = append(conds, "ev.type = ?")
+		args = append(args, step.EventType)
+	}
+
+	for _, p := range step.Properties {
+		switch p.Op {
+		case domain.PropertyEquals:
+			conds = append(conds, "ev.data->>? = ?")
+			args = append(args, p.Property, p.Value)
+		case domain.PropertyNotEquals:
+			conds = append(conds, "ev.data->>? IS DISTINCT FROM ?")
+			args = append(args, p.Property, p.Value)
+		case domain.PropertyContains:
+			conds = append(conds, "strpos(ev.data->>?, ?) > 0")
+			args = append(args, p.Property, p.Value)
+		case domain.PropertyRegex:
+			conds = append(conds, "ev.data->>? ~ ?")
+			args = append(args, p.Property, p.Value)
+		case domain.PropertyExists:
+			conds = append(conds, "COALESCE(jsonb_typeof(ev.data->?), 'null') <> 'null'")
+			args = append(args, p.Property)
+		}
+	}
+
+	return strings.Join(conds, " AND "), args
+}
+
+// globToLike - шаблон пути с * в шаблон LIKE, спецсимволы LIKE экранируются
+func globToLike(pattern string) string {
+	pattern = strings.NewReplacer(`\`, `\\`, `%`, `\%`, `_`, `\_`).Replace(pattern)
+	return strings.ReplaceAll(pattern, "*", "%")
+}
+
+// Explore - ряды числа ивентов типа opts.EventType по интервалам, с фильтрами по свойствам
+// и группировкой до двух свойств. отдаются только opts.Groups самых крупных групп
+func (d *EventsRepository) Explore(ctx context.Context, opts domain.ExplorerOptions) ([]domain.ExplorerRow, error) {
+	var allowedIntervals = map[string]string{
+		domain.ExplorerByHour: "hour",
+		domain.ExplorerByDay:  "day",
+		domain.ExplorerByWeek: "week",
+	}
+
+	unit, ok := allowedIntervals[opts.Interval]
+	if !ok {
+		return nil, domain.ErrInvalidExplorerQuery
+	}
+
+	db := getDB(ctx, d.db)
+
+	var groupArgs []interface{}
+	groups := []string{"'' AS group1", "'' AS group2"}
+	for i, key := range opts.GroupBy {
+		groups[i] = fmt.Sprintf("COALESCE(e.data->>?, '%s') AS group%d", domain.ExplorerNone, i+1)
+		groupArgs = append(groupArgs, key)
+	}
+
+	where := []string{"g.domain_id = ?", "e.type = ?", "e.timestamp >= ?", "e.timestamp <= ?"}
+	whereArgs := []interface{}{opts.DomainID, opts.EventType, opts.StartDate, opts.EndDate}
+	for _, f := range opts.Filters {
+		cond, args, err := propertyFilterSQL(f)
+		if err != nil {
+			return nil, err
+		}
+		where = append(where, cond)
+		whereArgs = append(whereArgs, args...)
+	}
+
+	query := `
+	WITH ev AS (
+		SELECT date_trunc(?::text, e.timestamp) AS bucket, ` + strings.Join(groups, ", ") + `
+		FROM events e
+		JOIN guest_sessions gs ON gs.id = e.session_id
+		JOIN guests g ON g.id = gs.guest_id
+		WHERE ` + strings.Join(where, " AND ") + `
+	),
+	top AS (
+		SELECT group1, group2, COUNT(*) AS total
+		FROM ev
+		GROUP BY group1, group2
+		ORDER BY total DESC, group1, group2
+		LIMIT ?
+	)
+	SELECT b.bucket, top.group1, top.group2, COUNT(ev.bucket) AS count
+	FROM generate_series(date_trunc(?::text, ?::timestamptz), date_trunc(?::text, ?::timestamptz), ('1 ' || ?::text)::interval) AS b(bucket)
+	CROSS JOIN top
+	LEFT JOIN ev ON ev.bucket = b.bucket AND ev.group1 = top.group1 AND ev.group2 = top.group2
+	GROUP BY b.bucket, top.group1, top.group2, top.total
+	ORDER BY top.total DESC, top.group1, top.group2, b.bucket
+	`
+
+	args := []interface{}{unit}
+	args = append(args, groupArgs...)
+	args = append(args, whereArgs...)
+	args = append(args, opts.Groups, unit, opts.StartDate, unit, opts.EndDate, unit)
+
+	rows := []domain.ExplorerRow{}
+	if err := db.Raw(query, args...).Scan(&rows).Error; err != nil {
+		return nil, err
+	}
+
+	return rows, nil
+}
+
+// propertyFilterSQL - условие фильтра на e.data. равенства идут через @>, чтобы работал GIN индекс
+func propertyFilterSQL(f domain.PropertyFilter) (string, []interface{}, error) {
+	contains := func(value any) (string, error) {
+		raw, err := json.Marshal(map[string]any{f.Property: value})
+		return string(raw), err
+	}
+
+	switch f.Op {
+	case domain.FilterEq, domain.FilterNeq:
+		raw, err := contains(f.Value)
+		if err != nil {
+			return "", nil, err
+		}
+		if f.Op == domain.FilterNeq {
+			return "NOT (e.data @> ?::jsonb)", []interface{}{raw}, nil
+		}
+		return "e.data @> ?::jsonb", []interface{}{raw}, nil
+	case domain.FilterIn:
+		values, _ := f.Value.([]any)
+		conds := make([]string, 0, len(values))
+		args := make([]interface{}, 0, len(values))
+		for _, v := range values {
+			raw, err := contains(v)
+			if err != nil {
+				return "", nil, err
+			}
+			conds = append(conds, "e.data @> ?::jsonb")
+			args = append(args, raw)
+		}
+		return "(" + strings.Join(conds, " OR ") + ")", args, nil
+	case domain.FilterContains:
+		return "strpos(e.data->>?, ?) > 0", []interface{}{f.Property, f.Value}, nil
+	case domain.FilterGt, domain.FilterGte, domain.FilterLt, domain.FilterLte:
+		operators := map[string]string{
+			domain.FilterGt:  ">",
+			domain.FilterGte: ">=",
+			domain.FilterLt:  "<",
+			domain.FilterLte: "<=",
+		}
+		//нечисловые значения не сравниваются, а не роняют запрос на приведении типа
+		return "(CASE WHEN jsonb_typeof(e.data->?) = 'number' THEN (e.data->>?)::numeric END) " + operators[f.Op] + " ?",
+			[]interface{}{f.Property, f.Property, f.Value}, nil
+	case domain.FilterExists:
+		return "COALESCE(jsonb_typeof(e.data->?), 'null') <> 'null'", []interface{}{f.Property}, nil
+	}
+
+	return "", nil, domain.ErrInvalidExplorerQuery
+}
+
+// UpdateCatalog - добавляет в каталог новые типы и свойства, у известных прибавляет счетчик и сдвигает last_seen.
+// новые ключи добавляются, пока в каталоге домена меньше CatalogMaxKeys записей, остальные отбрасываются
+func (d *EventsRepository) UpdateCatalog(ctx context.Context, entries []domain.EventCatalogEntry) error {
+	if len(entries) == 0 {
+		return nil
+	}
+
+	db := getDB(ctx, d.db)
+
+	values := make([]string, 0, len(entries))
+	args := make([]interface{}, 0, len(entries)*7+1)
+	for _, e := range entries {
+		values = append(values, "(?::bigint, ?::text, ?::text, ?::text, ?::bigint, ?::timestamptz, ?::timestamptz)")
+		args = append(args, e.DomainID, e.EventType, e.Property, e.ValueType, e.Events, e.FirstSeen, e.LastSeen)
+	}
+	args = append(args, domain.CatalogMaxKeys)
+
+	//новые ключи нумеруются внутри домена, чтобы пачка целиком не вышла за лимит
+	query := `
+	WITH v (domain_id, event_type, property, value_type, events, first_seen, last_seen) AS (
+		VALUES ` + strings.Join(values, ", ") + `
+	), k AS (
+		SELECT v.*, EXISTS (
+			SELECT 1 FROM event_catalogs c
+			WHERE c.domain_id = v.domain_id AND c.event_type = v.event_type AND c.property = v.property
+		) AS known
+		FROM v
+	), r AS (
+		SELECT k.*, ROW_NUMBER() OVER (PARTITION BY k.domain_id, k.known ORDER BY k.event_type, k.property) AS n
+		FROM k
+	)
+	INSERT INTO event_catalogs (domain_id, event_type, property, value_type, events, first_seen, last_seen)
+	SELECT r.domain_id, r.event_type, r.property, r.value_type, r.events, r.first_seen, r.last_seen
+	FROM r
+	WHERE r.known OR (SELECT COUNT(*) FROM event_catalogs c WHERE c.domain_id = r.domain_id) + r.n <= ?
+	ON CONFLICT (domain_id, event_type, property) DO UPDATE SET
+		value_type = EXCLUDED.value_type,
+		events = event_catalogs.events + EXCLUDED.events,
+		first_seen = LEAST(event_catalogs.first_seen, EXCLUDED.first_seen),
+		last_seen = GREATEST(event_catalogs.last_seen, EXCLUDED.last_seen)
+	`
+
+	return db.Exec(query, args...).Error
+}
+
+func (d *EventsRepository) Catalog(ctx context.Context, domain_id uint) ([]domain.EventCatalogEntry, error) {
+	db := getDB(ctx, d.db)
+
+	var mEntries []EventCatalog
+	if err := db.Where("domain_id = ?", domain_id).
+		Order("event_type ASC, property ASC").
+		Find(&mEntries).Error; err != nil {
+		return nil, err
+	}
+
+	entries := make([]domain.EventCatalogEntry, 0, len(mEntries))
+	for _, e := range mEntries {
+		entries = append(entries, e.ToDomain())
+	}
+
+	return entries, nil
 }
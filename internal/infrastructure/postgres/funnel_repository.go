@@ -0,0 +1,107 @@
+package postgres
+
+import (
+	"context"
+	"errors"
+	domain "metrika/internal/domain/analytics"
+
+	"gorm.io/gorm"
+)
+
+type FunnelRepository struct {
+	db *gorm.DB
+}
+
+func NewFunnelRepository(db *gorm.DB) *FunnelRepository {
+	return &FunnelRepository{db}
+}
+
+func (d *FunnelRepository) ByDomain(ctx context.Context, domain_id uint) ([]domain.Funnel, error) {
+	db := getDB(ctx, d.db)
+
+	var mFunnels []Funnel
+	if err := db.Where("domain_id = ?", domain_id).Order("id ASC").Find(&mFunnels).Error; err != nil {
+		return nil, err
+	}
+
+	funnels := make([]domain.Funnel, 0, len(mFunnels))
+	for _, f := range mFunnels {
+		funnels = append(funnels, f.ToDomain())
+	}
+
+	return funnels, nil
+}
+
+func (d *FunnelRepository) ByID(ctx context.Context, domain_id uint, funnel_id uint) (*domain.Funnel, error) {
+	db := getDB(ctx, d.db)
+
+	var mFunnel Funnel
+	if err := db.Where("id = ? AND domain_id = ?", funnel_id, domain_id).First(&mFunnel).Error; err != nil {
+		if errors.Is(err, gorm.ErrRecordNotFound) {
+			return nil, domain.ErrFunnelNotFound
+		}
+		return nil, err
+	}
+
+	funnel := mFunnel.ToDomain()
+	return &funnel, nil
+}
+
+func (d *FunnelRepository) Create(ctx context.Context, funnel *domain.Funnel) error {
+	db := getDB(ctx, d.db)
+
+	mFunnel := funnelModel(*funnel)
+	if err := db.Create(&mFunnel).Error; err != nil {
+		if errors.Is(err, gorm.ErrForeignKeyViolated) {
+			return domain.ErrDomainNotFound
+		}
+		return err
+	}
+
+	funnel.ID = mFunnel.ID
+	funnel.CreatedAt = mFunnel.CreatedAt
+
+	return nil
+}
+
+func (d *FunnelRepository) Update(ctx context.Context, funnel *domain.Funnel) error {
+	db := getDB(ctx, d.db)
+
+	mFunnel := funnelModel(*funnel)
+	res := db.Model(&Funnel{}).
+		Where("id = ? AND domain_id = ?", funnel.ID, funnel.DomainID).
+		Select("name", "scope", "window_minutes", "steps").
+		Updates(&mFunnel)
+	if res.Error != nil {
+		return res.Error
+	}
+	if res.RowsAffected == 0 {
+		return domain.ErrFunnelNotFound
+	}
+
+	return nil
+}
+
+func (d *FunnelRepository) Delete(ctx context.Context, domain_id uint, funnel_id uint) error {
+	db := getDB(ctx, d.db)
+
+	res := db.Where("id = ? AND domain_id = ?", funnel_id, domain_id).Delete(&Funnel{})
+	if res.Error != nil {
+		return res.Error
+	}
+	if res.RowsAffected == 0 {
+		return domain.ErrFunnelNotFound
+	}
+
+	return nil
+}
+
+func funnelModel(f domain.Funnel) Funnel {
+	return Funnel{
+		DomainID:      f.DomainID,
+		Name:          f.Name,
+		Scope:         string(f.Scope),
+		WindowMinutes: f.WindowMinutes,
+		Steps:         f.Steps,
+	}
+}
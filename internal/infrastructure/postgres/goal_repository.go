@@ -0,0 +1,258 @@
+package postgres
+
+import (
+	"context"
+	"errors"
+	domain "metrika/internal/domain/analytics"
+	"strings"
+	"time"
+
+	"gorm.io/gorm"
+)
+
+type GoalRepository struct {
+	db *gorm.DB
+}
+
+func NewGoalRepository(db *gorm.DB) *GoalRepository {
+	return &GoalRepository{db}
+}
+
+func (d *GoalRepository) ByDomain(ctx context.Context, domain_id uint) ([]domain.Goal, error) {
+	db := getDB(ctx, d.db)
+
+	var mGoals []Goal
+	if err := db.Where("domain_id = ?", domain_id).Order("id ASC").Find(&mGoals).Error; err != nil {
+		return nil, err
+	}
+
+	goals := make([]domain.Goal, 0, len(mGoals))
+	for _, g := range mGoals {
+		goals = append(goals, g.ToDomain())
+	}
+
+	return goals, nil
+}
+
+func (d *GoalRepository) ByID(ctx context.Context, domain_id uint, goal_id uint) (*domain.Goal, error) {
+	db := getDB(ctx, d.db)
+
+	var mGoal Goal
+	if err := db.Where("id = ? AND domain_id = ?", goal_id, domain_id).First(&mGoal).Error; err != nil {
+		if errors.Is(err, gorm.ErrRecordNotFound) {
+			return nil, domain.ErrGoalNotFound
+		}
+		return nil, err
+	}
+
+	goal := mGoal.ToDomain()
+	return &goal, nil
+}
+
+func (d *GoalRepository) Create(ctx context.Context, goal *domain.Goal) error {
+	db := getDB(ctx, d.db)
+
+	mGoal := goalModel(*goal)
+	if err := db.Create(&mGoal).Error; err != nil {
+		if errors.Is(err, gorm.ErrForeignKeyViolated) {
+			return domain.ErrDomainNotFound
+		}
+		return err
+	}
+
+	goal.ID = mGoal.ID
+	goal.CreatedAt = mGoal.CreatedAt
+
+	return nil
+}
+
+func (d *GoalRepository) Update(ctx context.Context, goal *domain.Goal) error {
+	db := getDB(ctx, d.db)
+
+	mGoal := goalModel(*goal)
+	res := db.Model(&Goal{}).
+		Where("id = ? AND domain_id = ?", goal.ID, goal.DomainID).
+		Select("name", "type", "url_pattern", "event_type", "selector", "form_id", "properties").
+		Updates(&mGoal)
+	if res.Error != nil {
+		return res.Error
+	}
+	if res.RowsAffected == 0 {
+		return domain.ErrGoalNotFound
+	}
+
+	return nil
+}
+
+func (d *GoalRepository) Delete(ctx context.Context, domain_id uint, goal_id uint) error {
+	db := getDB(ctx, d.db)
+
+	if err := db.Where("goal_id = ?", goal_id).Delete(&Conversion{}).Error; err != nil {
+		return err
+	}
+
+	res := db.Where("id = ? AND domain_id = ?", goal_id, domain_id).Delete(&Goal{})
+	if res.Error != nil {
+		return res.Error
+	}
+	if res.RowsAffected == 0 {
+		return domain.ErrGoalNotFound
+	}
+
+	return nil
+}
+
+func goalModel(g domain.Goal) Goal {
+	return Goal{
+		DomainID:   g.DomainID,
+		Name:       g.Name,
+		Type:       string(g.Type),
+		URLPattern: g.URLPattern,
+		EventType:  g.EventType,
+		Selector:   g.Selector,
+		FormID:     g.FormID,
+		Properties: g.Properties,
+	}
+}
+
+// SaveConversions - сохраняет конверсии, уже засчитанные цели сессий пропускаются
+func (d *GoalRepository) SaveConversions(ctx context.Context, conversions []domain.Conversion) error {
+	if len(conversions) == 0 {
+		return nil
+	}
+
+	db := getDB(ctx, d.db)
+
+	now := time.Now()
+	values := make([]string, 0, len(conversions))
+	args := make([]interface{}, 0, len(conversions)*5)
+	for _, c := range conversions {
+		values = append(values, "(?::timestamptz, ?::bigint, ?::bigint, ?::bigint, ?::timestamptz)")
+		args = append(args, now, c.GoalID, c.SessionID, c.EventID, c.Timestamp)
+	}
+
+	query := `
+	INSERT INTO conversions (created_at, goal_id, session_id, event_id, timestamp)
+	VALUES ` + strings.Join(values, ", ") + `
+	ON CONFLICT (goal_id, session_id) DO NOTHING
+	`
+
+	return db.Exec(query, args...).Error
+}
+
+// ConversionsReport - сессии домена, начатые за период, и какие из них достигли цели.
+// время конверсии на графике - время начала сессии, чтобы доля в каждой точке считалась от тех же сессий
+func (d *GoalRepository) ConversionsReport(ctx context.Context, opts domain.ConversionsReportOptions) (*domain.ConversionsReport, error) {
+	var allowedIntervals = map[string]string{
+		domain.ConversionsByHour: "hour",
+		domain.ConversionsByDay:  "day",
+		domain.ConversionsByWeek: "week",
+	}
+
+	unit, ok := allowedIntervals[opts.Interval]
+	if !ok {
+		return nil, domain.ErrConversionsIntervalInvalid
+	}
+
+	groupExpr, ok := sourceGroups[opts.GroupBy]
+	if !ok {
+		return nil, domain.ErrSourcesGroupNotAllowed
+	}
+
+	db := getDB(ctx, d.db)
+
+	goalFilter := ""
+	goalArgs := []interface{}{opts.DomainID}
+	if opts.GoalID != nil {
+		goalFilter = " AND gl.id = ?"
+		goalArgs = append(goalArgs, *opts.GoalID)
+	}
+
+	sessions := `
+	WITH s AS (
+		SELECT gs.id, gs.created_at, ` + groupExpr + ` AS source,
+			EXISTS (
+				SELECT 1 FROM conversions c
+				JOIN goals gl ON gl.id = c.goal_id
+				WHERE c.session_id = gs.id AND gl.domain_id = ?` + goalFilter + `
+			) AS converted
+		FROM guest_sessions gs
+		JOIN guests g ON g.id = gs.guest_id
+		WHERE g.domain_id = ? AND gs.created_at BETWEEN ? AND ?
+	)
+	`
+	sessionArgs := append(append([]interface{}{}, goalArgs...), opts.DomainID, opts.StartDate, opts.EndDate)
+
+	report := domain.ConversionsReport{
+		Goals:    []domain.GoalConversions{},
+		Timeline: []domain.ConversionPoint{},
+		Sources:  []domain.ConversionSource{},
+	}
+
+	var totals struct {
+		Sessions    int64
+		Conversions int64
+	}
+	if err := db.Raw(sessions+`
+	SELECT COUNT(*) AS sessions, COUNT(*) FILTER (WHERE converted) AS conversions FROM s
+	`, sessionArgs...).Scan(&totals).Error; err != nil {
+		return nil, err
+	}
+	report.Sessions, report.Conversions = totals.Sessions, totals.Conversions
+	report.ConversionRate = conversionRate(totals.Conversions, totals.Sessions)
+
+	if err := db.Raw(sessions+`
+	SELECT gl.id AS goal_id, gl.name, gl.type, COUNT(c.id) AS conversions
+	FROM goals gl
+	LEFT JOIN conversions c ON c.goal_id = gl.id AND c.session_id IN (SELECT id FROM s)
+	WHERE gl.domain_id = ?`+goalFilter+`
+	GROUP BY gl.id, gl.name, gl.type
+	ORDER BY conversions DESC, gl.id ASC
+	`, append(sessionArgs, goalArgs...)...).Scan(&report.Goals).Error; err != nil {
+		return nil, err
+	}
+	for i := range report.Goals {
+		report.Goals[i].ConversionRate = conversionRate(report.Goals[i].Conversions, report.Sessions)
+	}
+
+	timelineArgs := append(append([]interface{}{}, sessionArgs...), unit, opts.StartDate, unit, opts.EndDate, unit, unit)
+	if err := db.Raw(sessions+`
+	SELECT t.time, COUNT(s.id) AS sessions, COUNT(s.id) FILTER (WHERE s.converted) AS conversions
+	FROM generate_series(date_trunc(?::text, ?::timestamptz), date_trunc(?::text, ?::timestamptz), ('1 ' || ?::text)::interval) AS t(time)
+	LEFT JOIN s ON date_trunc(?::text, s.created_at) = t.time
+	GROUP BY t.time
+	ORDER BY t.time
+	`, timelineArgs...).Scan(&report.Timeline).Error; err != nil {
+		return nil, err
+	}
+	for i := range report.Timeline {
+		report.Timeline[i].ConversionRate = conversionRate(report.Timeline[i].Conversions, report.Timeline[i].Sessions)
+	}
+
+	sourcesQuery := sessions + `
+	SELECT source, COUNT(*) AS sessions, COUNT(*) FILTER (WHERE converted) AS conversions
+	FROM s
+	GROUP BY source
+	ORDER BY conversions DESC, sessions DESC, source ASC
+	`
+	sourcesArgs := sessionArgs
+	if opts.Limit != nil {
+		sourcesQuery += " LIMIT ?"
+		sourcesArgs = append(sourcesArgs, *opts.Limit)
+	}
+	if err := db.Raw(sourcesQuery, sourcesArgs...).Scan(&report.Sources).Error; err != nil {
+		return nil, err
+	}
+	for i := range report.Sources {
+		report.Sources[i].ConversionRate = conversionRate(report.Sources[i].Conversions, report.Sources[i].Sessions)
+	}
+
+	return &report, nil
+}
+
+func conversionRate(conversions, sessions int64) float64 {
+	if sessions == 0 {
+		return 0
+	}
+	return float64(conversions) / float64(sessions)
+}
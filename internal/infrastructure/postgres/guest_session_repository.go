@@ -3,10 +3,13 @@ package postgres
 import (
 	"context"
 	"errors"
+	"fmt"
 	domain "metrika/internal/domain/analytics"
+	"strings"
 	"time"
 
 	"gorm.io/gorm"
+	"gorm.io/gorm/clause"
 )
 
 type GuestSessionRepository struct {
@@ -26,6 +29,8 @@ func (d *GuestSessionRepository) Create(ctx context.Context, session *domain.Gue
 		Active:     session.Active,
 		LastActive: session.LastActive,
 		EndTime:    session.EndTime,
+		Device:     string(session.Device),
+		Country:    session.Country,
 	}
 
 	if err := db.Model(&GuestSession{}).Create(&mSessions).Error; err != nil {
@@ -73,59 +78,79 @@ func (d *GuestSessionRepository) CreateSessions(ctx context.Context, sessions *[
 func (d *GuestSessionRepository) GetCountActiveSessions(ctx context.Context, domain_id uint) (int64, error) {
 	db := getDB(ctx, d.db)
 
-	res := db.Exec("SELECT * FROM guest_sessions s LEFT JOIN guests u ON u.id=s.guest_id WHERE s.active = true AND u.domain_id=?", domain_id)
-
-	if res.Error != nil {
-		return 0, res.Error
+	var count int64
+	if err := db.Table("guest_sessions s").
+		Joins("JOIN guests u ON u.id=s.guest_id").
+		Where("s.active = true AND u.domain_id=?", domain_id).
+		Count(&count).Error; err != nil {
+		return 0, err
 	}
 
-	return res.RowsAffected, nil
+	return count, nil
 }
 
-func (d *GuestSessionRepository) GetVisitsByInterval(
-	ctx context.Context,
-	domain_id uint,
-	opts domain.GetVisitsByIntervalOptions,
-) (*[]domain.GuestSessionsByTimeBucket, error) {
+// OnlineBreakdown - активные сессии домена, сгруппированные по разрезу by(domain.OnlineBy*).
+// текущая страница - путь последнего pageview сессии
+func (d *GuestSessionRepository) OnlineBreakdown(ctx context.Context, domain_id uint, by string, limit int) ([]domain.OnlineBreakdownItem, error) {
+	var allowedKeys = map[string]string{
+		domain.OnlineByPage:    "CASE WHEN e.page_url IS NULL THEN '' ELSE " + normalizedPagePathSQL + " END",
+		domain.OnlineBySource:  "s.source_type",
+		domain.OnlineByDevice:  "s.device",
+		domain.OnlineByCountry: "s.country",
+	}
 
-	var buckets []domain.GuestSessionsByTimeBucket
+	key, ok := allowedKeys[by]
+	if !ok {
+		return nil, domain.ErrOnlineBreakdownNotAllowed
+	}
+
+	db := getDB(ctx, d.db)
+
+	query := db.Table("guest_sessions s").
+		Select("COALESCE(NULLIF("+key+", ''), ?) AS key, COUNT(*) AS online", domain.OnlineUnknown).
+		Joins("JOIN guests u ON u.id=s.guest_id")
+
+	if by == domain.OnlineByPage {
+		query = query.Joins("LEFT JOIN LATERAL (SELECT e.page_url FROM events e WHERE e.session_id=s.id AND e.type='pageview' ORDER BY e.timestamp DESC, e.id DESC LIMIT 1) e ON true")
+	}
+
+	items := []domain.OnlineBreakdownItem{}
+	if err := query.
+		Where("s.active = true AND u.domain_id=?", domain_id).
+		Group("1").
+		Order("online DESC, key ASC").
+		Limit(limit).
+		Scan(&items).Error; err != nil {
+		return nil, err
+	}
+
+	return items, nil
+}
+
+// OnlineSparkline - сколько сессий было активно в каждую минуту начиная с since.
+// сессия считается активной от создания до закрытия воркером
+func (d *GuestSessionRepository) OnlineSparkline(ctx context.Context, domain_id uint, since time.Time) ([]domain.OnlinePoint, error) {
+	db := getDB(ctx, d.db)
 
 	query := `
-	WITH params AS (
-	  SELECT ?::int AS interval_minutes, ?::int AS interval_diviser, ?::timestamptz AS start_ts, ?::timestamptz AS end_ts
-	),
-	bounds AS (
-	  SELECT
-	    (date_trunc('hour', start_ts)
-	      + ((floor(extract(minute FROM start_ts)/params.interval_diviser::numeric)::int * params.interval_minutes::int) || ' minutes')::interval) AS start_bucket,
-	    (date_trunc('hour', end_ts)
-	      + ((floor(extract(minute FROM end_ts)/params.interval_diviser::numeric)::int * params.interval_minutes::int) || ' minutes')::interval) AS end_bucket
-	  FROM params
-	),
-	agg AS (
-	  SELECT
-	    (date_trunc('hour', created_at)
-	      + ((floor(extract(minute FROM created_at)/params.interval_diviser::numeric)::int * params.interval_minutes::int) || ' minutes')::interval) AS time_bucket,
-	    COUNT(*) AS visits,
-	    COUNT(DISTINCT guest_id) AS uniques
-	  FROM guest_sessions, params
-	  WHERE created_at BETWEEN params.start_ts AND params.end_ts
-	  GROUP BY 1
-	)
-	SELECT gs.time_bucket,
-	       COALESCE(a.visits, 0) AS visits,
-	       COALESCE(a.uniques, 0) AS uniques
-	FROM bounds, params,
-	     generate_series(bounds.start_bucket, bounds.end_bucket, (params.interval_minutes::int || ' minutes')::interval) AS gs(time_bucket)
-	LEFT JOIN agg a USING (time_bucket)
-	ORDER BY time_bucket;
+	SELECT m.minute, COUNT(s.id) AS online
+	FROM generate_series(date_trunc('minute', ?::timestamptz), date_trunc('minute', NOW()), INTERVAL '1 minute') AS m(minute)
+	LEFT JOIN (
+		SELECT s.id, s.created_at, COALESCE(s.end_time, NOW()) AS ended_at
+		FROM guest_sessions s
+		JOIN guests u ON u.id=s.guest_id
+		WHERE u.domain_id=? AND COALESCE(s.end_time, NOW()) >= ?
+	) s ON s.created_at < m.minute + INTERVAL '1 minute' AND s.ended_at >= m.minute
+	GROUP BY m.minute
+	ORDER BY m.minute
 	`
-	err := d.db.Debug().
-		Raw(query, opts.IntervalMinutes, opts.IntervalDiviser, opts.Start, opts.End).
-		Scan(&buckets).
-		Error
 
-	return &buckets, err
+	points := []domain.OnlinePoint{}
+	if err := db.Raw(query, since, domain_id, since).Scan(&points).Error; err != nil {
+		return nil, err
+	}
+
+	return points, nil
 }
 
 func (d *GuestSessionRepository) ByRangeDate(ctx context.Context, opts domain.GuestSessionRepositoryByRangeDateOptions) (*[]domain.GuestSession, error) {
@@ -165,11 +190,15 @@ func (d *GuestSessionRepository) ByRangeDate(ctx context.Context, opts domain.Gu
 
 	for _, session := range mSessions {
 		sessions = append(sessions, domain.GuestSession{
-			ID:         session.ID,
-			GuestID:    session.GuestID,
-			EndTime:    session.EndTime,
-			LastActive: session.LastActive,
-			Active:     session.Active,
+			ID:             session.ID,
+			GuestID:        session.GuestID,
+			EndTime:        session.EndTime,
+			LastActive:     session.LastActive,
+			Active:         session.Active,
+			Device:         domain.DeviceClass(session.Device),
+			Country:        session.Country,
+			EngagedSeconds: float64(session.EngagedMs) / 1000,
+			SessionSource:  session.Source(),
 		})
 	}
 
@@ -191,38 +220,127 @@ func (d *GuestSessionRepository) LastActiveByGuestId(ctx context.Context, guest_
 	}
 
 	session := domain.GuestSession{ID: mSession.ID,
-		GuestID:    mSession.GuestID,
-		IPAddress:  mSession.IPAddress,
-		LastActive: mSession.LastActive,
-		EndTime:    mSession.EndTime,
+		GuestID:        mSession.GuestID,
+		IPAddress:      mSession.IPAddress,
+		LastActive:     mSession.LastActive,
+		EndTime:        mSession.EndTime,
+		Device:         domain.DeviceClass(mSession.Device),
+		Country:        mSession.Country,
+		EngagedSeconds: float64(mSession.EngagedMs) / 1000,
+		SessionSource:  mSession.Source(),
 	}
 
 	return &session, nil
 }
 
-func (d *GuestSessionRepository) SetLastActive(ctx context.Context, session_ids []uint, last_active time.Time) error {
+// FilterByDomain - возвращает id тех сессий из переданных, которые принадлежат домену
+func (d *GuestSessionRepository) FilterByDomain(ctx context.Context, domain_id uint, session_ids []uint) ([]uint, error) {
+	db := getDB(ctx, d.db)
+
+	var ids []uint
 
+	if err := db.Table("guest_sessions s").
+		Select("s.id").
+		Joins("JOIN guests g ON g.id = s.guest_id").
+		Where("g.domain_id = ? AND s.id IN ?", domain_id, session_ids).
+		Scan(&ids).Error; err != nil {
+		return nil, err
+	}
+
+	return ids, nil
+}
+
+// FilterActive - возвращает id тех сессий из переданных, которые еще не закрыты
+func (d *GuestSessionRepository) FilterActive(ctx context.Context, session_ids []uint) ([]uint, error) {
 	db := getDB(ctx, d.db)
 
-	if err := db.Model(&GuestSession{}).Where("id IN ?", session_ids).
-		Updates(map[string]interface{}{"last_active": last_active, "end_time": nil, "active": true}).
-		Error; err != nil {
+	var ids []uint
+
+	if err := db.Model(&GuestSession{}).
+		Where("id IN ? AND active = true", session_ids).
+		Pluck("id", &ids).Error; err != nil {
+		return nil, err
+	}
+
+	return ids, nil
+}
+
+// DomainsBySessions - домены сессий, session_id -> domain_id
+func (d *GuestSessionRepository) DomainsBySessions(ctx context.Context, session_ids []uint) (map[uint]uint, error) {
+	domains := make(map[uint]uint, len(session_ids))
+	if len(session_ids) == 0 {
+		return domains, nil
+	}
+
+	db := getDB(ctx, d.db)
+
+	var rows []struct {
+		SessionID uint
+		DomainID  uint
+	}
+	if err := db.Table("guest_sessions s").
+		Select("s.id AS session_id, g.domain_id").
+		Joins("JOIN guests g ON g.id = s.guest_id").
+		Where("s.id IN ?", session_ids).
+		Scan(&rows).Error; err != nil {
+		return nil, err
+	}
+
+	for _, row := range rows {
+		domains[row.SessionID] = row.DomainID
+	}
+
+	return domains, nil
+}
+
+// SetLastActive - сдвигает last_active активных сессий на время их последнего ивента.
+// закрытая сессия не открывается заново: ее ивенты, принятые до закрытия, просто сохраняются
+func (d *GuestSessionRepository) SetLastActive(ctx context.Context, last_active map[uint]time.Time) error {
+	if len(last_active) == 0 {
+		return nil
+	}
+
+	db := getDB(ctx, d.db)
+
+	values := make([]string, 0, len(last_active))
+	args := make([]interface{}, 0, len(last_active)*2)
+	for id, ts := range last_active {
+		values = append(values, "(?::bigint, ?::timestamptz)")
+		args = append(args, id, ts)
+	}
+
+	query := `
+	UPDATE guest_sessions s SET
+		last_active = GREATEST(s.last_active, v.ts)
+	FROM (VALUES ` + strings.Join(values, ", ") + `) AS v(id, ts)
+	WHERE s.id = v.id AND s.active = true
+	`
+
+	if err := db.Exec(query, args...).Error; err != nil {
 		return err
 	}
 
 	return nil
 }
 
+// GetStaleSessions - активные сессии, которые пора закрыть по настройкам их домена:
+// без ивентов дольше таймаута или начатые до полуночи по часовому поясу сайта
 func (d *GuestSessionRepository) GetStaleSessions(ctx context.Context, limit int) (*[]domain.GuestSession, error) {
 	db := getDB(ctx, d.db)
 
 	var sessions []GuestSession
 
 	if err := db.Raw(`
-	SELECT s.id, s.last_active FROM guest_sessions s WHERE s.active = true AND s.last_active < NOW() - INTERVAL  '25 minutes'
-	AND NOT EXISTS 
-	(SELECT 1 FROM events e WHERE e.session_id=s.id AND e.timestamp > NOW() - INTERVAL '30 minutes') 
-	LIMIT $1
+	SELECT s.id, s.last_active FROM guest_sessions s
+	JOIN guests g ON g.id = s.guest_id
+	JOIN domains d ON d.id = g.domain_id
+	WHERE s.active = true AND (
+		(s.last_active < NOW() - make_interval(mins => d.session_timeout)
+		AND NOT EXISTS
+		(SELECT 1 FROM events e WHERE e.session_id=s.id AND e.timestamp > NOW() - make_interval(mins => d.session_timeout)))
+		OR (d.split_at_midnight AND (s.last_active AT TIME ZONE d.timezone)::date < (NOW() AT TIME ZONE d.timezone)::date)
+	)
+	LIMIT ?
 	`, limit).Scan(&sessions).Error; err != nil {
 		return nil, err
 	}
@@ -252,3 +370,208 @@ func (d *GuestSessionRepository) CloseSessions(ctx context.Context, session_ids
 	}
 	return nil
 }
+
+// SetSources - проставляет источник трафика сессиям, у которых его еще нет.
+// источник определяется один раз по первому pageview и дальше не перезаписывается
+func (d *GuestSessionRepository) SetSources(ctx context.Context, sources map[uint]domain.SessionSource) error {
+	if len(sources) == 0 {
+		return nil
+	}
+
+	db := getDB(ctx, d.db)
+
+	values := make([]string, 0, len(sources))
+	args := make([]interface{}, 0, len(sources)*9)
+	for id, src := range sources {
+		values = append(values, "(?::bigint, ?, ?, ?, ?, ?, ?, ?, ?)")
+		args = append(args, id, src.LandingPage, src.ReferrerHost, string(src.SourceType),
+			src.UTMSource, src.UTMMedium, src.UTMCampaign, src.UTMTerm, src.UTMContent)
+	}
+
+	query := `
+	UPDATE guest_sessions s SET
+		landing_page = v.landing_page,
+		referrer_host = v.referrer_host,
+		source_type = v.source_type,
+		utm_source = v.utm_source,
+		utm_medium = v.utm_medium,
+		utm_campaign = v.utm_campaign,
+		utm_term = v.utm_term,
+		utm_content = v.utm_content
+	FROM (VALUES ` + strings.Join(values, ", ") + `) AS v(id, landing_page, referrer_host, source_type, utm_source, utm_medium, utm_campaign, utm_term, utm_content)
+	WHERE s.id = v.id AND s.landing_page = ''
+	`
+
+	if err := db.Exec(query, args...).Error; err != nil {
+		return err
+	}
+
+	return nil
+}
+
+// sourceGroups - выражения группировки сессий gs по источнику, общие для отчетов по источникам и конверсиям
+var sourceGroups = map[string]string{
+	domain.SourcesGroupBySource:   "COALESCE(NULLIF(gs.utm_source, ''), NULLIF(gs.referrer_host, ''), '(direct)')",
+	domain.SourcesGroupByType:     "COALESCE(NULLIF(gs.source_type, ''), 'direct')",
+	domain.SourcesGroupByReferrer: "COALESCE(NULLIF(gs.referrer_host, ''), '(direct)')",
+	domain.SourcesGroupByMedium:   "COALESCE(NULLIF(gs.utm_medium, ''), '(not set)')",
+	domain.SourcesGroupByCampaign: "COALESCE(NULLIF(gs.utm_campaign, ''), '(not set)')",
+}
+
+func (d *GuestSessionRepository) FindSources(ctx context.Context, opts domain.FindSourcesOptions) ([]domain.SourceStats, int64, error) {
+	var allowedOrders = map[string]string{
+		"source":      "source",
+		"visits":      "visits",
+		"uniques":     "uniques",
+		"bounce_rate": "bounce_rate",
+	}
+
+	groupExpr, ok := sourceGroups[opts.GroupBy]
+	if !ok {
+		return nil, 0, domain.ErrSourcesGroupNotAllowed
+	}
+
+	db := getDB(ctx, d.db)
+
+	parts := db.Table("guest_sessions gs").
+		Select(groupExpr+` AS source,
+	COUNT(*) AS visits,
+	COUNT(*) FILTER (WHERE pv.views <= 1) AS bounces
+	`).
+		Joins("JOIN guests g ON g.id=gs.guest_id").
+		Joins("LEFT JOIN LATERAL (SELECT COUNT(*) AS views FROM events e WHERE e.session_id=gs.id AND e.type='pageview') pv ON true").
+		Where("g.domain_id=?", opts.DomainID)
+
+	if opts.RollupUntil != nil {
+		parts = parts.Where("gs.created_at >= ?", opts.RollupUntil)
+	} else if opts.StartDate != nil {
+		parts = parts.Where("gs.created_at >= ?", opts.StartDate)
+	}
+	if opts.EndDate != nil {
+		parts = parts.Where("gs.created_at <= ?", opts.EndDate)
+	}
+
+	parts = parts.Group("source")
+
+	//у rollup_sources колонки источника как у guest_sessions, поэтому группировки те же
+	if opts.RollupUntil != nil {
+		rolled := db.Table("rollup_sources gs").
+			Select(groupExpr+" AS source, SUM(gs.visits) AS visits, SUM(gs.bounces) AS bounces").
+			Where("gs.domain_id = ? AND gs.granularity = ? AND gs.bucket >= ? AND gs.bucket < ?", opts.DomainID, domain.RollupDay, opts.StartDate, opts.RollupUntil).
+			Group("source")
+		parts = db.Raw("(?) UNION ALL (?)", rolled, parts)
+	}
+
+	totals := db.Table("(?) ps", parts).
+		Select(`source,
+	SUM(visits)::bigint AS visits,
+	COALESCE(SUM(bounces)::float / NULLIF(SUM(visits), 0), 0) AS bounce_rate
+	`).
+		Group("source")
+
+	//уникальные гости не складываются из роллапов по дням, поэтому считаются по сырым сессиям за весь период
+	uniques := db.Table("guest_sessions gs").
+		Select(groupExpr+" AS source, COUNT(DISTINCT gs.guest_id) AS uniques").
+		Joins("JOIN guests g ON g.id=gs.guest_id").
+		Where("g.domain_id=?", opts.DomainID)
+	if opts.StartDate != nil {
+		uniques = uniques.Where("gs.created_at >= ?", opts.StartDate)
+	}
+	if opts.EndDate != nil {
+		uniques = uniques.Where("gs.created_at <= ?", opts.EndDate)
+	}
+	uniques = uniques.Group("source")
+
+	query := db.Table("(?) t", totals).
+		Select("t.*, COALESCE(u.uniques, 0) AS uniques").
+		Joins("LEFT JOIN (?) u ON u.source = t.source", uniques)
+
+	var count int64
+	if err := db.Table("(?) s", query).Count(&count).Error; err != nil {
+		return nil, 0, err
+	}
+
+	if opts.Limit != nil {
+		query = query.Limit(*opts.Limit)
+	}
+	if opts.Offset != nil {
+		query = query.Offset(*opts.Offset)
+	}
+
+	if opts.Order != nil && opts.OrderType != nil {
+		columnName, ok := allowedOrders[*opts.Order]
+		if !ok {
+			return nil, 0, domain.ErrFindSourcesOrderNotAllowed
+		}
+		orderType := strings.ToUpper(*opts.OrderType)
+		if orderType != "ASC" && orderType != "DESC" {
+			return nil, 0, domain.ErrFindSourcesOrderNotAllowed
+		}
+		query = query.Order(fmt.Sprintf("%s %s, source ASC", columnName, orderType))
+	} else {
+		query = query.Order("visits DESC, source ASC")
+	}
+
+	var sources []domain.SourceStats
+	if err := query.Scan(&sources).Error; err != nil {
+		return nil, 0, err
+	}
+
+	return sources, count, nil
+}
+
+func (d *GuestSessionRepository) EngagementStates(ctx context.Context, session_ids []uint) (map[uint]domain.EngagementState, error) {
+	states := make(map[uint]domain.EngagementState, len(session_ids))
+	if len(session_ids) == 0 {
+		return states, nil
+	}
+
+	db := getDB(ctx, d.db)
+
+	var mSessions []GuestSession
+	if err := db.Model(&GuestSession{}).
+		Select("id, engagement_last_at, engagement_visible, engagement_pageview_id").
+		Where("id IN ?", session_ids).
+		Clauses(clause.Locking{Strength: "UPDATE"}).
+		Find(&mSessions).Error; err != nil {
+		return nil, err
+	}
+
+	for _, s := range mSessions {
+		states[s.ID] = domain.EngagementState{
+			LastEventAt: s.EngagementLastAt,
+			Visible:     s.EngagementVisible,
+			PageviewID:  s.EngagementPageviewID,
+		}
+	}
+
+	return states, nil
+}
+
+// AddEngagement - прибавляет вовлеченное время сессиям и сохраняет состояние подсчета
+func (d *GuestSessionRepository) AddEngagement(ctx context.Context, results map[uint]domain.EngagementResult) error {
+	if len(results) == 0 {
+		return nil
+	}
+
+	db := getDB(ctx, d.db)
+
+	values := make([]string, 0, len(results))
+	args := make([]interface{}, 0, len(results)*5)
+	for id, res := range results {
+		values = append(values, "(?::bigint, ?::bigint, ?::timestamptz, ?::boolean, ?::bigint)")
+		args = append(args, id, res.Engaged.Milliseconds(), res.State.LastEventAt, res.State.Visible, res.State.PageviewID)
+	}
+
+	query := `
+	UPDATE guest_sessions s SET
+		engaged_ms = s.engaged_ms + v.engaged_ms,
+		engagement_last_at = v.last_at,
+		engagement_visible = v.visible,
+		engagement_pageview_id = v.pageview_id
+	FROM (VALUES ` + strings.Join(values, ", ") + `) AS v(id, engaged_ms, last_at, visible, pageview_id)
+	WHERE s.id = v.id
+	`
+
+	return db.Exec(query, args...).Error
+}
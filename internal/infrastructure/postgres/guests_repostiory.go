@@ -37,7 +37,7 @@ func (r *GuestsRepository) FirstOrCreate(ctx context.Context, fingerprint string
 		DomainID:    domain_id,
 	}
 
-	if err := db.Model(&Guest{}).Where("f_id = ?", fingerprint).FirstOrCreate(&mGuest).Error; err != nil {
+	if err := db.Model(&Guest{}).Where("f_id = ? AND domain_id = ?", fingerprint, domain_id).FirstOrCreate(&mGuest).Error; err != nil {
 		return nil, err
 	}
 
@@ -79,6 +79,7 @@ func (r *GuestsRepository) Find(ctx context.Context, opts domain.FindGuestsOptio
 		"total_seconds_on_site": "total_seconds_on_site",
 		"is_online":             "is_online",
 		"sessions_count":        "sessions_count",
+		"engaged_seconds":       "engaged_seconds",
 	}
 
 	db := getDB(ctx, r.db)
@@ -99,6 +100,7 @@ func (r *GuestsRepository) Find(ctx context.Context, opts domain.FindGuestsOptio
 			ELSE EXTRACT(EPOCH FROM (gs.last_active - gs.created_at))
 		END
 	) AS total_seconds_on_site,
+	COALESCE(SUM(gs.engaged_ms), 0) / 1000.0 AS engaged_seconds,
 	EXISTS(
 	SELECT 1 FROM guest_sessions ss
 	WHERE ss.guest_id=g.id AND ss.active=true AND ss.end_time IS NULL
@@ -173,6 +175,7 @@ func (r *GuestsRepository) ByID(ctx context.Context, guest_id uint) (*domain.Gue
 			ELSE EXTRACT (EPOCH FROM (gs.last_active - gs.created_at))
 		END	
 		) AS total_seconds_on_site,
+	COALESCE(SUM(gs.engaged_ms), 0) / 1000.0 AS engaged_seconds,
 	MIN(gs.created_at) AS first_visit,
 	MAX(gs.created_at) AS last_visit,
 	COUNT(gs.id) AS sessions_count,
@@ -188,3 +191,51 @@ func (r *GuestsRepository) ByID(ctx context.Context, guest_id uint) (*domain.Gue
 
 	return &guest, nil
 }
+
+// Cohorts - гости домена по периоду первого визита и сколько из них были на сайте в каждом следующем периоде.
+// периоды режутся в часовом поясе opts.Timezone
+func (r *GuestsRepository) Cohorts(ctx context.Context, opts domain.CohortOptions) ([]domain.CohortCell, error) {
+	var allowedPeriods = map[string]string{
+		domain.CohortByDay:   "(a.period::date - a.cohort::date)",
+		domain.CohortByWeek:  "(a.period::date - a.cohort::date) / 7",
+		domain.CohortByMonth: "((EXTRACT(YEAR FROM a.period) - EXTRACT(YEAR FROM a.cohort)) * 12 + EXTRACT(MONTH FROM a.period) - EXTRACT(MONTH FROM a.cohort))::int",
+	}
+
+	offsetExpr, ok := allowedPeriods[opts.Period]
+	if !ok {
+		return nil, domain.ErrCohortPeriodNotAllowed
+	}
+
+	db := getDB(ctx, r.db)
+
+	query := `
+	WITH first AS (
+		SELECT g.id AS guest_id, date_trunc(?::text, MIN(gs.created_at) AT TIME ZONE ?::text) AS cohort
+		FROM guests g
+		JOIN guest_sessions gs ON gs.guest_id = g.id
+		WHERE g.domain_id = ?
+		GROUP BY g.id
+		HAVING MIN(gs.created_at) >= ? AND MIN(gs.created_at) <= ?
+	),
+	activity AS (
+		SELECT DISTINCT f.guest_id, f.cohort, date_trunc(?::text, gs.created_at AT TIME ZONE ?::text) AS period
+		FROM first f
+		JOIN guest_sessions gs ON gs.guest_id = f.guest_id
+	)
+	SELECT a.cohort AS start, ` + offsetExpr + ` AS "offset", COUNT(DISTINCT a.guest_id) AS guests
+	FROM activity a
+	WHERE ` + offsetExpr + ` <= ?
+	GROUP BY 1, 2
+	ORDER BY 1, 2
+	`
+
+	cells := []domain.CohortCell{}
+	if err := db.Raw(query,
+		opts.Period, opts.Timezone, opts.DomainID, opts.StartDate, opts.EndDate,
+		opts.Period, opts.Timezone, opts.Periods,
+	).Scan(&cells).Error; err != nil {
+		return nil, err
+	}
+
+	return cells, nil
+}
@@ -0,0 +1,188 @@
+package postgres
+
+import (
+	"context"
+	"errors"
+	domain "metrika/internal/domain/analytics"
+
+	"gorm.io/gorm"
+)
+
+type MembersRepository struct {
+	db *gorm.DB
+}
+
+func NewMembersRepository(db *gorm.DB) *MembersRepository {
+	return &MembersRepository{db}
+}
+
+// Role - роль юзера в домене. владелец домена всегда owner, даже если записи в domain_members нет
+func (r *MembersRepository) Role(ctx context.Context, domain_id uint, user_id uint) (domain.Role, error) {
+	db := getDB(ctx, r.db)
+
+	var mDomain Domain
+	if err := db.Model(&Domain{}).Where("id = ?", domain_id).First(&mDomain).Error; err != nil {
+		if errors.Is(err, gorm.ErrRecordNotFound) {
+			return "", domain.ErrDomainNotFound
+		}
+		return "", err
+	}
+
+	if mDomain.OwnerID != nil && *mDomain.OwnerID == user_id {
+		return domain.RoleOwner, nil
+	}
+
+	var member DomainMember
+	if err := db.Model(&DomainMember{}).Where("domain_id = ? AND user_id = ?", domain_id, user_id).First(&member).Error; err != nil {
+		if errors.Is(err, gorm.ErrRecordNotFound) {
+			return "", domain.ErrMemberNotFound
+		}
+		return "", err
+	}
+
+	return domain.Role(member.Role), nil
+}
+
+func (r *MembersRepository) ByDomain(ctx context.Context, domain_id uint) ([]domain.DomainMember, error) {
+	db := getDB(ctx, r.db)
+
+	var rows []struct {
+		DomainMember
+		Email string
+	}
+
+	if err := db.Table("domain_members m").
+		Select("m.*, u.email").
+		Joins("JOIN users u ON u.id = m.user_id").
+		Where("m.domain_id = ?", domain_id).
+		Order("m.id ASC").
+		Scan(&rows).Error; err != nil {
+		return nil, err
+	}
+
+	members := make([]domain.DomainMember, 0, len(rows))
+	for _, row := range rows {
+		member := row.DomainMember.ToDomain()
+		member.Email = row.Email
+		members = append(members, member)
+	}
+
+	return members, nil
+}
+
+func (r *MembersRepository) Add(ctx context.Context, member *domain.DomainMember) error {
+	db := getDB(ctx, r.db)
+
+	mMember := DomainMember{
+		DomainID: member.DomainID,
+		UserID:   member.UserID,
+		Role:     string(member.Role),
+	}
+
+	if err := db.Model(&DomainMember{}).Create(&mMember).Error; err != nil {
+		if errors.Is(err, gorm.ErrDuplicatedKey) {
+			return domain.ErrMemberAlreadyExists
+		}
+		return err
+	}
+
+	member.CreatedAt = mMember.CreatedAt
+
+	return nil
+}
+
+func (r *MembersRepository) UpdateRole(ctx context.Context, domain_id uint, user_id uint, role domain.Role) error {
+	db := getDB(ctx, r.db)
+
+	res := db.Model(&DomainMember{}).Where("domain_id = ? AND user_id = ?", domain_id, user_id).Update("role", string(role))
+	if res.Error != nil {
+		return res.Error
+	}
+	if res.RowsAffected == 0 {
+		return domain.ErrMemberNotFound
+	}
+
+	return nil
+}
+
+func (r *MembersRepository) Remove(ctx context.Context, domain_id uint, user_id uint) error {
+	db := getDB(ctx, r.db)
+
+	res := db.Where("domain_id = ? AND user_id = ?", domain_id, user_id).Delete(&DomainMember{})
+	if res.Error != nil {
+		return res.Error
+	}
+	if res.RowsAffected == 0 {
+		return domain.ErrMemberNotFound
+	}
+
+	return nil
+}
+
+func (r *MembersRepository) CreateInvitation(ctx context.Context, invitation *domain.DomainInvitation) error {
+	db := getDB(ctx, r.db)
+
+	mInvitation := DomainInvitation{
+		DomainID:  invitation.DomainID,
+		Email:     invitation.Email,
+		Role:      string(invitation.Role),
+		Token:     invitation.Token,
+		InvitedBy: invitation.InvitedBy,
+		ExpiresAt: invitation.ExpiresAt,
+	}
+
+	if err := db.Model(&DomainInvitation{}).Create(&mInvitation).Error; err != nil {
+		return err
+	}
+
+	invitation.ID = mInvitation.ID
+	invitation.CreatedAt = mInvitation.CreatedAt
+
+	return nil
+}
+
+func (r *MembersRepository) InvitationByToken(ctx context.Context, token string) (*domain.DomainInvitation, error) {
+	db := getDB(ctx, r.db)
+
+	var mInvitation DomainInvitation
+	if err := db.Model(&DomainInvitation{}).Where("token = ?", token).First(&mInvitation).Error; err != nil {
+		if errors.Is(err, gorm.ErrRecordNotFound) {
+			return nil, domain.ErrInvitationNotFound
+		}
+		return nil, err
+	}
+
+	invitation := mInvitation.ToDomain()
+
+	return &invitation, nil
+}
+
+func (r *MembersRepository) InvitationsByDomain(ctx context.Context, domain_id uint) ([]domain.DomainInvitation, error) {
+	db := getDB(ctx, r.db)
+
+	var mInvitations []DomainInvitation
+	if err := db.Model(&DomainInvitation{}).Where("domain_id = ?", domain_id).Order("id ASC").Find(&mInvitations).Error; err != nil {
+		return nil, err
+	}
+
+	invitations := make([]domain.DomainInvitation, 0, len(mInvitations))
+	for _, invitation := range mInvitations {
+		invitations = append(invitations, invitation.ToDomain())
+	}
+
+	return invitations, nil
+}
+
+func (r *MembersRepository) DeleteInvitation(ctx context.Context, domain_id uint, invitation_id uint) error {
+	db := getDB(ctx, r.db)
+
+	res := db.Where("domain_id = ? AND id = ?", domain_id, invitation_id).Delete(&DomainInvitation{})
+	if res.Error != nil {
+		return res.Error
+	}
+	if res.RowsAffected == 0 {
+		return domain.ErrInvitationNotFound
+	}
+
+	return nil
+}
@@ -13,22 +13,73 @@ type Model struct {
 
 type Event struct {
 	Model
-	SessionID uint                   `gorm:"column:session_id;NOT NULL"`
-	Type      string                 `gorm:"column:type;NOT NULL"`
-	PageURL   string                 `gorm:"column:page_url;NOT NULL"`
-	Element   string                 `gorm:"column:element;NOT NULL"`
-	Timestamp time.Time              `gorm:"column:timestamp;NOT NULL"`
-	Data      map[string]interface{} `gorm:"serializer:json;column:data"`
+	SessionID uint   `gorm:"column:session_id;NOT NULL"`
+	Type      string `gorm:"column:type;NOT NULL"`
+	PageURL   string `gorm:"column:page_url;NOT NULL"`
+	Element   string `gorm:"column:element;NOT NULL"`
+	//по времени ивентов режутся роллапы
+	Timestamp time.Time `gorm:"column:timestamp;NOT NULL;index"`
+	//jsonb с GIN индексом, чтобы фильтры по свойствам через @> шли по индексу
+	Data map[string]interface{} `gorm:"serializer:json;column:data;type:jsonb;index:idx_events_data,type:gin"`
+	//вовлеченное время pageview, для остальных ивентов 0
+	EngagedMs int64 `gorm:"column:engaged_ms;NOT NULL;default:0"`
+}
+
+// EventCatalog - типы ивентов и ключи их свойств, которые присылал домен.
+// строка с пустым property - сам тип ивента
+type EventCatalog struct {
+	ID        uint    `gorm:"primarykey"`
+	DomainID  uint    `gorm:"column:domain_id;NOT NULL;uniqueIndex:idx_event_catalog_key,priority:1"`
+	Domain    *Domain `gorm:"foreignKey:DomainID;constraint:OnDelete:CASCADE"`
+	EventType string  `gorm:"column:event_type;NOT NULL;uniqueIndex:idx_event_catalog_key,priority:2"`
+	Property  string  `gorm:"column:property;NOT NULL;default:'';uniqueIndex:idx_event_catalog_key,priority:3"`
+	//тип последнего увиденного значения, как его называет jsonb_typeof
+	ValueType string    `gorm:"column:value_type;NOT NULL;default:''"`
+	Events    int64     `gorm:"column:events;NOT NULL;default:0"`
+	FirstSeen time.Time `gorm:"column:first_seen;NOT NULL"`
+	LastSeen  time.Time `gorm:"column:last_seen;NOT NULL"`
+}
+
+func (c EventCatalog) ToDomain() analytics.EventCatalogEntry {
+	return analytics.EventCatalogEntry{
+		DomainID:  c.DomainID,
+		EventType: c.EventType,
+		Property:  c.Property,
+		ValueType: c.ValueType,
+		Events:    c.Events,
+		FirstSeen: c.FirstSeen,
+		LastSeen:  c.LastSeen,
+	}
 }
 
+// RecordEvent - старый построчный rrweb-ивент, новые записи хранятся чанками, а эти переносит в них MigrateLegacy.
+// created_at обязателен: в базах, где таблица успела стать партиционированной, он входит в первичный ключ
 type RecordEvent struct {
-	Model
-	SessionID uint                   `gorm:"column:session_id;NOT NULL"`
-	Type      int                 `gorm:"column:type;NOT NULL"`
-	Timestamp int64              `gorm:"column:timestamp;NOT NULL"`
+	ID        uint      `gorm:"primarykey"`
+	CreatedAt time.Time `gorm:"column:created_at;NOT NULL"`
+	UpdatedAt time.Time
+	SessionID uint                   `gorm:"column:session_id;NOT NULL;index:idx_record_events_session_ts,priority:1"`
+	Type      int                    `gorm:"column:type;NOT NULL"`
+	Timestamp int64                  `gorm:"column:timestamp;NOT NULL;index:idx_record_events_session_ts,priority:2"`
 	Data      map[string]interface{} `gorm:"serializer:json;column:data"`
 }
 
+// RecordChunk - сжатая пачка rrweb-ивентов одной сессии, таблица режется на помесячные партиции по created_at.
+// по start_ts/end_ts и маске типов чанки выбираются без распаковки
+type RecordChunk struct {
+	ID uint `gorm:"primarykey"`
+	//ключ партиционирования, входит в первичный ключ вместе с id
+	CreatedAt   time.Time `gorm:"column:created_at;NOT NULL"`
+	SessionID   uint      `gorm:"column:session_id;NOT NULL;index:idx_record_chunks_session_ts,priority:1"`
+	StartTs     int64     `gorm:"column:start_ts;NOT NULL;index:idx_record_chunks_session_ts,priority:2"`
+	EndTs       int64     `gorm:"column:end_ts;NOT NULL"`
+	EventsCount int       `gorm:"column:events_count;NOT NULL"`
+	//бит i выставлен, если в чанке есть ивент rrweb типа i
+	TypesMask int64  `gorm:"column:types_mask;NOT NULL;default:0"`
+	Codec     string `gorm:"column:codec;NOT NULL"`
+	Data      []byte `gorm:"column:data;type:bytea;NOT NULL"`
+}
+
 type User struct {
 	Model
 	Name      string `gorm:"column:name;NOT NULL" json:"name"`
@@ -47,8 +98,127 @@ type UserSession struct {
 
 type Domain struct {
 	Model
+	OwnerID *uint   `gorm:"column:owner_id;index" json:"owner_id"`
+	Owner   *User   `gorm:"foreignkey:OwnerID;constraint:OnDelete:SET NULL" json:"-"`
+	Name    string  `gorm:"column:name;NOT NULL;default:''" json:"name"`
 	SiteURL string  `gorm:"column:site_url;unique;NOT NULL" json:"site_url"`
-	Guests  []Guest `gorm:"foreignkey:DomainID;constraint:OnDelete:CASCADE"`
+	SiteKey *string `gorm:"column:site_key;uniqueIndex" json:"site_key"`
+	//правила маскирования записей сессий в json, NULL - правила по умолчанию
+	MaskingRules *string `gorm:"column:masking_rules;type:text" json:"-"`
+	//правила нарезки сессий, см. analytics.SessionSettings
+	SessionTimeout  int     `gorm:"column:session_timeout;NOT NULL;default:30"`
+	Timezone        string  `gorm:"column:timezone;NOT NULL;default:'UTC'"`
+	SplitAtMidnight bool    `gorm:"column:split_at_midnight;NOT NULL;default:true"`
+	SplitOnCampaign bool    `gorm:"column:split_on_campaign;NOT NULL;default:true"`
+	Guests          []Guest `gorm:"foreignkey:DomainID;constraint:OnDelete:CASCADE"`
+
+	//сроки хранения данных в днях, 0 - бессрочно. см. analytics.RetentionSettings
+	RetentionEventsDays   int `gorm:"column:retention_events_days;NOT NULL;default:90"`
+	RetentionSessionsDays int `gorm:"column:retention_sessions_days;NOT NULL;default:90"`
+	RetentionReplaysDays  int `gorm:"column:retention_replays_days;NOT NULL;default:30"`
+	RetentionRollupsDays  int `gorm:"column:retention_rollups_days;NOT NULL;default:0"`
+}
+
+type DomainMember struct {
+	Model
+	DomainID uint   `gorm:"column:domain_id;NOT NULL;uniqueIndex:idx_domain_members_domain_user"`
+	UserID   uint   `gorm:"column:user_id;NOT NULL;uniqueIndex:idx_domain_members_domain_user;index"`
+	Role     string `gorm:"column:role;NOT NULL"`
+}
+
+func (m DomainMember) ToDomain() analytics.DomainMember {
+	return analytics.DomainMember{
+		DomainID:  m.DomainID,
+		UserID:    m.UserID,
+		Role:      analytics.Role(m.Role),
+		CreatedAt: m.CreatedAt,
+	}
+}
+
+type DomainInvitation struct {
+	Model
+	DomainID  uint      `gorm:"column:domain_id;NOT NULL;index"`
+	Email     string    `gorm:"column:email;NOT NULL"`
+	Role      string    `gorm:"column:role;NOT NULL"`
+	Token     string    `gorm:"column:token;NOT NULL;uniqueIndex"`
+	InvitedBy uint      `gorm:"column:invited_by;NOT NULL"`
+	ExpiresAt time.Time `gorm:"column:expires_at;NOT NULL"`
+}
+
+func (i DomainInvitation) ToDomain() analytics.DomainInvitation {
+	return analytics.DomainInvitation{
+		ID:        i.ID,
+		DomainID:  i.DomainID,
+		Email:     i.Email,
+		Role:      analytics.Role(i.Role),
+		Token:     i.Token,
+		InvitedBy: i.InvitedBy,
+		ExpiresAt: i.ExpiresAt,
+		CreatedAt: i.CreatedAt,
+	}
+}
+
+// Goal - цель домена, см. analytics.Goal
+type Goal struct {
+	Model
+	DomainID   uint                        `gorm:"column:domain_id;NOT NULL;index"`
+	Domain     *Domain                     `gorm:"foreignKey:DomainID;constraint:OnDelete:CASCADE"`
+	Name       string                      `gorm:"column:name;NOT NULL"`
+	Type       string                      `gorm:"column:type;NOT NULL"`
+	URLPattern string                      `gorm:"column:url_pattern;NOT NULL;default:''"`
+	EventType  string                      `gorm:"column:event_type;NOT NULL;default:''"`
+	Selector   string                      `gorm:"column:selector;NOT NULL;default:''"`
+	FormID     string                      `gorm:"column:form_id;NOT NULL;default:''"`
+	Properties []analytics.PropertyMatcher `gorm:"serializer:json;column:properties"`
+}
+
+func (g Goal) ToDomain() analytics.Goal {
+	return analytics.Goal{
+		ID:         g.ID,
+		DomainID:   g.DomainID,
+		Name:       g.Name,
+		Type:       analytics.GoalType(g.Type),
+		URLPattern: g.URLPattern,
+		EventType:  g.EventType,
+		Selector:   g.Selector,
+		FormID:     g.FormID,
+		Properties: g.Properties,
+		CreatedAt:  g.CreatedAt,
+	}
+}
+
+// Conversion - достижение цели в сессии, одна строка на пару цель-сессия
+type Conversion struct {
+	ID        uint `gorm:"primarykey"`
+	CreatedAt time.Time
+	GoalID    uint      `gorm:"column:goal_id;NOT NULL;uniqueIndex:idx_conversions_goal_session,priority:1"`
+	Goal      *Goal     `gorm:"foreignKey:GoalID;constraint:OnDelete:CASCADE"`
+	SessionID uint      `gorm:"column:session_id;NOT NULL;uniqueIndex:idx_conversions_goal_session,priority:2;index"`
+	EventID   uint      `gorm:"column:event_id;NOT NULL"`
+	Timestamp time.Time `gorm:"column:timestamp;NOT NULL"`
+}
+
+// Funnel - воронка домена, шаги хранятся в json
+type Funnel struct {
+	Model
+	DomainID      uint                   `gorm:"column:domain_id;NOT NULL;index"`
+	Domain        *Domain                `gorm:"foreignKey:DomainID;constraint:OnDelete:CASCADE"`
+	Name          string                 `gorm:"column:name;NOT NULL"`
+	Scope         string                 `gorm:"column:scope;NOT NULL"`
+	WindowMinutes int                    `gorm:"column:window_minutes;NOT NULL"`
+	Steps         []analytics.FunnelStep `gorm:"serializer:json;column:steps"`
+}
+
+func (f Funnel) ToDomain() analytics.Funnel {
+	return analytics.Funnel{
+		ID:            f.ID,
+		DomainID:      f.DomainID,
+		Name:          f.Name,
+		Scope:         analytics.FunnelScope(f.Scope),
+		WindowMinutes: f.WindowMinutes,
+		Steps:         f.Steps,
+		CreatedAt:     f.CreatedAt,
+	}
 }
 
 type Guest struct {
@@ -58,20 +228,160 @@ type Guest struct {
 	Sessions    []GuestSession `gorm:"foreignkey:GuestID;constraint:OnDelete:CASCADE" json:"sessions,omitempty"`
 }
 
+func (d Domain) ToDomain() *analytics.Domain {
+	dom := &analytics.Domain{
+		ID:        d.ID,
+		Name:      d.Name,
+		SiteURL:   d.SiteURL,
+		CreatedAt: d.CreatedAt,
+		SessionSettings: analytics.SessionSettings{
+			TimeoutMinutes:        d.SessionTimeout,
+			Timezone:              d.Timezone,
+			SplitAtMidnight:       d.SplitAtMidnight,
+			SplitOnCampaignChange: d.SplitOnCampaign,
+		},
+		Retention: analytics.RetentionSettings{
+			EventsDays:   d.RetentionEventsDays,
+			SessionsDays: d.RetentionSessionsDays,
+			ReplaysDays:  d.RetentionReplaysDays,
+			RollupsDays:  d.RetentionRollupsDays,
+		},
+	}
+	if d.SiteKey != nil {
+		dom.SiteKey = *d.SiteKey
+	}
+	if d.OwnerID != nil {
+		dom.OwnerID = *d.OwnerID
+	}
+	return dom
+}
+
 func (g Guest) ToDomain() *analytics.Guest {
 	return &analytics.Guest{
-		ID: g.ID,
-		DomainID: g.DomainID,
+		ID:          g.ID,
+		DomainID:    g.DomainID,
 		Fingerprint: g.Fingerprint,
-		
 	}
 }
 
+// GuestSession - сессия гостя, таблица режется на помесячные партиции по created_at.
+// внешние ключи на партиционированную таблицу невозможны, поэтому строки, которые ссылаются на сессию,
+// удаляются вместе с ней явно
 type GuestSession struct {
-	Model
+	ID uint `gorm:"primarykey"`
+	//ключ партиционирования, входит в первичный ключ вместе с id
+	CreatedAt  time.Time `gorm:"column:created_at;NOT NULL"`
+	UpdatedAt  time.Time
 	GuestID    uint       `gorm:"column:guest_id;NOT NULL" json:"guest_id"`
 	IPAddress  string     `gorm:"column:ip_address;NOT NULL" json:"ip_address"`
 	Active     bool       `gorm:"column:active;NOT NULL;default:false"`
 	EndTime    *time.Time `gorm:"column:end_time;default:NULL"`
 	LastActive time.Time  `gorm:"column:last_active;NOT NULL;default:CURRENT_TIMESTAMP"`
+	Device     string     `gorm:"column:device;NOT NULL;default:''"`
+	Country    string     `gorm:"column:country;NOT NULL;default:''"`
+	//вовлеченное время и состояние его подсчета между пачками ивентов
+	EngagedMs            int64      `gorm:"column:engaged_ms;NOT NULL;default:0"`
+	EngagementLastAt     *time.Time `gorm:"column:engagement_last_at;default:NULL"`
+	EngagementVisible    bool       `gorm:"column:engagement_visible;NOT NULL;default:false"`
+	EngagementPageviewID uint       `gorm:"column:engagement_pageview_id;NOT NULL;default:0"`
+	//источник трафика, заполняется по первому pageview сессии
+	LandingPage  string `gorm:"column:landing_page;NOT NULL;default:''"`
+	ReferrerHost string `gorm:"column:referrer_host;NOT NULL;default:''"`
+	SourceType   string `gorm:"column:source_type;NOT NULL;default:''"`
+	UTMSource    string `gorm:"column:utm_source;NOT NULL;default:''"`
+	UTMMedium    string `gorm:"column:utm_medium;NOT NULL;default:''"`
+	UTMCampaign  string `gorm:"column:utm_campaign;NOT NULL;default:''"`
+	UTMTerm      string `gorm:"column:utm_term;NOT NULL;default:''"`
+	UTMContent   string `gorm:"column:utm_content;NOT NULL;default:''"`
+}
+
+func (s GuestSession) Source() analytics.SessionSource {
+	return analytics.SessionSource{
+		LandingPage:  s.LandingPage,
+		ReferrerHost: s.ReferrerHost,
+		SourceType:   analytics.SourceType(s.SourceType),
+		UTMSource:    s.UTMSource,
+		UTMMedium:    s.UTMMedium,
+		UTMCampaign:  s.UTMCampaign,
+		UTMTerm:      s.UTMTerm,
+		UTMContent:   s.UTMContent,
+	}
+}
+
+func (e RecordEvent) ToDomain() analytics.RecordEvent {
+	return analytics.RecordEvent{
+		ID:        e.ID,
+		SessionID: e.SessionID,
+		Type:      e.Type,
+		Timestamp: e.Timestamp,
+		Data:      e.Data,
+	}
+}
+
+// RollupStat - агрегаты домена за час или сутки, см. analytics.RollupHour.
+// сессии относятся к интервалу своего начала, просмотры - к интервалу ивента
+type RollupStat struct {
+	DomainID    uint      `gorm:"column:domain_id;NOT NULL;uniqueIndex:idx_rollup_stats_key,priority:1"`
+	Domain      *Domain   `gorm:"foreignKey:DomainID;constraint:OnDelete:CASCADE"`
+	Granularity string    `gorm:"column:granularity;NOT NULL;uniqueIndex:idx_rollup_stats_key,priority:2"`
+	Bucket      time.Time `gorm:"column:bucket;NOT NULL;uniqueIndex:idx_rollup_stats_key,priority:3"`
+	Visits      int64     `gorm:"column:visits;NOT NULL;default:0"`
+	Uniques     int64     `gorm:"column:uniques;NOT NULL;default:0"`
+	Pageviews   int64     `gorm:"column:pageviews;NOT NULL;default:0"`
+	//сессии не больше чем с одним просмотром
+	Bounces         int64   `gorm:"column:bounces;NOT NULL;default:0"`
+	DurationSeconds float64 `gorm:"column:duration_seconds;NOT NULL;default:0"`
+}
+
+// RollupPage - агрегаты просмотров страницы за интервал, суммы вместо средних, чтобы интервалы складывались
+type RollupPage struct {
+	DomainID          uint      `gorm:"column:domain_id;NOT NULL;uniqueIndex:idx_rollup_pages_key,priority:1"`
+	Domain            *Domain   `gorm:"foreignKey:DomainID;constraint:OnDelete:CASCADE"`
+	Granularity       string    `gorm:"column:granularity;NOT NULL;uniqueIndex:idx_rollup_pages_key,priority:2"`
+	Bucket            time.Time `gorm:"column:bucket;NOT NULL;uniqueIndex:idx_rollup_pages_key,priority:3"`
+	Path              string    `gorm:"column:path;NOT NULL;uniqueIndex:idx_rollup_pages_key,priority:4"`
+	Title             string    `gorm:"column:title;NOT NULL;default:''"`
+	Views             int64     `gorm:"column:views;NOT NULL;default:0"`
+	UniqueGuests      int64     `gorm:"column:unique_guests;NOT NULL;default:0"`
+	TimeOnPageSeconds float64   `gorm:"column:time_on_page_seconds;NOT NULL;default:0"`
+	EngagedMs         int64     `gorm:"column:engaged_ms;NOT NULL;default:0"`
+	Entrances         int64     `gorm:"column:entrances;NOT NULL;default:0"`
+	Exits             int64     `gorm:"column:exits;NOT NULL;default:0"`
+	Bounces           int64     `gorm:"column:bounces;NOT NULL;default:0"`
+}
+
+// RollupSource - сессии за интервал по источнику. колонки источника названы как в guest_sessions,
+// чтобы группировки отчета по источникам работали на обеих таблицах
+type RollupSource struct {
+	DomainID        uint      `gorm:"column:domain_id;NOT NULL;uniqueIndex:idx_rollup_sources_key,priority:1"`
+	Domain          *Domain   `gorm:"foreignKey:DomainID;constraint:OnDelete:CASCADE"`
+	Granularity     string    `gorm:"column:granularity;NOT NULL;uniqueIndex:idx_rollup_sources_key,priority:2"`
+	Bucket          time.Time `gorm:"column:bucket;NOT NULL;uniqueIndex:idx_rollup_sources_key,priority:3"`
+	SourceType      string    `gorm:"column:source_type;NOT NULL;default:'';uniqueIndex:idx_rollup_sources_key,priority:4"`
+	ReferrerHost    string    `gorm:"column:referrer_host;NOT NULL;default:'';uniqueIndex:idx_rollup_sources_key,priority:5"`
+	UTMSource       string    `gorm:"column:utm_source;NOT NULL;default:'';uniqueIndex:idx_rollup_sources_key,priority:6"`
+	UTMMedium       string    `gorm:"column:utm_medium;NOT NULL;default:'';uniqueIndex:idx_rollup_sources_key,priority:7"`
+	UTMCampaign     string    `gorm:"column:utm_campaign;NOT NULL;default:'';uniqueIndex:idx_rollup_sources_key,priority:8"`
+	Visits          int64     `gorm:"column:visits;NOT NULL;default:0"`
+	Uniques         int64     `gorm:"column:uniques;NOT NULL;default:0"`
+	Bounces         int64     `gorm:"column:bounces;NOT NULL;default:0"`
+	DurationSeconds float64   `gorm:"column:duration_seconds;NOT NULL;default:0"`
+}
+
+// RollupState - до какого момента посчитаны роллапы домена
+type RollupState struct {
+	DomainID    uint      `gorm:"column:domain_id;primaryKey"`
+	Domain      *Domain   `gorm:"foreignKey:DomainID;constraint:OnDelete:CASCADE"`
+	Granularity string    `gorm:"column:granularity;primaryKey"`
+	Timezone    string    `gorm:"column:timezone;NOT NULL"`
+	RolledUntil time.Time `gorm:"column:rolled_until;NOT NULL"`
+}
+
+func (s RollupState) ToDomain() analytics.RollupState {
+	return analytics.RollupState{
+		DomainID:    s.DomainID,
+		Granularity: s.Granularity,
+		Timezone:    s.Timezone,
+		RolledUntil: s.RolledUntil,
+	}
 }
@@ -0,0 +1,276 @@
+package postgres
+
+import (
+	"fmt"
+	domain "metrika/internal/domain/analytics"
+	"sort"
+	"strings"
+	"time"
+
+	"gorm.io/gorm"
+)
+
+type partitionedTable struct {
+	table string
+	//колонка партиционирования
+	key string
+}
+
+// partitionedTables - таблица каждого вида данных, который режется на помесячные партиции.
+// записи сессий хранятся чанками, старые построчные record_events переносятся в них и не партиционируются
+var partitionedTables = map[string]partitionedTable{
+	domain.RetainedEvents:   {"events", "timestamp"},
+	domain.RetainedSessions: {"guest_sessions", "created_at"},
+	domain.RetainedReplays:  {"record_chunks", "created_at"},
+}
+
+// сколько строк переносится из старой таблицы в партиционированную за одну транзакцию
+const partitionMigrationBatch = 10000
+
+// партиция месяца называется events_p2026_01, строки вне всех партиций попадают в events_default
+const partitionSuffixLayout = "2006_01"
+
+func (t partitionedTable) partitionName(month time.Time) string {
+	return t.table + "_p" + month.Format(partitionSuffixLayout)
+}
+
+func (t partitionedTable) defaultPartition() string {
+	return t.table + "_default"
+}
+
+func (t partitionedTable) legacy() string {
+	return t.table + "_legacy"
+}
+
+// migratePartitions - переводит обычные таблицы ивентов, сессий и чанков записей на помесячные партиции.
+// сначала в одной короткой транзакции таблица переименовывается в _legacy и на ее месте создается
+// партиционированная, так что новые строки сразу пишутся в нее. затем старые строки переносятся пачками
+// по partitionMigrationBatch, каждая в своей транзакции, и старая таблица удаляется.
+// перенос возобновляется при следующем запуске, если процесс остановился посередине.
+// пока он идет, другие инстансы не видят еще не перенесенные строки в отчетах, поэтому
+// на больших базах его лучше провести отдельным запуском при остановленном трафике
+func migratePartitions(db *gorm.DB) error {
+	for _, data := range domain.PartitionedData {
+		t := partitionedTables[data]
+
+		var kind string
+		if err := db.Raw("SELECT relkind FROM pg_class WHERE oid = to_regclass(?)", t.table).Scan(&kind).Error; err != nil {
+			return err
+		}
+		//p - уже партиционирована
+		if kind != "r" {
+			continue
+		}
+
+		if err := db.Transaction(func(tx *gorm.DB) error {
+			return partitionTable(tx, t)
+		}); err != nil {
+			return fmt.Errorf("partition %s: %w", t.table, err)
+		}
+	}
+
+	//индексы создаются на пустых таблицах, перенесенные строки попадают в них сразу
+	if err := db.AutoMigrate(&Event{}, &GuestSession{}, &RecordChunk{}); err != nil {
+		return err
+	}
+
+	//внешний ключ сессий на гостей пересоздается на партиционированной таблице
+	if !db.Migrator().HasConstraint(&Guest{}, "Sessions") {
+		if err := db.Migrator().CreateConstraint(&Guest{}, "Sessions"); err != nil {
+			return err
+		}
+	}
+
+	for _, data := range domain.PartitionedData {
+		t := partitionedTables[data]
+		if err := moveLegacyRows(db, t); err != nil {
+			return fmt.Errorf("move %s rows: %w", t.legacy(), err)
+		}
+	}
+
+	return nil
+}
+
+// partitionTable - создает партиционированную таблицу на месте обычной, строки остаются в _legacy
+func partitionTable(tx *gorm.DB, t partitionedTable) error {
+	legacy := t.legacy()
+
+	if err := tx.Exec(fmt.Sprintf(`ALTER TABLE %q RENAME TO %q`, t.table, legacy)).Error; err != nil {
+		return err
+	}
+
+	//имя первичного ключа освобождается для новой таблицы
+	var pkey string
+	if err := tx.Raw("SELECT conname FROM pg_constraint WHERE conrelid = to_regclass(?) AND contype = 'p'", legacy).Scan(&pkey).Error; err != nil {
+		return err
+	}
+	if pkey != "" {
+		if err := tx.Exec(fmt.Sprintf(`ALTER TABLE %q RENAME CONSTRAINT %q TO %q`, legacy, pkey, legacy+"_pkey")).Error; err != nil {
+			return err
+		}
+	}
+
+	//внешние ключи других таблиц остались бы на старой таблице и не пускали бы строки, ссылающиеся на новые
+	var references []struct {
+		Conname string
+		Conrel  string
+	}
+	if err := tx.Raw("SELECT conname, conrelid::regclass::text AS conrel FROM pg_constraint WHERE confrelid = to_regclass(?) AND contype = 'f'", legacy).
+		Scan(&references).Error; err != nil {
+		return err
+	}
+	for _, ref := range references {
+		if err := tx.Exec(fmt.Sprintf(`ALTER TABLE %s DROP CONSTRAINT %q`, ref.Conrel, ref.Conname)).Error; err != nil {
+			return err
+		}
+	}
+
+	//ключ партиционирования входит в первичный ключ и не может быть пустым
+	if err := tx.Exec(fmt.Sprintf(`UPDATE %q SET %q = now() WHERE %q IS NULL`, legacy, t.key, t.key)).Error; err != nil {
+		return err
+	}
+
+	statements := []string{
+		fmt.Sprintf(`CREATE TABLE %q (LIKE %q INCLUDING DEFAULTS, PRIMARY KEY (id, %q)) PARTITION BY RANGE (%q)`, t.table, legacy, t.key, t.key),
+		fmt.Sprintf(`CREATE TABLE %q PARTITION OF %q DEFAULT`, t.defaultPartition(), t.table),
+	}
+	for _, statement := range statements {
+		if err := tx.Exec(statement).Error; err != nil {
+			return err
+		}
+	}
+
+	//партиции на все месяцы, за которые есть строки, и на следующий месяц
+	var bounds struct {
+		First *time.Time
+		Last  *time.Time
+	}
+	if err := tx.Raw(fmt.Sprintf(`SELECT MIN(%q) AS first, MAX(%q) AS last FROM %q`, t.key, t.key, legacy)).Scan(&bounds).Error; err != nil {
+		return err
+	}
+	now := time.Now()
+	from, to := domain.PartitionMonth(now), domain.PartitionMonth(now).AddDate(0, 1, 0)
+	if bounds.First != nil && bounds.First.Before(from) {
+		from = domain.PartitionMonth(*bounds.First)
+	}
+	if bounds.Last != nil && bounds.Last.After(to) {
+		to = domain.PartitionMonth(*bounds.Last)
+	}
+	for month := from; !month.After(to); month = month.AddDate(0, 1, 0) {
+		if err := createPartition(tx, t, month); err != nil {
+			return err
+		}
+	}
+
+	//счетчик id переходит к новой таблице, иначе удалится вместе со старой
+	var sequence string
+	if err := tx.Raw("SELECT COALESCE(pg_get_serial_sequence(?, 'id'), '')", legacy).Scan(&sequence).Error; err != nil {
+		return err
+	}
+	if sequence != "" {
+		if err := tx.Exec(fmt.Sprintf(`ALTER SEQUENCE %s OWNED BY %q.id`, sequence, t.table)).Error; err != nil {
+			return err
+		}
+	}
+
+	return nil
+}
+
+// moveLegacyRows - переносит строки из _legacy пачками по id и удаляет ее, когда она опустеет
+func moveLegacyRows(db *gorm.DB, t partitionedTable) error {
+	legacy := t.legacy()
+
+	var exists bool
+	if err := db.Raw("SELECT to_regclass(?) IS NOT NULL", legacy).Scan(&exists).Error; err != nil {
+		return err
+	}
+	if !exists {
+		return nil
+	}
+
+	//колонки перечисляются явно: в новую таблицу миграции могли добавить колонки, которых нет в старой
+	var columns []string
+	if err := db.Raw("SELECT quote_ident(attname) FROM pg_attribute WHERE attrelid = to_regclass(?) AND attnum > 0 AND NOT attisdropped ORDER BY attnum", legacy).
+		Scan(&columns).Error; err != nil {
+		return err
+	}
+	list := strings.Join(columns, ", ")
+
+	move := fmt.Sprintf(`WITH moved AS (DELETE FROM %q WHERE id IN (SELECT id FROM %q ORDER BY id LIMIT ?) RETURNING %s) INSERT INTO %q (%s) SELECT %s FROM moved`,
+		legacy, legacy, list, t.table, list, list)
+	for {
+		res := db.Exec(move, partitionMigrationBatch)
+		if res.Error != nil {
+			return res.Error
+		}
+		if res.RowsAffected < partitionMigrationBatch {
+			break
+		}
+	}
+
+	return db.Exec(fmt.Sprintf(`DROP TABLE %q`, legacy)).Error
+}
+
+// createPartition - партиция месяца создается отдельной таблицей, в нее переносятся строки месяца
+// из партиции по умолчанию и только потом она подключается к родительской. должна вызываться в транзакции
+func createPartition(tx *gorm.DB, t partitionedTable, month time.Time) error {
+	name := t.partitionName(month)
+	from, to := month, month.AddDate(0, 1, 0)
+
+	var exists bool
+	if err := tx.Raw("SELECT to_regclass(?) IS NOT NULL", name).Scan(&exists).Error; err != nil {
+		return err
+	}
+	if exists {
+		return nil
+	}
+
+	//пока строки переносятся, новые в партицию по умолчанию не пишутся
+	statements := []string{
+		fmt.Sprintf(`LOCK TABLE %q IN SHARE ROW EXCLUSIVE MODE`, t.defaultPartition()),
+		fmt.Sprintf(`CREATE TABLE %q (LIKE %q INCLUDING DEFAULTS)`, name, t.table),
+	}
+	for _, statement := range statements {
+		if err := tx.Exec(statement).Error; err != nil {
+			return err
+		}
+	}
+
+	move := fmt.Sprintf(`WITH moved AS (DELETE FROM %q WHERE %q >= ? AND %q < ? RETURNING *) INSERT INTO %q SELECT * FROM moved`,
+		t.defaultPartition(), t.key, t.key, name)
+	if err := tx.Exec(move, from, to).Error; err != nil {
+		return err
+	}
+
+	attach := fmt.Sprintf(`ALTER TABLE %q ATTACH PARTITION %q FOR VALUES FROM ('%s') TO ('%s')`,
+		t.table, name, from.Format(time.RFC3339), to.Format(time.RFC3339))
+	return tx.Exec(attach).Error
+}
+
+// partitions - помесячные партиции таблицы по возрастанию, месяц берется из имени партиции
+func partitions(db *gorm.DB, data string, t partitionedTable) ([]domain.Partition, error) {
+	var names []string
+	if err := db.Raw("SELECT c.relname FROM pg_inherits i JOIN pg_class c ON c.oid = i.inhrelid WHERE i.inhparent = to_regclass(?)", t.table).
+		Scan(&names).Error; err != nil {
+		return nil, err
+	}
+
+	result := []domain.Partition{}
+	for _, name := range names {
+		suffix, ok := strings.CutPrefix(name, t.table+"_p")
+		if !ok {
+			continue
+		}
+		month, err := time.Parse(partitionSuffixLayout, suffix)
+		if err != nil {
+			continue
+		}
+		result = append(result, domain.Partition{Data: data, Name: name, From: month, To: month.AddDate(0, 1, 0)})
+	}
+
+	sort.Slice(result, func(i, j int) bool {
+		return result[i].From.Before(result[j].From)
+	})
+
+	return result, nil
+}
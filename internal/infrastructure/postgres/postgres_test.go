@@ -0,0 +1,90 @@
+package postgres
+
+import (
+	"fmt"
+	"os"
+	"sync"
+	"testing"
+	"time"
+
+	"gorm.io/driver/postgres"
+	"gorm.io/gorm"
+	"gorm.io/gorm/logger"
+)
+
+// тесты репозиториев ходят в настоящий postgres, адрес берется из METRIKA_TEST_DSN.
+// без него тесты пропускаются. каждый тест заводит свой домен, поэтому базу можно не чистить
+var (
+	testDBOnce sync.Once
+	testDBConn *gorm.DB
+	testDBErr  error
+)
+
+func testDB(t *testing.T) *gorm.DB {
+	t.Helper()
+
+	dsn := os.Getenv("METRIKA_TEST_DSN")
+	if dsn == "" {
+		t.Skip("METRIKA_TEST_DSN is not set")
+	}
+
+	testDBOnce.Do(func() {
+		testDBConn, testDBErr = gorm.Open(postgres.Open(dsn), &gorm.Config{TranslateError: true, Logger: logger.Discard})
+		if testDBErr == nil {
+			testDBErr = migrate(testDBConn)
+		}
+	})
+	if testDBErr != nil {
+		t.Fatalf("test db: %v", testDBErr)
+	}
+
+	return testDBConn
+}
+
+func create(t *testing.T, db *gorm.DB, value interface{}) {
+	t.Helper()
+
+	if err := db.Create(value).Error; err != nil {
+		t.Fatalf("create %T: %v", value, err)
+	}
+}
+
+// testGuest - новый домен с одним гостем
+func testGuest(t *testing.T, db *gorm.DB) (*Domain, *Guest) {
+	t.Helper()
+
+	dom := &Domain{SiteURL: fmt.Sprintf("https://test-%d.example", time.Now().UnixNano())}
+	create(t, db, dom)
+	t.Cleanup(func() { db.Unscoped().Delete(dom) })
+
+	guest := &Guest{DomainID: dom.ID, Fingerprint: "test"}
+	create(t, db, guest)
+
+	return dom, guest
+}
+
+// testVisit - закрытая сессия гостя с просмотрами страниц paths, по минуте на просмотр
+func testVisit(t *testing.T, db *gorm.DB, guest *Guest, at time.Time, paths ...string) *GuestSession {
+	t.Helper()
+
+	session := &GuestSession{
+		CreatedAt:  at,
+		GuestID:    guest.ID,
+		IPAddress:  "127.0.0.1",
+		LastActive: at.Add(time.Duration(len(paths)) * time.Minute),
+	}
+	create(t, db, session)
+
+	for i, path := range paths {
+		ts := at.Add(time.Duration(i) * time.Minute)
+		create(t, db, &Event{
+			Model:     Model{CreatedAt: ts},
+			SessionID: session.ID,
+			Type:      "pageview",
+			PageURL:   "https://test.example" + path,
+			Timestamp: ts,
+		})
+	}
+
+	return session
+}
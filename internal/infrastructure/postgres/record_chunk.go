@@ -0,0 +1,260 @@
+package postgres
+
+import (
+	"bytes"
+	"compress/gzip"
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	domain "metrika/internal/domain/analytics"
+	"sort"
+
+	"gorm.io/gorm"
+)
+
+const recordChunkCodecGzip = "gzip"
+
+// id ивента внутри чанка = id чанка << recordChunkIDShift | позиция в чанке, id выдается при первом сохранении.
+// при пересборке чанков (сжатие, перенос построчных ивентов) id пишется в payload, чтобы курсоры реплея
+// и чекпоинты не ломались
+const recordChunkIDShift = 16
+
+// построчным ивентам выставляется бит 52, чтобы их id не пересекались с id ивентов из чанков
+// (id чанков меньше 2^36) и оставались целыми числами, точными в js
+const recordLegacyIDFlag = 1 << 52
+
+// errStopIteration - fn просит прекратить обход, ошибкой не считается
+var errStopIteration = errors.New("stop iteration")
+
+// recordChunkEvent - ивент внутри сжатого чанка, поля короткие чтобы меньше весило до сжатия.
+// ID заполнен только у ивентов, перенесенных из других чанков или построчной таблицы
+type recordChunkEvent struct {
+	ID        uint           `json:"id,omitempty"`
+	Type      int            `json:"t"`
+	Timestamp int64          `json:"ts"`
+	Data      map[string]any `json:"d"`
+}
+
+func recordEventID(chunkID uint, position int) uint {
+	return chunkID<<recordChunkIDShift | uint(position)
+}
+
+func legacyRecordEventID(id uint) uint {
+	return id | recordLegacyIDFlag
+}
+
+// recordChunkSize - сколько ивентов помещается в чанк, позиция в чанке должна уместиться в recordChunkIDShift бит
+func recordChunkSize(chunkSize int) int {
+	if chunkSize <= 0 || chunkSize > 1<<recordChunkIDShift {
+		return 1 << recordChunkIDShift
+	}
+	return chunkSize
+}
+
+// buildRecordChunks - режет ивенты на чанки по chunkSize в рамках каждой сессии, ивенты в чанке упорядочены по времени.
+// ненулевые id ивентов сохраняются в чанке, новым ивентам id должен быть обнулен
+func buildRecordChunks(events []domain.RecordEvent, chunkSize int) ([]RecordChunk, error) {
+	chunkSize = recordChunkSize(chunkSize)
+
+	bySession := make(map[uint][]domain.RecordEvent)
+	var sessions []uint
+	for _, e := range events {
+		if _, ok := bySession[e.SessionID]; !ok {
+			sessions = append(sessions, e.SessionID)
+		}
+		bySession[e.SessionID] = append(bySession[e.SessionID], e)
+	}
+
+	var chunks []RecordChunk
+	for _, session_id := range sessions {
+		sessionEvents := bySession[session_id]
+		sort.SliceStable(sessionEvents, func(i, j int) bool {
+			return sessionEvents[i].Timestamp < sessionEvents[j].Timestamp
+		})
+
+		for start := 0; start < len(sessionEvents); start += chunkSize {
+			end := min(start+chunkSize, len(sessionEvents))
+
+			chunk, err := encodeRecordChunk(session_id, sessionEvents[start:end])
+			if err != nil {
+				return nil, err
+			}
+			chunks = append(chunks, chunk)
+		}
+	}
+
+	return chunks, nil
+}
+
+func encodeRecordChunk(session_id uint, events []domain.RecordEvent) (RecordChunk, error) {
+	chunk := RecordChunk{
+		SessionID:   session_id,
+		StartTs:     events[0].Timestamp,
+		EndTs:       events[len(events)-1].Timestamp,
+		EventsCount: len(events),
+		Codec:       recordChunkCodecGzip,
+	}
+
+	payload := make([]recordChunkEvent, 0, len(events))
+	for _, e := range events {
+		payload = append(payload, recordChunkEvent{ID: e.ID, Type: e.Type, Timestamp: e.Timestamp, Data: e.Data})
+		if e.Type >= 0 && e.Type < 63 {
+			chunk.TypesMask |= 1 << e.Type
+		}
+	}
+
+	var buf bytes.Buffer
+	zw := gzip.NewWriter(&buf)
+	if err := json.NewEncoder(zw).Encode(payload); err != nil {
+		return chunk, err
+	}
+	if err := zw.Close(); err != nil {
+		return chunk, err
+	}
+	chunk.Data = buf.Bytes()
+
+	return chunk, nil
+}
+
+func decodeRecordChunk(chunk RecordChunk) ([]domain.RecordEvent, error) {
+	if chunk.Codec != recordChunkCodecGzip {
+		return nil, fmt.Errorf("record chunk %d: unknown codec %q", chunk.ID, chunk.Codec)
+	}
+
+	zr, err := gzip.NewReader(bytes.NewReader(chunk.Data))
+	if err != nil {
+		return nil, fmt.Errorf("record chunk %d: %w", chunk.ID, err)
+	}
+	defer zr.Close()
+
+	var payload []recordChunkEvent
+	if err := json.NewDecoder(zr).Decode(&payload); err != nil && !errors.Is(err, io.EOF) {
+		return nil, fmt.Errorf("record chunk %d: %w", chunk.ID, err)
+	}
+
+	events := make([]domain.RecordEvent, 0, len(payload))
+	for i, e := range payload {
+		id := e.ID
+		if id == 0 {
+			id = recordEventID(chunk.ID, i)
+		}
+		events = append(events, domain.RecordEvent{
+			ID:        id,
+			SessionID: chunk.SessionID,
+			Type:      e.Type,
+			Timestamp: e.Timestamp,
+			Data:      e.Data,
+		})
+	}
+
+	return events, nil
+}
+
+// recordFilter - какие ивенты сессии нужны при обходе
+type recordFilter struct {
+	SessionID uint
+	//ивенты не раньше From и не позже To(0 - без ограничения), в мс
+	From int64
+	To   int64
+	//только ивенты с этими типами rrweb, 0 - любые
+	TypesMask int64
+}
+
+func (f recordFilter) match(e domain.RecordEvent) bool {
+	if e.Timestamp < f.From || (f.To > 0 && e.Timestamp > f.To) {
+		return false
+	}
+	if f.TypesMask != 0 && (e.Type < 0 || e.Type >= 63 || f.TypesMask&(1<<e.Type) == 0) {
+		return false
+	}
+	return true
+}
+
+func sortRecordEvents(events []domain.RecordEvent) {
+	sort.Slice(events, func(i, j int) bool {
+		if events[i].Timestamp != events[j].Timestamp {
+			return events[i].Timestamp < events[j].Timestamp
+		}
+		return events[i].ID < events[j].ID
+	})
+}
+
+// eachRecordEvent - обходит ивенты сессии по порядку (timestamp, id), распаковывая чанки по одному.
+// чанки разных запросов могут пересекаться по времени, поэтому ивенты копятся в буфере
+// и отдаются только когда следующий чанк начинается позже них.
+// еще не перенесенные в чанки построчные ивенты тоже участвуют в обходе
+func eachRecordEvent(ctx context.Context, db *gorm.DB, f recordFilter, fn func(domain.RecordEvent) error) error {
+	var legacy []RecordEvent
+	legacyQuery := db.WithContext(ctx).Model(&RecordEvent{}).Where("session_id=? AND timestamp >= ?", f.SessionID, f.From)
+	if f.To > 0 {
+		legacyQuery = legacyQuery.Where("timestamp <= ?", f.To)
+	}
+	if err := legacyQuery.Find(&legacy).Error; err != nil {
+		return err
+	}
+
+	var pending []domain.RecordEvent
+	for _, e := range legacy {
+		if event := e.ToDomain(); f.match(event) {
+			event.ID = legacyRecordEventID(e.ID)
+			pending = append(pending, event)
+		}
+	}
+	sortRecordEvents(pending)
+
+	//отдает все ивенты буфера раньше before
+	flush := func(before int64, all bool) error {
+		n := 0
+		for n < len(pending) && (all || pending[n].Timestamp < before) {
+			if err := fn(pending[n]); err != nil {
+				return err
+			}
+			n++
+		}
+		pending = pending[n:]
+		return nil
+	}
+
+	chunks := db.WithContext(ctx).Model(&RecordChunk{}).Where("session_id=? AND end_ts >= ?", f.SessionID, f.From)
+	if f.To > 0 {
+		chunks = chunks.Where("start_ts <= ?", f.To)
+	}
+	if f.TypesMask != 0 {
+		chunks = chunks.Where("types_mask & ? <> 0", f.TypesMask)
+	}
+
+	rows, err := chunks.Order("start_ts ASC, id ASC").Rows()
+	if err != nil {
+		return err
+	}
+	defer rows.Close()
+
+	for rows.Next() {
+		var chunk RecordChunk
+		if err := db.ScanRows(rows, &chunk); err != nil {
+			return err
+		}
+
+		if err := flush(chunk.StartTs, false); err != nil {
+			return err
+		}
+
+		events, err := decodeRecordChunk(chunk)
+		if err != nil {
+			return err
+		}
+		for _, e := range events {
+			if f.match(e) {
+				pending = append(pending, e)
+			}
+		}
+		sortRecordEvents(pending)
+	}
+	if err := rows.Err(); err != nil {
+		return err
+	}
+
+	return flush(0, true)
+}
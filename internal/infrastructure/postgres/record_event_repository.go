@@ -6,31 +6,41 @@ import (
 	domain "metrika/internal/domain/analytics"
 
 	"gorm.io/gorm"
+	"gorm.io/gorm/clause"
 )
 
+// RecordEventRepository - rrweb-ивенты хранятся сжатыми чанками(RecordChunk),
+// старые построчные записи(RecordEvent) читаются наравне с чанками, пока их не перенесет MigrateLegacy
 type RecordEventRepository struct {
-	db *gorm.DB
+	db        *gorm.DB
+	chunkSize int
 }
 
-func NewRecordEventRepository(db *gorm.DB) *RecordEventRepository {
-	return &RecordEventRepository{db}
+func NewRecordEventRepository(db *gorm.DB, chunkSize int) *RecordEventRepository {
+	return &RecordEventRepository{db, chunkSize}
 }
 
 func (r *RecordEventRepository) SaveEvents(ctx context.Context, events *[]domain.RecordEvent) error {
+	if len(*events) == 0 {
+		return nil
+	}
+
 	db := getDB(ctx, r.db)
 
-	var mEvents []RecordEvent
-	for _, event := range *events {
-		mEvents = append(mEvents, RecordEvent{
-			Data:      event.Data,
-			Timestamp: event.Timestamp,
-			Type:      event.Type,
-			SessionID: event.SessionID,
-		})
+	//id новым ивентам выдает чанк, присланные клиентом игнорируем
+	fresh := make([]domain.RecordEvent, len(*events))
+	for i, e := range *events {
+		e.ID = 0
+		fresh[i] = e
+	}
+
+	chunks, err := buildRecordChunks(fresh, r.chunkSize)
+	if err != nil {
+		return err
 	}
 
-	if err := db.Create(&mEvents).Error; err != nil {
-		if errors.Is(err, gorm.ErrForeignKeyViolated){
+	if err := db.Create(&chunks).Error; err != nil {
+		if errors.Is(err, gorm.ErrForeignKeyViolated) {
 			return domain.ErrSessionsNotFound
 		}
 		return err
@@ -42,25 +52,255 @@ func (r *RecordEventRepository) SaveEvents(ctx context.Context, events *[]domain
 func (r *RecordEventRepository) GetBySessionId(ctx context.Context, session_id uint) (*[]domain.RecordEvent, error) {
 	db := getDB(ctx, r.db)
 
-	var mEvents []RecordEvent
+	dEvents := []domain.RecordEvent{}
+	if err := eachRecordEvent(ctx, db, recordFilter{SessionID: session_id}, func(e domain.RecordEvent) error {
+		dEvents = append(dEvents, e)
+		return nil
+	}); err != nil {
+		return nil, err
+	}
+
+	return &dEvents, nil
+}
+
+func (r *RecordEventRepository) Meta(ctx context.Context, session_id uint) (*domain.ReplayMeta, error) {
+	db := getDB(ctx, r.db)
+
+	meta := domain.ReplayMeta{
+		SessionID:    session_id,
+		EventsByType: make(map[int]int64),
+		Pages:        []domain.ReplayPage{},
+		Checkpoints:  []domain.ReplayCheckpoint{},
+	}
 
-	if err := db.Model(&RecordEvent{}).Where("session_id=?", session_id).Order("timestamp ASC").Find(&mEvents).Error; err != nil {
-		if errors.Is(err, gorm.ErrRecordNotFound) {
-			return nil, domain.ErrRecordEventsNotFound
+	if err := eachRecordEvent(ctx, db, recordFilter{SessionID: session_id}, func(e domain.RecordEvent) error {
+		if meta.EventsCount == 0 {
+			meta.StartedAt = e.Timestamp
 		}
+		meta.EndedAt = e.Timestamp
+		meta.EventsCount++
+		meta.EventsByType[e.Type]++
+
+		switch e.Type {
+		case domain.RecordMeta:
+			page := domain.ReplayPage{Timestamp: e.Timestamp}
+			page.URL, _ = e.Data["href"].(string)
+			if width, ok := e.Data["width"].(float64); ok {
+				page.Width = int(width)
+			}
+			if height, ok := e.Data["height"].(float64); ok {
+				page.Height = int(height)
+			}
+			meta.Pages = append(meta.Pages, page)
+		case domain.RecordFullSnapshot:
+			meta.Checkpoints = append(meta.Checkpoints, domain.ReplayCheckpoint{EventID: e.ID, Timestamp: e.Timestamp})
+		}
+
+		return nil
+	}); err != nil {
 		return nil, err
 	}
 
-	var dEvents []domain.RecordEvent
-	for _, event := range mEvents {
-		dEvents = append(dEvents, domain.RecordEvent{
-			Type:      event.Type,
-			Timestamp: event.Timestamp,
-			SessionID: event.SessionID,
-			ID:        event.ID,
-			Data:      event.Data,
-		})
+	if meta.EventsCount == 0 {
+		return nil, domain.ErrRecordEventsNotFound
 	}
 
-	return &dEvents, nil
+	meta.Duration = meta.EndedAt - meta.StartedAt
+	meta.HasFullSnapshot = len(meta.Checkpoints) > 0
+
+	return &meta, nil
+}
+
+// LastBefore - последний ивент типа event_type не позже timestamp
+func (r *RecordEventRepository) LastBefore(ctx context.Context, session_id uint, event_type int, timestamp int64) (*domain.RecordEvent, error) {
+	db := getDB(ctx, r.db)
+
+	filter := recordFilter{SessionID: session_id, To: timestamp}
+	if event_type >= 0 && event_type < 63 {
+		filter.TypesMask = 1 << event_type
+	}
+
+	var last *domain.RecordEvent
+	if err := eachRecordEvent(ctx, db, filter, func(e domain.RecordEvent) error {
+		if e.Type == event_type {
+			last = &e
+		}
+		return nil
+	}); err != nil {
+		return nil, err
+	}
+
+	if last == nil {
+		return nil, domain.ErrRecordEventsNotFound
+	}
+
+	return last, nil
+}
+
+func windowFilter(opts domain.ReplayWindowOptions) recordFilter {
+	return recordFilter{SessionID: opts.SessionID, From: opts.After.Timestamp, To: opts.To}
+}
+
+// afterCursor - ивент идет после курсора окна(или совпадает с ним при Inclusive)
+func afterCursor(e domain.RecordEvent, opts domain.ReplayWindowOptions) bool {
+	if e.Timestamp != opts.After.Timestamp {
+		return e.Timestamp > opts.After.Timestamp
+	}
+	if opts.Inclusive {
+		return e.ID >= opts.After.ID
+	}
+	return e.ID > opts.After.ID
+}
+
+func (r *RecordEventRepository) Window(ctx context.Context, opts domain.ReplayWindowOptions) ([]domain.RecordEvent, error) {
+	events := make([]domain.RecordEvent, 0, opts.Limit)
+
+	err := r.Stream(ctx, opts, func(e domain.RecordEvent) error {
+		events = append(events, e)
+		if opts.Limit > 0 && len(events) >= opts.Limit {
+			return errStopIteration
+		}
+		return nil
+	})
+	if err != nil && !errors.Is(err, errStopIteration) {
+		return nil, err
+	}
+
+	return events, nil
+}
+
+// Stream - отдает ивенты по одному, в памяти держится не больше пары распакованных чанков
+func (r *RecordEventRepository) Stream(ctx context.Context, opts domain.ReplayWindowOptions, fn func(domain.RecordEvent) error) error {
+	db := getDB(ctx, r.db)
+
+	return eachRecordEvent(ctx, db, windowFilter(opts), func(e domain.RecordEvent) error {
+		if !afterCursor(e, opts) {
+			return nil
+		}
+		return fn(e)
+	})
+}
+
+// MigrateLegacy - переносит построчные ивенты sessions сессий в сжатые чанки.
+// возвращает сколько сессий перенесено, 0 - переносить больше нечего
+func (r *RecordEventRepository) MigrateLegacy(ctx context.Context, sessions int) (int, error) {
+	var session_ids []uint
+	if err := r.db.WithContext(ctx).Model(&RecordEvent{}).
+		Distinct("session_id").
+		Limit(sessions).
+		Pluck("session_id", &session_ids).Error; err != nil {
+		return 0, err
+	}
+
+	for i, session_id := range session_ids {
+		if err := r.db.WithContext(ctx).Transaction(func(tx *gorm.DB) error {
+			var mEvents []RecordEvent
+			if err := tx.Where("session_id=?", session_id).Order("timestamp ASC, id ASC").Find(&mEvents).Error; err != nil {
+				return err
+			}
+			if len(mEvents) == 0 {
+				return nil
+			}
+
+			events := make([]domain.RecordEvent, 0, len(mEvents))
+			ids := make([]uint, 0, len(mEvents))
+			//чанки попадают в партицию месяца самого раннего ивента, срок хранения записи не продлевается
+			createdAt := mEvents[0].CreatedAt
+			for _, e := range mEvents {
+				//ивент сохраняет id, под которым его уже видел клиент
+				event := e.ToDomain()
+				event.ID = legacyRecordEventID(e.ID)
+				events = append(events, event)
+				ids = append(ids, e.ID)
+				if e.CreatedAt.Before(createdAt) {
+					createdAt = e.CreatedAt
+				}
+			}
+
+			chunks, err := buildRecordChunks(events, r.chunkSize)
+			if err != nil {
+				return err
+			}
+			for i := range chunks {
+				chunks[i].CreatedAt = createdAt
+			}
+			if err := tx.Create(&chunks).Error; err != nil {
+				return err
+			}
+
+			return tx.Where("id IN ?", ids).Delete(&RecordEvent{}).Error
+		}); err != nil {
+			return i, err
+		}
+	}
+
+	return len(session_ids), nil
+}
+
+// CompactChunks - склеивает мелкие чанки закрытых сессий в чанки по chunkSize: клиент шлет запись
+// пачками по несколько десятков ивентов, и каждая пачка сохраняется отдельным чанком.
+// id ивентов переносятся в новые чанки, а сессия не должна дописываться во время сжатия,
+// поэтому трогаем только закрытые сессии. возвращает сколько сессий сжато
+func (r *RecordEventRepository) CompactChunks(ctx context.Context, sessions int) (int, error) {
+	chunkSize := recordChunkSize(r.chunkSize)
+
+	var session_ids []uint
+	if err := r.db.WithContext(ctx).Table("record_chunks c").
+		Joins("JOIN guest_sessions s ON s.id = c.session_id").
+		Where("s.active = false").
+		Group("c.session_id").
+		Having("COUNT(*) > CEIL(SUM(c.events_count)::numeric / ?)", chunkSize).
+		Limit(sessions).
+		Pluck("c.session_id", &session_ids).Error; err != nil {
+		return 0, err
+	}
+
+	for i, session_id := range session_ids {
+		if err := r.db.WithContext(ctx).Transaction(func(tx *gorm.DB) error {
+			var chunks []RecordChunk
+			if err := tx.Clauses(clause.Locking{Strength: "UPDATE"}).
+				Where("session_id = ?", session_id).
+				Order("start_ts ASC, id ASC").
+				Find(&chunks).Error; err != nil {
+				return err
+			}
+			if len(chunks) < 2 {
+				return nil
+			}
+
+			var events []domain.RecordEvent
+			ids := make([]uint, 0, len(chunks))
+			//новые чанки остаются в месяце самого старого, чтобы сжатие не продлевало срок хранения записи
+			createdAt := chunks[0].CreatedAt
+			for _, chunk := range chunks {
+				decoded, err := decodeRecordChunk(chunk)
+				if err != nil {
+					return err
+				}
+				events = append(events, decoded...)
+				ids = append(ids, chunk.ID)
+				if chunk.CreatedAt.Before(createdAt) {
+					createdAt = chunk.CreatedAt
+				}
+			}
+			sortRecordEvents(events)
+
+			compacted, err := buildRecordChunks(events, chunkSize)
+			if err != nil {
+				return err
+			}
+			for i := range compacted {
+				compacted[i].CreatedAt = createdAt
+			}
+
+			if err := tx.Where("id IN ?", ids).Delete(&RecordChunk{}).Error; err != nil {
+				return err
+			}
+			return tx.Create(&compacted).Error
+		}); err != nil {
+			return i, err
+		}
+	}
+
+	return len(session_ids), nil
 }
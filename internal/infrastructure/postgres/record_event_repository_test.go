@@ -0,0 +1,126 @@
+package postgres
+
+import (
+	"context"
+	domain "metrika/internal/domain/analytics"
+	"testing"
+	"time"
+)
+
+func recordIDs(events []domain.RecordEvent) []uint {
+	ids := make([]uint, 0, len(events))
+	for _, e := range events {
+		ids = append(ids, e.ID)
+	}
+	return ids
+}
+
+func equalIDs(a, b []uint) bool {
+	if len(a) != len(b) {
+		return false
+	}
+	for i := range a {
+		if a[i] != b[i] {
+			return false
+		}
+	}
+	return true
+}
+
+func TestRecordChunkRebuildKeepsEventIDs(t *testing.T) {
+	first, err := buildRecordChunks([]domain.RecordEvent{
+		{SessionID: 1, Type: 4, Timestamp: 10},
+		{SessionID: 1, Type: 2, Timestamp: 20},
+	}, 0)
+	if err != nil {
+		t.Fatal(err)
+	}
+	second, err := buildRecordChunks([]domain.RecordEvent{
+		{SessionID: 1, Type: 3, Timestamp: 20},
+	}, 0)
+	if err != nil {
+		t.Fatal(err)
+	}
+	first[0].ID, second[0].ID = 7, 9
+
+	var events []domain.RecordEvent
+	for _, chunk := range []RecordChunk{first[0], second[0]} {
+		decoded, err := decodeRecordChunk(chunk)
+		if err != nil {
+			t.Fatal(err)
+		}
+		events = append(events, decoded...)
+	}
+	sortRecordEvents(events)
+	want := []uint{recordEventID(7, 0), recordEventID(7, 1), recordEventID(9, 0)}
+	if got := recordIDs(events); !equalIDs(got, want) {
+		t.Fatalf("ids = %v, want %v", got, want)
+	}
+
+	rebuilt, err := buildRecordChunks(events, 2)
+	if err != nil {
+		t.Fatal(err)
+	}
+	var after []domain.RecordEvent
+	for i, chunk := range rebuilt {
+		chunk.ID = uint(100 + i)
+		decoded, err := decodeRecordChunk(chunk)
+		if err != nil {
+			t.Fatal(err)
+		}
+		after = append(after, decoded...)
+	}
+	if got := recordIDs(after); !equalIDs(got, want) {
+		t.Fatalf("ids after rebuild = %v, want %v", got, want)
+	}
+}
+
+func TestRecordEventIDsSurviveMigrationAndCompaction(t *testing.T) {
+	db := testDB(t)
+	ctx := context.Background()
+	_, guest := testGuest(t, db)
+	session := testVisit(t, db, guest, time.Now().Add(-time.Hour), "/")
+
+	create(t, db, &[]RecordEvent{
+		{SessionID: session.ID, Type: 4, Timestamp: 1000},
+		{SessionID: session.ID, Type: 2, Timestamp: 1001},
+	})
+
+	repo := NewRecordEventRepository(db, 100)
+	for _, batch := range [][]domain.RecordEvent{
+		{{SessionID: session.ID, Type: 3, Timestamp: 1002}, {SessionID: session.ID, Type: 3, Timestamp: 1003}},
+		//id от клиента не должен попасть в чанк
+		{{ID: 1, SessionID: session.ID, Type: 3, Timestamp: 1003}},
+	} {
+		if err := repo.SaveEvents(ctx, &batch); err != nil {
+			t.Fatalf("save: %v", err)
+		}
+	}
+
+	events, err := repo.GetBySessionId(ctx, session.ID)
+	if err != nil {
+		t.Fatal(err)
+	}
+	want := recordIDs(*events)
+
+	if _, err := repo.MigrateLegacy(ctx, 1000); err != nil {
+		t.Fatalf("migrate: %v", err)
+	}
+	if _, err := repo.CompactChunks(ctx, 1000); err != nil {
+		t.Fatalf("compact: %v", err)
+	}
+
+	var chunks int64
+	db.Model(&RecordChunk{}).Where("session_id = ?", session.ID).Count(&chunks)
+	if chunks != 1 {
+		t.Fatalf("chunks after compaction = %d, want 1", chunks)
+	}
+
+	events, err = repo.GetBySessionId(ctx, session.ID)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if got := recordIDs(*events); !equalIDs(got, want) {
+		t.Fatalf("ids after migration and compaction = %v, want %v", got, want)
+	}
+}
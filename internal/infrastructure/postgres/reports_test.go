@@ -0,0 +1,94 @@
+package postgres
+
+import (
+	"context"
+	domain "metrika/internal/domain/analytics"
+	"testing"
+	"time"
+)
+
+// гость, пришедший в два разных дня, - один уникальный гость периода,
+// сколько бы дней ни было уже свернуто в роллапы
+func TestReportsCountReturningGuestOnce(t *testing.T) {
+	db := testDB(t)
+	ctx := context.Background()
+
+	dom, guest := testGuest(t, db)
+
+	day := time.Now().UTC().Truncate(24*time.Hour).AddDate(0, 0, -3)
+	testVisit(t, db, guest, day.Add(10*time.Hour), "/")
+	testVisit(t, db, guest, day.Add(34*time.Hour), "/")
+
+	start, end := day, day.Add(72*time.Hour)
+	rollups := NewRollupRepository(db)
+	if err := rollups.Rebuild(ctx, domain.RollupRange{
+		DomainID:    dom.ID,
+		Granularity: domain.RollupDay,
+		Timezone:    "UTC",
+		StartDate:   start,
+		EndDate:     end,
+	}); err != nil {
+		t.Fatalf("rebuild rollups: %v", err)
+	}
+
+	for _, until := range []*time.Time{nil, ptr(day.Add(24 * time.Hour)), ptr(day.Add(48 * time.Hour))} {
+		pages, _, err := NewEventsRepository(db).FindPages(ctx, domain.FindPagesOptions{
+			DomainID:    dom.ID,
+			StartDate:   &start,
+			EndDate:     &end,
+			RollupUntil: until,
+		})
+		if err != nil {
+			t.Fatalf("find pages: %v", err)
+		}
+		if len(pages) != 1 || pages[0].Views != 2 || pages[0].UniqueGuests != 1 {
+			t.Fatalf("rollup until %v: pages = %+v, want 2 views of 1 guest", until, pages)
+		}
+
+		sources, _, err := NewGuestSessionRepository(db).FindSources(ctx, domain.FindSourcesOptions{
+			DomainID:    dom.ID,
+			StartDate:   &start,
+			EndDate:     &end,
+			GroupBy:     domain.SourcesGroupBySource,
+			RollupUntil: until,
+		})
+		if err != nil {
+			t.Fatalf("find sources: %v", err)
+		}
+		if len(sources) != 1 || sources[0].Visits != 2 || sources[0].Uniques != 1 {
+			t.Fatalf("rollup until %v: sources = %+v, want 2 visits of 1 guest", until, sources)
+		}
+	}
+}
+
+func ptr[T any](v T) *T {
+	return &v
+}
+
+// сессия началась до периода: ее первый просмотр в периоде - не вход, а последний до периода - не выход
+func TestPagesSessionCrossingRangeStart(t *testing.T) {
+	db := testDB(t)
+	ctx := context.Background()
+
+	dom, guest := testGuest(t, db)
+
+	start := time.Now().UTC().Truncate(24*time.Hour).AddDate(0, 0, -1)
+	end := start.Add(24 * time.Hour)
+	testVisit(t, db, guest, start.Add(-time.Minute), "/before", "/inside")
+
+	pages, _, err := NewEventsRepository(db).FindPages(ctx, domain.FindPagesOptions{
+		DomainID:  dom.ID,
+		StartDate: &start,
+		EndDate:   &end,
+	})
+	if err != nil {
+		t.Fatalf("find pages: %v", err)
+	}
+
+	if len(pages) != 1 || pages[0].Path != "/inside" {
+		t.Fatalf("pages = %+v, want only /inside", pages)
+	}
+	if page := pages[0]; page.Entrances != 0 || page.Exits != 1 || page.BounceRate != 0 {
+		t.Fatalf("/inside = %+v, want 0 entrances, 1 exit and no bounce", page)
+	}
+}
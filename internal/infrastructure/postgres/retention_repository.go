@@ -0,0 +1,172 @@
+package postgres
+
+import (
+	"context"
+	"fmt"
+	domain "metrika/internal/domain/analytics"
+	"time"
+
+	"gorm.io/gorm"
+)
+
+type RetentionRepository struct {
+	db *gorm.DB
+}
+
+func NewRetentionRepository(db *gorm.DB) *RetentionRepository {
+	return &RetentionRepository{db}
+}
+
+func (d *RetentionRepository) Settings(ctx context.Context) (map[uint]domain.RetentionSettings, error) {
+	db := getDB(ctx, d.db)
+
+	var mDomains []Domain
+	if err := db.Select("id", "retention_events_days", "retention_sessions_days", "retention_replays_days", "retention_rollups_days").
+		Find(&mDomains).Error; err != nil {
+		return nil, err
+	}
+
+	settings := make(map[uint]domain.RetentionSettings, len(mDomains))
+	for _, d := range mDomains {
+		settings[d.ID] = d.ToDomain().Retention
+	}
+
+	return settings, nil
+}
+
+func (d *RetentionRepository) Partitions(ctx context.Context, data string) ([]domain.Partition, error) {
+	t, ok := partitionedTables[data]
+	if !ok {
+		return nil, fmt.Errorf("unknown partitioned data %q", data)
+	}
+
+	return partitions(getDB(ctx, d.db), data, t)
+}
+
+func (d *RetentionRepository) CreatePartition(ctx context.Context, data string, month time.Time) error {
+	t, ok := partitionedTables[data]
+	if !ok {
+		return fmt.Errorf("unknown partitioned data %q", data)
+	}
+
+	return getDB(ctx, d.db).Transaction(func(tx *gorm.DB) error {
+		return createPartition(tx, t, domain.PartitionMonth(month))
+	})
+}
+
+func (d *RetentionRepository) DropPartition(ctx context.Context, p domain.Partition) error {
+	t, ok := partitionedTables[p.Data]
+	if !ok {
+		return fmt.Errorf("unknown partitioned data %q", p.Data)
+	}
+	//имя сверяется с именем партиции месяца, чтобы в DROP не попало ничего другого
+	if p.Name != t.partitionName(p.From) {
+		return fmt.Errorf("unexpected partition name %q", p.Name)
+	}
+
+	return getDB(ctx, d.db).Transaction(func(tx *gorm.DB) error {
+		if p.Data == domain.RetainedSessions {
+			if err := deleteSessionsData(tx, tx.Table(p.Name).Select("id")); err != nil {
+				return err
+			}
+		}
+
+		return tx.Exec(fmt.Sprintf(`DROP TABLE %q`, p.Name)).Error
+	})
+}
+
+func (d *RetentionRepository) DeleteExpired(ctx context.Context, domain_id uint, data string, before time.Time, limit int) (int64, error) {
+	db := getDB(ctx, d.db)
+
+	//сессии домена, по ним находятся ивенты и записи
+	sessions := db.Table("guest_sessions gs").
+		Select("gs.id").
+		Joins("JOIN guests g ON g.id = gs.guest_id").
+		Where("g.domain_id = ?", domain_id)
+
+	switch data {
+	case domain.RetainedEvents:
+		expired := db.Table("events e").
+			Select("e.id, e.timestamp").
+			Where("e.session_id IN (?) AND e.timestamp < ?", sessions, before).
+			Limit(limit)
+		res := db.Where(`(id, "timestamp") IN (?)`, expired).Delete(&Event{})
+		return res.RowsAffected, res.Error
+
+	case domain.RetainedReplays:
+		//основной объем записей уходит вместе с партициями record_chunks, построчно удаляются только
+		//чанки доменов, которые хранят записи меньше самого долгого срока, и еще не перенесенные в чанки ивенты
+		var deleted int64
+		err := db.Transaction(func(tx *gorm.DB) error {
+			chunks := tx.Table("record_chunks c").
+				Select("c.id, c.created_at").
+				Where("c.session_id IN (?) AND c.created_at < ?", sessions, before).
+				Limit(limit)
+			res := tx.Where("(id, created_at) IN (?)", chunks).Delete(&RecordChunk{})
+			if res.Error != nil {
+				return res.Error
+			}
+			deleted += res.RowsAffected
+
+			legacy := tx.Model(&RecordEvent{}).
+				Select("id").
+				Where("session_id IN (?) AND created_at < ?", sessions, before).
+				Limit(limit)
+			res = tx.Where("id IN (?)", legacy).Delete(&RecordEvent{})
+			deleted += res.RowsAffected
+			return res.Error
+		})
+		return deleted, err
+
+	case domain.RetainedSessions:
+		var deleted int64
+		err := db.Transaction(func(tx *gorm.DB) error {
+			var session_ids []uint
+			if err := tx.Table("guest_sessions gs").
+				Joins("JOIN guests g ON g.id = gs.guest_id").
+				Where("g.domain_id = ? AND gs.created_at < ?", domain_id, before).
+				Limit(limit).
+				Pluck("gs.id", &session_ids).Error; err != nil {
+				return err
+			}
+			if len(session_ids) == 0 {
+				return nil
+			}
+
+			if err := deleteSessionsData(tx, session_ids); err != nil {
+				return err
+			}
+
+			res := tx.Where("id IN ? AND created_at < ?", session_ids, before).Delete(&GuestSession{})
+			deleted = res.RowsAffected
+			return res.Error
+		})
+		return deleted, err
+
+	case domain.RetainedRollups:
+		//роллапов немного, они удаляются за один вызов без limit
+		var deleted int64
+		for _, rollup := range []interface{}{&RollupStat{}, &RollupPage{}, &RollupSource{}} {
+			res := db.Where("domain_id = ? AND bucket < ?", domain_id, before).Delete(rollup)
+			if res.Error != nil {
+				return deleted, res.Error
+			}
+			deleted += res.RowsAffected
+		}
+		return deleted, nil
+	}
+
+	return 0, fmt.Errorf("unknown retained data %q", data)
+}
+
+// deleteSessionsData - удаляет все, что ссылается на сессии: ивенты, конверсии и записи.
+// ивенты удаляются вместе с сессиями, даже если домен хранит их дольше, иначе они останутся без сессии.
+// sessions - список id или подзапрос
+func deleteSessionsData(tx *gorm.DB, sessions interface{}) error {
+	for _, model := range []interface{}{&Event{}, &Conversion{}, &RecordChunk{}, &RecordEvent{}} {
+		if err := tx.Where("session_id IN (?)", sessions).Delete(model).Error; err != nil {
+			return err
+		}
+	}
+	return nil
+}
@@ -0,0 +1,53 @@
+package postgres
+
+import (
+	"context"
+	domain "metrika/internal/domain/analytics"
+	"testing"
+	"time"
+)
+
+// сессии домена хранятся меньше ивентов: ивенты удаленных сессий не должны оставаться без сессии
+func TestDeleteExpiredSessionsDeletesTheirEvents(t *testing.T) {
+	db := testDB(t)
+	ctx := context.Background()
+	dom, guest := testGuest(t, db)
+
+	//настройки пишутся мимо валидации, как у доменов, заведенных до нее
+	if err := db.Model(dom).Updates(map[string]interface{}{
+		"retention_events_days":   365,
+		"retention_sessions_days": 30,
+	}).Error; err != nil {
+		t.Fatal(err)
+	}
+
+	now := time.Now()
+	expired := testVisit(t, db, guest, now.AddDate(0, 0, -60), "/old", "/old")
+	kept := testVisit(t, db, guest, now.AddDate(0, 0, -1), "/new")
+
+	before, _ := domain.RetentionCutoff(30, now)
+	repo := NewRetentionRepository(db)
+	deleted, err := repo.DeleteExpired(ctx, dom.ID, domain.RetainedSessions, before, 100)
+	if err != nil {
+		t.Fatalf("delete expired: %v", err)
+	}
+	if deleted != 1 {
+		t.Fatalf("deleted %d sessions, want 1", deleted)
+	}
+
+	for _, tt := range []struct {
+		session *GuestSession
+		events  int64
+	}{
+		{expired, 0},
+		{kept, 1},
+	} {
+		var events int64
+		if err := db.Model(&Event{}).Where("session_id = ?", tt.session.ID).Count(&events).Error; err != nil {
+			t.Fatal(err)
+		}
+		if events != tt.events {
+			t.Fatalf("session %d has %d events, want %d", tt.session.ID, events, tt.events)
+		}
+	}
+}
@@ -0,0 +1,207 @@
+package postgres
+
+import (
+	"context"
+	"errors"
+	domain "metrika/internal/domain/analytics"
+
+	"gorm.io/gorm"
+	"gorm.io/gorm/clause"
+)
+
+type RollupRepository struct {
+	db *gorm.DB
+}
+
+func NewRollupRepository(db *gorm.DB) *RollupRepository {
+	return &RollupRepository{db}
+}
+
+func (d *RollupRepository) Targets(ctx context.Context) ([]domain.RollupTarget, error) {
+	db := getDB(ctx, d.db)
+
+	targets := []domain.RollupTarget{}
+	if err := db.Model(&Domain{}).
+		Select("id AS domain_id, timezone, created_at").
+		Order("id ASC").
+		Scan(&targets).Error; err != nil {
+		return nil, err
+	}
+
+	return targets, nil
+}
+
+func (d *RollupRepository) States(ctx context.Context, granularity string) (map[uint]domain.RollupState, error) {
+	db := getDB(ctx, d.db)
+
+	var mStates []RollupState
+	if err := db.Where("granularity = ?", granularity).Find(&mStates).Error; err != nil {
+		return nil, err
+	}
+
+	states := make(map[uint]domain.RollupState, len(mStates))
+	for _, s := range mStates {
+		states[s.DomainID] = s.ToDomain()
+	}
+
+	return states, nil
+}
+
+func (d *RollupRepository) State(ctx context.Context, domain_id uint, granularity string) (*domain.RollupState, error) {
+	db := getDB(ctx, d.db)
+
+	var mState RollupState
+	if err := db.Where("domain_id = ? AND granularity = ?", domain_id, granularity).First(&mState).Error; err != nil {
+		if errors.Is(err, gorm.ErrRecordNotFound) {
+			return nil, nil
+		}
+		return nil, err
+	}
+
+	state := mState.ToDomain()
+	return &state, nil
+}
+
+func (d *RollupRepository) Reset(ctx context.Context, domain_id uint, granularity string) error {
+	db := getDB(ctx, d.db)
+
+	for _, table := range []interface{}{&RollupStat{}, &RollupPage{}, &RollupSource{}, &RollupState{}} {
+		if err := db.Where("domain_id = ? AND granularity = ?", domain_id, granularity).Delete(table).Error; err != nil {
+			return err
+		}
+	}
+
+	return nil
+}
+
+func (d *RollupRepository) SaveState(ctx context.Context, state domain.RollupState) error {
+	db := getDB(ctx, d.db)
+
+	mState := RollupState{
+		DomainID:    state.DomainID,
+		Granularity: state.Granularity,
+		Timezone:    state.Timezone,
+		RolledUntil: state.RolledUntil,
+	}
+
+	return db.Clauses(clause.OnConflict{
+		Columns:   []clause.Column{{Name: "domain_id"}, {Name: "granularity"}},
+		DoUpdates: clause.AssignmentColumns([]string{"timezone", "rolled_until"}),
+	}).Create(&mState).Error
+}
+
+// Rebuild - удаляет роллапы диапазона и считает их заново. сессии относятся к интервалу своего начала,
+// просмотры - к интервалу ивента, позиция просмотра и время на странице считаются по всей сессии
+func (d *RollupRepository) Rebuild(ctx context.Context, r domain.RollupRange) error {
+	var allowedGranularities = map[string]string{
+		domain.RollupHour: "hour",
+		domain.RollupDay:  "day",
+	}
+
+	unit, ok := allowedGranularities[r.Granularity]
+	if !ok {
+		return domain.ErrRollupGranularityInvalid
+	}
+
+	db := getDB(ctx, d.db)
+
+	bucket := func(column string) string {
+		return "date_trunc(?::text, " + column + " AT TIME ZONE ?::text) AT TIME ZONE ?::text"
+	}
+	bucketArgs := []interface{}{unit, r.Timezone, r.Timezone}
+
+	for _, table := range []interface{}{&RollupStat{}, &RollupPage{}, &RollupSource{}} {
+		if err := db.Where("domain_id = ? AND granularity = ? AND bucket >= ? AND bucket < ?", r.DomainID, r.Granularity, r.StartDate, r.EndDate).
+			Delete(table).Error; err != nil {
+			return err
+		}
+	}
+
+	sessionViews := "LEFT JOIN LATERAL (SELECT COUNT(*) AS views FROM events e WHERE e.session_id = gs.id AND e.type = 'pageview') pv ON true"
+	sessionDuration := "EXTRACT(EPOCH FROM COALESCE(gs.end_time, gs.last_active) - gs.created_at)"
+
+	stats := `
+	INSERT INTO rollup_stats (domain_id, granularity, bucket, visits, uniques, pageviews, bounces, duration_seconds)
+	SELECT ?::bigint, ?::text, COALESCE(s.bucket, p.bucket),
+		COALESCE(s.visits, 0), COALESCE(s.uniques, 0), COALESCE(p.pageviews, 0), COALESCE(s.bounces, 0), COALESCE(s.duration_seconds, 0)
+	FROM (
+		SELECT ` + bucket("gs.created_at") + ` AS bucket,
+			COUNT(*) AS visits,
+			COUNT(DISTINCT gs.guest_id) AS uniques,
+			COUNT(*) FILTER (WHERE pv.views <= 1) AS bounces,
+			SUM(` + sessionDuration + `) AS duration_seconds
+		FROM guest_sessions gs
+		JOIN guests g ON g.id = gs.guest_id
+		` + sessionViews + `
+		WHERE g.domain_id = ? AND gs.created_at >= ? AND gs.created_at < ?
+		GROUP BY 1
+	) s
+	FULL JOIN (
+		SELECT ` + bucket("e.timestamp") + ` AS bucket, COUNT(*) AS pageviews
+		FROM events e
+		JOIN guest_sessions gs ON gs.id = e.session_id
+		JOIN guests g ON g.id = gs.guest_id
+		WHERE g.domain_id = ? AND e.type = 'pageview' AND e.timestamp >= ? AND e.timestamp < ?
+		GROUP BY 1
+	) p ON p.bucket = s.bucket
+	`
+	statsArgs := []interface{}{r.DomainID, r.Granularity}
+	statsArgs = append(append(statsArgs, bucketArgs...), r.DomainID, r.StartDate, r.EndDate)
+	statsArgs = append(append(statsArgs, bucketArgs...), r.DomainID, r.StartDate, r.EndDate)
+	if err := db.Exec(stats, statsArgs...).Error; err != nil {
+		return err
+	}
+
+	pages := `
+	INSERT INTO rollup_pages (domain_id, granularity, bucket, path, title, views, unique_guests, time_on_page_seconds, engaged_ms, entrances, exits, bounces)
+	SELECT ?::bigint, ?::text, ` + bucket("pv.viewed_at") + `, pv.path,
+		COALESCE(MAX(pv.title), ''),
+		COUNT(*),
+		COUNT(DISTINCT pv.guest_id),
+		COALESCE(SUM(EXTRACT(EPOCH FROM (COALESCE(pv.next_viewed_at, GREATEST(pv.last_active, pv.viewed_at)) - pv.viewed_at))), 0),
+		COALESCE(SUM(pv.engaged_ms), 0),
+		COUNT(*) FILTER (WHERE pv.position = 1),
+		COUNT(*) FILTER (WHERE pv.position = pv.session_views),
+		COUNT(*) FILTER (WHERE pv.position = 1 AND pv.session_views = 1)
+	FROM (
+		SELECT e.session_id, gs.guest_id, e.timestamp AS viewed_at, gs.last_active,
+			` + normalizedPagePathSQL + ` AS path,
+			e.data->>'title' AS title,
+			e.engaged_ms,
+			ROW_NUMBER() OVER (PARTITION BY e.session_id ORDER BY e.timestamp, e.id) AS position,
+			COUNT(*) OVER (PARTITION BY e.session_id) AS session_views,
+			LEAD(e.timestamp) OVER (PARTITION BY e.session_id ORDER BY e.timestamp, e.id) AS next_viewed_at
+		FROM events e
+		JOIN guest_sessions gs ON gs.id = e.session_id
+		JOIN guests g ON g.id = gs.guest_id
+		WHERE g.domain_id = ? AND e.type = 'pageview'
+			AND e.session_id IN (SELECT p.session_id FROM events p WHERE p.type = 'pageview' AND p.timestamp >= ? AND p.timestamp < ?)
+	) pv
+	WHERE pv.viewed_at >= ? AND pv.viewed_at < ?
+	GROUP BY 3, 4
+	`
+	pagesArgs := []interface{}{r.DomainID, r.Granularity}
+	pagesArgs = append(append(pagesArgs, bucketArgs...), r.DomainID, r.StartDate, r.EndDate, r.StartDate, r.EndDate)
+	if err := db.Exec(pages, pagesArgs...).Error; err != nil {
+		return err
+	}
+
+	sources := `
+	INSERT INTO rollup_sources (domain_id, granularity, bucket, source_type, referrer_host, utm_source, utm_medium, utm_campaign, visits, uniques, bounces, duration_seconds)
+	SELECT ?::bigint, ?::text, ` + bucket("gs.created_at") + `,
+		gs.source_type, gs.referrer_host, gs.utm_source, gs.utm_medium, gs.utm_campaign,
+		COUNT(*),
+		COUNT(DISTINCT gs.guest_id),
+		COUNT(*) FILTER (WHERE pv.views <= 1),
+		COALESCE(SUM(` + sessionDuration + `), 0)
+	FROM guest_sessions gs
+	JOIN guests g ON g.id = gs.guest_id
+	` + sessionViews + `
+	WHERE g.domain_id = ? AND gs.created_at >= ? AND gs.created_at < ?
+	GROUP BY 3, 4, 5, 6, 7, 8
+	`
+	sourcesArgs := []interface{}{r.DomainID, r.Granularity}
+	sourcesArgs = append(append(sourcesArgs, bucketArgs...), r.DomainID, r.StartDate, r.EndDate)
+
+	return db.Exec(sources, sourcesArgs...).Error
+}
@@ -0,0 +1,218 @@
+package postgres
+
+import (
+	"context"
+	domain "metrika/internal/domain/analytics"
+	"strings"
+
+	"gorm.io/gorm"
+)
+
+type TimeseriesRepository struct {
+	db *gorm.DB
+}
+
+func NewTimeseriesRepository(db *gorm.DB) *TimeseriesRepository {
+	return &TimeseriesRepository{db}
+}
+
+// Timeseries - метрики по интервалам в часовом поясе opts.Timezone. интервалы режутся по местному
+// времени, а точка отдается моментом его начала, поэтому у дня с переходом на летнее время 23 часа
+func (d *TimeseriesRepository) Timeseries(ctx context.Context, opts domain.TimeseriesOptions) ([]domain.TimeseriesRow, error) {
+	unit, ok := timeseriesUnits[opts.Granularity]
+	if !ok {
+		return nil, domain.ErrInvalidTimeseriesQuery
+	}
+
+	db := getDB(ctx, d.db)
+
+	key := func(column string) (string, []interface{}) {
+		return "date_trunc(?::text, " + column + " AT TIME ZONE ?::text)", []interface{}{unit, opts.Timezone}
+	}
+
+	buckets, bucketsArgs := timeseriesBuckets(unit, opts)
+	query, args := timeseriesSQL(opts, key, buckets, bucketsArgs)
+	query = `SELECT b.local AT TIME ZONE ?::text AS bucket, ` + query + ` ORDER BY b.local`
+	args = append([]interface{}{opts.Timezone}, args...)
+
+	rows := []domain.TimeseriesRow{}
+	if err := db.Raw(query, args...).Scan(&rows).Error; err != nil {
+		return nil, err
+	}
+
+	return rows, nil
+}
+
+var timeseriesUnits = map[string]string{
+	domain.GranularityMinute: "minute",
+	domain.GranularityHour:   "hour",
+	domain.GranularityDay:    "day",
+	domain.GranularityWeek:   "week",
+	domain.GranularityMonth:  "month",
+}
+
+// timeseriesBuckets - местное время начала каждого интервала периода.
+// конец периода не включается, поэтому последний интервал - тот, в который попадает момент перед ним
+func timeseriesBuckets(unit string, opts domain.TimeseriesOptions) (string, []interface{}) {
+	buckets := `
+	SELECT b.local
+	FROM generate_series(
+		date_trunc(?::text, ?::timestamptz AT TIME ZONE ?::text),
+		date_trunc(?::text, (?::timestamptz - interval '1 microsecond') AT TIME ZONE ?::text),
+		('1 ' || ?::text)::interval
+	) AS b(local)
+	`
+	return buckets, []interface{}{unit, opts.StartDate, opts.Timezone, unit, opts.EndDate, opts.Timezone, unit}
+}
+
+func (d *TimeseriesRepository) Totals(ctx context.Context, opts domain.TimeseriesOptions) (domain.TimeseriesRow, error) {
+	db := getDB(ctx, d.db)
+
+	//весь период - один интервал
+	key := func(column string) (string, []interface{}) {
+		return "1", nil
+	}
+
+	query, args := timeseriesSQL(opts, key, "SELECT 1 AS local", nil)
+	query = `SELECT ?::timestamptz AS bucket, ` + query
+	args = append([]interface{}{opts.StartDate}, args...)
+
+	var totals domain.TimeseriesRow
+	if err := db.Raw(query, args...).Scan(&totals).Error; err != nil {
+		return totals, err
+	}
+
+	return totals, nil
+}
+
+// timeseriesSQL - колонки метрик и FROM с агрегатами по интервалам, к которым присоединяется buckets.
+// key строит ключ интервала по колонке времени, агрегаты считаются только для запрошенных метрик
+func timeseriesSQL(
+	opts domain.TimeseriesOptions,
+	key func(column string) (string, []interface{}),
+	buckets string,
+	bucketsArgs []interface{},
+) (string, []interface{}) {
+	columns := []string{}
+	joins := []string{}
+	args := []interface{}{}
+
+	if opts.Has(domain.MetricVisits) || opts.Has(domain.MetricUniques) || opts.Has(domain.MetricAvgDuration) {
+		expr, keyArgs := key("gs.created_at")
+		joins = append(joins, `
+		LEFT JOIN (
+			SELECT `+expr+` AS local,
+				COUNT(*) AS visits,
+				COUNT(DISTINCT gs.guest_id) AS uniques,
+				AVG(EXTRACT(EPOCH FROM COALESCE(gs.end_time, gs.last_active) - gs.created_at)) AS avg_duration,
+				SUM(EXTRACT(EPOCH FROM COALESCE(gs.end_time, gs.last_active) - gs.created_at)) AS duration_seconds
+			FROM guest_sessions gs
+			JOIN guests g ON g.id = gs.guest_id
+			WHERE g.domain_id = ? AND gs.created_at >= ? AND gs.created_at < ?
+			GROUP BY 1
+		) s ON s.local = b.local`)
+		args = append(append(args, keyArgs...), opts.DomainID, opts.StartDate, opts.EndDate)
+		columns = append(columns,
+			"COALESCE(s.visits, 0) AS visits",
+			"COALESCE(s.uniques, 0) AS uniques",
+			"COALESCE(s.avg_duration, 0) AS avg_duration",
+			"COALESCE(s.duration_seconds, 0) AS duration_seconds",
+		)
+	}
+
+	if opts.Has(domain.MetricPageviews) || opts.Has(domain.MetricEvents) {
+		expr, keyArgs := key("e.timestamp")
+		joins = append(joins, `
+		LEFT JOIN (
+			SELECT `+expr+` AS local,
+				COUNT(*) FILTER (WHERE e.type = 'pageview') AS pageviews,
+				COUNT(*) AS events
+			FROM events e
+			JOIN guest_sessions gs ON gs.id = e.session_id
+			JOIN guests g ON g.id = gs.guest_id
+			WHERE g.domain_id = ? AND e.timestamp >= ? AND e.timestamp < ?
+			GROUP BY 1
+		) e ON e.local = b.local`)
+		args = append(append(args, keyArgs...), opts.DomainID, opts.StartDate, opts.EndDate)
+		columns = append(columns,
+			"COALESCE(e.pageviews, 0) AS pageviews",
+			"COALESCE(e.events, 0) AS events",
+		)
+	}
+
+	if opts.Has(domain.MetricConversions) {
+		expr, keyArgs := key("c.timestamp")
+		joins = append(joins, `
+		LEFT JOIN (
+			SELECT `+expr+` AS local, COUNT(*) AS conversions
+			FROM conversions c
+			JOIN goals gl ON gl.id = c.goal_id
+			WHERE gl.domain_id = ? AND c.timestamp >= ? AND c.timestamp < ?
+			GROUP BY 1
+		) c ON c.local = b.local`)
+		args = append(append(args, keyArgs...), opts.DomainID, opts.StartDate, opts.EndDate)
+		columns = append(columns, "COALESCE(c.conversions, 0) AS conversions")
+	}
+
+	query := strings.Join(columns, ", ") + `
+	FROM (` + buckets + `) b` + strings.Join(joins, "")
+
+	return query, append(append([]interface{}{}, bucketsArgs...), args...)
+}
+
+// rollupColumns - метрики из роллапов, средняя длительность - по сумме длительностей
+const rollupColumns = `
+	COALESCE(SUM(r.visits), 0) AS visits,
+	COALESCE(SUM(r.uniques), 0) AS uniques,
+	COALESCE(SUM(r.pageviews), 0) AS pageviews,
+	COALESCE(SUM(r.duration_seconds), 0) AS duration_seconds,
+	COALESCE(SUM(r.duration_seconds) / NULLIF(SUM(r.visits), 0), 0) AS avg_duration
+	`
+
+// RollupTimeseries - ряд из роллапов гранулярности granularity, интервалы ряда собираются из интервалов роллапа.
+// границы периода должны лежать на границах интервалов роллапа
+func (d *TimeseriesRepository) RollupTimeseries(ctx context.Context, opts domain.TimeseriesOptions, granularity string) ([]domain.TimeseriesRow, error) {
+	unit, ok := timeseriesUnits[opts.Granularity]
+	if !ok {
+		return nil, domain.ErrInvalidTimeseriesQuery
+	}
+
+	db := getDB(ctx, d.db)
+
+	buckets, bucketsArgs := timeseriesBuckets(unit, opts)
+
+	query := `
+	SELECT b.local AT TIME ZONE ?::text AS bucket, ` + rollupColumns + `
+	FROM (` + buckets + `) b
+	LEFT JOIN rollup_stats r ON r.domain_id = ? AND r.granularity = ? AND r.bucket >= ? AND r.bucket < ?
+		AND date_trunc(?::text, r.bucket AT TIME ZONE ?::text) = b.local
+	GROUP BY b.local
+	ORDER BY b.local
+	`
+	args := append([]interface{}{opts.Timezone}, bucketsArgs...)
+	args = append(args, opts.DomainID, granularity, opts.StartDate, opts.EndDate, unit, opts.Timezone)
+
+	rows := []domain.TimeseriesRow{}
+	if err := db.Raw(query, args...).Scan(&rows).Error; err != nil {
+		return nil, err
+	}
+
+	return rows, nil
+}
+
+func (d *TimeseriesRepository) RollupTotals(ctx context.Context, opts domain.TimeseriesOptions, granularity string) (domain.TimeseriesRow, error) {
+	db := getDB(ctx, d.db)
+
+	query := `
+	SELECT ?::timestamptz AS bucket, ` + rollupColumns + `
+	FROM rollup_stats r
+	WHERE r.domain_id = ? AND r.granularity = ? AND r.bucket >= ? AND r.bucket < ?
+	`
+
+	var totals domain.TimeseriesRow
+	if err := db.Raw(query, opts.StartDate, opts.DomainID, granularity, opts.StartDate, opts.EndDate).Scan(&totals).Error; err != nil {
+		return totals, err
+	}
+
+	return totals, nil
+}
@@ -2,7 +2,11 @@ package postgres
 
 import (
 	"context"
+	"errors"
+	"metrika/internal/domain/tx"
+	"strings"
 
+	"github.com/jackc/pgx/v5/pgconn"
 	"gorm.io/gorm"
 )
 
@@ -18,10 +22,33 @@ func (tm *TxManager) WithinTransaction(
 	ctx context.Context,
 	fn func(ctx context.Context) error,
 ) error {
-	return tm.db.WithContext(ctx).Transaction(func(tx *gorm.DB) error {
+	err := tm.db.WithContext(ctx).Transaction(func(tx *gorm.DB) error {
 		ctx = context.WithValue(ctx, txKey{}, tx)
 		return fn(ctx)
 	})
+
+	return rejectedError(err)
+}
+
+// rejectedError - помечает tx.ErrRejected ошибки, вызванные самими данными:
+// нарушения ограничений(класс 23) и невалидные значения(класс 22)
+func rejectedError(err error) error {
+	if err == nil {
+		return nil
+	}
+
+	var pgErr *pgconn.PgError
+	switch {
+	case errors.Is(err, gorm.ErrDuplicatedKey),
+		errors.Is(err, gorm.ErrForeignKeyViolated),
+		errors.Is(err, gorm.ErrCheckConstraintViolated),
+		errors.Is(err, gorm.ErrInvalidData):
+	case errors.As(err, &pgErr) && (strings.HasPrefix(pgErr.Code, "22") || strings.HasPrefix(pgErr.Code, "23")):
+	default:
+		return err
+	}
+
+	return errors.Join(tx.ErrRejected, err)
 }
 
 func getDB(ctx context.Context, db *gorm.DB) *gorm.DB {
@@ -0,0 +1,194 @@
+package pubsub
+
+import (
+	"bufio"
+	"fmt"
+	"net"
+	"strings"
+	"sync"
+	"testing"
+)
+
+// fakeRedis - минимальная замена redis-сервера для тестов: AUTH, PING, PUBLISH и SUBSCRIBE
+type fakeRedis struct {
+	t        *testing.T
+	ln       net.Listener
+	password string
+
+	mu        sync.Mutex
+	conns     map[*fakeConn]struct{}
+	subs      map[string]map[*fakeConn]struct{}
+	hang      bool
+	published chan string
+}
+
+type fakeConn struct {
+	net.Conn
+	mu     sync.Mutex
+	wr     *bufio.Writer
+	authed bool
+}
+
+func (c *fakeConn) write(s string) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	c.wr.WriteString(s)
+	c.wr.Flush()
+}
+
+func newFakeRedis(t *testing.T, password string) *fakeRedis {
+	t.Helper()
+
+	ln, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatalf("listen: %v", err)
+	}
+
+	f := &fakeRedis{
+		t:         t,
+		ln:        ln,
+		password:  password,
+		conns:     make(map[*fakeConn]struct{}),
+		subs:      make(map[string]map[*fakeConn]struct{}),
+		published: make(chan string, 100),
+	}
+	go f.accept()
+	t.Cleanup(f.close)
+
+	return f
+}
+
+func (f *fakeRedis) addr() string {
+	return f.ln.Addr().String()
+}
+
+// setHang - сервер перестает отвечать на PUBLISH, как зависшее соединение
+func (f *fakeRedis) setHang(hang bool) {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	f.hang = hang
+}
+
+func (f *fakeRedis) subscribers(channel string) int {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	return len(f.subs[channel])
+}
+
+// dropConns - рвет все открытые соединения, как при рестарте сервера
+func (f *fakeRedis) dropConns() {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	for c := range f.conns {
+		c.Close()
+	}
+}
+
+func (f *fakeRedis) close() {
+	f.ln.Close()
+	f.dropConns()
+}
+
+func (f *fakeRedis) accept() {
+	for {
+		nc, err := f.ln.Accept()
+		if err != nil {
+			return
+		}
+		c := &fakeConn{Conn: nc, wr: bufio.NewWriter(nc), authed: f.password == ""}
+		f.mu.Lock()
+		f.conns[c] = struct{}{}
+		f.mu.Unlock()
+		go f.serve(c)
+	}
+}
+
+func (f *fakeRedis) serve(c *fakeConn) {
+	defer func() {
+		c.Close()
+		f.mu.Lock()
+		delete(f.conns, c)
+		for _, subs := range f.subs {
+			delete(subs, c)
+		}
+		f.mu.Unlock()
+	}()
+
+	rd := bufio.NewReader(c)
+	subscribed := false
+	for {
+		reply, err := readReply(rd)
+		if err != nil {
+			return
+		}
+		items, _ := reply.([]any)
+		args := make([]string, 0, len(items))
+		for _, item := range items {
+			s, _ := item.(string)
+			args = append(args, s)
+		}
+		if len(args) == 0 {
+			c.write("-ERR empty command\r\n")
+			continue
+		}
+
+		cmd := strings.ToUpper(args[0])
+		if cmd == "AUTH" {
+			if len(args) == 2 && args[1] == f.password {
+				c.authed = true
+				c.write("+OK\r\n")
+			} else {
+				c.write("-WRONGPASS invalid password\r\n")
+			}
+			continue
+		}
+		if !c.authed {
+			c.write("-NOAUTH Authentication required.\r\n")
+			continue
+		}
+
+		switch cmd {
+		case "PING":
+			if subscribed {
+				c.write("*2\r\n$4\r\npong\r\n$0\r\n\r\n")
+			} else {
+				c.write("+PONG\r\n")
+			}
+		case "SUBSCRIBE":
+			subscribed = true
+			for i, channel := range args[1:] {
+				f.mu.Lock()
+				if f.subs[channel] == nil {
+					f.subs[channel] = make(map[*fakeConn]struct{})
+				}
+				f.subs[channel][c] = struct{}{}
+				f.mu.Unlock()
+				c.write(fmt.Sprintf("*3\r\n$9\r\nsubscribe\r\n$%d\r\n%s\r\n:%d\r\n", len(channel), channel, i+1))
+			}
+		case "PUBLISH":
+			if len(args) != 3 {
+				c.write("-ERR wrong number of arguments\r\n")
+				continue
+			}
+			f.published <- args[1]
+
+			f.mu.Lock()
+			hang := f.hang
+			receivers := make([]*fakeConn, 0, len(f.subs[args[1]]))
+			for sub := range f.subs[args[1]] {
+				receivers = append(receivers, sub)
+			}
+			f.mu.Unlock()
+			if hang {
+				continue
+			}
+
+			for _, sub := range receivers {
+				sub.write(fmt.Sprintf("*3\r\n$7\r\nmessage\r\n$%d\r\n%s\r\n$%d\r\n%s\r\n", len(args[1]), args[1], len(args[2]), args[2]))
+			}
+			c.write(fmt.Sprintf(":%d\r\n", len(receivers)))
+		default:
+			c.write("-ERR unknown command\r\n")
+		}
+	}
+}
@@ -0,0 +1,120 @@
+package pubsub
+
+import (
+	"context"
+	"errors"
+	"sync"
+)
+
+var ErrClosed = errors.New("pubsub closed")
+
+// Memory - шина внутри одного процесса
+type Memory struct {
+	mu     sync.RWMutex
+	subs   map[string]map[*memorySubscription]struct{}
+	buffer int
+	closed bool
+}
+
+func NewMemory(buffer int) *Memory {
+	if buffer <= 0 {
+		buffer = 1
+	}
+
+	return &Memory{
+		subs:   make(map[string]map[*memorySubscription]struct{}),
+		buffer: buffer,
+	}
+}
+
+func (m *Memory) Publish(ctx context.Context, channel string, payload []byte) error {
+	m.mu.RLock()
+	defer m.mu.RUnlock()
+
+	if m.closed {
+		return ErrClosed
+	}
+
+	msg := Message{Channel: channel, Payload: payload}
+	for sub := range m.subs[channel] {
+		push(sub.ch, msg)
+	}
+
+	return nil
+}
+
+func (m *Memory) Subscribe(ctx context.Context, channels ...string) (Subscription, error) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	if m.closed {
+		return nil, ErrClosed
+	}
+
+	sub := &memorySubscription{
+		ch:       make(chan Message, m.buffer),
+		memory:   m,
+		channels: channels,
+	}
+	for _, channel := range channels {
+		if m.subs[channel] == nil {
+			m.subs[channel] = make(map[*memorySubscription]struct{})
+		}
+		m.subs[channel][sub] = struct{}{}
+	}
+
+	return sub, nil
+}
+
+func (m *Memory) Close() error {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	if m.closed {
+		return nil
+	}
+	m.closed = true
+
+	closed := make(map[*memorySubscription]struct{})
+	for _, subs := range m.subs {
+		for sub := range subs {
+			if _, ok := closed[sub]; !ok {
+				closed[sub] = struct{}{}
+				sub.closeOnce.Do(func() { close(sub.ch) })
+			}
+		}
+	}
+	m.subs = make(map[string]map[*memorySubscription]struct{})
+
+	return nil
+}
+
+func (m *Memory) unsubscribe(sub *memorySubscription) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	for _, channel := range sub.channels {
+		delete(m.subs[channel], sub)
+		if len(m.subs[channel]) == 0 {
+			delete(m.subs, channel)
+		}
+	}
+	//publish держит RLock, поэтому после удаления из map в канал уже никто не пишет
+	sub.closeOnce.Do(func() { close(sub.ch) })
+}
+
+type memorySubscription struct {
+	ch        chan Message
+	memory    *Memory
+	channels  []string
+	closeOnce sync.Once
+}
+
+func (s *memorySubscription) Messages() <-chan Message {
+	return s.ch
+}
+
+func (s *memorySubscription) Close() error {
+	s.memory.unsubscribe(s)
+	return nil
+}
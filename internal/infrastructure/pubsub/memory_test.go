@@ -0,0 +1,71 @@
+package pubsub
+
+import (
+	"context"
+	"errors"
+	"testing"
+)
+
+func TestMemoryPublishSubscribe(t *testing.T) {
+	m := NewMemory(4)
+	defer m.Close()
+	ctx := context.Background()
+
+	sub, err := m.Subscribe(ctx, "a", "b")
+	if err != nil {
+		t.Fatalf("subscribe: %v", err)
+	}
+
+	m.Publish(ctx, "a", []byte("1"))
+	m.Publish(ctx, "c", []byte("ignored"))
+	m.Publish(ctx, "b", []byte("2"))
+
+	for _, want := range []Message{{"a", []byte("1")}, {"b", []byte("2")}} {
+		if got := receive(t, sub); got.Channel != want.Channel || string(got.Payload) != string(want.Payload) {
+			t.Fatalf("got %q on %q, want %q on %q", got.Payload, got.Channel, want.Payload, want.Channel)
+		}
+	}
+}
+
+func TestMemoryDropsOldest(t *testing.T) {
+	m := NewMemory(2)
+	defer m.Close()
+	ctx := context.Background()
+
+	sub, _ := m.Subscribe(ctx, "a")
+	for _, payload := range []string{"1", "2", "3"} {
+		if err := m.Publish(ctx, "a", []byte(payload)); err != nil {
+			t.Fatalf("publish: %v", err)
+		}
+	}
+
+	for _, want := range []string{"2", "3"} {
+		if got := receive(t, sub); string(got.Payload) != want {
+			t.Fatalf("got %q, want %q", got.Payload, want)
+		}
+	}
+}
+
+func TestMemoryClose(t *testing.T) {
+	m := NewMemory(1)
+	ctx := context.Background()
+
+	unsubscribed, _ := m.Subscribe(ctx, "a")
+	open, _ := m.Subscribe(ctx, "a")
+
+	unsubscribed.Close()
+	if _, ok := <-unsubscribed.Messages(); ok {
+		t.Fatal("closed subscription still delivers")
+	}
+
+	m.Close()
+	if _, ok := <-open.Messages(); ok {
+		t.Fatal("subscription is open after bus close")
+	}
+	if err := m.Publish(ctx, "a", nil); !errors.Is(err, ErrClosed) {
+		t.Fatalf("publish after close = %v, want ErrClosed", err)
+	}
+	if _, err := m.Subscribe(ctx, "a"); !errors.Is(err, ErrClosed) {
+		t.Fatalf("subscribe after close = %v, want ErrClosed", err)
+	}
+}
@@ -0,0 +1,69 @@
+package pubsub
+
+import (
+	"context"
+	"fmt"
+	"log/slog"
+	"metrika/internal/config"
+)
+
+const (
+	BackendMemory = "memory"
+	BackendRedis  = "redis"
+)
+
+type Message struct {
+	Channel string
+	Payload []byte
+}
+
+// PubSub - шина сообщений между инстансами. доставка best-effort:
+// медленный подписчик теряет самые старые сообщения, а не тормозит издателя
+type PubSub interface {
+	Publish(ctx context.Context, channel string, payload []byte) error
+	Subscribe(ctx context.Context, channels ...string) (Subscription, error)
+	Close() error
+}
+
+type Subscription interface {
+	Messages() <-chan Message
+	Close() error
+}
+
+// New - шина по настройке backend. memory работает только внутри процесса,
+// redis нужен, когда запущено несколько инстансов
+func New(log *slog.Logger, cfg config.PubSub) (PubSub, error) {
+	switch cfg.Backend {
+	case "", BackendMemory:
+		return NewMemory(cfg.SubscriberBuffer), nil
+	case BackendRedis:
+		return NewRedis(log, RedisOptions{
+			Addr:             cfg.RedisAddr,
+			Password:         cfg.RedisPassword,
+			DialTimeout:      cfg.DialTimeout,
+			PingInterval:     cfg.PingInterval,
+			SubscriberBuffer: cfg.SubscriberBuffer,
+		}), nil
+	default:
+		return nil, fmt.Errorf("unknown pubsub backend %q", cfg.Backend)
+	}
+}
+
+// push - неблокирующая отправка в буфер подписчика, при переполнении выбрасывает самое старое сообщение.
+// возвращает true, если что-то было выброшено
+func push(ch chan Message, msg Message) bool {
+	dropped := false
+	for {
+		select {
+		case ch <- msg:
+			return dropped
+		default:
+		}
+
+		select {
+		case <-ch:
+			dropped = true
+		default:
+		}
+	}
+}
@@ -0,0 +1,424 @@
+package pubsub
+
+import (
+	"bufio"
+	"context"
+	"errors"
+	"log/slog"
+	"metrika/pkg/logger/sl"
+	"net"
+	"sync"
+	"time"
+)
+
+const (
+	redisReconnectMinDelay = 500 * time.Millisecond
+	redisReconnectMaxDelay = 30 * time.Second
+)
+
+type RedisOptions struct {
+	Addr        string
+	Password    string
+	DialTimeout time.Duration
+	//как часто пинговать соединение подписки, чтобы замечать обрыв без трафика
+	PingInterval     time.Duration
+	SubscriberBuffer int
+}
+
+// Redis - шина поверх PUBLISH/SUBSCRIBE сервера с протоколом Redis.
+// публикации по очереди отправляет одна горутина через общее соединение, Publish только ждет ее ответа
+// и не держит мьютекс шины во время сетевого вызова. у каждой подписки свое соединение,
+// которое переподключается с backoff, пока подписку не закроют
+type Redis struct {
+	log  *slog.Logger
+	opts RedisOptions
+
+	publishes chan publishRequest
+	done      chan struct{}
+	wg        sync.WaitGroup
+
+	mu     sync.Mutex
+	conn   *redisConn
+	closed bool
+	subs   map[*redisSubscription]struct{}
+}
+
+type publishRequest struct {
+	ctx     context.Context
+	channel string
+	payload []byte
+	result  chan error
+}
+
+func NewRedis(log *slog.Logger, opts RedisOptions) *Redis {
+	if opts.DialTimeout <= 0 {
+		opts.DialTimeout = 5 * time.Second
+	}
+	if opts.PingInterval <= 0 {
+		opts.PingInterval = 30 * time.Second
+	}
+	if opts.SubscriberBuffer <= 0 {
+		opts.SubscriberBuffer = 1
+	}
+
+	r := &Redis{
+		log:       log.With(slog.String("component", "infrastructure/pubsub/redis")),
+		opts:      opts,
+		publishes: make(chan publishRequest),
+		done:      make(chan struct{}),
+		subs:      make(map[*redisSubscription]struct{}),
+	}
+
+	r.wg.Add(1)
+	go r.publisher()
+
+	return r
+}
+
+type redisConn struct {
+	net.Conn
+	rd *bufio.Reader
+	wr *bufio.Writer
+}
+
+func (r *Redis) dial(ctx context.Context) (*redisConn, error) {
+	d := net.Dialer{Timeout: r.opts.DialTimeout}
+	nc, err := d.DialContext(ctx, "tcp", r.opts.Addr)
+	if err != nil {
+		return nil, err
+	}
+
+	conn := &redisConn{Conn: nc, rd: bufio.NewReader(nc), wr: bufio.NewWriter(nc)}
+
+	if r.opts.Password != "" {
+		if _, err := conn.do(r.opts.DialTimeout, "AUTH", r.opts.Password); err != nil {
+			conn.Close()
+			return nil, err
+		}
+	}
+
+	return conn, nil
+}
+
+// do - команда с ответом в пределах timeout
+func (c *redisConn) do(timeout time.Duration, args ...string) (any, error) {
+	return c.doUntil(time.Now().Add(timeout), args...)
+}
+
+func (c *redisConn) doUntil(deadline time.Time, args ...string) (any, error) {
+	c.SetDeadline(deadline)
+	defer c.SetDeadline(time.Time{})
+
+	if err := writeCommand(c.wr, args...); err != nil {
+		return nil, err
+	}
+	return readReply(c.rd)
+}
+
+func (r *Redis) Publish(ctx context.Context, channel string, payload []byte) error {
+	req := publishRequest{ctx: ctx, channel: channel, payload: payload, result: make(chan error, 1)}
+
+	select {
+	case r.publishes <- req:
+	case <-r.done:
+		return ErrClosed
+	case <-ctx.Done():
+		return ctx.Err()
+	}
+
+	select {
+	case err := <-req.result:
+		return err
+	case <-ctx.Done():
+		return ctx.Err()
+	}
+}
+
+// publisher - отправляет публикации по одной, пока шину не закроют
+func (r *Redis) publisher() {
+	defer r.wg.Done()
+
+	for {
+		select {
+		case <-r.done:
+			return
+		case req := <-r.publishes:
+			if err := req.ctx.Err(); err != nil {
+				req.result <- err
+				continue
+			}
+			req.result <- r.publish(req)
+		}
+	}
+}
+
+func (r *Redis) publish(req publishRequest) error {
+	deadline := time.Now().Add(r.opts.DialTimeout)
+	if d, ok := req.ctx.Deadline(); ok && d.Before(deadline) {
+		deadline = d
+	}
+
+	//одна повторная попытка на новом соединении: общее соединение могло умереть, пока простаивало
+	var err error
+	for attempt := 0; attempt < 2; attempt++ {
+		r.mu.Lock()
+		conn, closed := r.conn, r.closed
+		r.mu.Unlock()
+		if closed {
+			return ErrClosed
+		}
+
+		if conn == nil {
+			if conn, err = r.dial(req.ctx); err != nil {
+				return err
+			}
+			//Close мог пройти, пока шло подключение
+			r.mu.Lock()
+			if r.closed {
+				r.mu.Unlock()
+				conn.Close()
+				return ErrClosed
+			}
+			r.conn = conn
+			r.mu.Unlock()
+		}
+
+		_, err = conn.doUntil(deadline, "PUBLISH", req.channel, string(req.payload))
+		if err == nil {
+			return nil
+		}
+
+		var rerr redisError
+		if errors.As(err, &rerr) {
+			return err
+		}
+
+		conn.Close()
+		r.mu.Lock()
+		if r.conn == conn {
+			r.conn = nil
+		}
+		r.mu.Unlock()
+	}
+
+	return err
+}
+
+// Subscribe - первое подключение синхронное, чтобы ошибка настроек была видна сразу,
+// дальнейшие обрывы переподключаются в фоне
+func (r *Redis) Subscribe(ctx context.Context, channels ...string) (Subscription, error) {
+	conn, err := r.subscribeConn(ctx, channels)
+	if err != nil {
+		return nil, err
+	}
+
+	sub := &redisSubscription{
+		redis:    r,
+		channels: channels,
+		ch:       make(chan Message, r.opts.SubscriberBuffer),
+		done:     make(chan struct{}),
+		conn:     conn,
+	}
+
+	r.mu.Lock()
+	if r.closed {
+		r.mu.Unlock()
+		conn.Close()
+		return nil, ErrClosed
+	}
+	r.subs[sub] = struct{}{}
+	r.mu.Unlock()
+
+	sub.wg.Add(1)
+	go sub.run()
+
+	return sub, nil
+}
+
+func (r *Redis) subscribeConn(ctx context.Context, channels []string) (*redisConn, error) {
+	conn, err := r.dial(ctx)
+	if err != nil {
+		return nil, err
+	}
+
+	args := append([]string{"SUBSCRIBE"}, channels...)
+	conn.SetWriteDeadline(time.Now().Add(r.opts.DialTimeout))
+	if err := writeCommand(conn.wr, args...); err != nil {
+		conn.Close()
+		return nil, err
+	}
+	conn.SetWriteDeadline(time.Time{})
+
+	return conn, nil
+}
+
+func (r *Redis) Close() error {
+	r.mu.Lock()
+	if r.closed {
+		r.mu.Unlock()
+		return nil
+	}
+	r.closed = true
+	close(r.done)
+
+	//закрытое соединение прерывает публикацию, которая сейчас ждет сеть
+	if r.conn != nil {
+		r.conn.Close()
+		r.conn = nil
+	}
+
+	subs := make([]*redisSubscription, 0, len(r.subs))
+	for sub := range r.subs {
+		subs = append(subs, sub)
+	}
+	r.mu.Unlock()
+
+	for _, sub := range subs {
+		sub.Close()
+	}
+
+	r.wg.Wait()
+
+	return nil
+}
+
+type redisSubscription struct {
+	redis    *Redis
+	channels []string
+	ch       chan Message
+
+	mu        sync.Mutex
+	conn      *redisConn
+	done      chan struct{}
+	closeOnce sync.Once
+	wg        sync.WaitGroup
+}
+
+func (s *redisSubscription) Messages() <-chan Message {
+	return s.ch
+}
+
+func (s *redisSubscription) Close() error {
+	s.closeOnce.Do(func() {
+		close(s.done)
+
+		s.mu.Lock()
+		if s.conn != nil {
+			s.conn.Close()
+		}
+		s.mu.Unlock()
+
+		s.wg.Wait()
+
+		s.redis.mu.Lock()
+		delete(s.redis.subs, s)
+		s.redis.mu.Unlock()
+	})
+	return nil
+}
+
+func (s *redisSubscription) closing() bool {
+	select {
+	case <-s.done:
+		return true
+	default:
+		return false
+	}
+}
+
+// run - читает сообщения, при обрыве переподключается с экспоненциальной паузой
+func (s *redisSubscription) run() {
+	defer s.wg.Done()
+	defer close(s.ch)
+
+	delay := redisReconnectMinDelay
+	for {
+		s.mu.Lock()
+		conn := s.conn
+		s.mu.Unlock()
+
+		if conn != nil {
+			err := s.listen(conn)
+			conn.Close()
+			if s.closing() {
+				return
+			}
+			s.redis.log.Warn("соединение подписки pubsub оборвалось, переподключаемся", sl.Err(err))
+			delay = redisReconnectMinDelay
+		}
+
+		select {
+		case <-s.done:
+			return
+		case <-time.After(delay):
+		}
+
+		ctx, cancel := context.WithTimeout(context.Background(), s.redis.opts.DialTimeout)
+		conn, err := s.redis.subscribeConn(ctx, s.channels)
+		cancel()
+
+		s.mu.Lock()
+		if err == nil && s.closing() {
+			conn.Close()
+			conn, err = nil, ErrClosed
+		}
+		s.conn = conn
+		s.mu.Unlock()
+
+		if err != nil {
+			if s.closing() {
+				return
+			}
+			s.redis.log.Warn("не удалось переподключить подписку pubsub", sl.Err(err))
+			delay = min(delay*2, redisReconnectMaxDelay)
+		}
+	}
+}
+
+// listen - читает соединение подписки до ошибки. PING раз в PingInterval нужен, чтобы
+// дедлайн чтения срабатывал только на действительно мертвом соединении
+func (s *redisSubscription) listen(conn *redisConn) error {
+	interval := s.redis.opts.PingInterval
+
+	stopPing := make(chan struct{})
+	defer close(stopPing)
+	go func() {
+		ticker := time.NewTicker(interval)
+		defer ticker.Stop()
+		for {
+			select {
+			case <-stopPing:
+				return
+			case <-ticker.C:
+				conn.SetWriteDeadline(time.Now().Add(interval))
+				if err := writeCommand(conn.wr, "PING"); err != nil {
+					conn.Close()
+					return
+				}
+			}
+		}
+	}()
+
+	for {
+		conn.SetReadDeadline(time.Now().Add(2 * interval))
+
+		reply, err := readReply(conn.rd)
+		if err != nil {
+			return err
+		}
+
+		items, ok := reply.([]any)
+		if !ok || len(items) == 0 {
+			continue
+		}
+		kind, _ := items[0].(string)
+		if kind != "message" || len(items) != 3 {
+			//subscribe-подтверждения и pong
+			continue
+		}
+
+		channel, _ := items[1].(string)
+		payload, _ := items[2].(string)
+		push(s.ch, Message{Channel: channel, Payload: []byte(payload)})
+	}
+}
@@ -0,0 +1,229 @@
+package pubsub
+
+import (
+	"bufio"
+	"context"
+	"errors"
+	"io"
+	"log/slog"
+	"strings"
+	"testing"
+	"time"
+)
+
+func newTestRedis(t *testing.T, f *fakeRedis, password string) *Redis {
+	t.Helper()
+
+	r := NewRedis(slog.New(slog.NewTextHandler(io.Discard, nil)), RedisOptions{
+		Addr:             f.addr(),
+		Password:         password,
+		DialTimeout:      time.Second,
+		PingInterval:     100 * time.Millisecond,
+		SubscriberBuffer: 16,
+	})
+	t.Cleanup(func() { r.Close() })
+
+	return r
+}
+
+func waitFor(t *testing.T, what string, cond func() bool) {
+	t.Helper()
+
+	deadline := time.Now().Add(5 * time.Second)
+	for !cond() {
+		if time.Now().After(deadline) {
+			t.Fatalf("timed out waiting for %s", what)
+		}
+		time.Sleep(10 * time.Millisecond)
+	}
+}
+
+func receive(t *testing.T, sub Subscription) Message {
+	t.Helper()
+
+	select {
+	case msg, ok := <-sub.Messages():
+		if !ok {
+			t.Fatal("subscription closed")
+		}
+		return msg
+	case <-time.After(5 * time.Second):
+		t.Fatal("timed out waiting for message")
+	}
+	return Message{}
+}
+
+func TestRedisPublishSubscribe(t *testing.T) {
+	f := newFakeRedis(t, "secret")
+	r := newTestRedis(t, f, "secret")
+	ctx := context.Background()
+
+	sub, err := r.Subscribe(ctx, "live")
+	if err != nil {
+		t.Fatalf("subscribe: %v", err)
+	}
+	waitFor(t, "subscription", func() bool { return f.subscribers("live") == 1 })
+
+	if err := r.Publish(ctx, "live", []byte("hello\r\nworld")); err != nil {
+		t.Fatalf("publish: %v", err)
+	}
+
+	msg := receive(t, sub)
+	if msg.Channel != "live" || string(msg.Payload) != "hello\r\nworld" {
+		t.Fatalf("got %q on %q", msg.Payload, msg.Channel)
+	}
+}
+
+func TestRedisWrongPassword(t *testing.T) {
+	f := newFakeRedis(t, "secret")
+	r := newTestRedis(t, f, "wrong")
+
+	_, err := r.Subscribe(context.Background(), "live")
+	var rerr redisError
+	if !errors.As(err, &rerr) {
+		t.Fatalf("subscribe error = %v, want redis error", err)
+	}
+
+	err = r.Publish(context.Background(), "live", []byte("x"))
+	if !errors.As(err, &rerr) {
+		t.Fatalf("publish error = %v, want redis error", err)
+	}
+}
+
+func TestRedisSubscriptionReconnects(t *testing.T) {
+	f := newFakeRedis(t, "")
+	r := newTestRedis(t, f, "")
+	ctx := context.Background()
+
+	sub, err := r.Subscribe(ctx, "live")
+	if err != nil {
+		t.Fatalf("subscribe: %v", err)
+	}
+	waitFor(t, "subscription", func() bool { return f.subscribers("live") == 1 })
+
+	f.dropConns()
+	waitFor(t, "drop", func() bool { return f.subscribers("live") == 0 })
+	waitFor(t, "resubscription", func() bool { return f.subscribers("live") == 1 })
+
+	//общее соединение публикации тоже оборвано, Publish переподключается сам
+	if err := r.Publish(ctx, "live", []byte("after")); err != nil {
+		t.Fatalf("publish: %v", err)
+	}
+	if msg := receive(t, sub); string(msg.Payload) != "after" {
+		t.Fatalf("got %q", msg.Payload)
+	}
+}
+
+func TestRedisPublishRespectsContext(t *testing.T) {
+	f := newFakeRedis(t, "")
+	r := newTestRedis(t, f, "")
+	f.setHang(true)
+
+	ctx, cancel := context.WithTimeout(context.Background(), 100*time.Millisecond)
+	defer cancel()
+
+	start := time.Now()
+	if err := r.Publish(ctx, "live", []byte("x")); err == nil {
+		t.Fatal("publish to a hung server succeeded")
+	}
+	if elapsed := time.Since(start); elapsed > 500*time.Millisecond {
+		t.Fatalf("publish took %s, ctx deadline was 100ms", elapsed)
+	}
+}
+
+func TestRedisCloseDoesNotWaitForPublish(t *testing.T) {
+	f := newFakeRedis(t, "")
+	r := newTestRedis(t, f, "")
+	f.setHang(true)
+
+	published := make(chan error, 1)
+	go func() {
+		published <- r.Publish(context.Background(), "live", []byte("x"))
+	}()
+
+	select {
+	case <-f.published:
+	case <-time.After(5 * time.Second):
+		t.Fatal("publish did not reach the server")
+	}
+
+	//подписка и закрытие не ждут зависшую публикацию
+	f.setHang(false)
+	if _, err := r.Subscribe(context.Background(), "other"); err != nil {
+		t.Fatalf("subscribe during publish: %v", err)
+	}
+
+	start := time.Now()
+	if err := r.Close(); err != nil {
+		t.Fatalf("close: %v", err)
+	}
+	if elapsed := time.Since(start); elapsed > 500*time.Millisecond {
+		t.Fatalf("close took %s", elapsed)
+	}
+
+	select {
+	case err := <-published:
+		if err == nil {
+			t.Fatal("interrupted publish returned nil")
+		}
+	case <-time.After(5 * time.Second):
+		t.Fatal("publish did not return after close")
+	}
+
+	if err := r.Publish(context.Background(), "live", []byte("x")); !errors.Is(err, ErrClosed) {
+		t.Fatalf("publish after close = %v, want ErrClosed", err)
+	}
+}
+
+func TestReadReply(t *testing.T) {
+	tests := []struct {
+		name  string
+		input string
+		want  any
+	}{
+		{"simple string", "+OK\r\n", "OK"},
+		{"integer", ":42\r\n", int64(42)},
+		{"bulk string", "$5\r\na\r\nbc\r\n", "a\r\nbc"},
+		{"null bulk string", "$-1\r\n", nil},
+		{"array", "*2\r\n$7\r\nmessage\r\n:1\r\n", []any{"message", int64(1)}},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got, err := readReply(bufio.NewReader(strings.NewReader(tt.input)))
+			if err != nil {
+				t.Fatalf("readReply: %v", err)
+			}
+			if !equalReply(got, tt.want) {
+				t.Fatalf("got %#v, want %#v", got, tt.want)
+			}
+		})
+	}
+
+	if _, err := readReply(bufio.NewReader(strings.NewReader("-ERR boom\r\n"))); err == nil || err.Error() != "redis: ERR boom" {
+		t.Fatalf("error reply = %v", err)
+	}
+	if _, err := readReply(bufio.NewReader(strings.NewReader("?\r\n"))); !errors.Is(err, errProtocol) {
+		t.Fatalf("unknown type = %v, want protocol error", err)
+	}
+}
+
+func equalReply(a, b any) bool {
+	as, aok := a.([]any)
+	bs, bok := b.([]any)
+	if aok != bok {
+		return false
+	}
+	if !aok {
+		return a == b
+	}
+	if len(as) != len(bs) {
+		return false
+	}
+	for i := range as {
+		if !equalReply(as[i], bs[i]) {
+			return false
+		}
+	}
+	return true
+}
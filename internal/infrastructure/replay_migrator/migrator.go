@@ -0,0 +1,84 @@
+package replaymigrator
+
+import (
+	"context"
+	"log/slog"
+	"metrika/pkg/logger/sl"
+	"sync"
+	"time"
+)
+
+// ReplayMigrator - в фоне переносит старые построчные rrweb-ивенты в сжатые чанки
+// и завершается, когда переносить больше нечего
+type ReplayMigrator struct {
+	log      *slog.Logger
+	interval time.Duration
+	batch    int
+	fn       ReplayMigratorAdapter
+	ctx      context.Context
+	cancel   context.CancelFunc
+	wg       sync.WaitGroup
+}
+
+type ReplayMigratorAdapter interface {
+	MigrateLegacy(ctx context.Context, sessions int) (int, error)
+}
+
+func NewReplayMigrator(log *slog.Logger, interval time.Duration, batch int, fn ReplayMigratorAdapter) *ReplayMigrator {
+	ctx, cancel := context.WithCancel(context.Background())
+
+	return &ReplayMigrator{
+		log:      log,
+		interval: interval,
+		batch:    batch,
+		fn:       fn,
+		ctx:      ctx,
+		cancel:   cancel,
+	}
+}
+
+func (m *ReplayMigrator) Start() {
+	m.wg.Add(1)
+	defer m.wg.Done()
+
+	total := 0
+	for {
+		migrated, err := m.fn.MigrateLegacy(m.ctx, m.batch)
+		total += migrated
+		if err != nil {
+			if m.ctx.Err() != nil {
+				return
+			}
+			m.log.Error("ошибка переноса записей сессий в чанки", sl.Err(err))
+		} else if migrated < m.batch {
+			if total > 0 {
+				m.log.Info("перенос записей сессий в чанки завершен", slog.Int("sessions", total))
+			}
+			return
+		}
+
+		select {
+		case <-time.After(m.interval):
+		case <-m.ctx.Done():
+			return
+		}
+	}
+}
+
+// Stop - прерывает перенос(текущая сессия откатится в транзакции) и ждет выхода, но не дольше ctx
+func (m *ReplayMigrator) Stop(ctx context.Context) error {
+	m.cancel()
+
+	done := make(chan struct{})
+	go func() {
+		m.wg.Wait()
+		close(done)
+	}()
+
+	select {
+	case <-done:
+		return nil
+	case <-ctx.Done():
+		return ctx.Err()
+	}
+}
@@ -4,43 +4,66 @@ import (
 	"context"
 	"log/slog"
 	"metrika/pkg/logger/sl"
+	"sync"
 	"time"
 )
 
 type SessionsWorker struct {
 	log      *slog.Logger
 	interval time.Duration
+	batch    int
 	fn       SessionsWorkerAdapter
 	stop     chan struct{}
+	//закрывается, когда цикл воркера завершился
+	done     chan struct{}
+	stopOnce sync.Once
 }
 
 type SessionsWorkerAdapter interface {
 	CleanupBatchSessions(ctx context.Context, limit int) error
 }
 
-func NewSessionsWorker(log *slog.Logger, interval time.Duration, fn SessionsWorkerAdapter, stop chan struct{}) *SessionsWorker {
+func NewSessionsWorker(log *slog.Logger, interval time.Duration, batch int, fn SessionsWorkerAdapter, stop chan struct{}) *SessionsWorker {
 	return &SessionsWorker{
-		log,
-		interval,
-		fn,
-		stop,
+		log:      log,
+		interval: interval,
+		batch:    batch,
+		fn:       fn,
+		stop:     stop,
+		done:     make(chan struct{}),
 	}
 }
 
-func (s SessionsWorker) StartSessionManager() {
+// StartSessionManager - чистка идет прямо в цикле, поэтому проходы не накладываются друг на друга:
+// тики, пришедшие во время долгой чистки, тикер отбрасывает
+func (s *SessionsWorker) StartSessionManager() {
+	defer close(s.done)
+
 	ticker := time.NewTicker(s.interval)
+	defer ticker.Stop()
+
 	for c := ticker.C; ; {
 		select {
 		case <-c:
-			go func() {
-				ctx := context.Background()
-				if err := s.fn.CleanupBatchSessions(ctx, 1000); err != nil {
-					s.log.ErrorContext(ctx, "ошибка при закрытии неактивных сессий", sl.Err(err))
-				}
-			}()
+			ctx := context.Background()
+			if err := s.fn.CleanupBatchSessions(ctx, s.batch); err != nil {
+				s.log.ErrorContext(ctx, "ошибка при закрытии неактивных сессий", sl.Err(err))
+			}
 		case <-s.stop:
 			return
 		}
 
 	}
 }
+
+// Stop - останавливает воркер и ждет завершения текущей чистки, но не дольше ctx
+func (s *SessionsWorker) Stop(ctx context.Context) error {
+	s.stopOnce.Do(func() { close(s.stop) })
+
+	select {
+	case <-s.done:
+		return nil
+	case <-ctx.Done():
+		return ctx.Err()
+	}
+}
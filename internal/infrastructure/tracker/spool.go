@@ -0,0 +1,290 @@
+package tracker
+
+import (
+	"bufio"
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"os"
+	"path/filepath"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+
+	domain "metrika/internal/domain/analytics"
+)
+
+const (
+	segmentExt       = ".wal"
+	failedSegmentExt = ".failed"
+)
+
+// spool - write-ahead лог ивентов на локальном диске.
+// ивенты дописываются в активный сегмент, заполненный сегмент запечатывается
+// и сохраняется в базу целиком, после чего удаляется
+type spool struct {
+	dir string
+
+	mu          sync.Mutex
+	active      *os.File
+	activeSeq   uint64
+	activeCount int
+	segmentSize int
+}
+
+// openSpool - открывает спул в директории. сегменты, оставшиеся с прошлого запуска,
+// считаются запечатанными, их ивенты возвращаются как pending
+func openSpool(dir string, segmentSize int) (*spool, int64, error) {
+	if err := os.MkdirAll(dir, 0755); err != nil {
+		return nil, 0, err
+	}
+
+	s := &spool{dir: dir, segmentSize: segmentSize}
+
+	segments, err := s.segments()
+	if err != nil {
+		return nil, 0, err
+	}
+
+	var pending int64
+	for _, seg := range segments {
+		if seg.seq >= s.activeSeq {
+			s.activeSeq = seg.seq
+		}
+		n, err := countLines(seg.path)
+		if err != nil {
+			return nil, 0, err
+		}
+		pending += n
+	}
+
+	if err := s.openActive(); err != nil {
+		return nil, 0, err
+	}
+
+	return s, pending, nil
+}
+
+type segment struct {
+	seq  uint64
+	path string
+}
+
+func (s *spool) segmentPath(seq uint64) string {
+	return filepath.Join(s.dir, fmt.Sprintf("%020d%s", seq, segmentExt))
+}
+
+func (s *spool) openActive() error {
+	s.activeSeq++
+	f, err := os.OpenFile(s.segmentPath(s.activeSeq), os.O_CREATE|os.O_WRONLY|os.O_APPEND, 0644)
+	if err != nil {
+		return err
+	}
+	s.active = f
+	s.activeCount = 0
+	return nil
+}
+
+// append - дописывает ивенты в активный сегмент и сбрасывает их на диск.
+// возвращает true, если сегмент заполнился и был запечатан
+func (s *spool) append(events []domain.Event) (bool, error) {
+	var buf bytes.Buffer
+	enc := json.NewEncoder(&buf)
+	for _, e := range events {
+		if err := enc.Encode(e); err != nil {
+			return false, err
+		}
+	}
+
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	if s.active == nil {
+		return false, ErrTrackerClosed
+	}
+
+	if _, err := s.active.Write(buf.Bytes()); err != nil {
+		return false, err
+	}
+	if err := s.active.Sync(); err != nil {
+		return false, err
+	}
+	s.activeCount += len(events)
+
+	if s.activeCount >= s.segmentSize {
+		return true, s.sealLocked()
+	}
+
+	return false, nil
+}
+
+// seal - запечатывает активный сегмент, если в нем что-то есть
+func (s *spool) seal() error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	if s.active == nil || s.activeCount == 0 {
+		return nil
+	}
+
+	return s.sealLocked()
+}
+
+func (s *spool) sealLocked() error {
+	if err := s.active.Close(); err != nil {
+		return err
+	}
+	return s.openActive()
+}
+
+// close - закрывает спул, дальнейшая запись невозможна. пустой активный сегмент удаляется
+func (s *spool) close() error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	if s.active == nil {
+		return nil
+	}
+
+	path := s.active.Name()
+	empty := s.activeCount == 0
+	err := s.active.Close()
+	s.active = nil
+
+	if empty {
+		os.Remove(path)
+	}
+
+	return err
+}
+
+// sealed - запечатанные сегменты в порядке записи
+func (s *spool) sealed() ([]segment, error) {
+	segments, err := s.segments()
+	if err != nil {
+		return nil, err
+	}
+
+	s.mu.Lock()
+	activeSeq := s.activeSeq
+	closed := s.active == nil
+	s.mu.Unlock()
+
+	var sealed []segment
+	for _, seg := range segments {
+		if seg.seq == activeSeq && !closed {
+			continue
+		}
+		sealed = append(sealed, seg)
+	}
+
+	return sealed, nil
+}
+
+func (s *spool) segments() ([]segment, error) {
+	entries, err := os.ReadDir(s.dir)
+	if err != nil {
+		return nil, err
+	}
+
+	var segments []segment
+	for _, entry := range entries {
+		name := entry.Name()
+		if entry.IsDir() || !strings.HasSuffix(name, segmentExt) {
+			continue
+		}
+		seq, err := strconv.ParseUint(strings.TrimSuffix(name, segmentExt), 10, 64)
+		if err != nil {
+			continue
+		}
+		segments = append(segments, segment{seq: seq, path: filepath.Join(s.dir, name)})
+	}
+
+	sort.Slice(segments, func(i, j int) bool { return segments[i].seq < segments[j].seq })
+
+	return segments, nil
+}
+
+// read - ивенты сегмента и строки, которые не удалось разобрать
+func (s *spool) read(seg segment) ([]domain.Event, [][]byte, error) {
+	f, err := os.Open(seg.path)
+	if err != nil {
+		return nil, nil, err
+	}
+	defer f.Close()
+
+	var events []domain.Event
+	var broken [][]byte
+
+	scanner := bufio.NewScanner(f)
+	scanner.Buffer(make([]byte, 0, 64*1024), 16*1024*1024)
+	for scanner.Scan() {
+		line := scanner.Bytes()
+		if len(line) == 0 {
+			continue
+		}
+		var e domain.Event
+		//недописанная строка после падения процесса или битая запись
+		if err := json.Unmarshal(line, &e); err != nil {
+			broken = append(broken, bytes.Clone(line))
+			continue
+		}
+		events = append(events, e)
+	}
+
+	return events, broken, scanner.Err()
+}
+
+func (s *spool) remove(seg segment) error {
+	return os.Remove(seg.path)
+}
+
+// quarantine - откладывает в .failed файл сегмента только битые строки и отвергнутые ивенты,
+// чтобы их можно было разобрать вручную, а остальная очередь не блокировалась
+func (s *spool) quarantine(seg segment, broken [][]byte, rejected []domain.Event) error {
+	var buf bytes.Buffer
+	for _, line := range broken {
+		buf.Write(line)
+		buf.WriteByte('\n')
+	}
+	enc := json.NewEncoder(&buf)
+	for _, e := range rejected {
+		if err := enc.Encode(e); err != nil {
+			return err
+		}
+	}
+
+	f, err := os.OpenFile(strings.TrimSuffix(seg.path, segmentExt)+failedSegmentExt, os.O_CREATE|os.O_WRONLY|os.O_APPEND, 0644)
+	if err != nil {
+		return err
+	}
+	if _, err := f.Write(buf.Bytes()); err != nil {
+		f.Close()
+		return err
+	}
+	if err := f.Sync(); err != nil {
+		f.Close()
+		return err
+	}
+	return f.Close()
+}
+
+func countLines(path string) (int64, error) {
+	f, err := os.Open(path)
+	if err != nil {
+		return 0, err
+	}
+	defer f.Close()
+
+	var n int64
+	scanner := bufio.NewScanner(f)
+	scanner.Buffer(make([]byte, 0, 64*1024), 16*1024*1024)
+	for scanner.Scan() {
+		if len(scanner.Bytes()) > 0 {
+			n++
+		}
+	}
+
+	return n, scanner.Err()
+}
@@ -2,67 +2,369 @@ package tracker
 
 import (
 	"context"
-	"log"
+	"errors"
+	"log/slog"
+	"metrika/internal/config"
 	domain "metrika/internal/domain/analytics"
+	"metrika/internal/domain/tx"
+	"metrika/pkg/logger/sl"
+	"sync"
+	"sync/atomic"
 	"time"
 )
 
+var (
+	ErrTrackerClosed = errors.New("tracker closed")
+	ErrBufferFull    = errors.New("tracker buffer is full")
+)
+
+// Tracker - батчер ивентов. ивенты сначала пишутся в спул на диске,
+// а потом пачками сохраняются в базу с ретраями, так что ничего не теряется при рестарте
 type Tracker struct {
-	Events    chan domain.Event
-	BatchSize int
-	BuferSize int64
-	Interval  time.Duration
-	handler   StorageHandler
+	log     *slog.Logger
+	cfg     config.Tracker
+	handler StorageHandler
+	spool   *spool
+
+	//сколько ивентов лежит в спуле и еще не сохранено
+	pending   atomic.Int64
+	accepted  atomic.Int64
+	persisted atomic.Int64
+	retried   atomic.Int64
+	dropped   atomic.Int64
+
+	//сигнал сейверу, что запечатан полный сегмент
+	notify chan struct{}
+	//закрывается, когда в буфере освобождается место
+	spaceMu sync.Mutex
+	space   chan struct{}
+
+	closed atomic.Bool
+	stop   chan struct{}
+	drain  chan context.Context
+	done   chan struct{}
+	//отменяется в Close, когда истек его ctx, и прерывает текущее сохранение с ретраями
+	ctx    context.Context
+	cancel context.CancelFunc
 }
 
+// StorageHandler - хранилище ивентов. ошибка с tx.ErrRejected значит, что пачку не примут и при повторе,
+// любая другая ошибка считается временной недоступностью хранилища
 type StorageHandler interface {
 	SaveEvents(ctx context.Context, events *[]domain.Event) error
 }
 
-func New(batchSize int, interval time.Duration, buferSize int64, handler StorageHandler) *Tracker {
-	tr := Tracker{
-		Events:    make(chan domain.Event, buferSize),
-		Interval:  interval,
-		BuferSize: buferSize,
-		BatchSize: batchSize,
-		handler:   handler,
+func New(log *slog.Logger, cfg config.Tracker, handler StorageHandler) (*Tracker, error) {
+	sp, pending, err := openSpool(cfg.SpoolDir, cfg.BatchSize)
+	if err != nil {
+		return nil, err
+	}
+
+	ctx, cancel := context.WithCancel(context.Background())
+
+	tr := &Tracker{
+		log:     log.With(slog.String("component", "tracker")),
+		cfg:     cfg,
+		handler: handler,
+		spool:   sp,
+		notify:  make(chan struct{}, 1),
+		space:   make(chan struct{}),
+		stop:    make(chan struct{}),
+		drain:   make(chan context.Context),
+		done:    make(chan struct{}),
+		ctx:     ctx,
+		cancel:  cancel,
+	}
+	tr.pending.Store(pending)
+
+	if pending > 0 {
+		tr.log.Info("найдены несохраненные ивенты в спуле", slog.Int64("pending", pending))
 	}
 
 	go tr.saver()
 
-	return &tr
+	return tr, nil
 }
 
 func (r *Tracker) saver() {
-	ticker := time.NewTicker(r.Interval)
-	batch := make([]domain.Event, 0, r.BatchSize)
+	defer close(r.done)
 
-	ctx := context.Background()
+	ticker := time.NewTicker(r.cfg.FlushInterval)
+	defer ticker.Stop()
+
+	//сначала дохраняем то, что осталось с прошлого запуска
+	r.flush(r.ctx)
 
 	for {
 		select {
-		case e := <-r.Events:
-			batch = append(batch, e)
+		case <-r.notify:
+			r.flush(r.ctx)
+		case <-ticker.C:
+			if err := r.spool.seal(); err != nil {
+				r.log.Error("ошибка при запечатывании сегмента спула", sl.Err(err))
+			}
+			r.flush(r.ctx)
+		case <-r.ctx.Done():
+			if err := r.spool.close(); err != nil {
+				r.log.Error("ошибка при закрытии спула", sl.Err(err))
+			}
+			return
+		case ctx := <-r.drain:
+			if err := r.spool.close(); err != nil {
+				r.log.Error("ошибка при закрытии спула", sl.Err(err))
+			}
+			r.flush(ctx)
+			return
+		}
+	}
+}
+
+// flush - сохраняет все запечатанные сегменты по порядку.
+// при отмене ctx оставшиеся сегменты остаются на диске до следующего запуска
+func (r *Tracker) flush(ctx context.Context) {
+	segments, err := r.spool.sealed()
+	if err != nil {
+		r.log.Error("ошибка чтения спула", sl.Err(err))
+		return
+	}
+
+	for _, seg := range segments {
+		if ctx.Err() != nil {
+			return
+		}
+
+		events, broken, err := r.spool.read(seg)
+		if err != nil {
+			r.log.Error("ошибка чтения сегмента спула", slog.String("segment", seg.path), sl.Err(err))
+			continue
+		}
+
+		lines, _ := countLines(seg.path)
 
-			if len(batch) >= r.BatchSize {
-				r.handler.SaveEvents(ctx, &batch)
-				batch = batch[:0]
+		var rejected []domain.Event
+		if len(events) > 0 {
+			rejected, err = r.save(ctx, events)
+			if err != nil {
+				return
 			}
-		case <-ticker.C:
-			if len(batch) > 0 {
-				log.Println("СОХРАНЯЮ ИВЕНТ")
-				r.handler.SaveEvents(ctx, &batch)
-				batch = batch[:0]
+			r.persisted.Add(int64(len(events) - len(rejected)))
+		}
+
+		//в карантин уходят только битые строки и ивенты, которые хранилище отвергло, остальные уже сохранены
+		if len(broken) > 0 || len(rejected) > 0 {
+			r.log.Error("часть ивентов сегмента не сохранена, они отложены",
+				slog.String("segment", seg.path),
+				slog.Int("rejected", len(rejected)),
+				slog.Int("broken", len(broken)),
+			)
+			if err := r.spool.quarantine(seg, broken, rejected); err != nil {
+				r.log.Error("ошибка при откладывании ивентов", slog.String("segment", seg.path), sl.Err(err))
 			}
+			r.dropped.Add(int64(len(broken) + len(rejected)))
+		}
+
+		if err := r.spool.remove(seg); err != nil {
+			r.log.Error("ошибка удаления сегмента спула", slog.String("segment", seg.path), sl.Err(err))
+		}
+		r.release(lines)
+	}
+}
+
+// save - сохраняет пачку. пока хранилище недоступно, пачка повторяется без ограничения числа попыток.
+// если хранилище отвергло пачку, она делится пополам, пока не останутся отдельные ивенты,
+// которые хранилище не принимает - они возвращаются как rejected. ошибка возвращается только при отмене ctx
+func (r *Tracker) save(ctx context.Context, events []domain.Event) ([]domain.Event, error) {
+	err := r.saveWithRetry(ctx, events)
+	if err == nil {
+		return nil, nil
+	}
+	if ctx.Err() != nil {
+		return nil, ctx.Err()
+	}
+
+	r.log.Warn("пачка ивентов отвергнута хранилищем, сохраняем по частям", slog.Int("events", len(events)), sl.Err(err))
+
+	return r.split(ctx, events)
+}
+
+func (r *Tracker) split(ctx context.Context, events []domain.Event) ([]domain.Event, error) {
+	if len(events) == 1 {
+		return events, nil
+	}
+
+	var rejected []domain.Event
+	for _, part := range [][]domain.Event{events[:len(events)/2], events[len(events)/2:]} {
+		part = append([]domain.Event(nil), part...)
+		if err := r.saveWithRetry(ctx, part); err == nil {
+			continue
+		}
+		if ctx.Err() != nil {
+			return nil, ctx.Err()
+		}
+
+		failed, err := r.split(ctx, part)
+		if err != nil {
+			return nil, err
+		}
+		rejected = append(rejected, failed...)
+	}
+
+	return rejected, nil
+}
+
+// saveWithRetry - сохраняет пачку, пока хранилище недоступно - повторяет с экспоненциальной задержкой до RetryMaxDelay.
+// возвращает ошибку, только если хранилище отвергло пачку или отменен ctx
+func (r *Tracker) saveWithRetry(ctx context.Context, events []domain.Event) error {
+	delay := r.cfg.RetryBaseDelay
+
+	for attempt := 0; ; attempt++ {
+		err := r.handler.SaveEvents(ctx, &events)
+		if err == nil {
+			return nil
+		}
+
+		if ctx.Err() != nil {
+			return ctx.Err()
+		}
+
+		if errors.Is(err, tx.ErrRejected) {
+			return err
+		}
+
+		r.retried.Add(1)
+		attrs := []any{
+			slog.Int("attempt", attempt+1),
+			slog.String("delay", delay.String()),
+			sl.Err(err),
+		}
+		//ивенты остаются в спуле, но долгая недоступность хранилища должна быть заметна
+		if attempt >= r.cfg.MaxRetries {
+			r.log.Error("хранилище ивентов недоступно, повтор", attrs...)
+		} else {
+			r.log.Warn("ошибка сохранения пачки ивентов, повтор", attrs...)
+		}
+
+		timer := time.NewTimer(delay)
+		select {
+		case <-timer.C:
+		case <-ctx.Done():
+			timer.Stop()
+			return ctx.Err()
+		}
+
+		delay *= 2
+		if delay > r.cfg.RetryMaxDelay {
+			delay = r.cfg.RetryMaxDelay
+		}
+	}
+}
+
+// release - освобождает место в буфере и будит ждущих писателей
+func (r *Tracker) release(n int64) {
+	r.pending.Add(-n)
+
+	r.spaceMu.Lock()
+	close(r.space)
+	r.space = make(chan struct{})
+	r.spaceMu.Unlock()
+}
+
+// TrackEvents - принимает ивенты в спул. если буфер заполнен - ждет, пока освободится место,
+// но не дольше EnqueueTimeout и ctx. ивенты, которые так и не приняты, учитываются как dropped
+func (r *Tracker) TrackEvents(ctx context.Context, events []domain.Event) error {
+	if len(events) == 0 {
+		return nil
+	}
+
+	n := int64(len(events))
+
+	if r.closed.Load() {
+		r.dropped.Add(n)
+		return ErrTrackerClosed
+	}
+
+	ctx, cancel := context.WithTimeout(ctx, r.cfg.EnqueueTimeout)
+	defer cancel()
+
+	for {
+		if r.pending.Add(n) <= r.cfg.BufferSize {
+			break
+		}
+		r.pending.Add(-n)
+
+		r.spaceMu.Lock()
+		space := r.space
+		r.spaceMu.Unlock()
+
+		select {
+		case <-space:
+		case <-ctx.Done():
+			r.dropped.Add(n)
+			return ErrBufferFull
+		}
+	}
+
+	sealed, err := r.spool.append(events)
+	if err != nil {
+		r.pending.Add(-n)
+		r.dropped.Add(n)
+		return err
+	}
+
+	r.accepted.Add(n)
+
+	if sealed {
+		select {
+		case r.notify <- struct{}{}:
+		default:
 		}
 	}
+
+	return nil
+}
+
+func (r *Tracker) TrackEvent(ctx context.Context, e domain.Event) error {
+	return r.TrackEvents(ctx, []domain.Event{e})
+}
+
+// Stats - счетчики принятых, сохраненных, повторенных и потерянных ивентов
+func (r *Tracker) Stats() domain.IngestionStats {
+	return domain.IngestionStats{
+		Accepted:  r.accepted.Load(),
+		Persisted: r.persisted.Load(),
+		Retried:   r.retried.Load(),
+		Dropped:   r.dropped.Load(),
+		Pending:   r.pending.Load(),
+	}
 }
 
-func (r *Tracker) TrackEvent(e domain.Event) {
+// Close - перестает принимать ивенты и сохраняет все, что лежит в спуле.
+// если ctx истек раньше, несохраненное останется на диске до следующего запуска
+func (r *Tracker) Close(ctx context.Context) error {
+	if !r.closed.CompareAndSwap(false, true) {
+		return ErrTrackerClosed
+	}
+
+	//сейвер может быть занят ретраями - тогда по истечении ctx они прерываются
 	select {
-	case r.Events <- e:
+	case r.drain <- ctx:
+	case <-r.done:
+	case <-ctx.Done():
+		r.cancel()
+		return ctx.Err()
+	}
 
-	default:
+	select {
+	case <-r.done:
+	case <-ctx.Done():
+		r.cancel()
+		return ctx.Err()
+	}
+	r.cancel()
 
+	if pending := r.pending.Load(); pending > 0 {
+		r.log.Warn("не все ивенты сохранены при остановке, они остались в спуле", slog.Int64("pending", pending))
 	}
+
+	return nil
 }
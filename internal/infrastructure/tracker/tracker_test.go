@@ -0,0 +1,219 @@
+package tracker
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"log/slog"
+	"metrika/internal/config"
+	domain "metrika/internal/domain/analytics"
+	"metrika/internal/domain/tx"
+	"path/filepath"
+	"sync"
+	"testing"
+	"time"
+)
+
+// fakeStorage - хранилище, которое можно "уронить": пока down, все сохранения падают как при обрыве соединения.
+// ивенты с типом bad хранилище отвергает
+type fakeStorage struct {
+	mu    sync.Mutex
+	down  bool
+	calls int
+	saved map[string]bool
+}
+
+func newFakeStorage(down bool) *fakeStorage {
+	return &fakeStorage{down: down, saved: make(map[string]bool)}
+}
+
+func (s *fakeStorage) SaveEvents(ctx context.Context, events *[]domain.Event) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	s.calls++
+	if s.down {
+		return errors.New("dial tcp 127.0.0.1:5432: connect: connection refused")
+	}
+	for _, e := range *events {
+		if e.Type == "bad" {
+			return errors.Join(tx.ErrRejected, errors.New("violates check constraint"))
+		}
+	}
+	for _, e := range *events {
+		s.saved[e.Element] = true
+	}
+	return nil
+}
+
+func (s *fakeStorage) setDown(down bool) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.down = down
+}
+
+func (s *fakeStorage) stats() (calls int, saved int) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	return s.calls, len(s.saved)
+}
+
+func testConfig(dir string) config.Tracker {
+	return config.Tracker{
+		SpoolDir:       dir,
+		BatchSize:      10,
+		FlushInterval:  10 * time.Millisecond,
+		BufferSize:     1000,
+		EnqueueTimeout: time.Second,
+		MaxRetries:     1,
+		RetryBaseDelay: time.Millisecond,
+		RetryMaxDelay:  10 * time.Millisecond,
+	}
+}
+
+func newTestTracker(t *testing.T, dir string, storage StorageHandler) *Tracker {
+	t.Helper()
+
+	tr, err := New(slog.New(slog.NewTextHandler(io.Discard, nil)), testConfig(dir), storage)
+	if err != nil {
+		t.Fatalf("new tracker: %v", err)
+	}
+	return tr
+}
+
+func trackN(t *testing.T, tr *Tracker, n int, eventType func(i int) string) {
+	t.Helper()
+
+	for i := 0; i < n; i++ {
+		e := domain.Event{SessionID: 1, Type: eventType(i), Element: fmt.Sprint(i), Timestamp: time.Now()}
+		if err := tr.TrackEvent(context.Background(), e); err != nil {
+			t.Fatalf("track: %v", err)
+		}
+	}
+}
+
+func pageview(int) string { return "pageview" }
+
+func files(t *testing.T, dir, ext string) []string {
+	t.Helper()
+
+	matches, err := filepath.Glob(filepath.Join(dir, "*"+ext))
+	if err != nil {
+		t.Fatal(err)
+	}
+	return matches
+}
+
+func waitFor(t *testing.T, what string, cond func() bool) {
+	t.Helper()
+
+	deadline := time.Now().Add(5 * time.Second)
+	for !cond() {
+		if time.Now().After(deadline) {
+			t.Fatalf("timed out waiting for %s", what)
+		}
+		time.Sleep(10 * time.Millisecond)
+	}
+}
+
+func TestTrackerKeepsEventsDuringOutage(t *testing.T) {
+	dir := t.TempDir()
+	storage := newFakeStorage(true)
+	tr := newTestTracker(t, dir, storage)
+
+	trackN(t, tr, 25, pageview)
+
+	//хранилище лежит намного дольше MaxRetries повторов
+	waitFor(t, "retries", func() bool {
+		calls, _ := storage.stats()
+		return calls > 20
+	})
+
+	if failed := files(t, dir, failedSegmentExt); len(failed) > 0 {
+		t.Fatalf("events quarantined during outage: %v", failed)
+	}
+	if stats := tr.Stats(); stats.Dropped != 0 || stats.Pending != 25 {
+		t.Fatalf("stats during outage = %+v", stats)
+	}
+
+	storage.setDown(false)
+
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+	if err := tr.Close(ctx); err != nil {
+		t.Fatalf("close: %v", err)
+	}
+
+	if _, saved := storage.stats(); saved != 25 {
+		t.Fatalf("saved %d events, want 25", saved)
+	}
+	if stats := tr.Stats(); stats.Dropped != 0 || stats.Persisted != 25 || stats.Pending != 0 {
+		t.Fatalf("stats after recovery = %+v", stats)
+	}
+	if failed := files(t, dir, failedSegmentExt); len(failed) > 0 {
+		t.Fatalf("unexpected quarantine: %v", failed)
+	}
+}
+
+func TestTrackerResumesAfterRestartDuringOutage(t *testing.T) {
+	dir := t.TempDir()
+	storage := newFakeStorage(true)
+	tr := newTestTracker(t, dir, storage)
+
+	trackN(t, tr, 25, pageview)
+
+	ctx, cancel := context.WithTimeout(context.Background(), 100*time.Millisecond)
+	defer cancel()
+	if err := tr.Close(ctx); !errors.Is(err, context.DeadlineExceeded) {
+		t.Fatalf("close during outage = %v, want deadline exceeded", err)
+	}
+	if wal := files(t, dir, segmentExt); len(wal) == 0 {
+		t.Fatal("spool is empty after close during outage")
+	}
+
+	storage.setDown(false)
+	tr = newTestTracker(t, dir, storage)
+
+	ctx, cancel = context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+	if err := tr.Close(ctx); err != nil {
+		t.Fatalf("close: %v", err)
+	}
+
+	if _, saved := storage.stats(); saved != 25 {
+		t.Fatalf("saved %d events, want 25", saved)
+	}
+	if wal := files(t, dir, segmentExt); len(wal) != 0 {
+		t.Fatalf("segments left after recovery: %v", wal)
+	}
+}
+
+func TestTrackerQuarantinesRejectedEvents(t *testing.T) {
+	dir := t.TempDir()
+	storage := newFakeStorage(false)
+	tr := newTestTracker(t, dir, storage)
+
+	trackN(t, tr, 10, func(i int) string {
+		if i == 3 {
+			return "bad"
+		}
+		return "pageview"
+	})
+
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+	if err := tr.Close(ctx); err != nil {
+		t.Fatalf("close: %v", err)
+	}
+
+	if _, saved := storage.stats(); saved != 9 {
+		t.Fatalf("saved %d events, want 9", saved)
+	}
+	if stats := tr.Stats(); stats.Dropped != 1 || stats.Persisted != 9 {
+		t.Fatalf("stats = %+v", stats)
+	}
+	if failed := files(t, dir, failedSegmentExt); len(failed) != 1 {
+		t.Fatalf("failed segments = %v, want 1", failed)
+	}
+}
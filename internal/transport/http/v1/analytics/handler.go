@@ -1,10 +1,10 @@
 package analytics
 
 import (
-	"context"
 	"errors"
 	"log/slog"
 	domain "metrika/internal/domain/analytics"
+	"metrika/internal/transport/http/v1/middleware"
 	"metrika/internal/usecase/analytics"
 	response "metrika/pkg/api"
 	"metrika/pkg/logger/sl"
@@ -27,13 +27,15 @@ type Handler struct {
 }
 
 type CollectEventsRequest struct {
-	Events []CollectEventRequest `json:"events" validate:"required"`
+	SiteKey string                `json:"site_key" validate:"required"`
+	Events  []CollectEventRequest `json:"events" validate:"required"`
 }
 
 type CollectEventRequest struct {
 	SessionID uint                   `json:"session_id" validate:"required"`
 	Type      string                 `json:"type" validate:"required"`
 	PageURL   string                 `json:"page_url" validate:"required"`
+	URL       string                 `json:"url"`
 	Element   string                 `json:"element"`
 	Timestamp time.Time              `json:"timestamp"`
 	Data      map[string]interface{} `json:"data"`
@@ -70,6 +72,14 @@ func (h *Handler) AddEvent(w http.ResponseWriter, r *http.Request) {
 
 	var events []domain.Event
 	for _, event := range req.Events {
+		//полный адрес нужен для utm-меток и определения внутренних переходов
+		if event.URL != "" {
+			if event.Data == nil {
+				event.Data = make(map[string]interface{})
+			}
+			event.Data["url"] = event.URL
+		}
+
 		e := domain.Event{
 			SessionID: event.SessionID,
 			Type:      event.Type,
@@ -81,14 +91,42 @@ func (h *Handler) AddEvent(w http.ResponseWriter, r *http.Request) {
 		events = append(events, e)
 	}
 
-	go h.events.Execute(context.Background(), &events)
+	dom, err := h.events.Authorize(r.Context(), req.SiteKey, requestOrigin(r), events)
+	if err != nil {
+		if h.writeDomainError(w, r, err) {
+			return
+		}
+		if errors.Is(err, domain.ErrSessionInvalid) {
+			w.WriteHeader(http.StatusBadRequest)
+			render.JSON(w, r, response.BadRequest("session not found"))
+			return
+		}
+		//клиент запрашивает новую сессию и отправляет ивенты в нее
+		if errors.Is(err, domain.ErrSessionClosed) {
+			w.WriteHeader(http.StatusConflict)
+			render.JSON(w, r, response.ErrorWithStatus(response.StatusSessionClosed, "session closed"))
+			return
+		}
+		h.log.Error("ошибка проверки домена для ивентов", sl.Err(err))
+		w.WriteHeader(http.StatusInternalServerError)
+		render.JSON(w, r, response.Error("internal error"))
+		return
+	}
+
+	if err := h.events.Execute(r.Context(), dom.ID, events); err != nil {
+		h.log.Error("ошибка приема ивентов", sl.Err(err))
+		w.WriteHeader(http.StatusServiceUnavailable)
+		render.JSON(w, r, response.Error("ingestion unavailable, retry later"))
+		return
+	}
 
 	w.WriteHeader(http.StatusOK)
 	render.JSON(w, r, response.OK())
 }
 
 type AddRecordEventsRequest struct {
-	Events []domain.RecordEvent `json:"events" validate:"required"`
+	SiteKey string               `json:"site_key" validate:"required"`
+	Events  []domain.RecordEvent `json:"events" validate:"required"`
 }
 
 // TODO: вынести в обработку сохранений по пачкам в воркер
@@ -116,6 +154,21 @@ func (h *Handler) AddRecordEvents(w http.ResponseWriter, r *http.Request) {
 		return
 	}
 
+	if _, err := h.recordEvents.Authorize(r.Context(), req.SiteKey, requestOrigin(r), uint(session_id)); err != nil {
+		if h.writeDomainError(w, r, err) {
+			return
+		}
+		if errors.Is(err, domain.ErrSessionInvalid) {
+			w.WriteHeader(http.StatusBadRequest)
+			render.JSON(w, r, response.BadRequest("session not found"))
+			return
+		}
+		h.log.Error("ошибка проверки домена для записи", sl.Err(err))
+		w.WriteHeader(http.StatusInternalServerError)
+		render.JSON(w, r, response.Error("internal error"))
+		return
+	}
+
 	if err := h.recordEvents.Execute(r.Context(), req.Events, uint(session_id)); err != nil {
 		if errors.Is(err, domain.ErrSessionsNotFound) {
 			w.WriteHeader(http.StatusBadRequest)
@@ -132,7 +185,11 @@ func (h *Handler) AddRecordEvents(w http.ResponseWriter, r *http.Request) {
 }
 
 type CreateNewSessionRequest struct {
-	FingerprintID string `json:"f_id"`
+	FingerprintID string `json:"f_id" validate:"required"`
+	SiteKey       string `json:"site_key" validate:"required"`
+	//адрес страницы и document.referrer - по ним сессия разбивается при смене кампании
+	URL      string `json:"url"`
+	Referrer string `json:"referrer"`
 }
 
 type CreateNewSessionResponse struct {
@@ -158,10 +215,24 @@ func (h *Handler) CreateGuestSession(w http.ResponseWriter, r *http.Request) {
 
 	ipAddress := r.Header.Get("X-Forwarded-For")
 
-	//TODO: не забыть реализовать разные домены
-	session, err := h.sessions.Execute(r.Context(), req.FingerprintID, ipAddress, "test.ru")
+	session, err := h.sessions.Execute(r.Context(), analytics.GuestSessionRequest{
+		FingerprintID: req.FingerprintID,
+		IPAddress:     ipAddress,
+		UserAgent:     r.UserAgent(),
+		Country:       requestCountry(r),
+		SiteKey:       req.SiteKey,
+		Origin:        requestOrigin(r),
+		URL:           req.URL,
+		Referrer:      req.Referrer,
+	})
 	if err != nil {
+		if h.writeDomainError(w, r, err) {
+			return
+		}
 		logger.Error("ошибка создания гостевой сессии", sl.Err(err))
+		w.WriteHeader(http.StatusInternalServerError)
+		render.JSON(w, r, response.Error("failed to create session"))
+		return
 	}
 
 	w.WriteHeader(http.StatusCreated)
@@ -184,7 +255,19 @@ func (h *Handler) GetRecordEvents(w http.ResponseWriter, r *http.Request) {
 		return
 	}
 
-	events, err := h.getRecordEvents.Execute(r.Context(), uint(session_id))
+	claims, ok := middleware.ClaimsFromContext(r.Context())
+	if !ok {
+		w.WriteHeader(http.StatusUnauthorized)
+		render.JSON(w, r, response.Error("unauthorized"))
+		return
+	}
+
+	events, err := h.getRecordEvents.Execute(r.Context(), claims.UserID, uint(session_id))
+	if errors.Is(err, domain.ErrDomainAccessDenied) {
+		w.WriteHeader(http.StatusForbidden)
+		render.JSON(w, r, response.Error("access denied"))
+		return
+	}
 	if err != nil && !errors.Is(err, domain.ErrRecordEventsNotFound) {
 		w.WriteHeader(http.StatusInternalServerError)
 		render.JSON(w, r, response.Error("internal error"))
@@ -197,3 +280,39 @@ func (h *Handler) GetRecordEvents(w http.ResponseWriter, r *http.Request) {
 		Response: response.OK(),
 	})
 }
+
+// requestOrigin - источник запроса: Origin, а если его нет - Referer
+func requestOrigin(r *http.Request) string {
+	if origin := r.Header.Get("Origin"); origin != "" && origin != "null" {
+		return origin
+	}
+	return r.Header.Get("Referer")
+}
+
+// заголовки, в которые CDN и прокси кладут страну клиента по geoip
+var countryHeaders = []string{"CF-IPCountry", "CloudFront-Viewer-Country", "X-Country-Code", "X-Geo-Country"}
+
+// requestCountry - страна клиента из заголовка прокси, сам сервер geoip не определяет
+func requestCountry(r *http.Request) string {
+	for _, header := range countryHeaders {
+		if country := r.Header.Get(header); country != "" {
+			return country
+		}
+	}
+	return ""
+}
+
+// writeDomainError - отвечает клиенту, если ключ сайта неизвестен или origin не совпадает с доменом
+func (h *Handler) writeDomainError(w http.ResponseWriter, r *http.Request, err error) bool {
+	switch {
+	case errors.Is(err, domain.ErrDomainNotFound):
+		w.WriteHeader(http.StatusForbidden)
+		render.JSON(w, r, response.Error("unknown site key"))
+		return true
+	case errors.Is(err, domain.ErrOriginNotAllowed):
+		w.WriteHeader(http.StatusForbidden)
+		render.JSON(w, r, response.Error("origin not allowed"))
+		return true
+	}
+	return false
+}
@@ -0,0 +1,319 @@
+package domains
+
+import (
+	"errors"
+	"log/slog"
+	domain "metrika/internal/domain/analytics"
+	"metrika/internal/transport/http/v1/middleware"
+	"metrika/internal/usecase/metrika"
+	response "metrika/pkg/api"
+	"metrika/pkg/logger/sl"
+	"net/http"
+	"strconv"
+
+	"github.com/go-chi/chi/v5"
+	"github.com/go-chi/render"
+	"github.com/go-playground/validator/v10"
+)
+
+type Handler struct {
+	log           *slog.Logger
+	createDomain  *metrika.CreateDomainUseCase
+	getDomains    *metrika.GetDomainsUseCase
+	renameDomain  *metrika.RenameDomainUseCase
+	rotateSiteKey *metrika.RotateSiteKeyUseCase
+	deleteDomain  *metrika.DeleteDomainUseCase
+	sessions      *metrika.UpdateSessionSettingsUseCase
+	retention     *metrika.UpdateRetentionSettingsUseCase
+}
+
+func NewHandler(
+	log *slog.Logger,
+	createDomain *metrika.CreateDomainUseCase,
+	getDomains *metrika.GetDomainsUseCase,
+	renameDomain *metrika.RenameDomainUseCase,
+	rotateSiteKey *metrika.RotateSiteKeyUseCase,
+	deleteDomain *metrika.DeleteDomainUseCase,
+	sessions *metrika.UpdateSessionSettingsUseCase,
+	retention *metrika.UpdateRetentionSettingsUseCase,
+) *Handler {
+	return &Handler{
+		log,
+		createDomain,
+		getDomains,
+		renameDomain,
+		rotateSiteKey,
+		deleteDomain,
+		sessions,
+		retention,
+	}
+}
+
+type DomainResponse struct {
+	Response response.Response `json:"response"`
+	Domain   *domain.Domain    `json:"domain"`
+}
+
+type DomainsResponse struct {
+	Response response.Response `json:"response"`
+	Domains  []domain.Domain   `json:"domains"`
+}
+
+type CreateDomainRequest struct {
+	SiteURL string `json:"site_url" validate:"required,url"`
+	Name    string `json:"name"`
+}
+
+func (h *Handler) CreateDomain(w http.ResponseWriter, r *http.Request) {
+	claims, ok := middleware.ClaimsFromContext(r.Context())
+	if !ok {
+		w.WriteHeader(http.StatusUnauthorized)
+		render.JSON(w, r, response.Error("unauthorized"))
+		return
+	}
+
+	var req CreateDomainRequest
+	if err := render.Decode(r, &req); err != nil {
+		w.WriteHeader(http.StatusBadRequest)
+		render.JSON(w, r, response.BadRequest("unable to decode request"))
+		return
+	}
+
+	if err := response.ValidateRequest(req); err != nil {
+		validateErr := err.(validator.ValidationErrors)
+		w.WriteHeader(http.StatusBadRequest)
+		render.JSON(w, r, response.ValidationError(validateErr))
+		return
+	}
+
+	dom, err := h.createDomain.Execute(r.Context(), claims.UserID, req.SiteURL, req.Name)
+	if err != nil {
+		if errors.Is(err, domain.ErrDomainAlreadyExists) {
+			w.WriteHeader(http.StatusConflict)
+			render.JSON(w, r, response.ErrorWithStatus(response.StatusAlreadyExists, "domain already exists"))
+			return
+		}
+		h.log.Error("ошибка создания домена", sl.Err(err))
+		w.WriteHeader(http.StatusInternalServerError)
+		render.JSON(w, r, response.Error("failed to create domain"))
+		return
+	}
+
+	w.WriteHeader(http.StatusCreated)
+	render.JSON(w, r, DomainResponse{
+		Response: response.OK(),
+		Domain:   dom,
+	})
+}
+
+func (h *Handler) GetDomains(w http.ResponseWriter, r *http.Request) {
+	claims, ok := middleware.ClaimsFromContext(r.Context())
+	if !ok {
+		w.WriteHeader(http.StatusUnauthorized)
+		render.JSON(w, r, response.Error("unauthorized"))
+		return
+	}
+
+	domains, err := h.getDomains.Execute(r.Context(), claims.UserID)
+	if err != nil {
+		h.log.Error("ошибка получения доменов юзера", sl.Err(err))
+		w.WriteHeader(http.StatusInternalServerError)
+		render.JSON(w, r, response.Error("failed to get domains"))
+		return
+	}
+
+	render.JSON(w, r, DomainsResponse{
+		Response: response.OK(),
+		Domains:  domains,
+	})
+}
+
+type RenameDomainRequest struct {
+	Name string `json:"name" validate:"required"`
+}
+
+func (h *Handler) RenameDomain(w http.ResponseWriter, r *http.Request) {
+	domain_id, err := strconv.Atoi(chi.URLParam(r, "domain_id"))
+	if err != nil {
+		w.WriteHeader(http.StatusBadRequest)
+		render.JSON(w, r, response.BadRequest("bad domain id"))
+		return
+	}
+
+	var req RenameDomainRequest
+	if err := render.Decode(r, &req); err != nil {
+		w.WriteHeader(http.StatusBadRequest)
+		render.JSON(w, r, response.BadRequest("unable to decode request"))
+		return
+	}
+
+	if err := response.ValidateRequest(req); err != nil {
+		validateErr := err.(validator.ValidationErrors)
+		w.WriteHeader(http.StatusBadRequest)
+		render.JSON(w, r, response.ValidationError(validateErr))
+		return
+	}
+
+	dom, err := h.renameDomain.Execute(r.Context(), uint(domain_id), req.Name)
+	if err != nil {
+		h.writeError(w, r, err, "failed to rename domain")
+		return
+	}
+
+	render.JSON(w, r, DomainResponse{
+		Response: response.OK(),
+		Domain:   dom,
+	})
+}
+
+type UpdateSessionSettingsRequest struct {
+	TimeoutMinutes        int    `json:"timeout_minutes" validate:"required"`
+	Timezone              string `json:"timezone" validate:"required"`
+	SplitAtMidnight       bool   `json:"split_at_midnight"`
+	SplitOnCampaignChange bool   `json:"split_on_campaign_change"`
+}
+
+// UpdateSessionSettings - таймаут неактивности и правила разбиения сессий домена
+func (h *Handler) UpdateSessionSettings(w http.ResponseWriter, r *http.Request) {
+	domain_id, err := strconv.Atoi(chi.URLParam(r, "domain_id"))
+	if err != nil {
+		w.WriteHeader(http.StatusBadRequest)
+		render.JSON(w, r, response.BadRequest("bad domain id"))
+		return
+	}
+
+	var req UpdateSessionSettingsRequest
+	if err := render.Decode(r, &req); err != nil {
+		w.WriteHeader(http.StatusBadRequest)
+		render.JSON(w, r, response.BadRequest("unable to decode request"))
+		return
+	}
+
+	if err := response.ValidateRequest(req); err != nil {
+		validateErr := err.(validator.ValidationErrors)
+		w.WriteHeader(http.StatusBadRequest)
+		render.JSON(w, r, response.ValidationError(validateErr))
+		return
+	}
+
+	dom, err := h.sessions.Execute(r.Context(), uint(domain_id), domain.SessionSettings{
+		TimeoutMinutes:        req.TimeoutMinutes,
+		Timezone:              req.Timezone,
+		SplitAtMidnight:       req.SplitAtMidnight,
+		SplitOnCampaignChange: req.SplitOnCampaignChange,
+	})
+	if err != nil {
+		if errors.Is(err, domain.ErrInvalidSessionSettings) {
+			w.WriteHeader(http.StatusBadRequest)
+			render.JSON(w, r, response.BadRequest(err.Error()))
+			return
+		}
+		h.writeError(w, r, err, "failed to update session settings")
+		return
+	}
+
+	render.JSON(w, r, DomainResponse{
+		Response: response.OK(),
+		Domain:   dom,
+	})
+}
+
+// UpdateRetentionSettingsRequest - сроки хранения в днях, 0 - бессрочно
+type UpdateRetentionSettingsRequest struct {
+	EventsDays   *int `json:"events_days" validate:"required"`
+	SessionsDays *int `json:"sessions_days" validate:"required"`
+	ReplaysDays  *int `json:"replays_days" validate:"required"`
+	RollupsDays  *int `json:"rollups_days" validate:"required"`
+}
+
+// UpdateRetentionSettings - сколько хранятся ивенты, сессии, записи и роллапы домена
+func (h *Handler) UpdateRetentionSettings(w http.ResponseWriter, r *http.Request) {
+	domain_id, err := strconv.Atoi(chi.URLParam(r, "domain_id"))
+	if err != nil {
+		w.WriteHeader(http.StatusBadRequest)
+		render.JSON(w, r, response.BadRequest("bad domain id"))
+		return
+	}
+
+	var req UpdateRetentionSettingsRequest
+	if err := render.Decode(r, &req); err != nil {
+		w.WriteHeader(http.StatusBadRequest)
+		render.JSON(w, r, response.BadRequest("unable to decode request"))
+		return
+	}
+
+	if err := response.ValidateRequest(req); err != nil {
+		validateErr := err.(validator.ValidationErrors)
+		w.WriteHeader(http.StatusBadRequest)
+		render.JSON(w, r, response.ValidationError(validateErr))
+		return
+	}
+
+	dom, err := h.retention.Execute(r.Context(), uint(domain_id), domain.RetentionSettings{
+		EventsDays:   *req.EventsDays,
+		SessionsDays: *req.SessionsDays,
+		ReplaysDays:  *req.ReplaysDays,
+		RollupsDays:  *req.RollupsDays,
+	})
+	if err != nil {
+		if errors.Is(err, domain.ErrInvalidRetentionSettings) {
+			w.WriteHeader(http.StatusBadRequest)
+			render.JSON(w, r, response.BadRequest(err.Error()))
+			return
+		}
+		h.writeError(w, r, err, "failed to update retention settings")
+		return
+	}
+
+	render.JSON(w, r, DomainResponse{
+		Response: response.OK(),
+		Domain:   dom,
+	})
+}
+
+func (h *Handler) RotateSiteKey(w http.ResponseWriter, r *http.Request) {
+	domain_id, err := strconv.Atoi(chi.URLParam(r, "domain_id"))
+	if err != nil {
+		w.WriteHeader(http.StatusBadRequest)
+		render.JSON(w, r, response.BadRequest("bad domain id"))
+		return
+	}
+
+	dom, err := h.rotateSiteKey.Execute(r.Context(), uint(domain_id))
+	if err != nil {
+		h.writeError(w, r, err, "failed to rotate site key")
+		return
+	}
+
+	render.JSON(w, r, DomainResponse{
+		Response: response.OK(),
+		Domain:   dom,
+	})
+}
+
+func (h *Handler) DeleteDomain(w http.ResponseWriter, r *http.Request) {
+	domain_id, err := strconv.Atoi(chi.URLParam(r, "domain_id"))
+	if err != nil {
+		w.WriteHeader(http.StatusBadRequest)
+		render.JSON(w, r, response.BadRequest("bad domain id"))
+		return
+	}
+
+	if err := h.deleteDomain.Execute(r.Context(), uint(domain_id)); err != nil {
+		h.writeError(w, r, err, "failed to delete domain")
+		return
+	}
+
+	render.JSON(w, r, response.OK())
+}
+
+func (h *Handler) writeError(w http.ResponseWriter, r *http.Request, err error, msg string) {
+	if errors.Is(err, domain.ErrDomainNotFound) {
+		w.WriteHeader(http.StatusNotFound)
+		render.JSON(w, r, response.ErrorWithStatus(response.StatusNotFound, "domain not found"))
+		return
+	}
+	h.log.Error(msg, sl.Err(err))
+	w.WriteHeader(http.StatusInternalServerError)
+	render.JSON(w, r, response.Error(msg))
+}
@@ -0,0 +1,138 @@
+package events
+
+import (
+	"errors"
+	"log/slog"
+	domain "metrika/internal/domain/analytics"
+	"metrika/internal/usecase/metrika"
+	response "metrika/pkg/api"
+	"metrika/pkg/logger/sl"
+	"net/http"
+	"strconv"
+	"time"
+
+	"github.com/go-chi/chi/v5"
+	"github.com/go-chi/render"
+	"github.com/go-playground/validator/v10"
+)
+
+type Handler struct {
+	log     *slog.Logger
+	explore *metrika.ExploreEventsUseCase
+	catalog *metrika.EventCatalogUseCase
+}
+
+func NewHandler(
+	log *slog.Logger,
+	explore *metrika.ExploreEventsUseCase,
+	catalog *metrika.EventCatalogUseCase,
+) *Handler {
+	return &Handler{
+		log,
+		explore,
+		catalog,
+	}
+}
+
+type ExploreRequest struct {
+	EventType string                  `json:"event_type" validate:"required"`
+	Filters   []domain.PropertyFilter `json:"filters"`
+	GroupBy   []string                `json:"group_by"`
+	Interval  string                  `json:"interval"`
+	StartDate *time.Time              `json:"start_date"`
+	EndDate   *time.Time              `json:"end_date"`
+	Groups    int                     `json:"groups"`
+}
+
+type ExploreResponse struct {
+	Response response.Response `json:"response"`
+	*domain.ExplorerResult
+}
+
+type CatalogResponse struct {
+	Response   response.Response         `json:"response"`
+	EventTypes []domain.EventCatalogType `json:"event_types"`
+}
+
+// Explore - число ивентов типа по интервалам с фильтрами по свойствам data
+// и разбивкой по значениям до двух свойств
+func (h *Handler) Explore(w http.ResponseWriter, r *http.Request) {
+	domain_id, err := strconv.Atoi(chi.URLParam(r, "domain_id"))
+	if err != nil {
+		w.WriteHeader(http.StatusBadRequest)
+		render.JSON(w, r, response.BadRequest("bad domain id"))
+		return
+	}
+
+	var req ExploreRequest
+	if err := render.Decode(r, &req); err != nil {
+		w.WriteHeader(http.StatusBadRequest)
+		render.JSON(w, r, response.BadRequest("unable to decode request"))
+		return
+	}
+
+	if err := response.ValidateRequest(req); err != nil {
+		validateErr := err.(validator.ValidationErrors)
+		w.WriteHeader(http.StatusBadRequest)
+		render.JSON(w, r, response.ValidationError(validateErr))
+		return
+	}
+
+	if req.StartDate != nil && req.EndDate != nil && req.EndDate.Before(*req.StartDate) {
+		w.WriteHeader(http.StatusBadRequest)
+		render.JSON(w, r, response.BadRequest("end date before start date"))
+		return
+	}
+
+	opts := domain.ExplorerOptions{
+		DomainID:  uint(domain_id),
+		EventType: req.EventType,
+		Filters:   req.Filters,
+		GroupBy:   req.GroupBy,
+		Interval:  req.Interval,
+		Groups:    req.Groups,
+	}
+
+	result, err := h.explore.Execute(r.Context(), opts, req.StartDate, req.EndDate)
+	if err != nil {
+		h.writeError(w, r, err, "failed to explore events")
+		return
+	}
+
+	render.JSON(w, r, ExploreResponse{
+		Response:       response.OK(),
+		ExplorerResult: result,
+	})
+}
+
+// GetCatalog - типы ивентов домена и ключи их свойств с типами значений
+func (h *Handler) GetCatalog(w http.ResponseWriter, r *http.Request) {
+	domain_id, err := strconv.Atoi(chi.URLParam(r, "domain_id"))
+	if err != nil {
+		w.WriteHeader(http.StatusBadRequest)
+		render.JSON(w, r, response.BadRequest("bad domain id"))
+		return
+	}
+
+	catalog, err := h.catalog.Execute(r.Context(), uint(domain_id))
+	if err != nil {
+		h.writeError(w, r, err, "failed to get event catalog")
+		return
+	}
+
+	render.JSON(w, r, CatalogResponse{
+		Response:   response.OK(),
+		EventTypes: catalog,
+	})
+}
+
+func (h *Handler) writeError(w http.ResponseWriter, r *http.Request, err error, msg string) {
+	if errors.Is(err, domain.ErrInvalidExplorerQuery) {
+		w.WriteHeader(http.StatusBadRequest)
+		render.JSON(w, r, response.BadRequest(err.Error()))
+		return
+	}
+	h.log.Error(msg, sl.Err(err))
+	w.WriteHeader(http.StatusInternalServerError)
+	render.JSON(w, r, response.Error(msg))
+}
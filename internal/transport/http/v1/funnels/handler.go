@@ -0,0 +1,277 @@
+package funnels
+
+import (
+	"errors"
+	"log/slog"
+	domain "metrika/internal/domain/analytics"
+	"metrika/internal/usecase/metrika"
+	response "metrika/pkg/api"
+	"metrika/pkg/logger/sl"
+	"net/http"
+	"strconv"
+	"time"
+
+	"github.com/go-chi/chi/v5"
+	"github.com/go-chi/render"
+	"github.com/go-playground/validator/v10"
+)
+
+type Handler struct {
+	log          *slog.Logger
+	getFunnels   *metrika.GetFunnelsUseCase
+	createFunnel *metrika.CreateFunnelUseCase
+	updateFunnel *metrika.UpdateFunnelUseCase
+	deleteFunnel *metrika.DeleteFunnelUseCase
+	report       *metrika.FunnelReportUseCase
+}
+
+func NewHandler(
+	log *slog.Logger,
+	getFunnels *metrika.GetFunnelsUseCase,
+	createFunnel *metrika.CreateFunnelUseCase,
+	updateFunnel *metrika.UpdateFunnelUseCase,
+	deleteFunnel *metrika.DeleteFunnelUseCase,
+	report *metrika.FunnelReportUseCase,
+) *Handler {
+	return &Handler{
+		log,
+		getFunnels,
+		createFunnel,
+		updateFunnel,
+		deleteFunnel,
+		report,
+	}
+}
+
+type FunnelsResponse struct {
+	Response response.Response `json:"response"`
+	Funnels  []domain.Funnel   `json:"funnels"`
+}
+
+type FunnelResponse struct {
+	Response response.Response `json:"response"`
+	Funnel   *domain.Funnel    `json:"funnel"`
+}
+
+type FunnelReportResponse struct {
+	Response response.Response `json:"response"`
+	*domain.FunnelReport
+}
+
+type FunnelRequest struct {
+	Name string `json:"name" validate:"required"`
+	// session по умолчанию
+	Scope domain.FunnelScope `json:"scope"`
+	// сутки по умолчанию
+	WindowMinutes int                 `json:"window_minutes"`
+	Steps         []domain.FunnelStep `json:"steps" validate:"required"`
+}
+
+func (req FunnelRequest) funnel(domain_id uint) domain.Funnel {
+	return domain.Funnel{
+		DomainID:      domain_id,
+		Name:          req.Name,
+		Scope:         req.Scope,
+		WindowMinutes: req.WindowMinutes,
+		Steps:         req.Steps,
+	}
+}
+
+func (h *Handler) GetFunnels(w http.ResponseWriter, r *http.Request) {
+	domain_id, err := strconv.Atoi(chi.URLParam(r, "domain_id"))
+	if err != nil {
+		w.WriteHeader(http.StatusBadRequest)
+		render.JSON(w, r, response.BadRequest("bad domain id"))
+		return
+	}
+
+	funnels, err := h.getFunnels.Execute(r.Context(), uint(domain_id))
+	if err != nil {
+		h.writeError(w, r, err, "failed to get funnels")
+		return
+	}
+
+	render.JSON(w, r, FunnelsResponse{
+		Response: response.OK(),
+		Funnels:  funnels,
+	})
+}
+
+func (h *Handler) CreateFunnel(w http.ResponseWriter, r *http.Request) {
+	domain_id, err := strconv.Atoi(chi.URLParam(r, "domain_id"))
+	if err != nil {
+		w.WriteHeader(http.StatusBadRequest)
+		render.JSON(w, r, response.BadRequest("bad domain id"))
+		return
+	}
+
+	req, ok := h.decodeFunnel(w, r)
+	if !ok {
+		return
+	}
+
+	funnel, err := h.createFunnel.Execute(r.Context(), req.funnel(uint(domain_id)))
+	if err != nil {
+		h.writeError(w, r, err, "failed to create funnel")
+		return
+	}
+
+	w.WriteHeader(http.StatusCreated)
+	render.JSON(w, r, FunnelResponse{
+		Response: response.OK(),
+		Funnel:   funnel,
+	})
+}
+
+func (h *Handler) UpdateFunnel(w http.ResponseWriter, r *http.Request) {
+	domain_id, err := strconv.Atoi(chi.URLParam(r, "domain_id"))
+	if err != nil {
+		w.WriteHeader(http.StatusBadRequest)
+		render.JSON(w, r, response.BadRequest("bad domain id"))
+		return
+	}
+
+	funnel_id, err := strconv.Atoi(chi.URLParam(r, "funnel_id"))
+	if err != nil {
+		w.WriteHeader(http.StatusBadRequest)
+		render.JSON(w, r, response.BadRequest("bad funnel id"))
+		return
+	}
+
+	req, ok := h.decodeFunnel(w, r)
+	if !ok {
+		return
+	}
+
+	update := req.funnel(uint(domain_id))
+	update.ID = uint(funnel_id)
+
+	funnel, err := h.updateFunnel.Execute(r.Context(), update)
+	if err != nil {
+		h.writeError(w, r, err, "failed to update funnel")
+		return
+	}
+
+	render.JSON(w, r, FunnelResponse{
+		Response: response.OK(),
+		Funnel:   funnel,
+	})
+}
+
+func (h *Handler) DeleteFunnel(w http.ResponseWriter, r *http.Request) {
+	domain_id, err := strconv.Atoi(chi.URLParam(r, "domain_id"))
+	if err != nil {
+		w.WriteHeader(http.StatusBadRequest)
+		render.JSON(w, r, response.BadRequest("bad domain id"))
+		return
+	}
+
+	funnel_id, err := strconv.Atoi(chi.URLParam(r, "funnel_id"))
+	if err != nil {
+		w.WriteHeader(http.StatusBadRequest)
+		render.JSON(w, r, response.BadRequest("bad funnel id"))
+		return
+	}
+
+	if err := h.deleteFunnel.Execute(r.Context(), uint(domain_id), uint(funnel_id)); err != nil {
+		h.writeError(w, r, err, "failed to delete funnel")
+		return
+	}
+
+	render.JSON(w, r, response.OK())
+}
+
+// GetReport - прохождение воронки за период start_date - end_date, по умолчанию последние 30 дней
+func (h *Handler) GetReport(w http.ResponseWriter, r *http.Request) {
+	domain_id, err := strconv.Atoi(chi.URLParam(r, "domain_id"))
+	if err != nil {
+		w.WriteHeader(http.StatusBadRequest)
+		render.JSON(w, r, response.BadRequest("bad domain id"))
+		return
+	}
+
+	funnel_id, err := strconv.Atoi(chi.URLParam(r, "funnel_id"))
+	if err != nil {
+		w.WriteHeader(http.StatusBadRequest)
+		render.JSON(w, r, response.BadRequest("bad funnel id"))
+		return
+	}
+
+	q := r.URL.Query()
+
+	var start_date, end_date *time.Time
+	if st := q.Get("start_date"); st != "" {
+		start, err := time.Parse(time.RFC3339Nano, st)
+		if err != nil {
+			w.WriteHeader(http.StatusBadRequest)
+			render.JSON(w, r, response.BadRequest("bad start date"))
+			return
+		}
+		start_date = &start
+	}
+
+	if end := q.Get("end_date"); end != "" {
+		e, err := time.Parse(time.RFC3339Nano, end)
+		if err != nil {
+			w.WriteHeader(http.StatusBadRequest)
+			render.JSON(w, r, response.BadRequest("bad end date"))
+			return
+		}
+		end_date = &e
+	}
+
+	if start_date != nil && end_date != nil && end_date.Before(*start_date) {
+		w.WriteHeader(http.StatusBadRequest)
+		render.JSON(w, r, response.BadRequest("end date before start date"))
+		return
+	}
+
+	report, err := h.report.Execute(r.Context(), uint(domain_id), uint(funnel_id), start_date, end_date)
+	if err != nil {
+		h.writeError(w, r, err, "failed to get funnel report")
+		return
+	}
+
+	render.JSON(w, r, FunnelReportResponse{
+		Response:     response.OK(),
+		FunnelReport: report,
+	})
+}
+
+func (h *Handler) decodeFunnel(w http.ResponseWriter, r *http.Request) (FunnelRequest, bool) {
+	var req FunnelRequest
+	if err := render.Decode(r, &req); err != nil {
+		w.WriteHeader(http.StatusBadRequest)
+		render.JSON(w, r, response.BadRequest("unable to decode request"))
+		return req, false
+	}
+
+	if err := response.ValidateRequest(req); err != nil {
+		validateErr := err.(validator.ValidationErrors)
+		w.WriteHeader(http.StatusBadRequest)
+		render.JSON(w, r, response.ValidationError(validateErr))
+		return req, false
+	}
+
+	return req, true
+}
+
+func (h *Handler) writeError(w http.ResponseWriter, r *http.Request, err error, msg string) {
+	switch {
+	case errors.Is(err, domain.ErrInvalidFunnel):
+		w.WriteHeader(http.StatusBadRequest)
+		render.JSON(w, r, response.BadRequest(err.Error()))
+		return
+	case errors.Is(err, domain.ErrFunnelNotFound):
+		w.WriteHeader(http.StatusNotFound)
+		render.JSON(w, r, response.ErrorWithStatus(response.StatusNotFound, "funnel not found"))
+		return
+	case errors.Is(err, domain.ErrDomainNotFound):
+		w.WriteHeader(http.StatusNotFound)
+		render.JSON(w, r, response.ErrorWithStatus(response.StatusNotFound, "domain not found"))
+		return
+	}
+	h.log.Error(msg, sl.Err(err))
+	w.WriteHeader(http.StatusInternalServerError)
+	render.JSON(w, r, response.Error(msg))
+}
@@ -0,0 +1,310 @@
+package goals
+
+import (
+	"errors"
+	"log/slog"
+	domain "metrika/internal/domain/analytics"
+	"metrika/internal/usecase/metrika"
+	response "metrika/pkg/api"
+	"metrika/pkg/logger/sl"
+	"net/http"
+	"strconv"
+	"time"
+
+	"github.com/go-chi/chi/v5"
+	"github.com/go-chi/render"
+	"github.com/go-playground/validator/v10"
+)
+
+type Handler struct {
+	log         *slog.Logger
+	getGoals    *metrika.GetGoalsUseCase
+	createGoal  *metrika.CreateGoalUseCase
+	updateGoal  *metrika.UpdateGoalUseCase
+	deleteGoal  *metrika.DeleteGoalUseCase
+	conversions *metrika.ConversionsReportUseCase
+}
+
+func NewHandler(
+	log *slog.Logger,
+	getGoals *metrika.GetGoalsUseCase,
+	createGoal *metrika.CreateGoalUseCase,
+	updateGoal *metrika.UpdateGoalUseCase,
+	deleteGoal *metrika.DeleteGoalUseCase,
+	conversions *metrika.ConversionsReportUseCase,
+) *Handler {
+	return &Handler{
+		log,
+		getGoals,
+		createGoal,
+		updateGoal,
+		deleteGoal,
+		conversions,
+	}
+}
+
+type GoalsResponse struct {
+	Response response.Response `json:"response"`
+	Goals    []domain.Goal     `json:"goals"`
+}
+
+type GoalResponse struct {
+	Response response.Response `json:"response"`
+	Goal     *domain.Goal      `json:"goal"`
+}
+
+type ConversionsResponse struct {
+	Response response.Response `json:"response"`
+	*domain.ConversionsReport
+}
+
+type GoalRequest struct {
+	Name       string                   `json:"name" validate:"required"`
+	Type       domain.GoalType          `json:"type" validate:"required"`
+	URLPattern string                   `json:"url_pattern"`
+	EventType  string                   `json:"event_type"`
+	Selector   string                   `json:"selector"`
+	FormID     string                   `json:"form_id"`
+	Properties []domain.PropertyMatcher `json:"properties"`
+}
+
+func (req GoalRequest) goal(domain_id uint) domain.Goal {
+	return domain.Goal{
+		DomainID:   domain_id,
+		Name:       req.Name,
+		Type:       req.Type,
+		URLPattern: req.URLPattern,
+		EventType:  req.EventType,
+		Selector:   req.Selector,
+		FormID:     req.FormID,
+		Properties: req.Properties,
+	}
+}
+
+func (h *Handler) GetGoals(w http.ResponseWriter, r *http.Request) {
+	domain_id, err := strconv.Atoi(chi.URLParam(r, "domain_id"))
+	if err != nil {
+		w.WriteHeader(http.StatusBadRequest)
+		render.JSON(w, r, response.BadRequest("bad domain id"))
+		return
+	}
+
+	goals, err := h.getGoals.Execute(r.Context(), uint(domain_id))
+	if err != nil {
+		h.writeError(w, r, err, "failed to get goals")
+		return
+	}
+
+	render.JSON(w, r, GoalsResponse{
+		Response: response.OK(),
+		Goals:    goals,
+	})
+}
+
+func (h *Handler) CreateGoal(w http.ResponseWriter, r *http.Request) {
+	domain_id, err := strconv.Atoi(chi.URLParam(r, "domain_id"))
+	if err != nil {
+		w.WriteHeader(http.StatusBadRequest)
+		render.JSON(w, r, response.BadRequest("bad domain id"))
+		return
+	}
+
+	req, ok := h.decodeGoal(w, r)
+	if !ok {
+		return
+	}
+
+	goal, err := h.createGoal.Execute(r.Context(), req.goal(uint(domain_id)))
+	if err != nil {
+		h.writeError(w, r, err, "failed to create goal")
+		return
+	}
+
+	w.WriteHeader(http.StatusCreated)
+	render.JSON(w, r, GoalResponse{
+		Response: response.OK(),
+		Goal:     goal,
+	})
+}
+
+func (h *Handler) UpdateGoal(w http.ResponseWriter, r *http.Request) {
+	domain_id, err := strconv.Atoi(chi.URLParam(r, "domain_id"))
+	if err != nil {
+		w.WriteHeader(http.StatusBadRequest)
+		render.JSON(w, r, response.BadRequest("bad domain id"))
+		return
+	}
+
+	goal_id, err := strconv.Atoi(chi.URLParam(r, "goal_id"))
+	if err != nil {
+		w.WriteHeader(http.StatusBadRequest)
+		render.JSON(w, r, response.BadRequest("bad goal id"))
+		return
+	}
+
+	req, ok := h.decodeGoal(w, r)
+	if !ok {
+		return
+	}
+
+	update := req.goal(uint(domain_id))
+	update.ID = uint(goal_id)
+
+	goal, err := h.updateGoal.Execute(r.Context(), update)
+	if err != nil {
+		h.writeError(w, r, err, "failed to update goal")
+		return
+	}
+
+	render.JSON(w, r, GoalResponse{
+		Response: response.OK(),
+		Goal:     goal,
+	})
+}
+
+func (h *Handler) DeleteGoal(w http.ResponseWriter, r *http.Request) {
+	domain_id, err := strconv.Atoi(chi.URLParam(r, "domain_id"))
+	if err != nil {
+		w.WriteHeader(http.StatusBadRequest)
+		render.JSON(w, r, response.BadRequest("bad domain id"))
+		return
+	}
+
+	goal_id, err := strconv.Atoi(chi.URLParam(r, "goal_id"))
+	if err != nil {
+		w.WriteHeader(http.StatusBadRequest)
+		render.JSON(w, r, response.BadRequest("bad goal id"))
+		return
+	}
+
+	if err := h.deleteGoal.Execute(r.Context(), uint(domain_id), uint(goal_id)); err != nil {
+		h.writeError(w, r, err, "failed to delete goal")
+		return
+	}
+
+	render.JSON(w, r, response.OK())
+}
+
+// GetConversions - доля сессий с достижением целей, график и атрибуция по источникам.
+// goal_id - отчет по одной цели, без него сессия считается сконвертированной при достижении любой
+func (h *Handler) GetConversions(w http.ResponseWriter, r *http.Request) {
+	domain_id, err := strconv.Atoi(chi.URLParam(r, "domain_id"))
+	if err != nil {
+		w.WriteHeader(http.StatusBadRequest)
+		render.JSON(w, r, response.BadRequest("bad domain id"))
+		return
+	}
+
+	q := r.URL.Query()
+	opts := domain.ConversionsReportOptions{
+		DomainID: uint(domain_id),
+		Interval: q.Get("interval"),
+		GroupBy:  q.Get("group_by"),
+	}
+
+	if g := q.Get("goal_id"); g != "" {
+		goal_id, err := strconv.Atoi(g)
+		if err != nil {
+			w.WriteHeader(http.StatusBadRequest)
+			render.JSON(w, r, response.BadRequest("bad goal id"))
+			return
+		}
+		id := uint(goal_id)
+		opts.GoalID = &id
+	}
+
+	if st := q.Get("start_date"); st != "" {
+		start_date, err := time.Parse(time.RFC3339Nano, st)
+		if err != nil {
+			w.WriteHeader(http.StatusBadRequest)
+			render.JSON(w, r, response.BadRequest("bad start date"))
+			return
+		}
+		opts.StartDate = &start_date
+	}
+
+	if end := q.Get("end_date"); end != "" {
+		end_date, err := time.Parse(time.RFC3339Nano, end)
+		if err != nil {
+			w.WriteHeader(http.StatusBadRequest)
+			render.JSON(w, r, response.BadRequest("bad end date"))
+			return
+		}
+		opts.EndDate = &end_date
+	}
+
+	if opts.StartDate != nil && opts.EndDate != nil && opts.EndDate.Before(*opts.StartDate) {
+		w.WriteHeader(http.StatusBadRequest)
+		render.JSON(w, r, response.BadRequest("end date before start date"))
+		return
+	}
+
+	if lt := q.Get("limit"); lt != "" {
+		limit, err := strconv.Atoi(lt)
+		if err != nil || limit < 0 {
+			w.WriteHeader(http.StatusBadRequest)
+			render.JSON(w, r, response.BadRequest("bad limit"))
+			return
+		}
+		opts.Limit = &limit
+	}
+
+	report, err := h.conversions.Execute(r.Context(), opts)
+	if err != nil {
+		if errors.Is(err, domain.ErrConversionsIntervalInvalid) {
+			w.WriteHeader(http.StatusBadRequest)
+			render.JSON(w, r, response.BadRequest("bad interval"))
+			return
+		}
+		if errors.Is(err, domain.ErrSourcesGroupNotAllowed) {
+			w.WriteHeader(http.StatusBadRequest)
+			render.JSON(w, r, response.BadRequest("bad group_by"))
+			return
+		}
+		h.writeError(w, r, err, "failed to get conversions")
+		return
+	}
+
+	render.JSON(w, r, ConversionsResponse{
+		Response:          response.OK(),
+		ConversionsReport: report,
+	})
+}
+
+func (h *Handler) decodeGoal(w http.ResponseWriter, r *http.Request) (GoalRequest, bool) {
+	var req GoalRequest
+	if err := render.Decode(r, &req); err != nil {
+		w.WriteHeader(http.StatusBadRequest)
+		render.JSON(w, r, response.BadRequest("unable to decode request"))
+		return req, false
+	}
+
+	if err := response.ValidateRequest(req); err != nil {
+		validateErr := err.(validator.ValidationErrors)
+		w.WriteHeader(http.StatusBadRequest)
+		render.JSON(w, r, response.ValidationError(validateErr))
+		return req, false
+	}
+
+	return req, true
+}
+
+func (h *Handler) writeError(w http.ResponseWriter, r *http.Request, err error, msg string) {
+	switch {
+	case errors.Is(err, domain.ErrInvalidGoal):
+		w.WriteHeader(http.StatusBadRequest)
+		render.JSON(w, r, response.BadRequest(err.Error()))
+		return
+	case errors.Is(err, domain.ErrGoalNotFound):
+		w.WriteHeader(http.StatusNotFound)
+		render.JSON(w, r, response.ErrorWithStatus(response.StatusNotFound, "goal not found"))
+		return
+	case errors.Is(err, domain.ErrDomainNotFound):
+		w.WriteHeader(http.StatusNotFound)
+		render.JSON(w, r, response.ErrorWithStatus(response.StatusNotFound, "domain not found"))
+		return
+	}
+	h.log.Error(msg, sl.Err(err))
+	w.WriteHeader(http.StatusInternalServerError)
+	render.JSON(w, r, response.Error(msg))
+}
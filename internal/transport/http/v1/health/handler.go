@@ -0,0 +1,91 @@
+package health
+
+import (
+	"context"
+	"log/slog"
+	domain "metrika/internal/domain/analytics"
+	"metrika/internal/usecase/metrika"
+	response "metrika/pkg/api"
+	"metrika/pkg/logger/sl"
+	"net/http"
+	"sync/atomic"
+	"time"
+
+	"github.com/go-chi/render"
+)
+
+type Pinger interface {
+	PingContext(ctx context.Context) error
+}
+
+// Handler - liveness и readiness пробы процесса и его служебные счетчики
+type Handler struct {
+	log            *slog.Logger
+	db             Pinger
+	ingestionStats *metrika.IngestionStatsUseCase
+	ready          atomic.Bool
+}
+
+func NewHandler(log *slog.Logger, db Pinger, ingestionStats *metrika.IngestionStatsUseCase) *Handler {
+	return &Handler{log: log, db: db, ingestionStats: ingestionStats}
+}
+
+// SetReady - готов ли процесс принимать трафик. при остановке сбрасывается первым делом
+func (h *Handler) SetReady(ready bool) {
+	h.ready.Store(ready)
+}
+
+type HealthResponse struct {
+	Response response.Response `json:"response"`
+	Ready    bool              `json:"ready"`
+}
+
+// Healthz - процесс жив и обслуживает запросы
+func (h *Handler) Healthz(w http.ResponseWriter, r *http.Request) {
+	render.JSON(w, r, HealthResponse{
+		Response: response.OK(),
+		Ready:    h.ready.Load(),
+	})
+}
+
+// Readyz - процесс готов принимать трафик: не в процессе остановки и база доступна
+func (h *Handler) Readyz(w http.ResponseWriter, r *http.Request) {
+	if !h.ready.Load() {
+		w.WriteHeader(http.StatusServiceUnavailable)
+		render.JSON(w, r, HealthResponse{
+			Response: response.Error("shutting down"),
+		})
+		return
+	}
+
+	ctx, cancel := context.WithTimeout(r.Context(), time.Second*2)
+	defer cancel()
+
+	if err := h.db.PingContext(ctx); err != nil {
+		h.log.Error("база недоступна", sl.Err(err))
+		w.WriteHeader(http.StatusServiceUnavailable)
+		render.JSON(w, r, HealthResponse{
+			Response: response.Error("database unavailable"),
+		})
+		return
+	}
+
+	render.JSON(w, r, HealthResponse{
+		Response: response.OK(),
+		Ready:    true,
+	})
+}
+
+type IngestionStatsResponse struct {
+	Response response.Response     `json:"response"`
+	Stats    domain.IngestionStats `json:"stats"`
+}
+
+// IngestionStats - счетчики приема ивентов всего процесса, по всем доменам сразу.
+// поэтому отдаются только на служебном адресе, а не в api
+func (h *Handler) IngestionStats(w http.ResponseWriter, r *http.Request) {
+	render.JSON(w, r, IngestionStatsResponse{
+		Response: response.OK(),
+		Stats:    h.ingestionStats.Execute(),
+	})
+}
@@ -0,0 +1,259 @@
+package live
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"log/slog"
+	domain "metrika/internal/domain/analytics"
+	"metrika/internal/infrastructure/jwt"
+	"metrika/internal/transport/http/v1/middleware"
+	"metrika/internal/usecase/analytics"
+	response "metrika/pkg/api"
+	"metrika/pkg/logger/sl"
+	"net/http"
+	"net/url"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/go-chi/chi/v5"
+	"github.com/go-chi/render"
+	"golang.org/x/net/websocket"
+)
+
+var errOriginNotAllowed = errors.New("origin not allowed")
+
+type Handler struct {
+	log       *slog.Logger
+	feed      *analytics.LiveFeed
+	heartbeat time.Duration
+	jwt       *jwt.JWTProvider
+	appUrl    string
+}
+
+func NewHandler(log *slog.Logger, feed *analytics.LiveFeed, heartbeat time.Duration, jwt *jwt.JWTProvider, appUrl string) *Handler {
+	return &Handler{log, feed, heartbeat, jwt, appUrl}
+}
+
+type TicketResponse struct {
+	Response  response.Response `json:"response"`
+	Ticket    string            `json:"ticket"`
+	ExpiresIn int               `json:"expires_in"`
+}
+
+// Ticket - выдает тикет для открытия живой ленты домена из браузера.
+// тикет передается в query ?ticket= вместо access токена и годится только для этого стрима
+func (h *Handler) Ticket(w http.ResponseWriter, r *http.Request) {
+	claims, ok := middleware.ClaimsFromContext(r.Context())
+	if !ok {
+		w.WriteHeader(http.StatusUnauthorized)
+		render.JSON(w, r, response.Error("unauthorized"))
+		return
+	}
+
+	ticket, err := h.jwt.GenerateStreamTicket(claims, strings.TrimSuffix(r.URL.Path, "/ticket"))
+	if err != nil {
+		h.log.Error("ошибка выдачи тикета живой ленты", sl.Err(err))
+		w.WriteHeader(http.StatusInternalServerError)
+		render.JSON(w, r, response.Error("internal error"))
+		return
+	}
+
+	render.JSON(w, r, TicketResponse{
+		Response:  response.OK(),
+		Ticket:    ticket,
+		ExpiresIn: int(jwt.StreamTicketTTL.Seconds()),
+	})
+}
+
+// sender - транспорт, в который пишется лента: SSE или WebSocket
+type sender interface {
+	Send(msg domain.LiveMessage) error
+	Heartbeat() error
+}
+
+// Stream - живая лента домена. по умолчанию Server-Sent Events,
+// при заголовке Upgrade: websocket - WebSocket с теми же сообщениями в JSON
+func (h *Handler) Stream(w http.ResponseWriter, r *http.Request) {
+	domain_id, err := strconv.Atoi(chi.URLParam(r, "domain_id"))
+	if err != nil {
+		w.WriteHeader(http.StatusBadRequest)
+		render.JSON(w, r, response.BadRequest("bad domain id"))
+		return
+	}
+
+	snapshot, err := h.feed.Snapshot(r.Context(), uint(domain_id))
+	if err != nil {
+		h.log.Error("ошибка получения онлайна для живой ленты", sl.Err(err))
+		w.WriteHeader(http.StatusInternalServerError)
+		render.JSON(w, r, response.Error("internal error"))
+		return
+	}
+
+	//соединение живет дольше общего WriteTimeout сервера
+	if err := http.NewResponseController(w).SetWriteDeadline(time.Time{}); err != nil {
+		h.log.Warn("не удалось снять дедлайн записи для живой ленты", sl.Err(err))
+	}
+
+	if strings.EqualFold(r.Header.Get("Upgrade"), "websocket") {
+		h.serveWebSocket(w, r, uint(domain_id), snapshot)
+		return
+	}
+
+	h.serveSSE(w, r, uint(domain_id), snapshot)
+}
+
+func (h *Handler) serveSSE(w http.ResponseWriter, r *http.Request, domain_id uint, snapshot domain.LiveMessage) {
+	flusher, ok := w.(http.Flusher)
+	if !ok {
+		w.WriteHeader(http.StatusInternalServerError)
+		render.JSON(w, r, response.Error("streaming unsupported"))
+		return
+	}
+
+	w.Header().Set("Content-Type", "text/event-stream")
+	w.Header().Set("Cache-Control", "no-cache")
+	w.Header().Set("Connection", "keep-alive")
+	//nginx не должен буферизовать ответ
+	w.Header().Set("X-Accel-Buffering", "no")
+	w.WriteHeader(http.StatusOK)
+
+	h.pump(r.Context(), domain_id, snapshot, &sseSender{w: w, flusher: flusher})
+}
+
+func (h *Handler) serveWebSocket(w http.ResponseWriter, r *http.Request, domain_id uint, snapshot domain.LiveMessage) {
+	server := websocket.Server{
+		//браузер открывает websocket с любой страницы, поэтому пускаем только с дашборда или того же хоста
+		Handshake: func(_ *websocket.Config, r *http.Request) error {
+			if !h.originAllowed(r) {
+				h.log.Warn("websocket живой ленты с чужого origin", slog.String("origin", r.Header.Get("Origin")))
+				return errOriginNotAllowed
+			}
+			return nil
+		},
+		Handler: func(ws *websocket.Conn) {
+			defer ws.Close()
+
+			ctx, cancel := context.WithCancel(r.Context())
+			defer cancel()
+
+			//входящие сообщения не нужны, читаем только чтобы заметить закрытие соединения клиентом
+			go func() {
+				defer cancel()
+				var discard []byte
+				for {
+					if err := websocket.Message.Receive(ws, &discard); err != nil {
+						return
+					}
+				}
+			}()
+
+			h.pump(ctx, domain_id, snapshot, &wsSender{ws: ws})
+		},
+	}
+
+	server.ServeHTTP(w, r)
+}
+
+// originAllowed - Origin совпадает с адресом фронтенда или с хостом самого api
+func (h *Handler) originAllowed(r *http.Request) bool {
+	origin, err := url.Parse(r.Header.Get("Origin"))
+	if err != nil || origin.Host == "" {
+		return false
+	}
+
+	if app, err := url.Parse(h.appUrl); err == nil && strings.EqualFold(origin.Scheme, app.Scheme) && strings.EqualFold(origin.Host, app.Host) {
+		return true
+	}
+
+	return strings.EqualFold(origin.Host, r.Host)
+}
+
+// pump - пересылает сообщения подписки клиенту, пока он не отключится или сервер не начнет останавливаться
+func (h *Handler) pump(ctx context.Context, domain_id uint, snapshot domain.LiveMessage, out sender) {
+	sub := h.feed.Subscribe(domain_id)
+	defer sub.Close()
+
+	if err := out.Send(snapshot); err != nil {
+		return
+	}
+
+	heartbeat := time.NewTicker(h.heartbeat)
+	defer heartbeat.Stop()
+
+	var reported uint64
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case <-h.feed.Done():
+			return
+		case <-heartbeat.C:
+			if err := out.Heartbeat(); err != nil {
+				return
+			}
+		case msg, ok := <-sub.Messages():
+			if !ok {
+				return
+			}
+
+			//сообщаем клиенту, сколько сообщений он пропустил из-за переполнения буфера
+			if dropped := sub.Dropped(); dropped != reported {
+				reported = dropped
+				if err := out.Send(domain.LiveMessage{
+					Type:     domain.LiveDropped,
+					DomainID: domain_id,
+					At:       time.Now(),
+					Data:     domain.LiveDroppedData{Dropped: dropped},
+				}); err != nil {
+					return
+				}
+			}
+
+			if err := out.Send(msg); err != nil {
+				h.log.Debug("живая лента закрыта клиентом", sl.Err(err))
+				return
+			}
+		}
+	}
+}
+
+type sseSender struct {
+	w       http.ResponseWriter
+	flusher http.Flusher
+}
+
+func (s *sseSender) Send(msg domain.LiveMessage) error {
+	data, err := json.Marshal(msg)
+	if err != nil {
+		return err
+	}
+	if _, err := fmt.Fprintf(s.w, "event: %s\ndata: %s\n\n", msg.Type, data); err != nil {
+		return err
+	}
+	s.flusher.Flush()
+	return nil
+}
+
+// Heartbeat - SSE-комментарий, EventSource его игнорирует
+func (s *sseSender) Heartbeat() error {
+	if _, err := fmt.Fprint(s.w, ": ping\n\n"); err != nil {
+		return err
+	}
+	s.flusher.Flush()
+	return nil
+}
+
+type wsSender struct {
+	ws *websocket.Conn
+}
+
+func (s *wsSender) Send(msg domain.LiveMessage) error {
+	return websocket.JSON.Send(s.ws, msg)
+}
+
+func (s *wsSender) Heartbeat() error {
+	return websocket.JSON.Send(s.ws, domain.LiveMessage{Type: "ping", At: time.Now()})
+}
@@ -0,0 +1,276 @@
+package members
+
+import (
+	"errors"
+	"log/slog"
+	domain "metrika/internal/domain/analytics"
+	"metrika/internal/transport/http/v1/middleware"
+	"metrika/internal/usecase/metrika"
+	response "metrika/pkg/api"
+	"metrika/pkg/logger/sl"
+	"net/http"
+	"strconv"
+
+	"github.com/go-chi/chi/v5"
+	"github.com/go-chi/render"
+	"github.com/go-playground/validator/v10"
+)
+
+type Handler struct {
+	log              *slog.Logger
+	appUrl           string
+	getMembers       *metrika.GetMembersUseCase
+	inviteMember     *metrika.InviteMemberUseCase
+	acceptInvitation *metrika.AcceptInvitationUseCase
+	updateRole       *metrika.UpdateMemberRoleUseCase
+	removeMember     *metrika.RemoveMemberUseCase
+	revokeInvitation *metrika.RevokeInvitationUseCase
+}
+
+func NewHandler(
+	log *slog.Logger,
+	appUrl string,
+	getMembers *metrika.GetMembersUseCase,
+	inviteMember *metrika.InviteMemberUseCase,
+	acceptInvitation *metrika.AcceptInvitationUseCase,
+	updateRole *metrika.UpdateMemberRoleUseCase,
+	removeMember *metrika.RemoveMemberUseCase,
+	revokeInvitation *metrika.RevokeInvitationUseCase,
+) *Handler {
+	return &Handler{
+		log,
+		appUrl,
+		getMembers,
+		inviteMember,
+		acceptInvitation,
+		updateRole,
+		removeMember,
+		revokeInvitation,
+	}
+}
+
+type GetMembersResponse struct {
+	Response    response.Response         `json:"response"`
+	Members     []domain.DomainMember     `json:"members"`
+	Invitations []domain.DomainInvitation `json:"invitations"`
+}
+
+func (h *Handler) GetMembers(w http.ResponseWriter, r *http.Request) {
+	domain_id, err := strconv.Atoi(chi.URLParam(r, "domain_id"))
+	if err != nil {
+		w.WriteHeader(http.StatusBadRequest)
+		render.JSON(w, r, response.BadRequest("bad domain id"))
+		return
+	}
+
+	members, invitations, err := h.getMembers.Execute(r.Context(), uint(domain_id))
+	if err != nil {
+		h.log.Error("ошибка получения команды домена", sl.Err(err))
+		w.WriteHeader(http.StatusInternalServerError)
+		render.JSON(w, r, response.Error("failed to get members"))
+		return
+	}
+
+	render.JSON(w, r, GetMembersResponse{
+		Response:    response.OK(),
+		Members:     members,
+		Invitations: invitations,
+	})
+}
+
+type InviteMemberRequest struct {
+	Email string `json:"email" validate:"required,email"`
+	Role  string `json:"role" validate:"required"`
+}
+
+type InviteMemberResponse struct {
+	Response   response.Response        `json:"response"`
+	Invitation *domain.DomainInvitation `json:"invitation"`
+	// ссылка для письма с приглашением
+	AcceptURL string `json:"accept_url"`
+}
+
+func (h *Handler) InviteMember(w http.ResponseWriter, r *http.Request) {
+	domain_id, err := strconv.Atoi(chi.URLParam(r, "domain_id"))
+	if err != nil {
+		w.WriteHeader(http.StatusBadRequest)
+		render.JSON(w, r, response.BadRequest("bad domain id"))
+		return
+	}
+
+	claims, ok := middleware.ClaimsFromContext(r.Context())
+	if !ok {
+		w.WriteHeader(http.StatusUnauthorized)
+		render.JSON(w, r, response.Error("unauthorized"))
+		return
+	}
+
+	var req InviteMemberRequest
+	if err := render.Decode(r, &req); err != nil {
+		w.WriteHeader(http.StatusBadRequest)
+		render.JSON(w, r, response.BadRequest("unable to decode request"))
+		return
+	}
+
+	if err := response.ValidateRequest(req); err != nil {
+		validateErr := err.(validator.ValidationErrors)
+		w.WriteHeader(http.StatusBadRequest)
+		render.JSON(w, r, response.ValidationError(validateErr))
+		return
+	}
+
+	invitation, err := h.inviteMember.Execute(r.Context(), uint(domain_id), claims.UserID, req.Email, domain.Role(req.Role))
+	if err != nil {
+		h.writeError(w, r, err, "failed to invite member")
+		return
+	}
+
+	w.WriteHeader(http.StatusCreated)
+	render.JSON(w, r, InviteMemberResponse{
+		Response:   response.OK(),
+		Invitation: invitation,
+		AcceptURL:  h.appUrl + "/invitations/" + invitation.Token,
+	})
+}
+
+type AcceptInvitationResponse struct {
+	Response response.Response    `json:"response"`
+	Member   *domain.DomainMember `json:"member"`
+}
+
+func (h *Handler) AcceptInvitation(w http.ResponseWriter, r *http.Request) {
+	claims, ok := middleware.ClaimsFromContext(r.Context())
+	if !ok {
+		w.WriteHeader(http.StatusUnauthorized)
+		render.JSON(w, r, response.Error("unauthorized"))
+		return
+	}
+
+	member, err := h.acceptInvitation.Execute(r.Context(), chi.URLParam(r, "token"), claims.UserID, claims.Email)
+	if err != nil {
+		h.writeError(w, r, err, "failed to accept invitation")
+		return
+	}
+
+	render.JSON(w, r, AcceptInvitationResponse{
+		Response: response.OK(),
+		Member:   member,
+	})
+}
+
+type UpdateMemberRoleRequest struct {
+	Role string `json:"role" validate:"required"`
+}
+
+func (h *Handler) UpdateMemberRole(w http.ResponseWriter, r *http.Request) {
+	domain_id, err := strconv.Atoi(chi.URLParam(r, "domain_id"))
+	if err != nil {
+		w.WriteHeader(http.StatusBadRequest)
+		render.JSON(w, r, response.BadRequest("bad domain id"))
+		return
+	}
+
+	user_id, err := strconv.Atoi(chi.URLParam(r, "user_id"))
+	if err != nil {
+		w.WriteHeader(http.StatusBadRequest)
+		render.JSON(w, r, response.BadRequest("bad user id"))
+		return
+	}
+
+	var req UpdateMemberRoleRequest
+	if err := render.Decode(r, &req); err != nil {
+		w.WriteHeader(http.StatusBadRequest)
+		render.JSON(w, r, response.BadRequest("unable to decode request"))
+		return
+	}
+
+	if err := response.ValidateRequest(req); err != nil {
+		validateErr := err.(validator.ValidationErrors)
+		w.WriteHeader(http.StatusBadRequest)
+		render.JSON(w, r, response.ValidationError(validateErr))
+		return
+	}
+
+	if err := h.updateRole.Execute(r.Context(), uint(domain_id), uint(user_id), domain.Role(req.Role)); err != nil {
+		h.writeError(w, r, err, "failed to update member role")
+		return
+	}
+
+	render.JSON(w, r, response.OK())
+}
+
+func (h *Handler) RemoveMember(w http.ResponseWriter, r *http.Request) {
+	domain_id, err := strconv.Atoi(chi.URLParam(r, "domain_id"))
+	if err != nil {
+		w.WriteHeader(http.StatusBadRequest)
+		render.JSON(w, r, response.BadRequest("bad domain id"))
+		return
+	}
+
+	user_id, err := strconv.Atoi(chi.URLParam(r, "user_id"))
+	if err != nil {
+		w.WriteHeader(http.StatusBadRequest)
+		render.JSON(w, r, response.BadRequest("bad user id"))
+		return
+	}
+
+	if err := h.removeMember.Execute(r.Context(), uint(domain_id), uint(user_id)); err != nil {
+		h.writeError(w, r, err, "failed to remove member")
+		return
+	}
+
+	render.JSON(w, r, response.OK())
+}
+
+func (h *Handler) RevokeInvitation(w http.ResponseWriter, r *http.Request) {
+	domain_id, err := strconv.Atoi(chi.URLParam(r, "domain_id"))
+	if err != nil {
+		w.WriteHeader(http.StatusBadRequest)
+		render.JSON(w, r, response.BadRequest("bad domain id"))
+		return
+	}
+
+	invitation_id, err := strconv.Atoi(chi.URLParam(r, "invitation_id"))
+	if err != nil {
+		w.WriteHeader(http.StatusBadRequest)
+		render.JSON(w, r, response.BadRequest("bad invitation id"))
+		return
+	}
+
+	if err := h.revokeInvitation.Execute(r.Context(), uint(domain_id), uint(invitation_id)); err != nil {
+		h.writeError(w, r, err, "failed to revoke invitation")
+		return
+	}
+
+	render.JSON(w, r, response.OK())
+}
+
+func (h *Handler) writeError(w http.ResponseWriter, r *http.Request, err error, msg string) {
+	switch {
+	case errors.Is(err, domain.ErrMemberNotFound):
+		w.WriteHeader(http.StatusNotFound)
+		render.JSON(w, r, response.ErrorWithStatus(response.StatusNotFound, "member not found"))
+	case errors.Is(err, domain.ErrInvitationNotFound):
+		w.WriteHeader(http.StatusNotFound)
+		render.JSON(w, r, response.ErrorWithStatus(response.StatusNotFound, "invitation not found"))
+	case errors.Is(err, domain.ErrMemberAlreadyExists):
+		w.WriteHeader(http.StatusConflict)
+		render.JSON(w, r, response.ErrorWithStatus(response.StatusAlreadyExists, "already a member"))
+	case errors.Is(err, domain.ErrInvalidRole):
+		w.WriteHeader(http.StatusBadRequest)
+		render.JSON(w, r, response.BadRequest("invalid role"))
+	case errors.Is(err, domain.ErrOwnerRoleImmutable):
+		w.WriteHeader(http.StatusForbidden)
+		render.JSON(w, r, response.Error("owner can not be changed"))
+	case errors.Is(err, domain.ErrInvitationExpired):
+		w.WriteHeader(http.StatusGone)
+		render.JSON(w, r, response.Error("invitation expired"))
+	case errors.Is(err, domain.ErrInvitationEmailMismatch):
+		w.WriteHeader(http.StatusForbidden)
+		render.JSON(w, r, response.Error("invitation was sent to another email"))
+	default:
+		h.log.Error(msg, sl.Err(err))
+		w.WriteHeader(http.StatusInternalServerError)
+		render.JSON(w, r, response.Error(msg))
+	}
+}
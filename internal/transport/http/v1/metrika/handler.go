@@ -4,6 +4,7 @@ import (
 	"errors"
 	"log/slog"
 	domain "metrika/internal/domain/analytics"
+	"metrika/internal/transport/http/v1/middleware"
 	"metrika/internal/usecase/analytics"
 	"metrika/internal/usecase/metrika"
 	response "metrika/pkg/api"
@@ -11,6 +12,7 @@ import (
 	"metrika/pkg/pointers"
 	"net/http"
 	"strconv"
+	"strings"
 	"time"
 
 	"github.com/go-chi/chi/v5"
@@ -21,26 +23,41 @@ type Handler struct {
 	log                    *slog.Logger
 	getSessions            *metrika.SessionsByRangeDateUseCase
 	getCountActiveSessions *metrika.ActiveSessionsUseCase
-	getSessionsByInterval  *metrika.SessionsByIntervalUseCase
+	timeseries             *metrika.TimeseriesUseCase
 	getGuests              *analytics.GetGuestsUseCase
 	getGuest               *analytics.GetGuestUseCase
+	roles                  *metrika.GetDomainRoleUseCase
+	pagesReport            *metrika.PagesReportUseCase
+	sourcesReport          *metrika.SourcesReportUseCase
+	heatmap                *metrika.HeatmapUseCase
+	cohorts                *metrika.CohortsReportUseCase
 }
 
 func NewHandler(
 	log *slog.Logger,
 	getSessions *metrika.SessionsByRangeDateUseCase,
 	getCountActiveSessions *metrika.ActiveSessionsUseCase,
-	getSessionsByInterval *metrika.SessionsByIntervalUseCase,
+	timeseries *metrika.TimeseriesUseCase,
 	getGuests *analytics.GetGuestsUseCase,
 	getGuest *analytics.GetGuestUseCase,
+	roles *metrika.GetDomainRoleUseCase,
+	pagesReport *metrika.PagesReportUseCase,
+	sourcesReport *metrika.SourcesReportUseCase,
+	heatmap *metrika.HeatmapUseCase,
+	cohorts *metrika.CohortsReportUseCase,
 ) *Handler {
 	return &Handler{
 		log,
 		getSessions,
 		getCountActiveSessions,
-		getSessionsByInterval,
+		timeseries,
 		getGuests,
 		getGuest,
+		roles,
+		pagesReport,
+		sourcesReport,
+		heatmap,
+		cohorts,
 	}
 }
 
@@ -137,10 +154,12 @@ func (h *Handler) GetGuestSessionByRangeDate(w http.ResponseWriter, r *http.Requ
 }
 
 type GetCountActiveSessionsResponse struct {
-	Online   int64             `json:"online"`
+	*domain.OnlineNow
 	Response response.Response `json:"response"`
 }
 
+// GetCountActiveSessions - онлайн домена с разбивкой и спарклайном за последние 30 минут.
+// limit - сколько значений каждого разреза отдавать
 func (h *Handler) GetCountActiveSessions(w http.ResponseWriter, r *http.Request) {
 
 	domain_id, err := strconv.Atoi(chi.URLParam(r, "domain_id"))
@@ -150,7 +169,18 @@ func (h *Handler) GetCountActiveSessions(w http.ResponseWriter, r *http.Request)
 		return
 	}
 
-	count, err := h.getCountActiveSessions.Execute(r.Context(), uint(domain_id))
+	var limit *int
+	if lt := r.URL.Query().Get("limit"); lt != "" {
+		l, err := strconv.Atoi(lt)
+		if err != nil || l <= 0 {
+			w.WriteHeader(http.StatusBadRequest)
+			render.JSON(w, r, response.BadRequest("bad limit"))
+			return
+		}
+		limit = &l
+	}
+
+	online, err := h.getCountActiveSessions.Execute(r.Context(), uint(domain_id), limit)
 	if err != nil {
 		h.log.Error("ошибка при получении активных сессий домена", sl.Err(err))
 		w.WriteHeader(http.StatusInternalServerError)
@@ -159,18 +189,19 @@ func (h *Handler) GetCountActiveSessions(w http.ResponseWriter, r *http.Request)
 	}
 
 	render.JSON(w, r, GetCountActiveSessionsResponse{
-		Online:   count,
-		Response: response.OK(),
+		OnlineNow: online,
+		Response:  response.OK(),
 	})
 }
 
-type GuestSessionsByIntervalResponse struct {
-	Response response.Response                   `json:"response"`
-	Sessions *[]domain.GuestSessionsByTimeBucket `json:"sessions"`
+type GetTimeseriesResponse struct {
+	Response response.Response `json:"response"`
+	*domain.TimeseriesReport
 }
 
-func (h *Handler) GetGuestSessionsByInterval(w http.ResponseWriter, r *http.Request) {
-
+// GetTimeseries - ряды метрик (metrics через запятую) с шагом granularity в часовом поясе домена.
+// compare=true добавляет предыдущий период такой же длины
+func (h *Handler) GetTimeseries(w http.ResponseWriter, r *http.Request) {
 	domain_id, err := strconv.Atoi(chi.URLParam(r, "domain_id"))
 	if err != nil {
 		w.WriteHeader(http.StatusBadRequest)
@@ -178,55 +209,57 @@ func (h *Handler) GetGuestSessionsByInterval(w http.ResponseWriter, r *http.Requ
 		return
 	}
 
-	var opts domain.GetVisitsByIntervalOptions
-
-	start_date, err := time.Parse(time.RFC3339Nano, r.URL.Query().Get("start"))
-	if err != nil {
-		w.WriteHeader(http.StatusBadRequest)
-		render.JSON(w, r, response.BadRequest("bad start date"))
-		return
+	q := r.URL.Query()
+	opts := domain.TimeseriesOptions{
+		DomainID:    uint(domain_id),
+		Granularity: q.Get("granularity"),
 	}
-	opts.Start = start_date
 
-	end_date, err := time.Parse(time.RFC3339Nano, r.URL.Query().Get("end"))
-	if err != nil {
-		w.WriteHeader(http.StatusBadRequest)
-		render.JSON(w, r, response.BadRequest("bad end date"))
-		return
+	if m := q.Get("metrics"); m != "" {
+		for _, metric := range strings.Split(m, ",") {
+			opts.Metrics = append(opts.Metrics, strings.TrimSpace(metric))
+		}
 	}
-	opts.End = end_date
 
-	interval, err := strconv.Atoi(r.URL.Query().Get("interval"))
-	if err != nil {
-		w.WriteHeader(http.StatusBadRequest)
-		render.JSON(w, r, response.BadRequest("bad interval"))
-		return
+	if c := q.Get("compare"); c != "" {
+		compare, err := strconv.ParseBool(c)
+		if err != nil {
+			w.WriteHeader(http.StatusBadRequest)
+			render.JSON(w, r, response.BadRequest("bad compare"))
+			return
+		}
+		opts.Compare = compare
 	}
 
-	opts.IntervalMinutes = interval
-
-	diviser, err := strconv.Atoi(r.URL.Query().Get("diviser"))
-	if err != nil {
+	params, msg := parseListParams(r)
+	if msg != "" {
 		w.WriteHeader(http.StatusBadRequest)
-		render.JSON(w, r, response.BadRequest("bad diviser"))
+		render.JSON(w, r, response.BadRequest(msg))
 		return
 	}
 
-	opts.IntervalDiviser = diviser
-
-	sessions, err := h.getSessionsByInterval.Execute(r.Context(), uint(domain_id), opts)
+	report, err := h.timeseries.Execute(r.Context(), opts, params.StartDate, params.EndDate)
 	if err != nil {
-		h.log.Error("ошибка при получении сессий по интервалам за период", sl.Err(err))
+		if errors.Is(err, domain.ErrInvalidTimeseriesQuery) {
+			w.WriteHeader(http.StatusBadRequest)
+			render.JSON(w, r, response.BadRequest(err.Error()))
+			return
+		}
+		if errors.Is(err, domain.ErrDomainNotFound) {
+			w.WriteHeader(http.StatusNotFound)
+			render.JSON(w, r, response.ErrorWithStatus(response.StatusNotFound, "domain not found"))
+			return
+		}
+		h.log.Error("ошибка при построении временного ряда", sl.Err(err))
 		w.WriteHeader(http.StatusInternalServerError)
-		render.JSON(w, r, response.Error("failed to get o"))
+		render.JSON(w, r, response.Error("failed to get timeseries"))
 		return
 	}
 
-	render.JSON(w, r, GuestSessionsByIntervalResponse{
-		Response: response.OK(),
-		Sessions: sessions,
+	render.JSON(w, r, GetTimeseriesResponse{
+		Response:         response.OK(),
+		TimeseriesReport: report,
 	})
-
 }
 
 type GetGuestsResponse struct {
@@ -342,8 +375,337 @@ func (h *Handler) GetGuest(w http.ResponseWriter, r *http.Request) {
 		return
 	}
 
+	//гость доступен только команде его домена
+	if claims, ok := middleware.ClaimsFromContext(r.Context()); !ok || !h.hasRole(r, guest.DomainID, claims.UserID, domain.RoleViewer) {
+		w.WriteHeader(http.StatusForbidden)
+		render.JSON(w, r, response.Error("access denied"))
+		return
+	}
+
 	render.JSON(w,r, GetGuestResponse{
 		Response: response.OK(),
 		Guest: *guest,
 	})
 }
+
+type GetPagesResponse struct {
+	Response response.Response  `json:"response"`
+	Pages    []domain.PageStats `json:"pages"`
+	Total    int64              `json:"total"`
+}
+
+// GetPages - отчет по страницам: просмотры, входы, выходы и отказы по нормализованному пути
+func (h *Handler) GetPages(w http.ResponseWriter, r *http.Request) {
+	domain_id, err := strconv.Atoi(chi.URLParam(r, "domain_id"))
+	if err != nil {
+		w.WriteHeader(http.StatusBadRequest)
+		render.JSON(w, r, response.BadRequest("bad domain id"))
+		return
+	}
+
+	var opts domain.FindPagesOptions
+	opts.DomainID = uint(domain_id)
+
+	params, msg := parseListParams(r)
+	if msg != "" {
+		w.WriteHeader(http.StatusBadRequest)
+		render.JSON(w, r, response.BadRequest(msg))
+		return
+	}
+	opts.StartDate, opts.EndDate = params.StartDate, params.EndDate
+	opts.Limit, opts.Offset = params.Limit, params.Offset
+	opts.Order, opts.OrderType = params.Order, params.OrderType
+
+	pages, total, err := h.pagesReport.Execute(r.Context(), opts)
+	if err != nil {
+		if errors.Is(err, domain.ErrFindPagesOrderNotAllowed) {
+			w.WriteHeader(http.StatusBadRequest)
+			render.JSON(w, r, response.BadRequest("bad order"))
+			return
+		}
+		h.log.Error("ошибка при получении отчета по страницам", sl.Err(err))
+		w.WriteHeader(http.StatusInternalServerError)
+		render.JSON(w, r, response.Error("failed to get pages"))
+		return
+	}
+
+	render.JSON(w, r, GetPagesResponse{
+		Response: response.OK(),
+		Pages:    pages,
+		Total:    total,
+	})
+}
+
+type GetSourcesResponse struct {
+	Response response.Response    `json:"response"`
+	Sources  []domain.SourceStats `json:"sources"`
+	Total    int64                `json:"total"`
+}
+
+// GetSources - визиты, уники и отказы по источнику трафика или utm-кампании.
+// group_by: source(по умолчанию), type, referrer, medium, campaign
+func (h *Handler) GetSources(w http.ResponseWriter, r *http.Request) {
+	domain_id, err := strconv.Atoi(chi.URLParam(r, "domain_id"))
+	if err != nil {
+		w.WriteHeader(http.StatusBadRequest)
+		render.JSON(w, r, response.BadRequest("bad domain id"))
+		return
+	}
+
+	var opts domain.FindSourcesOptions
+	opts.DomainID = uint(domain_id)
+	opts.GroupBy = r.URL.Query().Get("group_by")
+
+	params, msg := parseListParams(r)
+	if msg != "" {
+		w.WriteHeader(http.StatusBadRequest)
+		render.JSON(w, r, response.BadRequest(msg))
+		return
+	}
+	opts.StartDate, opts.EndDate = params.StartDate, params.EndDate
+	opts.Limit, opts.Offset = params.Limit, params.Offset
+	opts.Order, opts.OrderType = params.Order, params.OrderType
+
+	sources, total, err := h.sourcesReport.Execute(r.Context(), opts)
+	if err != nil {
+		if errors.Is(err, domain.ErrFindSourcesOrderNotAllowed) {
+			w.WriteHeader(http.StatusBadRequest)
+			render.JSON(w, r, response.BadRequest("bad order"))
+			return
+		}
+		if errors.Is(err, domain.ErrSourcesGroupNotAllowed) {
+			w.WriteHeader(http.StatusBadRequest)
+			render.JSON(w, r, response.BadRequest("bad group_by"))
+			return
+		}
+		h.log.Error("ошибка при получении отчета по источникам", sl.Err(err))
+		w.WriteHeader(http.StatusInternalServerError)
+		render.JSON(w, r, response.Error("failed to get sources"))
+		return
+	}
+
+	render.JSON(w, r, GetSourcesResponse{
+		Response: response.OK(),
+		Sources:  sources,
+		Total:    total,
+	})
+}
+
+type GetHeatmapResponse struct {
+	Response response.Response `json:"response"`
+	Heatmap  *domain.Heatmap   `json:"heatmap"`
+}
+
+// GetHeatmap - тепловая карта кликов по странице: сетка плотности и клики по селекторам.
+// page - адрес или путь страницы, device(mobile/tablet/desktop) и min_width/max_width фильтруют по вьюпорту
+func (h *Handler) GetHeatmap(w http.ResponseWriter, r *http.Request) {
+	domain_id, err := strconv.Atoi(chi.URLParam(r, "domain_id"))
+	if err != nil {
+		w.WriteHeader(http.StatusBadRequest)
+		render.JSON(w, r, response.BadRequest("bad domain id"))
+		return
+	}
+
+	q := r.URL.Query()
+
+	var opts domain.HeatmapOptions
+	opts.DomainID = uint(domain_id)
+
+	opts.PagePath = q.Get("page")
+	if opts.PagePath == "" {
+		w.WriteHeader(http.StatusBadRequest)
+		render.JSON(w, r, response.BadRequest("page is required"))
+		return
+	}
+
+	params, msg := parseListParams(r)
+	if msg != "" {
+		w.WriteHeader(http.StatusBadRequest)
+		render.JSON(w, r, response.BadRequest(msg))
+		return
+	}
+	opts.StartDate, opts.EndDate = params.StartDate, params.EndDate
+	if params.Limit != nil {
+		opts.SelectorsLimit = *params.Limit
+	}
+
+	device := domain.DeviceClass(q.Get("device"))
+	if device != "" && !device.Valid() {
+		w.WriteHeader(http.StatusBadRequest)
+		render.JSON(w, r, response.BadRequest("bad device"))
+		return
+	}
+
+	if mw := q.Get("min_width"); mw != "" {
+		min_width, err := strconv.Atoi(mw)
+		if err != nil || min_width < 0 {
+			w.WriteHeader(http.StatusBadRequest)
+			render.JSON(w, r, response.BadRequest("bad min width"))
+			return
+		}
+		opts.MinWidth = &min_width
+	}
+
+	if mw := q.Get("max_width"); mw != "" {
+		max_width, err := strconv.Atoi(mw)
+		if err != nil || max_width < 0 {
+			w.WriteHeader(http.StatusBadRequest)
+			render.JSON(w, r, response.BadRequest("bad max width"))
+			return
+		}
+		opts.MaxWidth = &max_width
+	}
+
+	if g := q.Get("grid"); g != "" {
+		grid, err := strconv.Atoi(g)
+		if err != nil || grid <= 0 {
+			w.WriteHeader(http.StatusBadRequest)
+			render.JSON(w, r, response.BadRequest("bad grid"))
+			return
+		}
+		opts.GridSize = grid
+	}
+
+	heatmap, err := h.heatmap.Execute(r.Context(), opts, device)
+	if err != nil {
+		h.log.Error("ошибка при построении тепловой карты", sl.Err(err))
+		w.WriteHeader(http.StatusInternalServerError)
+		render.JSON(w, r, response.Error("failed to get heatmap"))
+		return
+	}
+
+	render.JSON(w, r, GetHeatmapResponse{
+		Response: response.OK(),
+		Heatmap:  heatmap,
+	})
+}
+
+type GetCohortsResponse struct {
+	Response response.Response `json:"response"`
+	*domain.CohortReport
+}
+
+// GetCohorts - удержание гостей по когортам первого визита.
+// period - day, week или month, periods - сколько следующих периодов считать
+func (h *Handler) GetCohorts(w http.ResponseWriter, r *http.Request) {
+	domain_id, err := strconv.Atoi(chi.URLParam(r, "domain_id"))
+	if err != nil {
+		w.WriteHeader(http.StatusBadRequest)
+		render.JSON(w, r, response.BadRequest("bad domain id"))
+		return
+	}
+
+	opts := domain.CohortOptions{
+		DomainID: uint(domain_id),
+		Period:   r.URL.Query().Get("period"),
+	}
+
+	if p := r.URL.Query().Get("periods"); p != "" {
+		periods, err := strconv.Atoi(p)
+		if err != nil || periods <= 0 {
+			w.WriteHeader(http.StatusBadRequest)
+			render.JSON(w, r, response.BadRequest("bad periods"))
+			return
+		}
+		opts.Periods = periods
+	}
+
+	params, msg := parseListParams(r)
+	if msg != "" {
+		w.WriteHeader(http.StatusBadRequest)
+		render.JSON(w, r, response.BadRequest(msg))
+		return
+	}
+
+	report, err := h.cohorts.Execute(r.Context(), opts, params.StartDate, params.EndDate)
+	if err != nil {
+		if errors.Is(err, domain.ErrCohortPeriodNotAllowed) {
+			w.WriteHeader(http.StatusBadRequest)
+			render.JSON(w, r, response.BadRequest("bad period"))
+			return
+		}
+		if errors.Is(err, domain.ErrDomainNotFound) {
+			w.WriteHeader(http.StatusNotFound)
+			render.JSON(w, r, response.ErrorWithStatus(response.StatusNotFound, "domain not found"))
+			return
+		}
+		h.log.Error("ошибка при построении когорт", sl.Err(err))
+		w.WriteHeader(http.StatusInternalServerError)
+		render.JSON(w, r, response.Error("failed to get cohorts"))
+		return
+	}
+
+	render.JSON(w, r, GetCohortsResponse{
+		Response:     response.OK(),
+		CohortReport: report,
+	})
+}
+
+type listParams struct {
+	StartDate *time.Time
+	EndDate   *time.Time
+	Limit     *int
+	Offset    *int
+	Order     *string
+	OrderType *string
+}
+
+// parseListParams - общие параметры отчетов: период, пагинация и сортировка. Возвращает текст ошибки для клиента
+func parseListParams(r *http.Request) (listParams, string) {
+	var params listParams
+	q := r.URL.Query()
+
+	if st := q.Get("start_date"); st != "" {
+		start_date, err := time.Parse(time.RFC3339Nano, st)
+		if err != nil {
+			return params, "bad start date"
+		}
+		params.StartDate = &start_date
+	}
+
+	if end := q.Get("end_date"); end != "" {
+		end_date, err := time.Parse(time.RFC3339Nano, end)
+		if err != nil {
+			return params, "bad end date"
+		}
+		params.EndDate = &end_date
+	}
+
+	if params.StartDate != nil && params.EndDate != nil && params.EndDate.Before(*params.StartDate) {
+		return params, "end date before start date"
+	}
+
+	if lt := q.Get("limit"); lt != "" {
+		limit, err := strconv.Atoi(lt)
+		if err != nil || limit < 0 {
+			return params, "bad limit"
+		}
+		params.Limit = &limit
+	}
+
+	if ot := q.Get("offset"); ot != "" {
+		offset, err := strconv.Atoi(ot)
+		if err != nil || offset < 0 {
+			return params, "bad offset"
+		}
+		params.Offset = &offset
+	}
+
+	if order := q.Get("order"); order != "" {
+		params.Order = &order
+	}
+
+	if orderType := q.Get("order_type"); orderType != "" {
+		params.OrderType = &orderType
+	}
+
+	return params, ""
+}
+
+func (h *Handler) hasRole(r *http.Request, domain_id uint, user_id uint, required domain.Role) bool {
+	role, err := h.roles.Execute(r.Context(), domain_id, user_id)
+	if err != nil {
+		return false
+	}
+	return role.Allows(required)
+}
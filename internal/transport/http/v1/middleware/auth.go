@@ -4,6 +4,7 @@ import (
 	"context"
 	"log/slog"
 	"metrika/internal/config"
+	"metrika/internal/domain/auth"
 	"metrika/internal/infrastructure/jwt"
 	"net/http"
 	"strings"
@@ -15,6 +16,8 @@ var (
 	JWTClaimsDataKey = "jwt-claims-key"
 	TokenEmpty       = "empty token"
 	InvalidToken     = "invalid token"
+	//EventSource и WebSocket из браузера не умеют ставить заголовки, поэтому для них в query передается тикет стрима
+	StreamTicketParam = "ticket"
 )
 
 // AuthMiddleware - мидлвэйр с авторизацией
@@ -26,6 +29,10 @@ func AuthMiddleware(log *slog.Logger, jwtSecret string, cfg config.Config, jwt j
 			)
 
 			accessToken := r.Header.Get("Authorization")
+			if accessToken == "" && isStreamRequest(r) && r.URL.Query().Has(StreamTicketParam) {
+				streamTicket(log, jwt, next, w, r)
+				return
+			}
 			if accessToken == "" {
 				log.Error("access token is empty")
 				w.WriteHeader(http.StatusUnauthorized)
@@ -59,3 +66,39 @@ func AuthMiddleware(log *slog.Logger, jwtSecret string, cfg config.Config, jwt j
 		})
 	}
 }
+
+// streamTicket - пускает в стрим по тикету вместо access токена.
+// тикет убирается из query, чтобы дальше по цепочке он нигде не светился
+func streamTicket(log *slog.Logger, jwt jwt.JWTProvider, next http.Handler, w http.ResponseWriter, r *http.Request) {
+	query := r.URL.Query()
+	ticket := query.Get(StreamTicketParam)
+	query.Del(StreamTicketParam)
+	r.URL.RawQuery = query.Encode()
+	r.RequestURI = r.URL.RequestURI()
+
+	claims, err := jwt.ValidateStreamTicket(ticket, r.URL.Path)
+	if err != nil {
+		log.Warn("невалидный тикет стрима", slog.String("path", r.URL.Path))
+		w.WriteHeader(http.StatusUnauthorized)
+		render.JSON(w, r, InvalidToken)
+		return
+	}
+
+	c := context.WithValue(r.Context(), JWTClaimsDataKey, claims)
+
+	next.ServeHTTP(w, r.WithContext(c))
+}
+
+// isStreamRequest - запрос открывает SSE или WebSocket соединение
+func isStreamRequest(r *http.Request) bool {
+	if strings.EqualFold(r.Header.Get("Upgrade"), "websocket") {
+		return true
+	}
+	return strings.Contains(r.Header.Get("Accept"), "text/event-stream")
+}
+
+// ClaimsFromContext - данные юзера из access токена, положенные в контекст AuthMiddleware
+func ClaimsFromContext(ctx context.Context) (*auth.JWTClaims, bool) {
+	claims, ok := ctx.Value(JWTClaimsDataKey).(*auth.JWTClaims)
+	return claims, ok
+}
@@ -0,0 +1,80 @@
+package middleware
+
+import (
+	"context"
+	"errors"
+	"log/slog"
+	"metrika/internal/domain/analytics"
+	response "metrika/pkg/api"
+	"metrika/pkg/logger/sl"
+	"net/http"
+	"strconv"
+
+	"github.com/go-chi/chi/v5"
+	"github.com/go-chi/render"
+)
+
+var DomainRoleDataKey = "domain-role-key"
+
+type DomainRoleResolver interface {
+	Execute(ctx context.Context, domain_id uint, user_id uint) (analytics.Role, error)
+}
+
+// DomainRoleMiddleware - пропускает к роутам /{domain_id} только юзеров из команды домена,
+// чья роль не ниже требуемой. должен стоять после AuthMiddleware
+func DomainRoleMiddleware(log *slog.Logger, roles DomainRoleResolver, required analytics.Role) func(next http.Handler) http.Handler {
+	return func(next http.Handler) http.Handler {
+		return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			log := log.With(
+				slog.String("component", "middleware/domainRoleMiddleware"),
+			)
+
+			claims, ok := ClaimsFromContext(r.Context())
+			if !ok {
+				w.WriteHeader(http.StatusUnauthorized)
+				render.JSON(w, r, InvalidToken)
+				return
+			}
+
+			domain_id, err := strconv.Atoi(chi.URLParam(r, "domain_id"))
+			if err != nil {
+				w.WriteHeader(http.StatusBadRequest)
+				render.JSON(w, r, response.BadRequest("bad domain id"))
+				return
+			}
+
+			role, err := roles.Execute(r.Context(), uint(domain_id), claims.UserID)
+			if err != nil {
+				switch {
+				case errors.Is(err, analytics.ErrDomainNotFound):
+					w.WriteHeader(http.StatusNotFound)
+					render.JSON(w, r, response.ErrorWithStatus(response.StatusNotFound, "domain not found"))
+				case errors.Is(err, analytics.ErrDomainAccessDenied):
+					w.WriteHeader(http.StatusForbidden)
+					render.JSON(w, r, response.Error("access denied"))
+				default:
+					log.Error("ошибка проверки доступа к домену", sl.Err(err))
+					w.WriteHeader(http.StatusInternalServerError)
+					render.JSON(w, r, response.Error("internal error"))
+				}
+				return
+			}
+
+			if !role.Allows(required) {
+				w.WriteHeader(http.StatusForbidden)
+				render.JSON(w, r, response.Error("insufficient role"))
+				return
+			}
+
+			c := context.WithValue(r.Context(), DomainRoleDataKey, role)
+
+			next.ServeHTTP(w, r.WithContext(c))
+		})
+	}
+}
+
+// DomainRoleFromContext - роль юзера в домене, положенная в контекст DomainRoleMiddleware
+func DomainRoleFromContext(ctx context.Context) (analytics.Role, bool) {
+	role, ok := ctx.Value(DomainRoleDataKey).(analytics.Role)
+	return role, ok
+}
@@ -0,0 +1,191 @@
+package middleware
+
+import (
+	"bufio"
+	"compress/flate"
+	"compress/gzip"
+	"compress/zlib"
+	"errors"
+	"fmt"
+	"io"
+	"log/slog"
+	response "metrika/pkg/api"
+	"mime"
+	"net/http"
+	"strings"
+
+	"github.com/go-chi/render"
+)
+
+var errBodyTooLarge = errors.New("request body too large")
+
+// IngestionBodyMiddleware - готовит тело запросов приема ивентов для обычного render.Decode:
+// распаковывает gzip/deflate и принимает text/plain от navigator.sendBeacon как json.
+// тело не буферизуется, а читается потоком с ограничением maxBytes и до, и после распаковки.
+// если обработчик упал на превышении лимита, клиент вместо его ответа получает 413
+func IngestionBodyMiddleware(log *slog.Logger, maxBytes int64) func(next http.Handler) http.Handler {
+	return func(next http.Handler) http.Handler {
+		return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			log := log.With(
+				slog.String("component", "middleware/ingestionBodyMiddleware"),
+			)
+
+			if r.ContentLength > maxBytes {
+				writeTooLarge(w, r, maxBytes)
+				return
+			}
+
+			//сжатое тело тоже не может быть больше лимита
+			raw := http.MaxBytesReader(w, r.Body, maxBytes)
+			defer raw.Close()
+
+			body, err := decompressedReader(raw, r.Header.Get("Content-Encoding"))
+			if err != nil {
+				var unsupported unsupportedEncodingError
+				if errors.As(err, &unsupported) {
+					w.WriteHeader(http.StatusUnsupportedMediaType)
+					render.JSON(w, r, response.Error(unsupported.Error()))
+					return
+				}
+				if isTooLarge(err) {
+					writeTooLarge(w, r, maxBytes)
+					return
+				}
+				w.WriteHeader(http.StatusBadRequest)
+				render.JSON(w, r, response.BadRequest("bad request body encoding"))
+				return
+			}
+
+			limited := &limitedBody{r: body, left: maxBytes}
+			r.Body = struct {
+				io.Reader
+				io.Closer
+			}{limited, raw}
+			//длина распакованного тела заранее неизвестна
+			if body != io.Reader(raw) {
+				r.ContentLength = -1
+				r.Header.Del("Content-Length")
+			}
+			r.Header.Del("Content-Encoding")
+
+			//sendBeacon со строкой отправляет text/plain, чтобы обойтись без preflight
+			mediaType, _, _ := mime.ParseMediaType(r.Header.Get("Content-Type"))
+			if mediaType == "" || mediaType == "text/plain" {
+				r.Header.Set("Content-Type", "application/json")
+			}
+
+			tw := &tooLargeWriter{ResponseWriter: w, r: r, body: limited, maxBytes: maxBytes}
+			next.ServeHTTP(tw, r)
+
+			if tw.replaced {
+				log.Warn("тело запроса превышает лимит", slog.Int64("max_bytes", maxBytes), slog.String("path", r.URL.Path))
+			}
+		})
+	}
+}
+
+// limitedBody - отдает не больше left байт распакованного тела, дальше errBodyTooLarge
+type limitedBody struct {
+	r        io.Reader
+	left     int64
+	exceeded bool
+}
+
+func (b *limitedBody) Read(p []byte) (int, error) {
+	if b.exceeded {
+		return 0, errBodyTooLarge
+	}
+	//читаем на байт больше остатка, чтобы отличить тело ровно в лимит от превышения
+	if int64(len(p)) > b.left+1 {
+		p = p[:b.left+1]
+	}
+	n, err := b.r.Read(p)
+	if int64(n) > b.left {
+		b.exceeded = true
+		return int(b.left), errBodyTooLarge
+	}
+	b.left -= int64(n)
+	if isTooLarge(err) {
+		b.exceeded = true
+	}
+	return n, err
+}
+
+// tooLargeWriter - если тело не уложилось в лимит, ответ обработчика(обычно 400 от неудачного
+// декодирования) заменяется на 413
+type tooLargeWriter struct {
+	http.ResponseWriter
+	r        *http.Request
+	body     *limitedBody
+	maxBytes int64
+
+	wroteHeader bool
+	replaced    bool
+}
+
+func (w *tooLargeWriter) WriteHeader(status int) {
+	if w.wroteHeader {
+		return
+	}
+	w.wroteHeader = true
+
+	if w.body.exceeded {
+		w.replaced = true
+		w.Header().Set("Content-Type", "application/json")
+		writeTooLarge(w.ResponseWriter, w.r, w.maxBytes)
+		return
+	}
+	w.ResponseWriter.WriteHeader(status)
+}
+
+func (w *tooLargeWriter) Write(p []byte) (int, error) {
+	if !w.wroteHeader {
+		w.WriteHeader(http.StatusOK)
+	}
+	if w.replaced {
+		return len(p), nil
+	}
+	return w.ResponseWriter.Write(p)
+}
+
+type unsupportedEncodingError string
+
+func (e unsupportedEncodingError) Error() string {
+	return fmt.Sprintf("unsupported content encoding %q", string(e))
+}
+
+func decompressedReader(body io.Reader, encoding string) (io.Reader, error) {
+	switch strings.ToLower(strings.TrimSpace(encoding)) {
+	case "", "identity":
+		return body, nil
+	case "gzip", "x-gzip":
+		return gzip.NewReader(body)
+	case "deflate":
+		//по стандарту deflate - это zlib, но часть клиентов шлет сырой deflate
+		br := bufio.NewReader(body)
+		header, err := br.Peek(2)
+		if err != nil {
+			return nil, err
+		}
+		if isZlibHeader(header) {
+			return zlib.NewReader(br)
+		}
+		return flate.NewReader(br), nil
+	default:
+		return nil, unsupportedEncodingError(encoding)
+	}
+}
+
+func isZlibHeader(h []byte) bool {
+	return h[0]&0x0f == 8 && (uint16(h[0])<<8|uint16(h[1]))%31 == 0
+}
+
+func isTooLarge(err error) bool {
+	var maxBytesErr *http.MaxBytesError
+	return errors.Is(err, errBodyTooLarge) || errors.As(err, &maxBytesErr)
+}
+
+func writeTooLarge(w http.ResponseWriter, r *http.Request, maxBytes int64) {
+	w.WriteHeader(http.StatusRequestEntityTooLarge)
+	render.JSON(w, r, response.Error(fmt.Sprintf("request body exceeds %d bytes", maxBytes)))
+}
@@ -0,0 +1,158 @@
+package privacy
+
+import (
+	"errors"
+	"log/slog"
+	domain "metrika/internal/domain/analytics"
+	"metrika/internal/usecase/metrika"
+	response "metrika/pkg/api"
+	"metrika/pkg/logger/sl"
+	"net/http"
+	"strconv"
+
+	"github.com/go-chi/chi/v5"
+	"github.com/go-chi/render"
+)
+
+type Handler struct {
+	log         *slog.Logger
+	getRules    *metrika.GetMaskingRulesUseCase
+	updateRules *metrika.UpdateMaskingRulesUseCase
+	dryRun      *metrika.DryRunMaskingUseCase
+}
+
+func NewHandler(
+	log *slog.Logger,
+	getRules *metrika.GetMaskingRulesUseCase,
+	updateRules *metrika.UpdateMaskingRulesUseCase,
+	dryRun *metrika.DryRunMaskingUseCase,
+) *Handler {
+	return &Handler{
+		log,
+		getRules,
+		updateRules,
+		dryRun,
+	}
+}
+
+type GetMaskingRulesResponse struct {
+	Response response.Response   `json:"response"`
+	Rules    domain.MaskingRules `json:"rules"`
+	//false - домен использует правила по умолчанию
+	Custom bool `json:"custom"`
+}
+
+func (h *Handler) GetMaskingRules(w http.ResponseWriter, r *http.Request) {
+	domain_id, err := strconv.Atoi(chi.URLParam(r, "domain_id"))
+	if err != nil {
+		w.WriteHeader(http.StatusBadRequest)
+		render.JSON(w, r, response.BadRequest("bad domain id"))
+		return
+	}
+
+	rules, custom, err := h.getRules.Execute(r.Context(), uint(domain_id))
+	if err != nil {
+		h.writeError(w, r, err)
+		return
+	}
+
+	render.JSON(w, r, GetMaskingRulesResponse{
+		Response: response.OK(),
+		Rules:    rules,
+		Custom:   custom,
+	})
+}
+
+func (h *Handler) UpdateMaskingRules(w http.ResponseWriter, r *http.Request) {
+	domain_id, err := strconv.Atoi(chi.URLParam(r, "domain_id"))
+	if err != nil {
+		w.WriteHeader(http.StatusBadRequest)
+		render.JSON(w, r, response.BadRequest("bad domain id"))
+		return
+	}
+
+	var rules domain.MaskingRules
+	if err := render.Decode(r, &rules); err != nil {
+		w.WriteHeader(http.StatusBadRequest)
+		render.JSON(w, r, response.BadRequest("unable to decode request"))
+		return
+	}
+
+	if err := h.updateRules.Execute(r.Context(), uint(domain_id), rules); err != nil {
+		h.writeError(w, r, err)
+		return
+	}
+
+	render.JSON(w, r, response.OK())
+}
+
+type DryRunRequest struct {
+	Rules     *domain.MaskingRules `json:"rules"`
+	Events    []domain.RecordEvent `json:"events"`
+	SessionID *uint                `json:"session_id"`
+	Limit     int                  `json:"limit"`
+}
+
+type DryRunResponse struct {
+	Response response.Response `json:"response"`
+	*metrika.DryRunMaskingResult
+}
+
+// DryRun - прогоняет правила(переданные или текущие) по ивентам из запроса
+// или по началу записи сессии и показывает, что будет замаскировано
+func (h *Handler) DryRun(w http.ResponseWriter, r *http.Request) {
+	domain_id, err := strconv.Atoi(chi.URLParam(r, "domain_id"))
+	if err != nil {
+		w.WriteHeader(http.StatusBadRequest)
+		render.JSON(w, r, response.BadRequest("bad domain id"))
+		return
+	}
+
+	var req DryRunRequest
+	if err := render.Decode(r, &req); err != nil {
+		w.WriteHeader(http.StatusBadRequest)
+		render.JSON(w, r, response.BadRequest("unable to decode request"))
+		return
+	}
+
+	if len(req.Events) == 0 && req.SessionID == nil {
+		w.WriteHeader(http.StatusBadRequest)
+		render.JSON(w, r, response.BadRequest("events or session_id is required"))
+		return
+	}
+
+	result, err := h.dryRun.Execute(r.Context(), metrika.DryRunMaskingOptions{
+		DomainID:  uint(domain_id),
+		Rules:     req.Rules,
+		Events:    req.Events,
+		SessionID: req.SessionID,
+		Limit:     req.Limit,
+	})
+	if err != nil {
+		h.writeError(w, r, err)
+		return
+	}
+
+	render.JSON(w, r, DryRunResponse{
+		Response:            response.OK(),
+		DryRunMaskingResult: result,
+	})
+}
+
+func (h *Handler) writeError(w http.ResponseWriter, r *http.Request, err error) {
+	switch {
+	case errors.Is(err, domain.ErrInvalidMaskingRules):
+		w.WriteHeader(http.StatusBadRequest)
+		render.JSON(w, r, response.BadRequest(err.Error()))
+	case errors.Is(err, domain.ErrDomainNotFound):
+		w.WriteHeader(http.StatusNotFound)
+		render.JSON(w, r, response.Error("domain not found"))
+	case errors.Is(err, domain.ErrSessionsNotFound):
+		w.WriteHeader(http.StatusNotFound)
+		render.JSON(w, r, response.Error("session not found"))
+	default:
+		h.log.Error("ошибка правил маскирования", sl.Err(err))
+		w.WriteHeader(http.StatusInternalServerError)
+		render.JSON(w, r, response.Error("internal error"))
+	}
+}
@@ -0,0 +1,232 @@
+package replays
+
+import (
+	"encoding/json"
+	"errors"
+	"log/slog"
+	domain "metrika/internal/domain/analytics"
+	"metrika/internal/usecase/metrika"
+	response "metrika/pkg/api"
+	"metrika/pkg/logger/sl"
+	"net/http"
+	"strconv"
+	"time"
+
+	"github.com/go-chi/chi/v5"
+	"github.com/go-chi/render"
+)
+
+// через сколько ивентов сбрасывать буфер стрима клиенту
+const streamFlushEvery = 100
+
+type Handler struct {
+	log    *slog.Logger
+	replay *metrika.ReplayUseCase
+}
+
+func NewHandler(log *slog.Logger, replay *metrika.ReplayUseCase) *Handler {
+	return &Handler{log, replay}
+}
+
+type GetMetaResponse struct {
+	Response response.Response  `json:"response"`
+	Meta     *domain.ReplayMeta `json:"meta"`
+}
+
+func (h *Handler) GetMeta(w http.ResponseWriter, r *http.Request) {
+	domain_id, session_id, ok := h.parseIDs(w, r)
+	if !ok {
+		return
+	}
+
+	meta, err := h.replay.Meta(r.Context(), domain_id, session_id)
+	if err != nil {
+		h.writeError(w, r, err)
+		return
+	}
+
+	render.JSON(w, r, GetMetaResponse{
+		Response: response.OK(),
+		Meta:     meta,
+	})
+}
+
+type GetEventsResponse struct {
+	Response response.Response `json:"response"`
+	*domain.ReplayWindow
+}
+
+// GetEvents - окно ивентов реплея. без cursor начинается с ближайшего полного снапшота к from,
+// дальше клиент листает по next_cursor
+func (h *Handler) GetEvents(w http.ResponseWriter, r *http.Request) {
+	domain_id, session_id, ok := h.parseIDs(w, r)
+	if !ok {
+		return
+	}
+
+	from, to, ok := h.parseRange(w, r)
+	if !ok {
+		return
+	}
+
+	var cursor *domain.ReplayCursor
+	if c := r.URL.Query().Get("cursor"); c != "" {
+		parsed, err := domain.ParseReplayCursor(c)
+		if err != nil {
+			w.WriteHeader(http.StatusBadRequest)
+			render.JSON(w, r, response.BadRequest("bad cursor"))
+			return
+		}
+		cursor = &parsed
+	}
+
+	var limit int
+	if lt := r.URL.Query().Get("limit"); lt != "" {
+		l, err := strconv.Atoi(lt)
+		if err != nil || l < 0 {
+			w.WriteHeader(http.StatusBadRequest)
+			render.JSON(w, r, response.BadRequest("bad limit"))
+			return
+		}
+		limit = l
+	}
+
+	window, err := h.replay.Window(r.Context(), domain_id, session_id, from, to, cursor, limit)
+	if err != nil {
+		h.writeError(w, r, err)
+		return
+	}
+
+	render.JSON(w, r, GetEventsResponse{
+		Response:     response.OK(),
+		ReplayWindow: window,
+	})
+}
+
+// Stream - ивенты реплея в NDJSON, по одному в строке, чтобы плеер начинал до загрузки всей сессии
+func (h *Handler) Stream(w http.ResponseWriter, r *http.Request) {
+	domain_id, session_id, ok := h.parseIDs(w, r)
+	if !ok {
+		return
+	}
+
+	from, to, ok := h.parseRange(w, r)
+	if !ok {
+		return
+	}
+
+	//длинная сессия отдается дольше общего WriteTimeout сервера
+	if err := http.NewResponseController(w).SetWriteDeadline(time.Time{}); err != nil {
+		h.log.Warn("не удалось снять дедлайн записи для стрима", sl.Err(err))
+	}
+
+	flusher, _ := w.(http.Flusher)
+	enc := json.NewEncoder(w)
+
+	//заголовки пишем только на первом ивенте, чтобы до этого можно было ответить обычной ошибкой
+	started := false
+	start := func() {
+		started = true
+		w.Header().Set("Content-Type", "application/x-ndjson")
+		w.Header().Set("Cache-Control", "no-cache")
+		w.WriteHeader(http.StatusOK)
+	}
+
+	sent := 0
+	err := h.replay.Stream(r.Context(), domain_id, session_id, from, to, func(e domain.RecordEvent) error {
+		if !started {
+			start()
+		}
+		if err := enc.Encode(e); err != nil {
+			return err
+		}
+		sent++
+		if flusher != nil && sent%streamFlushEvery == 0 {
+			flusher.Flush()
+		}
+		return nil
+	})
+
+	if err != nil {
+		if !started {
+			h.writeError(w, r, err)
+			return
+		}
+		//ответ уже начат, клиент увидит оборванный стрим
+		h.log.Error("стрим реплея прерван", sl.Err(err), slog.Uint64("session_id", uint64(session_id)))
+		return
+	}
+
+	if !started {
+		start()
+	}
+	if flusher != nil {
+		flusher.Flush()
+	}
+}
+
+func (h *Handler) parseIDs(w http.ResponseWriter, r *http.Request) (uint, uint, bool) {
+	domain_id, err := strconv.Atoi(chi.URLParam(r, "domain_id"))
+	if err != nil {
+		w.WriteHeader(http.StatusBadRequest)
+		render.JSON(w, r, response.BadRequest("bad domain id"))
+		return 0, 0, false
+	}
+
+	session_id, err := strconv.Atoi(chi.URLParam(r, "session_id"))
+	if err != nil {
+		w.WriteHeader(http.StatusBadRequest)
+		render.JSON(w, r, response.BadRequest("bad session id"))
+		return 0, 0, false
+	}
+
+	return uint(domain_id), uint(session_id), true
+}
+
+// parseRange - from/to в миллисекундах unix, как таймстемпы rrweb
+func (h *Handler) parseRange(w http.ResponseWriter, r *http.Request) (int64, int64, bool) {
+	var from, to int64
+
+	if f := r.URL.Query().Get("from"); f != "" {
+		v, err := strconv.ParseInt(f, 10, 64)
+		if err != nil || v < 0 {
+			w.WriteHeader(http.StatusBadRequest)
+			render.JSON(w, r, response.BadRequest("bad from"))
+			return 0, 0, false
+		}
+		from = v
+	}
+
+	if t := r.URL.Query().Get("to"); t != "" {
+		v, err := strconv.ParseInt(t, 10, 64)
+		if err != nil || v < 0 {
+			w.WriteHeader(http.StatusBadRequest)
+			render.JSON(w, r, response.BadRequest("bad to"))
+			return 0, 0, false
+		}
+		to = v
+	}
+
+	if to > 0 && to < from {
+		w.WriteHeader(http.StatusBadRequest)
+		render.JSON(w, r, response.BadRequest("to before from"))
+		return 0, 0, false
+	}
+
+	return from, to, true
+}
+
+func (h *Handler) writeError(w http.ResponseWriter, r *http.Request, err error) {
+	switch {
+	case errors.Is(err, domain.ErrSessionsNotFound):
+		w.WriteHeader(http.StatusNotFound)
+		render.JSON(w, r, response.Error("session not found"))
+	case errors.Is(err, domain.ErrRecordEventsNotFound):
+		w.WriteHeader(http.StatusNotFound)
+		render.JSON(w, r, response.Error("replay not found"))
+	default:
+		h.log.Error("ошибка получения реплея", sl.Err(err))
+		w.WriteHeader(http.StatusInternalServerError)
+		render.JSON(w, r, response.Error("failed to get replay"))
+	}
+}
@@ -2,51 +2,95 @@ package analytics
 
 import (
 	"context"
+	"errors"
 	domain "metrika/internal/domain/analytics"
-	"metrika/internal/domain/tx"
 	"time"
 )
 
 type TrackerProvider interface {
-	TrackEvent(e domain.Event)
+	TrackEvents(ctx context.Context, events []domain.Event) error
 }
 
 type CollectEventsUseCase struct {
-	events   domain.EventsRepository
 	sessions domain.GuestSessionRepository
+	domains  domain.DomainRepository
 	tracker  TrackerProvider
-	tx       tx.TransactionManager
+	live     *LiveFeed
 }
 
 func NewCollectEventsUseCase(
-	events domain.EventsRepository,
 	tracker TrackerProvider,
 	sessions domain.GuestSessionRepository,
-	tx tx.TransactionManager,
+	domains domain.DomainRepository,
+	live *LiveFeed,
 ) *CollectEventsUseCase {
-	return &CollectEventsUseCase{events, sessions, tracker, tx}
+	return &CollectEventsUseCase{sessions, domains, tracker, live}
 }
 
+// Authorize - проверяет ключ сайта и origin запроса, а также
+// что все сессии из пачки ивентов принадлежат этому домену и еще не закрыты.
+// в закрытую сессию ивенты не принимаются, клиент должен запросить новую
+func (ec *CollectEventsUseCase) Authorize(
+	ctx context.Context,
+	siteKey, origin string,
+	events []domain.Event,
+) (*domain.Domain, error) {
+	dom, err := resolveDomain(ctx, ec.domains, siteKey, origin)
+	if err != nil {
+		return nil, err
+	}
+
+	unique := make(map[uint]struct{})
+	var ids []uint
+	for _, e := range events {
+		if _, ok := unique[e.SessionID]; ok {
+			continue
+		}
+		unique[e.SessionID] = struct{}{}
+		ids = append(ids, e.SessionID)
+	}
+
+	owned, err := ec.sessions.FilterByDomain(ctx, dom.ID, ids)
+	if err != nil {
+		return nil, err
+	}
+
+	if len(owned) != len(ids) {
+		return nil, domain.ErrSessionInvalid
+	}
+
+	active, err := ec.sessions.FilterActive(ctx, ids)
+	if err != nil {
+		return nil, err
+	}
+
+	if len(active) != len(ids) {
+		return nil, domain.ErrSessionClosed
+	}
+
+	return dom, nil
+}
+
+// Execute - отдает ивенты в трекер, который сохранит их пачкой, и публикует их в живую ленту домена
 func (ec *CollectEventsUseCase) Execute(
 	ctx context.Context,
-	events *[]domain.Event,
+	domain_id uint,
+	events []domain.Event,
 ) error {
-	return ec.tx.WithinTransaction(ctx, func(ctx context.Context) error {
-		if err := ec.events.SaveEvents(ctx, events); err != nil {
-			return err
-		}
+	now := time.Now()
 
-		var ids []uint
-		for _, e := range *events {
-			ids = append(ids, e.SessionID)
+	//время ивента берем с клиента, но не даем ему быть пустым или из будущего
+	for i := range events {
+		if events[i].Timestamp.IsZero() || events[i].Timestamp.After(now) {
+			events[i].Timestamp = now
 		}
+	}
 
-		//TODO:учесть что с момента отправки задачи в очередь на сохранение может пройти много времени
-		//TODO: соответственно time.Now может быть не актуален для данной задачи
-		if err := ec.sessions.SetLastActive(ctx, ids, time.Now()); err != nil {
-			return err
-		}
+	if err := ec.tracker.TrackEvents(ctx, events); err != nil {
+		return errors.Join(domain.ErrIngestionUnavailable, err)
+	}
+
+	ec.live.PublishEvents(domain_id, events)
 
-		return nil
-	})
+	return nil
 }
@@ -6,11 +6,34 @@ import (
 )
 
 type CollectRecordEventsUseCase struct {
-	events domain.RecordEventRepository
+	events   domain.RecordEventRepository
+	sessions domain.GuestSessionRepository
+	domains  domain.DomainRepository
+	masker   *RecordMasker
 }
 
-func NewCollectRecordEventsUseCase(events domain.RecordEventRepository) *CollectRecordEventsUseCase {
-	return &CollectRecordEventsUseCase{events}
+func NewCollectRecordEventsUseCase(events domain.RecordEventRepository, sessions domain.GuestSessionRepository, domains domain.DomainRepository, masker *RecordMasker) *CollectRecordEventsUseCase {
+	return &CollectRecordEventsUseCase{events, sessions, domains, masker}
+}
+
+// Authorize - проверяет ключ сайта и origin запроса и что сессия принадлежит этому домену.
+// закрытые сессии не отсекаем: при смене сессии клиент дописывает в старую накопленную запись
+func (uc *CollectRecordEventsUseCase) Authorize(ctx context.Context, siteKey, origin string, session_id uint) (*domain.Domain, error) {
+	dom, err := resolveDomain(ctx, uc.domains, siteKey, origin)
+	if err != nil {
+		return nil, err
+	}
+
+	owned, err := uc.sessions.FilterByDomain(ctx, dom.ID, []uint{session_id})
+	if err != nil {
+		return nil, err
+	}
+
+	if len(owned) == 0 {
+		return nil, domain.ErrSessionInvalid
+	}
+
+	return dom, nil
 }
 
 func (uc *CollectRecordEventsUseCase) Execute(ctx context.Context, events []domain.RecordEvent, session_id uint) error {
@@ -19,6 +42,11 @@ func (uc *CollectRecordEventsUseCase) Execute(ctx context.Context, events []doma
 		events[i].SessionID = session_id
 	}
 
+	//персональные данные вырезаем до сохранения, без маскирования запись не сохраняем
+	if err := uc.masker.Mask(ctx, session_id, events); err != nil {
+		return err
+	}
+
 	if err := uc.events.SaveEvents(ctx, &events); err != nil {
 		return err
 	}
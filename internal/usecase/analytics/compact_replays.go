@@ -0,0 +1,51 @@
+package analytics
+
+import (
+	"context"
+	"log/slog"
+	domain "metrika/internal/domain/analytics"
+	"metrika/pkg/logger/sl"
+	"sync"
+)
+
+// CompactReplaysUseCase - склеивает мелкие чанки записей закрытых сессий, запускается по расписанию
+type CompactReplaysUseCase struct {
+	log     *slog.Logger
+	records domain.RecordEventRepository
+	batch   int
+
+	running sync.Mutex
+}
+
+func NewCompactReplaysUseCase(log *slog.Logger, records domain.RecordEventRepository, batch int) *CompactReplaysUseCase {
+	return &CompactReplaysUseCase{
+		log:     log.With(slog.String("component", "usecase/analytics/compact_replays")),
+		records: records,
+		batch:   batch,
+	}
+}
+
+func (uc *CompactReplaysUseCase) Run(ctx context.Context) {
+	if !uc.running.TryLock() {
+		uc.log.Warn("предыдущий проход сжатия записей еще не закончился, пропускаем")
+		return
+	}
+	defer uc.running.Unlock()
+
+	total := 0
+	for ctx.Err() == nil {
+		compacted, err := uc.records.CompactChunks(ctx, uc.batch)
+		total += compacted
+		if err != nil {
+			uc.log.Error("ошибка сжатия чанков записей", sl.Err(err))
+			break
+		}
+		if compacted < uc.batch {
+			break
+		}
+	}
+
+	if total > 0 {
+		uc.log.Info("чанки записей сжаты", slog.Int("sessions", total))
+	}
+}
@@ -14,6 +14,7 @@ type GetGuestSessionUseCase struct {
 	sessions domain.GuestSessionRepository
 	domains  domain.DomainRepository
 	logger   *slog.Logger
+	live     *LiveFeed
 }
 
 func NewGetGuestSessionUseCase(
@@ -21,16 +22,33 @@ func NewGetGuestSessionUseCase(
 	sessions domain.GuestSessionRepository,
 	domain domain.DomainRepository,
 	logger *slog.Logger,
+	live *LiveFeed,
 ) *GetGuestSessionUseCase {
-	return &GetGuestSessionUseCase{guests, sessions, domain, logger}
+	return &GetGuestSessionUseCase{guests, sessions, domain, logger, live}
 }
 
-func (gc *GetGuestSessionUseCase) Execute(ctx context.Context, FingerprintID, IPAddress string, domainUrl string) (*domain.GuestSession, error) {
+// GuestSessionRequest - данные клиента, запросившего сессию
+type GuestSessionRequest struct {
+	FingerprintID string
+	IPAddress     string
+	//User-Agent и страна(ISO-код из заголовка прокси) запоминаются только при создании сессии
+	UserAgent string
+	Country   string
+	SiteKey   string
+	Origin    string
+	//адрес страницы и document.referrer, по ним определяется смена кампании
+	URL      string
+	Referrer string
+}
+
+// Execute - активная сессия гостя или новая, если активной нет
+// или ее пора разбить по настройкам сессий домена
+func (gc *GetGuestSessionUseCase) Execute(ctx context.Context, req GuestSessionRequest) (*domain.GuestSession, error) {
 
-	//ищем домен
-	dom, err := gc.domains.ByURL(ctx, domainUrl)
+	//ищем домен по ключу сайта и сверяем origin
+	dom, err := resolveDomain(ctx, gc.domains, req.SiteKey, req.Origin)
 	if err != nil {
-		if errors.Is(err, domain.ErrDomainNotFound) {
+		if errors.Is(err, domain.ErrDomainNotFound) || errors.Is(err, domain.ErrOriginNotAllowed) {
 			return nil, err
 		}
 		gc.logger.Error("ошибка получения домена", sl.Err(err))
@@ -38,7 +56,7 @@ func (gc *GetGuestSessionUseCase) Execute(ctx context.Context, FingerprintID, IP
 	}
 
 	//ищем или создаем юзера по переданному отпечатку
-	guest, err := gc.guests.FirstOrCreate(ctx, FingerprintID, dom.ID)
+	guest, err := gc.guests.FirstOrCreate(ctx, req.FingerprintID, dom.ID)
 	if err != nil {
 		gc.logger.Error("ошибка получения гостевого юзера по f_id", sl.Err(err))
 		return nil, err
@@ -46,23 +64,43 @@ func (gc *GetGuestSessionUseCase) Execute(ctx context.Context, FingerprintID, IP
 
 	//ищем активную сессию юзера
 	activeSession, err := gc.sessions.LastActiveByGuestId(ctx, guest.ID)
-	if err != nil && errors.Is(err, domain.ErrLastActiveSessionNotFound){
+	if err != nil && errors.Is(err, domain.ErrLastActiveSessionNotFound) {
 		gc.logger.Error("ошибка получения последней активной сессии гостевого")
 		return nil, err
 	}
-	//если активная сессия уже есть,
+
+	now := time.Now()
+
+	//если активная сессия уже есть и ее не нужно разбивать,
 	//то возвращаем ее, не создавая новую
-	if err == nil && activeSession != nil{
-		return activeSession, nil
+	if err == nil && activeSession != nil {
+		var source *domain.SessionSource
+		if req.URL != "" {
+			src := domain.SourceFromURL(req.URL, req.Referrer)
+			source = &src
+		}
+
+		reason := dom.SessionSettings.SplitReason(*activeSession, now, source)
+		if reason == "" {
+			return activeSession, nil
+		}
+
+		if err := gc.sessions.CloseSessions(ctx, []uint{activeSession.ID}); err != nil {
+			gc.logger.Error("ошибка закрытия сессии перед разбиением", sl.Err(err))
+			return nil, err
+		}
+		gc.logger.Debug("сессия разбита", slog.Uint64("session_id", uint64(activeSession.ID)), slog.String("reason", reason))
 	}
 
 	//если активных сессий нет - создаем новую
 	session := domain.GuestSession{
 		GuestID:    guest.ID,
-		IPAddress:  IPAddress,
+		IPAddress:  req.IPAddress,
 		EndTime:    nil,
 		Active:     true,
-		LastActive: time.Now(),
+		LastActive: now,
+		Device:     domain.DeviceFromUserAgent(req.UserAgent),
+		Country:    domain.NormalizeCountry(req.Country),
 	}
 
 	if err := gc.sessions.Create(ctx, &session); err != nil {
@@ -72,5 +110,7 @@ func (gc *GetGuestSessionUseCase) Execute(ctx context.Context, FingerprintID, IP
 
 	gc.logger.Debug("SESSION", slog.Any("session", session))
 
+	gc.live.PublishSession(dom.ID, session)
+
 	return &session, nil
 }
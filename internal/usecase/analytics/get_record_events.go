@@ -2,17 +2,43 @@ package analytics
 
 import (
 	"context"
+	"errors"
 	domain "metrika/internal/domain/analytics"
 )
 
 type GetRecordEventsUseCase struct {
-	events domain.RecordEventRepository
+	events   domain.RecordEventRepository
+	sessions domain.GuestSessionRepository
+	members  domain.MembersRepository
 }
 
-func NewGetRecordEventsUseCase(events domain.RecordEventRepository) *GetRecordEventsUseCase {
-	return &GetRecordEventsUseCase{events}
+func NewGetRecordEventsUseCase(events domain.RecordEventRepository, sessions domain.GuestSessionRepository, members domain.MembersRepository) *GetRecordEventsUseCase {
+	return &GetRecordEventsUseCase{events, sessions, members}
 }
 
-func (uc *GetRecordEventsUseCase) Execute(ctx context.Context, session_id uint) (*[]domain.RecordEvent, error) {
+// Execute - запись сессии, доступна только команде домена сессии
+func (uc *GetRecordEventsUseCase) Execute(ctx context.Context, user_id uint, session_id uint) (*[]domain.RecordEvent, error) {
+	domains, err := uc.sessions.DomainsBySessions(ctx, []uint{session_id})
+	if err != nil {
+		return nil, err
+	}
+
+	domain_id, ok := domains[session_id]
+	if !ok {
+		return nil, domain.ErrRecordEventsNotFound
+	}
+
+	role, err := uc.members.Role(ctx, domain_id, user_id)
+	if err != nil {
+		if errors.Is(err, domain.ErrMemberNotFound) {
+			return nil, domain.ErrDomainAccessDenied
+		}
+		return nil, err
+	}
+
+	if !role.Allows(domain.RoleViewer) {
+		return nil, domain.ErrDomainAccessDenied
+	}
+
 	return uc.events.GetBySessionId(ctx, session_id)
 }
@@ -0,0 +1,120 @@
+package analytics
+
+import (
+	"context"
+	"log/slog"
+	domain "metrika/internal/domain/analytics"
+	"metrika/pkg/logger/sl"
+	"sync"
+	"time"
+)
+
+// сколько живут скомпилированные цели домена, пока их не перечитаем из базы
+const goalsTTL = time.Minute
+
+type cachedGoals struct {
+	matchers []*domain.GoalMatcher
+	loadedAt time.Time
+}
+
+// GoalEvaluator - сопоставляет ивенты с целями их доменов
+type GoalEvaluator struct {
+	log   *slog.Logger
+	goals domain.GoalRepository
+
+	mu    sync.Mutex
+	cache map[uint]cachedGoals
+}
+
+func NewGoalEvaluator(log *slog.Logger, goals domain.GoalRepository) *GoalEvaluator {
+	return &GoalEvaluator{
+		log:   log.With(slog.String("component", "usecase/analytics/goals")),
+		goals: goals,
+		cache: make(map[uint]cachedGoals),
+	}
+}
+
+// Invalidate - цели домена изменились, перечитать при следующем ивенте
+func (e *GoalEvaluator) Invalidate(domain_id uint) {
+	e.mu.Lock()
+	defer e.mu.Unlock()
+
+	delete(e.cache, domain_id)
+}
+
+// Evaluate - конверсии пачки ивентов. domains - домен каждой сессии пачки.
+// в пачке засчитывается первое по времени достижение цели в сессии
+func (e *GoalEvaluator) Evaluate(ctx context.Context, domains map[uint]uint, events []domain.Event) ([]domain.Conversion, error) {
+	type key struct {
+		goalID    uint
+		sessionID uint
+	}
+	first := make(map[key]domain.Conversion)
+
+	for _, ev := range events {
+		domain_id, ok := domains[ev.SessionID]
+		if !ok {
+			continue
+		}
+
+		matchers, err := e.matchers(ctx, domain_id)
+		if err != nil {
+			return nil, err
+		}
+
+		for _, m := range matchers {
+			if !m.Match(ev) {
+				continue
+			}
+			k := key{m.Goal().ID, ev.SessionID}
+			if prev, ok := first[k]; ok && !ev.Timestamp.Before(prev.Timestamp) {
+				continue
+			}
+			first[k] = domain.Conversion{
+				GoalID:    m.Goal().ID,
+				SessionID: ev.SessionID,
+				EventID:   ev.ID,
+				Timestamp: ev.Timestamp,
+			}
+		}
+	}
+
+	conversions := make([]domain.Conversion, 0, len(first))
+	for _, c := range first {
+		conversions = append(conversions, c)
+	}
+
+	return conversions, nil
+}
+
+func (e *GoalEvaluator) matchers(ctx context.Context, domain_id uint) ([]*domain.GoalMatcher, error) {
+	e.mu.Lock()
+	cached, ok := e.cache[domain_id]
+	e.mu.Unlock()
+
+	if ok && time.Since(cached.loadedAt) < goalsTTL {
+		return cached.matchers, nil
+	}
+
+	goals, err := e.goals.ByDomain(ctx, domain_id)
+	if err != nil {
+		return nil, err
+	}
+
+	matchers := make([]*domain.GoalMatcher, 0, len(goals))
+	for _, g := range goals {
+		m, err := g.Compile()
+		if err != nil {
+			//цели проверяются при сохранении, сюда попадает только испорченная руками запись
+			e.log.Warn("пропущена некорректная цель", slog.Uint64("goal_id", uint64(g.ID)), sl.Err(err))
+			continue
+		}
+		matchers = append(matchers, m)
+	}
+
+	e.mu.Lock()
+	e.cache[domain_id] = cachedGoals{matchers: matchers, loadedAt: time.Now()}
+	e.mu.Unlock()
+
+	return matchers, nil
+}
@@ -0,0 +1,177 @@
+package analytics
+
+import (
+	"context"
+	"log/slog"
+	domain "metrika/internal/domain/analytics"
+	"metrika/pkg/logger/sl"
+	"sync"
+	"time"
+)
+
+const (
+	//как часто в ленту уходит число просмотров за секунду
+	livePageviewsInterval = time.Second
+	//как часто пересчитывается онлайн доменов, у которых есть подписчики
+	liveOnlineInterval = 5 * time.Second
+	//сколько последних ивентов одной пачки попадает в ленту, остальные только учитываются в счетчиках
+	liveEventsPerBatch = 20
+	//сессия считается активной, если ее ивенты или heartbeat приходили за это время. клиент шлет heartbeat раз в 15с
+	liveActiveWindow = time.Minute
+)
+
+// LiveFeed - живая лента дашборда: прием ивентов и создание сессий публикуют в брокер,
+// а Run раз в интервал досылает своим подписчикам агрегаты(онлайн, просмотры в секунду).
+// просмотры считаются через счетчики брокера, поэтому сумма общая для всех инстансов
+type LiveFeed struct {
+	log      *slog.Logger
+	broker   domain.LiveBroker
+	sessions domain.GuestSessionRepository
+
+	done      chan struct{}
+	closeOnce sync.Once
+}
+
+func NewLiveFeed(log *slog.Logger, broker domain.LiveBroker, sessions domain.GuestSessionRepository) *LiveFeed {
+	return &LiveFeed{
+		log:      log.With(slog.String("component", "usecase/analytics/live_feed")),
+		broker:   broker,
+		sessions: sessions,
+		done:     make(chan struct{}),
+	}
+}
+
+// Close - останавливает Run и сигналит открытым стримам закрыться, иначе они держат graceful shutdown сервера
+func (lf *LiveFeed) Close() {
+	lf.closeOnce.Do(func() { close(lf.done) })
+}
+
+func (lf *LiveFeed) Done() <-chan struct{} {
+	return lf.done
+}
+
+// PublishEvents - считает просмотры пачки, отмечает активность ее сессий на всех инстансах
+// и отправляет последние ивенты подписчикам домена
+func (lf *LiveFeed) PublishEvents(domain_id uint, events []domain.Event) {
+	var views int64
+	seen := make(map[uint]bool)
+	var session_ids []uint
+	for _, e := range events {
+		if e.Type == "pageview" {
+			views++
+		}
+		if !seen[e.SessionID] {
+			seen[e.SessionID] = true
+			session_ids = append(session_ids, e.SessionID)
+		}
+	}
+	if views > 0 {
+		lf.broker.Count(domain_id, domain.LivePageviews, views)
+	}
+	lf.broker.Touch(domain_id, session_ids)
+
+	//heartbeat - служебные ивенты для подсчета вовлеченности, в ленте они только шумят
+	visible := make([]domain.Event, 0, len(events))
+	for _, e := range events {
+		if e.Type != domain.EventHeartbeat {
+			visible = append(visible, e)
+		}
+	}
+
+	if len(visible) > liveEventsPerBatch {
+		visible = visible[len(visible)-liveEventsPerBatch:]
+	}
+	for _, e := range visible {
+		lf.broker.Publish(domain_id, domain.LiveMessage{
+			Type:     domain.LiveEvent,
+			DomainID: domain_id,
+			At:       time.Now(),
+			Data: domain.LiveEventData{
+				SessionID: e.SessionID,
+				Type:      e.Type,
+				PageURL:   e.PageURL,
+				Element:   e.Element,
+				Timestamp: e.Timestamp,
+			},
+		})
+	}
+}
+
+func (lf *LiveFeed) PublishSession(domain_id uint, session domain.GuestSession) {
+	lf.broker.Publish(domain_id, domain.LiveMessage{
+		Type:     domain.LiveSession,
+		DomainID: domain_id,
+		At:       time.Now(),
+		Data:     session,
+	})
+}
+
+func (lf *LiveFeed) Subscribe(domain_id uint) domain.LiveSubscription {
+	return lf.broker.Subscribe(domain_id)
+}
+
+// Snapshot - текущий онлайн домена, отправляется подписчику сразу после подключения
+func (lf *LiveFeed) Snapshot(ctx context.Context, domain_id uint) (domain.LiveMessage, error) {
+	online, err := lf.sessions.GetCountActiveSessions(ctx, domain_id)
+	if err != nil {
+		return domain.LiveMessage{}, err
+	}
+
+	return domain.LiveMessage{
+		Type:     domain.LiveOnline,
+		DomainID: domain_id,
+		At:       time.Now(),
+		Data: domain.LiveOnlineData{
+			Online: online,
+			Active: lf.broker.Active(domain_id, time.Now().Add(-liveActiveWindow)),
+		},
+	}, nil
+}
+
+// Run - публикует агрегаты до отмены ctx или Close
+func (lf *LiveFeed) Run(ctx context.Context) {
+	pageviewsTicker := time.NewTicker(livePageviewsInterval)
+	defer pageviewsTicker.Stop()
+	onlineTicker := time.NewTicker(liveOnlineInterval)
+	defer onlineTicker.Stop()
+
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case <-lf.done:
+			return
+		case <-pageviewsTicker.C:
+			lf.publishPageviews()
+		case <-onlineTicker.C:
+			lf.publishOnline(ctx)
+		}
+	}
+}
+
+func (lf *LiveFeed) publishPageviews() {
+	counts := lf.broker.TakeCounter(domain.LivePageviews)
+
+	now := time.Now()
+	for _, domain_id := range lf.broker.Domains() {
+		lf.broker.Deliver(domain_id, domain.LiveMessage{
+			Type:     domain.LivePageviews,
+			DomainID: domain_id,
+			At:       now,
+			Data:     domain.LivePageviewsData{PerSecond: counts[domain_id]},
+		})
+	}
+}
+
+func (lf *LiveFeed) publishOnline(ctx context.Context) {
+	for _, domain_id := range lf.broker.Domains() {
+		msg, err := lf.Snapshot(ctx, domain_id)
+		if err != nil {
+			if ctx.Err() == nil {
+				lf.log.Error("ошибка подсчета онлайна для живой ленты", slog.Uint64("domain_id", uint64(domain_id)), sl.Err(err))
+			}
+			continue
+		}
+		lf.broker.Deliver(domain_id, msg)
+	}
+}
@@ -0,0 +1,161 @@
+package analytics
+
+import (
+	"context"
+	"errors"
+	domain "metrika/internal/domain/analytics"
+	"sync"
+	"time"
+)
+
+const (
+	//сколько живут скомпилированные правила домена, пока их не перечитаем из базы
+	maskingRulesTTL = time.Minute
+	//через сколько простоя забываем состояние маскирования сессии
+	maskStateIdleTTL   = time.Hour
+	maskStateSweepEach = 10 * time.Minute
+)
+
+type cachedMasker struct {
+	masker   *domain.Masker
+	loadedAt time.Time
+}
+
+type sessionMask struct {
+	mu       sync.Mutex
+	domainID uint
+	state    *domain.MaskState
+	lastUsed time.Time
+}
+
+// RecordMasker - маскирует записи сессий по правилам их домена до сохранения.
+// состояние замаскированных узлов держится в памяти по сессиям, т.к. инкрементальные ивенты
+// ссылаются на узлы из снапшота, пришедшего прошлыми запросами. если снапшот сессии сюда не приходил
+// (рестарт, другой инстанс, состояние забыто по простою), маскируется весь текст до следующего снапшота
+type RecordMasker struct {
+	domains domain.DomainRepository
+
+	mu        sync.Mutex
+	rules     map[uint]cachedMasker
+	sessions  map[uint]*sessionMask
+	lastSweep time.Time
+}
+
+func NewRecordMasker(domains domain.DomainRepository) *RecordMasker {
+	return &RecordMasker{
+		domains:   domains,
+		rules:     make(map[uint]cachedMasker),
+		sessions:  make(map[uint]*sessionMask),
+		lastSweep: time.Now(),
+	}
+}
+
+// Mask - маскирует ивенты сессии на месте
+func (m *RecordMasker) Mask(ctx context.Context, session_id uint, events []domain.RecordEvent) error {
+	session, err := m.session(ctx, session_id)
+	if err != nil {
+		return err
+	}
+
+	masker, err := m.Masker(ctx, session.domainID)
+	if err != nil {
+		return err
+	}
+
+	session.mu.Lock()
+	defer session.mu.Unlock()
+
+	masker.Apply(events, session.state)
+	session.lastUsed = time.Now()
+
+	return nil
+}
+
+// Masker - скомпилированные правила домена, сохраненные или по умолчанию
+func (m *RecordMasker) Masker(ctx context.Context, domain_id uint) (*domain.Masker, error) {
+	m.mu.Lock()
+	cached, ok := m.rules[domain_id]
+	m.mu.Unlock()
+
+	if ok && time.Since(cached.loadedAt) < maskingRulesTTL {
+		return cached.masker, nil
+	}
+
+	rules, err := m.domains.MaskingRules(ctx, domain_id)
+	if err != nil {
+		return nil, err
+	}
+	if rules == nil {
+		defaults := domain.DefaultMaskingRules()
+		rules = &defaults
+	}
+
+	masker, err := rules.Compile()
+	if err != nil {
+		return nil, err
+	}
+
+	m.mu.Lock()
+	m.rules[domain_id] = cachedMasker{masker, time.Now()}
+	m.mu.Unlock()
+
+	return masker, nil
+}
+
+// Invalidate - сбрасывает кэш правил домена после их изменения
+func (m *RecordMasker) Invalidate(domain_id uint) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	delete(m.rules, domain_id)
+}
+
+func (m *RecordMasker) session(ctx context.Context, session_id uint) (*sessionMask, error) {
+	m.mu.Lock()
+	m.sweep()
+	session, ok := m.sessions[session_id]
+	m.mu.Unlock()
+
+	if ok {
+		return session, nil
+	}
+
+	dom, err := m.domains.BySessionID(ctx, session_id)
+	if err != nil {
+		if errors.Is(err, domain.ErrDomainNotFound) {
+			return nil, domain.ErrSessionsNotFound
+		}
+		return nil, err
+	}
+
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	//пока ходили в базу, сессию мог завести параллельный запрос
+	if session, ok := m.sessions[session_id]; ok {
+		return session, nil
+	}
+
+	session = &sessionMask{domainID: dom.ID, state: domain.NewMaskState(), lastUsed: time.Now()}
+	m.sessions[session_id] = session
+
+	return session, nil
+}
+
+// sweep - забывает давно неактивные сессии, вызывается под m.mu
+func (m *RecordMasker) sweep() {
+	if time.Since(m.lastSweep) < maskStateSweepEach {
+		return
+	}
+	m.lastSweep = time.Now()
+
+	for id, session := range m.sessions {
+		if session.mu.TryLock() {
+			idle := time.Since(session.lastUsed) > maskStateIdleTTL
+			session.mu.Unlock()
+			if idle {
+				delete(m.sessions, id)
+			}
+		}
+	}
+}
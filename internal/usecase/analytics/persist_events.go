@@ -0,0 +1,139 @@
+package analytics
+
+import (
+	"context"
+	domain "metrika/internal/domain/analytics"
+	"metrika/internal/domain/tx"
+	"time"
+)
+
+// PersistEventsUseCase - сохраняет пачку ивентов из трекера
+type PersistEventsUseCase struct {
+	events    domain.EventsRepository
+	sessions  domain.GuestSessionRepository
+	goals     domain.GoalRepository
+	evaluator *GoalEvaluator
+	tx        tx.TransactionManager
+}
+
+func NewPersistEventsUseCase(
+	events domain.EventsRepository,
+	sessions domain.GuestSessionRepository,
+	goals domain.GoalRepository,
+	evaluator *GoalEvaluator,
+	tx tx.TransactionManager,
+) *PersistEventsUseCase {
+	return &PersistEventsUseCase{events, sessions, goals, evaluator, tx}
+}
+
+func (uc *PersistEventsUseCase) SaveEvents(ctx context.Context, events *[]domain.Event) error {
+	return uc.tx.WithinTransaction(ctx, func(ctx context.Context) error {
+		if err := uc.events.SaveEvents(ctx, events); err != nil {
+			return err
+		}
+
+		//пачка могла пролежать в спуле долго, поэтому last_active берем
+		//из времени последнего ивента сессии, а не из time.Now
+		lastActive := make(map[uint]time.Time)
+		for _, e := range *events {
+			if e.Timestamp.After(lastActive[e.SessionID]) {
+				lastActive[e.SessionID] = e.Timestamp
+			}
+		}
+
+		if err := uc.sessions.SetLastActive(ctx, lastActive); err != nil {
+			return err
+		}
+
+		//источник сессии определяем по самому раннему pageview в пачке,
+		//репозиторий не перезапишет уже проставленный
+		first := make(map[uint]domain.Event)
+		for _, e := range *events {
+			if e.Type != "pageview" {
+				continue
+			}
+			if prev, ok := first[e.SessionID]; !ok || e.Timestamp.Before(prev.Timestamp) {
+				first[e.SessionID] = e
+			}
+		}
+
+		sources := make(map[uint]domain.SessionSource, len(first))
+		for id, e := range first {
+			sources[id] = domain.SourceFromPageview(e)
+		}
+
+		if err := uc.sessions.SetSources(ctx, sources); err != nil {
+			return err
+		}
+
+		if err := uc.addEngagement(ctx, *events); err != nil {
+			return err
+		}
+
+		session_ids := make([]uint, 0)
+		seen := make(map[uint]bool)
+		for _, e := range *events {
+			if !seen[e.SessionID] {
+				seen[e.SessionID] = true
+				session_ids = append(session_ids, e.SessionID)
+			}
+		}
+
+		domains, err := uc.sessions.DomainsBySessions(ctx, session_ids)
+		if err != nil {
+			return err
+		}
+
+		if err := uc.events.UpdateCatalog(ctx, domain.CatalogFromEvents(domains, *events)); err != nil {
+			return err
+		}
+
+		return uc.addConversions(ctx, domains, *events)
+	})
+}
+
+// addConversions - проверяет ивенты пачки по целям их доменов и сохраняет конверсии.
+// domains - домен каждой сессии пачки
+func (uc *PersistEventsUseCase) addConversions(ctx context.Context, domains map[uint]uint, events []domain.Event) error {
+	conversions, err := uc.evaluator.Evaluate(ctx, domains, events)
+	if err != nil {
+		return err
+	}
+
+	return uc.goals.SaveConversions(ctx, conversions)
+}
+
+// addEngagement - досчитывает вовлеченное время сессий пачки, продолжая с состояния,
+// сохраненного после прошлых пачек
+func (uc *PersistEventsUseCase) addEngagement(ctx context.Context, events []domain.Event) error {
+	bySession := make(map[uint][]domain.Event)
+	for _, e := range events {
+		bySession[e.SessionID] = append(bySession[e.SessionID], e)
+	}
+
+	session_ids := make([]uint, 0, len(bySession))
+	for id := range bySession {
+		session_ids = append(session_ids, id)
+	}
+
+	states, err := uc.sessions.EngagementStates(ctx, session_ids)
+	if err != nil {
+		return err
+	}
+
+	results := make(map[uint]domain.EngagementResult, len(bySession))
+	pageviews := make(map[uint]time.Duration)
+	for id, sessionEvents := range bySession {
+		res := domain.CalculateEngagement(states[id], sessionEvents)
+		results[id] = res
+		for pageview_id, engaged := range res.Pageviews {
+			pageviews[pageview_id] += engaged
+		}
+	}
+
+	if err := uc.sessions.AddEngagement(ctx, results); err != nil {
+		return err
+	}
+
+	return uc.events.AddEngagedTime(ctx, pageviews)
+}
@@ -0,0 +1,25 @@
+package analytics
+
+import (
+	"context"
+	domain "metrika/internal/domain/analytics"
+)
+
+// resolveDomain - находит домен по публичному ключу сайта и проверяет,
+// что запрос пришел именно с этого сайта
+func resolveDomain(ctx context.Context, domains domain.DomainRepository, siteKey, origin string) (*domain.Domain, error) {
+	if siteKey == "" {
+		return nil, domain.ErrDomainNotFound
+	}
+
+	dom, err := domains.BySiteKey(ctx, siteKey)
+	if err != nil {
+		return nil, err
+	}
+
+	if !dom.AllowsOrigin(origin) {
+		return nil, domain.ErrOriginNotAllowed
+	}
+
+	return dom, nil
+}
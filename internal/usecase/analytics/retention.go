@@ -0,0 +1,123 @@
+package analytics
+
+import (
+	"context"
+	"log/slog"
+	domain "metrika/internal/domain/analytics"
+	"metrika/pkg/logger/sl"
+	"sync"
+	"time"
+)
+
+// RetentionUseCase - обслуживание партиций и сроков хранения, запускается по расписанию.
+// партиции создаются заранее на ahead месяцев вперед, партиция удаляется целиком, когда она старше
+// самого долгого срока хранения среди доменов, а данные доменов с более коротким сроком удаляются построчно
+type RetentionUseCase struct {
+	log       *slog.Logger
+	retention domain.RetentionRepository
+	ahead     int
+	batch     int
+
+	running sync.Mutex
+}
+
+func NewRetentionUseCase(log *slog.Logger, retention domain.RetentionRepository, ahead int, batch int) *RetentionUseCase {
+	return &RetentionUseCase{
+		log:       log.With(slog.String("component", "usecase/analytics/retention")),
+		retention: retention,
+		ahead:     ahead,
+		batch:     batch,
+	}
+}
+
+func (uc *RetentionUseCase) Run(ctx context.Context) {
+	if !uc.running.TryLock() {
+		uc.log.Warn("предыдущий проход обслуживания партиций еще не закончился, пропускаем")
+		return
+	}
+	defer uc.running.Unlock()
+
+	now := time.Now()
+
+	for _, data := range domain.PartitionedData {
+		for i := 0; i <= uc.ahead; i++ {
+			month := domain.PartitionMonth(now).AddDate(0, i, 0)
+			if err := uc.retention.CreatePartition(ctx, data, month); err != nil {
+				uc.log.Error("ошибка создания партиции", slog.String("data", data), slog.Time("month", month), sl.Err(err))
+			}
+		}
+	}
+
+	settings, err := uc.retention.Settings(ctx)
+	if err != nil {
+		uc.log.Error("ошибка получения сроков хранения доменов", sl.Err(err))
+		return
+	}
+
+	for _, data := range domain.PartitionedData {
+		if err := uc.dropPartitions(ctx, data, settings, now); err != nil {
+			uc.log.Error("ошибка удаления партиций", slog.String("data", data), sl.Err(err))
+		}
+	}
+
+	//ивенты и записи раньше сессий, на которые они ссылаются
+	for _, data := range []string{domain.RetainedEvents, domain.RetainedReplays, domain.RetainedSessions, domain.RetainedRollups} {
+		for domain_id, s := range settings {
+			if ctx.Err() != nil {
+				return
+			}
+
+			before, ok := domain.RetentionCutoff(s.Days(data), now)
+			if !ok {
+				continue
+			}
+
+			if err := uc.deleteExpired(ctx, domain_id, data, before); err != nil {
+				uc.log.Error("ошибка удаления устаревших данных",
+					slog.Uint64("domain_id", uint64(domain_id)),
+					slog.String("data", data),
+					sl.Err(err),
+				)
+			}
+		}
+	}
+}
+
+func (uc *RetentionUseCase) dropPartitions(ctx context.Context, data string, settings map[uint]domain.RetentionSettings, now time.Time) error {
+	days, ok := domain.LongestRetention(settings, data)
+	if !ok {
+		return nil
+	}
+	before, _ := domain.RetentionCutoff(days, now)
+
+	partitions, err := uc.retention.Partitions(ctx, data)
+	if err != nil {
+		return err
+	}
+
+	for _, p := range partitions {
+		if p.To.After(before) {
+			break
+		}
+		if err := uc.retention.DropPartition(ctx, p); err != nil {
+			return err
+		}
+		uc.log.Info("партиция удалена", slog.String("data", data), slog.String("partition", p.Name))
+	}
+
+	return nil
+}
+
+// deleteExpired - удаляет пачками по batch строк, чтобы не держать долгих блокировок
+func (uc *RetentionUseCase) deleteExpired(ctx context.Context, domain_id uint, data string, before time.Time) error {
+	for ctx.Err() == nil {
+		deleted, err := uc.retention.DeleteExpired(ctx, domain_id, data, before, uc.batch)
+		if err != nil {
+			return err
+		}
+		if deleted < int64(uc.batch) {
+			return nil
+		}
+	}
+	return ctx.Err()
+}
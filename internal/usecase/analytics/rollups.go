@@ -0,0 +1,107 @@
+package analytics
+
+import (
+	"context"
+	"log/slog"
+	domain "metrika/internal/domain/analytics"
+	"metrika/internal/domain/tx"
+	"metrika/pkg/logger/sl"
+	"sync"
+	"time"
+)
+
+// RollupsUseCase - ведет часовые и дневные роллапы доменов, запускается по расписанию.
+// каждый домен и гранулярность пересчитываются в своей транзакции, ошибка одного домена не останавливает остальные
+type RollupsUseCase struct {
+	log      *slog.Logger
+	rollups  domain.RollupRepository
+	tx       tx.TransactionManager
+	lookback time.Duration
+	batch    int
+
+	//проход может не успеть до следующего запуска по расписанию
+	running sync.Mutex
+}
+
+func NewRollupsUseCase(log *slog.Logger, rollups domain.RollupRepository, tx tx.TransactionManager, lookback time.Duration, batch int) *RollupsUseCase {
+	return &RollupsUseCase{
+		log:      log.With(slog.String("component", "usecase/analytics/rollups")),
+		rollups:  rollups,
+		tx:       tx,
+		lookback: lookback,
+		batch:    batch,
+	}
+}
+
+func (uc *RollupsUseCase) Run(ctx context.Context) {
+	if !uc.running.TryLock() {
+		uc.log.Warn("предыдущий проход роллапов еще не закончился, пропускаем")
+		return
+	}
+	defer uc.running.Unlock()
+
+	targets, err := uc.rollups.Targets(ctx)
+	if err != nil {
+		uc.log.Error("ошибка получения доменов для роллапов", sl.Err(err))
+		return
+	}
+
+	for _, granularity := range []string{domain.RollupHour, domain.RollupDay} {
+		states, err := uc.rollups.States(ctx, granularity)
+		if err != nil {
+			uc.log.Error("ошибка получения состояния роллапов", slog.String("granularity", granularity), sl.Err(err))
+			continue
+		}
+
+		for _, target := range targets {
+			if ctx.Err() != nil {
+				return
+			}
+
+			var state *domain.RollupState
+			if s, ok := states[target.DomainID]; ok {
+				state = &s
+			}
+
+			if err := uc.rollup(ctx, granularity, target, state); err != nil {
+				uc.log.Error("ошибка пересчета роллапов",
+					slog.Uint64("domain_id", uint64(target.DomainID)),
+					slog.String("granularity", granularity),
+					sl.Err(err),
+				)
+			}
+		}
+	}
+}
+
+func (uc *RollupsUseCase) rollup(ctx context.Context, granularity string, target domain.RollupTarget, state *domain.RollupState) error {
+	r, ok := domain.PlanRollup(granularity, target, state, time.Now(), uc.lookback, uc.batch)
+	if !ok {
+		return nil
+	}
+
+	return uc.tx.WithinTransaction(ctx, func(ctx context.Context) error {
+		until := r.EndDate
+		if state != nil {
+			//сутки домена теперь режутся в другом поясе, старые дневные роллапы не подходят
+			if state.Timezone != r.Timezone {
+				if err := uc.rollups.Reset(ctx, r.DomainID, granularity); err != nil {
+					return err
+				}
+			} else if state.RolledUntil.After(until) {
+				until = state.RolledUntil
+			}
+		}
+
+		if err := uc.rollups.Rebuild(ctx, r); err != nil {
+			return err
+		}
+
+		return uc.rollups.SaveState(ctx, domain.RollupState{
+			DomainID:    r.DomainID,
+			Granularity: granularity,
+			Timezone:    r.Timezone,
+			RolledUntil: until,
+		})
+	})
+}
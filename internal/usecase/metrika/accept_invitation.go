@@ -0,0 +1,56 @@
+package metrika
+
+import (
+	"context"
+	domain "metrika/internal/domain/analytics"
+	"metrika/internal/domain/tx"
+	"strings"
+	"time"
+)
+
+type AcceptInvitationUseCase struct {
+	members domain.MembersRepository
+	tx      tx.TransactionManager
+}
+
+func NewAcceptInvitationUseCase(members domain.MembersRepository, tx tx.TransactionManager) *AcceptInvitationUseCase {
+	return &AcceptInvitationUseCase{members, tx}
+}
+
+// Execute - добавляет юзера в команду домена по токену приглашения.
+// принять приглашение может только юзер с той почтой, на которую оно отправлено
+func (uc *AcceptInvitationUseCase) Execute(ctx context.Context, token string, user_id uint, email string) (*domain.DomainMember, error) {
+	var member *domain.DomainMember
+
+	if err := uc.tx.WithinTransaction(ctx, func(ctx context.Context) error {
+		invitation, err := uc.members.InvitationByToken(ctx, token)
+		if err != nil {
+			return err
+		}
+
+		if invitation.Expired(time.Now()) {
+			return domain.ErrInvitationExpired
+		}
+
+		if !strings.EqualFold(invitation.Email, email) {
+			return domain.ErrInvitationEmailMismatch
+		}
+
+		member = &domain.DomainMember{
+			DomainID: invitation.DomainID,
+			UserID:   user_id,
+			Email:    email,
+			Role:     invitation.Role,
+		}
+
+		if err := uc.members.Add(ctx, member); err != nil {
+			return err
+		}
+
+		return uc.members.DeleteInvitation(ctx, invitation.DomainID, invitation.ID)
+	}); err != nil {
+		return nil, err
+	}
+
+	return member, nil
+}
@@ -0,0 +1,61 @@
+package metrika
+
+import (
+	"context"
+	domain "metrika/internal/domain/analytics"
+	"time"
+)
+
+type CohortsReportUseCase struct {
+	guests  domain.GuestsRepository
+	domains domain.DomainRepository
+}
+
+func NewCohortsReportUseCase(guests domain.GuestsRepository, domains domain.DomainRepository) *CohortsReportUseCase {
+	return &CohortsReportUseCase{guests, domains}
+}
+
+// Execute - матрица удержания гостей, сгруппированных по периоду первого визита.
+// периоды считаются в часовом поясе домена из его настроек сессий
+func (uc *CohortsReportUseCase) Execute(ctx context.Context, opts domain.CohortOptions, start_date *time.Time, end_date *time.Time) (*domain.CohortReport, error) {
+	dom, err := uc.domains.ByID(ctx, opts.DomainID)
+	if err != nil {
+		return nil, err
+	}
+	opts.Timezone = dom.SessionSettings.Timezone
+	if opts.Timezone == "" {
+		opts.Timezone = domain.DefaultSessionSettings().Timezone
+	}
+
+	if opts.Period == "" {
+		opts.Period = domain.CohortByWeek
+	}
+
+	if opts.Periods <= 0 {
+		opts.Periods = domain.CohortDefaultPeriods
+	}
+	if opts.Periods > domain.CohortMaxPeriods {
+		opts.Periods = domain.CohortMaxPeriods
+	}
+
+	now := time.Now()
+
+	opts.EndDate = now
+	if end_date != nil {
+		opts.EndDate = *end_date
+	}
+
+	//по умолчанию столько когорт, сколько периодов удержания
+	opts.StartDate = opts.AddPeriods(opts.EndDate, -opts.Periods)
+	if start_date != nil {
+		opts.StartDate = *start_date
+	}
+
+	cells, err := uc.guests.Cohorts(ctx, opts)
+	if err != nil {
+		return nil, err
+	}
+
+	report := domain.BuildCohortReport(opts, cells, now)
+	return &report, nil
+}
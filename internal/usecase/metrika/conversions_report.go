@@ -0,0 +1,48 @@
+package metrika
+
+import (
+	"context"
+	domain "metrika/internal/domain/analytics"
+	"metrika/pkg/pointers"
+	"time"
+)
+
+type ConversionsReportUseCase struct {
+	goals domain.GoalRepository
+}
+
+func NewConversionsReportUseCase(goals domain.GoalRepository) *ConversionsReportUseCase {
+	return &ConversionsReportUseCase{goals}
+}
+
+func (uc *ConversionsReportUseCase) Execute(ctx context.Context, opts domain.ConversionsReportOptions) (*domain.ConversionsReport, error) {
+	if opts.GoalID != nil {
+		//цель другого домена отдаем как несуществующую
+		if _, err := uc.goals.ByID(ctx, opts.DomainID, *opts.GoalID); err != nil {
+			return nil, err
+		}
+	}
+
+	if opts.EndDate == nil {
+		opts.EndDate = pointers.NewTimePointer(time.Now())
+	}
+
+	if opts.StartDate == nil {
+		opts.StartDate = pointers.NewTimePointer(opts.EndDate.Add(-defaultReportPeriod))
+	}
+
+	if opts.Interval == "" {
+		opts.Interval = domain.ConversionsByDay
+	}
+
+	if opts.GroupBy == "" {
+		opts.GroupBy = domain.SourcesGroupBySource
+	}
+
+	//max 100
+	if opts.Limit == nil || *opts.Limit > 100 || *opts.Limit <= 0 {
+		opts.Limit = pointers.NewIntPointer(100)
+	}
+
+	return uc.goals.ConversionsReport(ctx, opts)
+}
@@ -4,8 +4,12 @@ import (
 	"context"
 	"log/slog"
 	"metrika/internal/domain/analytics"
+	"time"
 )
 
+// сколько значений каждого разреза отдавать по умолчанию
+const defaultOnlineBreakdownLimit = 10
+
 type ActiveSessionsUseCase struct {
 	log      *slog.Logger
 	sessions analytics.GuestSessionRepository
@@ -18,14 +22,42 @@ func NewAciveSessionsUseCase(log *slog.Logger, sessions analytics.GuestSessionRe
 	}
 }
 
-func (uc *ActiveSessionsUseCase) Execute(ctx context.Context, domain_id uint) (int64, error) {
+// Execute - онлайн домена с разбивкой по текущей странице, источнику, устройству и стране
+// и поминутным спарклайном за последние analytics.OnlineSparklineWindow
+func (uc *ActiveSessionsUseCase) Execute(ctx context.Context, domain_id uint, limit *int) (*analytics.OnlineNow, error) {
+	breakdownLimit := defaultOnlineBreakdownLimit
+	if limit != nil && *limit > 0 {
+		breakdownLimit = *limit
+	}
+
 	count, err := uc.sessions.GetCountActiveSessions(ctx, domain_id)
 	if err != nil {
-		if err == analytics.ErrSessionsNotFound {
-			return 0, nil
+		return nil, err
+	}
+
+	online := analytics.OnlineNow{Online: count}
+
+	breakdowns := []struct {
+		by   string
+		dest *[]analytics.OnlineBreakdownItem
+	}{
+		{analytics.OnlineByPage, &online.Pages},
+		{analytics.OnlineBySource, &online.Sources},
+		{analytics.OnlineByDevice, &online.Devices},
+		{analytics.OnlineByCountry, &online.Countries},
+	}
+	for _, b := range breakdowns {
+		items, err := uc.sessions.OnlineBreakdown(ctx, domain_id, b.by, breakdownLimit)
+		if err != nil {
+			return nil, err
 		}
-		return 0, err
+		*b.dest = items
+	}
+
+	online.Sparkline, err = uc.sessions.OnlineSparkline(ctx, domain_id, time.Now().Add(-analytics.OnlineSparklineWindow))
+	if err != nil {
+		return nil, err
 	}
 
-	return count, nil
+	return &online, nil
 }
@@ -0,0 +1,65 @@
+package metrika
+
+import (
+	"context"
+	"log/slog"
+	domain "metrika/internal/domain/analytics"
+	"metrika/internal/domain/tx"
+	"metrika/pkg/logger/sl"
+)
+
+type CreateDomainUseCase struct {
+	log     *slog.Logger
+	domains domain.DomainRepository
+	members domain.MembersRepository
+	tx      tx.TransactionManager
+}
+
+func NewCreateDomainUseCase(
+	log *slog.Logger,
+	domains domain.DomainRepository,
+	members domain.MembersRepository,
+	tx tx.TransactionManager,
+) *CreateDomainUseCase {
+	return &CreateDomainUseCase{log, domains, members, tx}
+}
+
+func (uc *CreateDomainUseCase) Execute(ctx context.Context, owner_id uint, site_url, name string) (*domain.Domain, error) {
+	site_key, err := domain.GenerateSiteKey()
+	if err != nil {
+		uc.log.Error("ошибка генерации ключа сайта", sl.Err(err))
+		return nil, err
+	}
+
+	dom := domain.Domain{
+		OwnerID: owner_id,
+		Name:    name,
+		SiteURL: site_url,
+		SiteKey: site_key,
+
+		SessionSettings: domain.DefaultSessionSettings(),
+		Retention:       domain.DefaultRetentionSettings(),
+	}
+
+	//если имя не указано - называем домен по хосту сайта
+	if dom.Name == "" {
+		dom.Name = dom.Host()
+	}
+
+	if err := uc.tx.WithinTransaction(ctx, func(ctx context.Context) error {
+		if err := uc.domains.Create(ctx, &dom); err != nil {
+			return err
+		}
+
+		//создатель домена становится его владельцем в команде
+		return uc.members.Add(ctx, &domain.DomainMember{
+			DomainID: dom.ID,
+			UserID:   owner_id,
+			Role:     domain.RoleOwner,
+		})
+	}); err != nil {
+		return nil, err
+	}
+
+	return &dom, nil
+}
@@ -0,0 +1,23 @@
+package metrika
+
+import (
+	"context"
+	domain "metrika/internal/domain/analytics"
+	"metrika/internal/domain/tx"
+)
+
+type DeleteDomainUseCase struct {
+	domains domain.DomainRepository
+	tx      tx.TransactionManager
+}
+
+func NewDeleteDomainUseCase(domains domain.DomainRepository, tx tx.TransactionManager) *DeleteDomainUseCase {
+	return &DeleteDomainUseCase{domains, tx}
+}
+
+// Execute - удаляет домен со всеми собранными по нему данными
+func (uc *DeleteDomainUseCase) Execute(ctx context.Context, domain_id uint) error {
+	return uc.tx.WithinTransaction(ctx, func(ctx context.Context) error {
+		return uc.domains.Delete(ctx, domain_id)
+	})
+}
@@ -0,0 +1,67 @@
+package metrika
+
+import (
+	"context"
+	domain "metrika/internal/domain/analytics"
+	"time"
+)
+
+type ExploreEventsUseCase struct {
+	events domain.EventsRepository
+}
+
+func NewExploreEventsUseCase(events domain.EventsRepository) *ExploreEventsUseCase {
+	return &ExploreEventsUseCase{events}
+}
+
+// Execute - ряды ивентов типа с фильтрами и группировкой по свойствам
+func (uc *ExploreEventsUseCase) Execute(ctx context.Context, opts domain.ExplorerOptions, start_date *time.Time, end_date *time.Time) (*domain.ExplorerResult, error) {
+	opts.EndDate = time.Now()
+	if end_date != nil {
+		opts.EndDate = *end_date
+	}
+	opts.StartDate = opts.EndDate.Add(-defaultReportPeriod)
+	if start_date != nil {
+		opts.StartDate = *start_date
+	}
+
+	if opts.Interval == "" {
+		opts.Interval = domain.ExplorerByDay
+	}
+	if opts.Groups <= 0 {
+		opts.Groups = domain.ExplorerDefaultGroups
+	}
+	if opts.Groups > domain.ExplorerMaxGroups {
+		opts.Groups = domain.ExplorerMaxGroups
+	}
+
+	if err := opts.Validate(); err != nil {
+		return nil, err
+	}
+
+	rows, err := uc.events.Explore(ctx, opts)
+	if err != nil {
+		return nil, err
+	}
+
+	result := domain.BuildExplorerResult(opts, rows)
+	return &result, nil
+}
+
+type EventCatalogUseCase struct {
+	events domain.EventsRepository
+}
+
+func NewEventCatalogUseCase(events domain.EventsRepository) *EventCatalogUseCase {
+	return &EventCatalogUseCase{events}
+}
+
+// Execute - типы ивентов домена и их свойства, встречавшиеся при приеме
+func (uc *EventCatalogUseCase) Execute(ctx context.Context, domain_id uint) ([]domain.EventCatalogType, error) {
+	entries, err := uc.events.Catalog(ctx, domain_id)
+	if err != nil {
+		return nil, err
+	}
+
+	return domain.BuildEventCatalog(entries), nil
+}
@@ -0,0 +1,117 @@
+package metrika
+
+import (
+	"context"
+	domain "metrika/internal/domain/analytics"
+	"time"
+)
+
+type GetFunnelsUseCase struct {
+	funnels domain.FunnelRepository
+}
+
+func NewGetFunnelsUseCase(funnels domain.FunnelRepository) *GetFunnelsUseCase {
+	return &GetFunnelsUseCase{funnels}
+}
+
+func (uc *GetFunnelsUseCase) Execute(ctx context.Context, domain_id uint) ([]domain.Funnel, error) {
+	return uc.funnels.ByDomain(ctx, domain_id)
+}
+
+type CreateFunnelUseCase struct {
+	funnels domain.FunnelRepository
+}
+
+func NewCreateFunnelUseCase(funnels domain.FunnelRepository) *CreateFunnelUseCase {
+	return &CreateFunnelUseCase{funnels}
+}
+
+func (uc *CreateFunnelUseCase) Execute(ctx context.Context, funnel domain.Funnel) (*domain.Funnel, error) {
+	funnel = withFunnelDefaults(funnel)
+	if err := funnel.Validate(); err != nil {
+		return nil, err
+	}
+
+	if err := uc.funnels.Create(ctx, &funnel); err != nil {
+		return nil, err
+	}
+
+	return &funnel, nil
+}
+
+type UpdateFunnelUseCase struct {
+	funnels domain.FunnelRepository
+}
+
+func NewUpdateFunnelUseCase(funnels domain.FunnelRepository) *UpdateFunnelUseCase {
+	return &UpdateFunnelUseCase{funnels}
+}
+
+func (uc *UpdateFunnelUseCase) Execute(ctx context.Context, funnel domain.Funnel) (*domain.Funnel, error) {
+	funnel = withFunnelDefaults(funnel)
+	if err := funnel.Validate(); err != nil {
+		return nil, err
+	}
+
+	if err := uc.funnels.Update(ctx, &funnel); err != nil {
+		return nil, err
+	}
+
+	return uc.funnels.ByID(ctx, funnel.DomainID, funnel.ID)
+}
+
+type DeleteFunnelUseCase struct {
+	funnels domain.FunnelRepository
+}
+
+func NewDeleteFunnelUseCase(funnels domain.FunnelRepository) *DeleteFunnelUseCase {
+	return &DeleteFunnelUseCase{funnels}
+}
+
+func (uc *DeleteFunnelUseCase) Execute(ctx context.Context, domain_id uint, funnel_id uint) error {
+	return uc.funnels.Delete(ctx, domain_id, funnel_id)
+}
+
+func withFunnelDefaults(funnel domain.Funnel) domain.Funnel {
+	if funnel.Scope == "" {
+		funnel.Scope = domain.FunnelScopeSession
+	}
+	if funnel.WindowMinutes == 0 {
+		funnel.WindowMinutes = domain.FunnelDefaultWindow
+	}
+	return funnel
+}
+
+type FunnelReportUseCase struct {
+	funnels domain.FunnelRepository
+	events  domain.EventsRepository
+}
+
+func NewFunnelReportUseCase(funnels domain.FunnelRepository, events domain.EventsRepository) *FunnelReportUseCase {
+	return &FunnelReportUseCase{funnels, events}
+}
+
+// Execute - прохождение воронки за период: количество на каждом шаге, отвал и медиана времени между шагами
+func (uc *FunnelReportUseCase) Execute(ctx context.Context, domain_id uint, funnel_id uint, start_date *time.Time, end_date *time.Time) (*domain.FunnelReport, error) {
+	funnel, err := uc.funnels.ByID(ctx, domain_id, funnel_id)
+	if err != nil {
+		return nil, err
+	}
+
+	opts := domain.FunnelQueryOptions{Funnel: *funnel, EndDate: time.Now()}
+	if end_date != nil {
+		opts.EndDate = *end_date
+	}
+	opts.StartDate = opts.EndDate.Add(-defaultReportPeriod)
+	if start_date != nil {
+		opts.StartDate = *start_date
+	}
+
+	counts, err := uc.events.Funnel(ctx, opts)
+	if err != nil {
+		return nil, err
+	}
+
+	report := domain.BuildFunnelReport(opts, counts)
+	return &report, nil
+}
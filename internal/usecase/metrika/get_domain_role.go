@@ -0,0 +1,28 @@
+package metrika
+
+import (
+	"context"
+	"errors"
+	domain "metrika/internal/domain/analytics"
+)
+
+type GetDomainRoleUseCase struct {
+	members domain.MembersRepository
+}
+
+func NewGetDomainRoleUseCase(members domain.MembersRepository) *GetDomainRoleUseCase {
+	return &GetDomainRoleUseCase{members}
+}
+
+// Execute - роль юзера дашборда в домене. если юзер не состоит в команде домена - доступа нет
+func (uc *GetDomainRoleUseCase) Execute(ctx context.Context, domain_id uint, user_id uint) (domain.Role, error) {
+	role, err := uc.members.Role(ctx, domain_id, user_id)
+	if err != nil {
+		if errors.Is(err, domain.ErrMemberNotFound) {
+			return "", domain.ErrDomainAccessDenied
+		}
+		return "", err
+	}
+
+	return role, nil
+}
@@ -0,0 +1,18 @@
+package metrika
+
+import (
+	"context"
+	domain "metrika/internal/domain/analytics"
+)
+
+type GetDomainsUseCase struct {
+	domains domain.DomainRepository
+}
+
+func NewGetDomainsUseCase(domains domain.DomainRepository) *GetDomainsUseCase {
+	return &GetDomainsUseCase{domains}
+}
+
+func (uc *GetDomainsUseCase) Execute(ctx context.Context, user_id uint) ([]domain.Domain, error) {
+	return uc.domains.ByUser(ctx, user_id)
+}
@@ -0,0 +1,29 @@
+package metrika
+
+import (
+	"context"
+	domain "metrika/internal/domain/analytics"
+)
+
+type GetMembersUseCase struct {
+	members domain.MembersRepository
+}
+
+func NewGetMembersUseCase(members domain.MembersRepository) *GetMembersUseCase {
+	return &GetMembersUseCase{members}
+}
+
+// Execute - команда домена и приглашения, которые еще не приняты
+func (uc *GetMembersUseCase) Execute(ctx context.Context, domain_id uint) ([]domain.DomainMember, []domain.DomainInvitation, error) {
+	members, err := uc.members.ByDomain(ctx, domain_id)
+	if err != nil {
+		return nil, nil, err
+	}
+
+	invitations, err := uc.members.InvitationsByDomain(ctx, domain_id)
+	if err != nil {
+		return nil, nil, err
+	}
+
+	return members, invitations, nil
+}
@@ -0,0 +1,95 @@
+package metrika
+
+import (
+	"context"
+	domain "metrika/internal/domain/analytics"
+	"metrika/internal/domain/tx"
+)
+
+// GoalsInvalidator - сброс закэшированных целей у приема ивентов
+type GoalsInvalidator interface {
+	Invalidate(domain_id uint)
+}
+
+type GetGoalsUseCase struct {
+	goals domain.GoalRepository
+}
+
+func NewGetGoalsUseCase(goals domain.GoalRepository) *GetGoalsUseCase {
+	return &GetGoalsUseCase{goals}
+}
+
+func (uc *GetGoalsUseCase) Execute(ctx context.Context, domain_id uint) ([]domain.Goal, error) {
+	return uc.goals.ByDomain(ctx, domain_id)
+}
+
+type CreateGoalUseCase struct {
+	goals       domain.GoalRepository
+	invalidator GoalsInvalidator
+}
+
+func NewCreateGoalUseCase(goals domain.GoalRepository, invalidator GoalsInvalidator) *CreateGoalUseCase {
+	return &CreateGoalUseCase{goals, invalidator}
+}
+
+func (uc *CreateGoalUseCase) Execute(ctx context.Context, goal domain.Goal) (*domain.Goal, error) {
+	if _, err := goal.Compile(); err != nil {
+		return nil, err
+	}
+
+	if err := uc.goals.Create(ctx, &goal); err != nil {
+		return nil, err
+	}
+
+	uc.invalidator.Invalidate(goal.DomainID)
+
+	return &goal, nil
+}
+
+type UpdateGoalUseCase struct {
+	goals       domain.GoalRepository
+	invalidator GoalsInvalidator
+}
+
+func NewUpdateGoalUseCase(goals domain.GoalRepository, invalidator GoalsInvalidator) *UpdateGoalUseCase {
+	return &UpdateGoalUseCase{goals, invalidator}
+}
+
+// Execute - меняет условия цели. уже сохраненные конверсии не пересчитываются
+func (uc *UpdateGoalUseCase) Execute(ctx context.Context, goal domain.Goal) (*domain.Goal, error) {
+	if _, err := goal.Compile(); err != nil {
+		return nil, err
+	}
+
+	if err := uc.goals.Update(ctx, &goal); err != nil {
+		return nil, err
+	}
+
+	uc.invalidator.Invalidate(goal.DomainID)
+
+	return uc.goals.ByID(ctx, goal.DomainID, goal.ID)
+}
+
+type DeleteGoalUseCase struct {
+	goals       domain.GoalRepository
+	invalidator GoalsInvalidator
+	tx          tx.TransactionManager
+}
+
+func NewDeleteGoalUseCase(goals domain.GoalRepository, invalidator GoalsInvalidator, tx tx.TransactionManager) *DeleteGoalUseCase {
+	return &DeleteGoalUseCase{goals, invalidator, tx}
+}
+
+// Execute - удаляет цель вместе с ее конверсиями
+func (uc *DeleteGoalUseCase) Execute(ctx context.Context, domain_id uint, goal_id uint) error {
+	err := uc.tx.WithinTransaction(ctx, func(ctx context.Context) error {
+		return uc.goals.Delete(ctx, domain_id, goal_id)
+	})
+	if err != nil {
+		return err
+	}
+
+	uc.invalidator.Invalidate(domain_id)
+
+	return nil
+}
@@ -0,0 +1,59 @@
+package metrika
+
+import (
+	"context"
+	domain "metrika/internal/domain/analytics"
+	"metrika/pkg/pointers"
+	"time"
+)
+
+const (
+	defaultHeatmapGrid      = 50
+	maxHeatmapGrid          = 200
+	defaultHeatmapSelectors = 50
+)
+
+type HeatmapUseCase struct {
+	events domain.EventsRepository
+}
+
+func NewHeatmapUseCase(events domain.EventsRepository) *HeatmapUseCase {
+	return &HeatmapUseCase{events}
+}
+
+// Execute - клики по одной странице за период. device задает диапазон ширины вьюпорта,
+// явные min/max ширины его сужают
+func (uc *HeatmapUseCase) Execute(ctx context.Context, opts domain.HeatmapOptions, device domain.DeviceClass) (*domain.Heatmap, error) {
+	opts.PagePath = domain.NormalizePagePath(opts.PagePath)
+
+	if opts.EndDate == nil {
+		opts.EndDate = pointers.NewTimePointer(time.Now())
+	}
+
+	if opts.StartDate == nil {
+		opts.StartDate = pointers.NewTimePointer(opts.EndDate.Add(-defaultReportPeriod))
+	}
+
+	if opts.GridSize <= 0 {
+		opts.GridSize = defaultHeatmapGrid
+	}
+	if opts.GridSize > maxHeatmapGrid {
+		opts.GridSize = maxHeatmapGrid
+	}
+
+	if opts.SelectorsLimit <= 0 {
+		opts.SelectorsLimit = defaultHeatmapSelectors
+	}
+
+	if device != "" {
+		min, max := device.WidthRange()
+		if opts.MinWidth == nil || *opts.MinWidth < min {
+			opts.MinWidth = pointers.NewIntPointer(min)
+		}
+		if max > 0 && (opts.MaxWidth == nil || *opts.MaxWidth > max) {
+			opts.MaxWidth = pointers.NewIntPointer(max)
+		}
+	}
+
+	return uc.events.Heatmap(ctx, opts)
+}
@@ -0,0 +1,21 @@
+package metrika
+
+import (
+	domain "metrika/internal/domain/analytics"
+)
+
+type IngestionStatsProvider interface {
+	Stats() domain.IngestionStats
+}
+
+type IngestionStatsUseCase struct {
+	tracker IngestionStatsProvider
+}
+
+func NewIngestionStatsUseCase(tracker IngestionStatsProvider) *IngestionStatsUseCase {
+	return &IngestionStatsUseCase{tracker}
+}
+
+func (uc *IngestionStatsUseCase) Execute() domain.IngestionStats {
+	return uc.tracker.Stats()
+}
@@ -0,0 +1,47 @@
+package metrika
+
+import (
+	"context"
+	"log/slog"
+	domain "metrika/internal/domain/analytics"
+	"metrika/pkg/logger/sl"
+	"strings"
+	"time"
+)
+
+type InviteMemberUseCase struct {
+	log     *slog.Logger
+	members domain.MembersRepository
+}
+
+func NewInviteMemberUseCase(log *slog.Logger, members domain.MembersRepository) *InviteMemberUseCase {
+	return &InviteMemberUseCase{log, members}
+}
+
+// Execute - создает приглашение в команду домена. владельца через приглашение назначить нельзя
+func (uc *InviteMemberUseCase) Execute(ctx context.Context, domain_id uint, invited_by uint, email string, role domain.Role) (*domain.DomainInvitation, error) {
+	if !role.Valid() || role == domain.RoleOwner {
+		return nil, domain.ErrInvalidRole
+	}
+
+	token, err := domain.GenerateInvitationToken()
+	if err != nil {
+		uc.log.Error("ошибка генерации токена приглашения", sl.Err(err))
+		return nil, err
+	}
+
+	invitation := domain.DomainInvitation{
+		DomainID:  domain_id,
+		Email:     strings.ToLower(strings.TrimSpace(email)),
+		Role:      role,
+		Token:     token,
+		InvitedBy: invited_by,
+		ExpiresAt: time.Now().Add(domain.InvitationTTL),
+	}
+
+	if err := uc.members.CreateInvitation(ctx, &invitation); err != nil {
+		return nil, err
+	}
+
+	return &invitation, nil
+}
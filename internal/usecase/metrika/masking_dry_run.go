@@ -0,0 +1,91 @@
+package metrika
+
+import (
+	"context"
+	domain "metrika/internal/domain/analytics"
+)
+
+const (
+	defaultDryRunEvents = 200
+	maxDryRunEvents     = 2000
+)
+
+type DryRunMaskingOptions struct {
+	DomainID uint
+	//правила для проверки, nil - текущие правила домена
+	Rules *domain.MaskingRules
+	//ивенты из запроса, либо начало записи сессии SessionID
+	Events    []domain.RecordEvent
+	SessionID *uint
+	Limit     int
+}
+
+type DryRunMaskingResult struct {
+	Events   []domain.RecordEvent `json:"events"`
+	Findings []domain.MaskFinding `json:"findings"`
+}
+
+// DryRunMaskingUseCase - показывает, что будет замаскировано, ничего не сохраняя
+type DryRunMaskingUseCase struct {
+	rules    *GetMaskingRulesUseCase
+	records  domain.RecordEventRepository
+	sessions domain.GuestSessionRepository
+}
+
+func NewDryRunMaskingUseCase(rules *GetMaskingRulesUseCase, records domain.RecordEventRepository, sessions domain.GuestSessionRepository) *DryRunMaskingUseCase {
+	return &DryRunMaskingUseCase{rules, records, sessions}
+}
+
+func (uc *DryRunMaskingUseCase) Execute(ctx context.Context, opts DryRunMaskingOptions) (*DryRunMaskingResult, error) {
+	rules := opts.Rules
+	if rules == nil {
+		current, _, err := uc.rules.Execute(ctx, opts.DomainID)
+		if err != nil {
+			return nil, err
+		}
+		rules = &current
+	}
+
+	masker, err := rules.Compile()
+	if err != nil {
+		return nil, err
+	}
+
+	events := opts.Events
+	if opts.SessionID != nil {
+		ids, err := uc.sessions.FilterByDomain(ctx, opts.DomainID, []uint{*opts.SessionID})
+		if err != nil {
+			return nil, err
+		}
+		if len(ids) == 0 {
+			return nil, domain.ErrSessionsNotFound
+		}
+
+		if opts.Limit <= 0 {
+			opts.Limit = defaultDryRunEvents
+		}
+		if opts.Limit > maxDryRunEvents {
+			opts.Limit = maxDryRunEvents
+		}
+
+		//старые записи могли сохраниться до появления правил, поэтому прогоняем их заново
+		events, err = uc.records.Window(ctx, domain.ReplayWindowOptions{
+			SessionID: *opts.SessionID,
+			Inclusive: true,
+			Limit:     opts.Limit,
+		})
+		if err != nil {
+			return nil, err
+		}
+	}
+
+	findings := masker.Apply(events, domain.NewMaskState())
+	if findings == nil {
+		findings = []domain.MaskFinding{}
+	}
+	if events == nil {
+		events = []domain.RecordEvent{}
+	}
+
+	return &DryRunMaskingResult{Events: events, Findings: findings}, nil
+}
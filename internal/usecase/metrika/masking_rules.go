@@ -0,0 +1,54 @@
+package metrika
+
+import (
+	"context"
+	domain "metrika/internal/domain/analytics"
+)
+
+// MaskingRulesInvalidator - сброс закэшированных правил у приема записей
+type MaskingRulesInvalidator interface {
+	Invalidate(domain_id uint)
+}
+
+type GetMaskingRulesUseCase struct {
+	domains domain.DomainRepository
+}
+
+func NewGetMaskingRulesUseCase(domains domain.DomainRepository) *GetMaskingRulesUseCase {
+	return &GetMaskingRulesUseCase{domains}
+}
+
+// Execute - правила домена и признак, что они настроены, а не взяты по умолчанию
+func (uc *GetMaskingRulesUseCase) Execute(ctx context.Context, domain_id uint) (domain.MaskingRules, bool, error) {
+	rules, err := uc.domains.MaskingRules(ctx, domain_id)
+	if err != nil {
+		return domain.MaskingRules{}, false, err
+	}
+	if rules == nil {
+		return domain.DefaultMaskingRules(), false, nil
+	}
+	return *rules, true, nil
+}
+
+type UpdateMaskingRulesUseCase struct {
+	domains     domain.DomainRepository
+	invalidator MaskingRulesInvalidator
+}
+
+func NewUpdateMaskingRulesUseCase(domains domain.DomainRepository, invalidator MaskingRulesInvalidator) *UpdateMaskingRulesUseCase {
+	return &UpdateMaskingRulesUseCase{domains, invalidator}
+}
+
+func (uc *UpdateMaskingRulesUseCase) Execute(ctx context.Context, domain_id uint, rules domain.MaskingRules) error {
+	if _, err := rules.Compile(); err != nil {
+		return err
+	}
+
+	if err := uc.domains.SetMaskingRules(ctx, domain_id, rules); err != nil {
+		return err
+	}
+
+	uc.invalidator.Invalidate(domain_id)
+
+	return nil
+}
@@ -0,0 +1,45 @@
+package metrika
+
+import (
+	"context"
+	domain "metrika/internal/domain/analytics"
+	"metrika/pkg/pointers"
+	"time"
+)
+
+// окно отчета по умолчанию, если даты не переданы
+const defaultReportPeriod = 30 * 24 * time.Hour
+
+type PagesReportUseCase struct {
+	events domain.EventsRepository
+	dailyRollups
+}
+
+func NewPagesReportUseCase(events domain.EventsRepository, domains domain.DomainRepository, rollups domain.RollupRepository) *PagesReportUseCase {
+	return &PagesReportUseCase{events, dailyRollups{domains, rollups}}
+}
+
+func (uc *PagesReportUseCase) Execute(ctx context.Context, opts domain.FindPagesOptions) ([]domain.PageStats, int64, error) {
+	start, end, until, err := uc.period(ctx, opts.DomainID, opts.StartDate, opts.EndDate)
+	if err != nil {
+		return nil, 0, err
+	}
+	opts.StartDate, opts.EndDate, opts.RollupUntil = &start, &end, until
+
+	//max 100
+	if opts.Limit == nil || *opts.Limit > 100 || *opts.Limit <= 0 {
+		opts.Limit = pointers.NewIntPointer(100)
+	}
+
+	//default order
+	if opts.Order == nil {
+		opts.Order = pointers.NewStringPointer("views")
+	}
+
+	//default order type
+	if opts.OrderType == nil {
+		opts.OrderType = pointers.NewStringPointer("DESC")
+	}
+
+	return uc.events.FindPages(ctx, opts)
+}
@@ -0,0 +1,28 @@
+package metrika
+
+import (
+	"context"
+	domain "metrika/internal/domain/analytics"
+)
+
+type RemoveMemberUseCase struct {
+	members domain.MembersRepository
+}
+
+func NewRemoveMemberUseCase(members domain.MembersRepository) *RemoveMemberUseCase {
+	return &RemoveMemberUseCase{members}
+}
+
+// Execute - исключает юзера из команды домена. владельца исключить нельзя
+func (uc *RemoveMemberUseCase) Execute(ctx context.Context, domain_id uint, user_id uint) error {
+	current, err := uc.members.Role(ctx, domain_id, user_id)
+	if err != nil {
+		return err
+	}
+
+	if current == domain.RoleOwner {
+		return domain.ErrOwnerRoleImmutable
+	}
+
+	return uc.members.Remove(ctx, domain_id, user_id)
+}
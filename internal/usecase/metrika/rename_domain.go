@@ -0,0 +1,22 @@
+package metrika
+
+import (
+	"context"
+	domain "metrika/internal/domain/analytics"
+)
+
+type RenameDomainUseCase struct {
+	domains domain.DomainRepository
+}
+
+func NewRenameDomainUseCase(domains domain.DomainRepository) *RenameDomainUseCase {
+	return &RenameDomainUseCase{domains}
+}
+
+func (uc *RenameDomainUseCase) Execute(ctx context.Context, domain_id uint, name string) (*domain.Domain, error) {
+	if err := uc.domains.Rename(ctx, domain_id, name); err != nil {
+		return nil, err
+	}
+
+	return uc.domains.ByID(ctx, domain_id)
+}
@@ -0,0 +1,133 @@
+package metrika
+
+import (
+	"context"
+	"errors"
+	domain "metrika/internal/domain/analytics"
+)
+
+const (
+	defaultReplayWindow = 500
+	maxReplayWindow     = 5000
+)
+
+type ReplayUseCase struct {
+	records  domain.RecordEventRepository
+	sessions domain.GuestSessionRepository
+}
+
+func NewReplayUseCase(records domain.RecordEventRepository, sessions domain.GuestSessionRepository) *ReplayUseCase {
+	return &ReplayUseCase{records, sessions}
+}
+
+// checkSession - реплей доступен только если сессия принадлежит домену
+func (uc *ReplayUseCase) checkSession(ctx context.Context, domain_id uint, session_id uint) error {
+	ids, err := uc.sessions.FilterByDomain(ctx, domain_id, []uint{session_id})
+	if err != nil {
+		return err
+	}
+	if len(ids) == 0 {
+		return domain.ErrSessionsNotFound
+	}
+	return nil
+}
+
+func (uc *ReplayUseCase) Meta(ctx context.Context, domain_id uint, session_id uint) (*domain.ReplayMeta, error) {
+	if err := uc.checkSession(ctx, domain_id, session_id); err != nil {
+		return nil, err
+	}
+
+	return uc.records.Meta(ctx, session_id)
+}
+
+// startCursor - позиция, с которой плеер сможет отрисовать момент from:
+// ближайший полный снапшот не позже from вместе с предшествующим ему meta-ивентом
+func (uc *ReplayUseCase) startCursor(ctx context.Context, session_id uint, from int64) (domain.ReplayCursor, error) {
+	snapshot, err := uc.records.LastBefore(ctx, session_id, domain.RecordFullSnapshot, from)
+	if err != nil {
+		if errors.Is(err, domain.ErrRecordEventsNotFound) {
+			//снапшота до from нет - отдаем с начала сессии
+			return domain.ReplayCursor{}, nil
+		}
+		return domain.ReplayCursor{}, err
+	}
+
+	cursor := domain.ReplayCursor{Timestamp: snapshot.Timestamp, ID: snapshot.ID}
+
+	meta, err := uc.records.LastBefore(ctx, session_id, domain.RecordMeta, snapshot.Timestamp)
+	if err != nil {
+		if errors.Is(err, domain.ErrRecordEventsNotFound) {
+			return cursor, nil
+		}
+		return domain.ReplayCursor{}, err
+	}
+
+	return domain.ReplayCursor{Timestamp: meta.Timestamp, ID: meta.ID}, nil
+}
+
+// windowOptions - если курсора нет, окно начинается с ближайшего снапшота к from
+func (uc *ReplayUseCase) windowOptions(ctx context.Context, session_id uint, from int64, to int64, cursor *domain.ReplayCursor) (domain.ReplayWindowOptions, error) {
+	opts := domain.ReplayWindowOptions{SessionID: session_id, To: to}
+
+	if cursor != nil {
+		opts.After = *cursor
+		return opts, nil
+	}
+
+	start, err := uc.startCursor(ctx, session_id, from)
+	if err != nil {
+		return opts, err
+	}
+	opts.After = start
+	opts.Inclusive = true
+
+	return opts, nil
+}
+
+func (uc *ReplayUseCase) Window(ctx context.Context, domain_id uint, session_id uint, from int64, to int64, cursor *domain.ReplayCursor, limit int) (*domain.ReplayWindow, error) {
+	if err := uc.checkSession(ctx, domain_id, session_id); err != nil {
+		return nil, err
+	}
+
+	if limit <= 0 {
+		limit = defaultReplayWindow
+	}
+	if limit > maxReplayWindow {
+		limit = maxReplayWindow
+	}
+
+	opts, err := uc.windowOptions(ctx, session_id, from, to, cursor)
+	if err != nil {
+		return nil, err
+	}
+	//берем на один больше, чтобы понять, есть ли следующая страница
+	opts.Limit = limit + 1
+
+	events, err := uc.records.Window(ctx, opts)
+	if err != nil {
+		return nil, err
+	}
+
+	window := domain.ReplayWindow{Events: events}
+	if len(events) > limit {
+		window.Events = events[:limit]
+		last := window.Events[limit-1]
+		window.NextCursor = domain.ReplayCursor{Timestamp: last.Timestamp, ID: last.ID}.String()
+	}
+
+	return &window, nil
+}
+
+// Stream - все ивенты окна по одному, начиная с ближайшего снапшота к from
+func (uc *ReplayUseCase) Stream(ctx context.Context, domain_id uint, session_id uint, from int64, to int64, fn func(domain.RecordEvent) error) error {
+	if err := uc.checkSession(ctx, domain_id, session_id); err != nil {
+		return err
+	}
+
+	opts, err := uc.windowOptions(ctx, session_id, from, to, nil)
+	if err != nil {
+		return err
+	}
+
+	return uc.records.Stream(ctx, opts, fn)
+}
@@ -0,0 +1,28 @@
+package metrika
+
+import (
+	"context"
+	domain "metrika/internal/domain/analytics"
+)
+
+type UpdateRetentionSettingsUseCase struct {
+	domains domain.DomainRepository
+}
+
+func NewUpdateRetentionSettingsUseCase(domains domain.DomainRepository) *UpdateRetentionSettingsUseCase {
+	return &UpdateRetentionSettingsUseCase{domains}
+}
+
+// Execute - сохраняет сроки хранения данных домена. устаревшие данные удалятся
+// при следующем проходе обслуживания партиций
+func (uc *UpdateRetentionSettingsUseCase) Execute(ctx context.Context, domain_id uint, settings domain.RetentionSettings) (*domain.Domain, error) {
+	if err := settings.Validate(); err != nil {
+		return nil, err
+	}
+
+	if err := uc.domains.SetRetentionSettings(ctx, domain_id, settings); err != nil {
+		return nil, err
+	}
+
+	return uc.domains.ByID(ctx, domain_id)
+}
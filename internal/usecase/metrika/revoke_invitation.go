@@ -0,0 +1,18 @@
+package metrika
+
+import (
+	"context"
+	domain "metrika/internal/domain/analytics"
+)
+
+type RevokeInvitationUseCase struct {
+	members domain.MembersRepository
+}
+
+func NewRevokeInvitationUseCase(members domain.MembersRepository) *RevokeInvitationUseCase {
+	return &RevokeInvitationUseCase{members}
+}
+
+func (uc *RevokeInvitationUseCase) Execute(ctx context.Context, domain_id uint, invitation_id uint) error {
+	return uc.members.DeleteInvitation(ctx, domain_id, invitation_id)
+}
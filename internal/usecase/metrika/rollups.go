@@ -0,0 +1,47 @@
+package metrika
+
+import (
+	"context"
+	domain "metrika/internal/domain/analytics"
+	"time"
+)
+
+// dailyRollups - выбор части отчета, которая читается из дневных роллапов домена
+type dailyRollups struct {
+	domains domain.DomainRepository
+	rollups domain.RollupRepository
+}
+
+// period - начало и конец отчета по умолчанию, начало по умолчанию - полночь в часовом поясе домена,
+// иначе первый день отчета не читался бы из роллапов. until nil, если роллапы не подходят
+func (d dailyRollups) period(ctx context.Context, domain_id uint, start_date *time.Time, end_date *time.Time) (start time.Time, end time.Time, until *time.Time, err error) {
+	dom, err := d.domains.ByID(ctx, domain_id)
+	if err != nil {
+		return start, end, nil, err
+	}
+	timezone := dom.SessionSettings.Timezone
+	if timezone == "" {
+		timezone = domain.DefaultSessionSettings().Timezone
+	}
+
+	end = time.Now()
+	if end_date != nil {
+		end = *end_date
+	}
+	loc := domain.RollupLocation(domain.RollupDay, timezone)
+	start = domain.TruncateRollup(end.Add(-defaultReportPeriod), domain.RollupDay, loc)
+	if start_date != nil {
+		start = *start_date
+	}
+
+	state, err := d.rollups.State(ctx, domain_id, domain.RollupDay)
+	if err != nil {
+		return start, end, nil, err
+	}
+
+	if rolled, ok := state.ReadableUntil(start, end, timezone); ok {
+		until = &rolled
+	}
+
+	return start, end, until, nil
+}
@@ -0,0 +1,28 @@
+package metrika
+
+import (
+	"context"
+	domain "metrika/internal/domain/analytics"
+)
+
+type RotateSiteKeyUseCase struct {
+	domains domain.DomainRepository
+}
+
+func NewRotateSiteKeyUseCase(domains domain.DomainRepository) *RotateSiteKeyUseCase {
+	return &RotateSiteKeyUseCase{domains}
+}
+
+// Execute - выпускает новый ключ сайта, старый сразу перестает приниматься
+func (uc *RotateSiteKeyUseCase) Execute(ctx context.Context, domain_id uint) (*domain.Domain, error) {
+	site_key, err := domain.GenerateSiteKey()
+	if err != nil {
+		return nil, err
+	}
+
+	if err := uc.domains.UpdateSiteKey(ctx, domain_id, site_key); err != nil {
+		return nil, err
+	}
+
+	return uc.domains.ByID(ctx, domain_id)
+}
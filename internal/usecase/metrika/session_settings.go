@@ -0,0 +1,28 @@
+package metrika
+
+import (
+	"context"
+	domain "metrika/internal/domain/analytics"
+)
+
+type UpdateSessionSettingsUseCase struct {
+	domains domain.DomainRepository
+}
+
+func NewUpdateSessionSettingsUseCase(domains domain.DomainRepository) *UpdateSessionSettingsUseCase {
+	return &UpdateSessionSettingsUseCase{domains}
+}
+
+// Execute - сохраняет правила нарезки сессий. уже открытые сессии подхватят их
+// при следующем проходе воркера или запросе сессии клиентом
+func (uc *UpdateSessionSettingsUseCase) Execute(ctx context.Context, domain_id uint, settings domain.SessionSettings) (*domain.Domain, error) {
+	if err := settings.Validate(); err != nil {
+		return nil, err
+	}
+
+	if err := uc.domains.SetSessionSettings(ctx, domain_id, settings); err != nil {
+		return nil, err
+	}
+
+	return uc.domains.ByID(ctx, domain_id)
+}
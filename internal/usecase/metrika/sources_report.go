@@ -0,0 +1,45 @@
+package metrika
+
+import (
+	"context"
+	domain "metrika/internal/domain/analytics"
+	"metrika/pkg/pointers"
+)
+
+type SourcesReportUseCase struct {
+	sessions domain.GuestSessionRepository
+	dailyRollups
+}
+
+func NewSourcesReportUseCase(sessions domain.GuestSessionRepository, domains domain.DomainRepository, rollups domain.RollupRepository) *SourcesReportUseCase {
+	return &SourcesReportUseCase{sessions, dailyRollups{domains, rollups}}
+}
+
+func (uc *SourcesReportUseCase) Execute(ctx context.Context, opts domain.FindSourcesOptions) ([]domain.SourceStats, int64, error) {
+	start, end, until, err := uc.period(ctx, opts.DomainID, opts.StartDate, opts.EndDate)
+	if err != nil {
+		return nil, 0, err
+	}
+	opts.StartDate, opts.EndDate, opts.RollupUntil = &start, &end, until
+
+	if opts.GroupBy == "" {
+		opts.GroupBy = domain.SourcesGroupBySource
+	}
+
+	//max 100
+	if opts.Limit == nil || *opts.Limit > 100 || *opts.Limit <= 0 {
+		opts.Limit = pointers.NewIntPointer(100)
+	}
+
+	//default order
+	if opts.Order == nil {
+		opts.Order = pointers.NewStringPointer("visits")
+	}
+
+	//default order type
+	if opts.OrderType == nil {
+		opts.OrderType = pointers.NewStringPointer("DESC")
+	}
+
+	return uc.sessions.FindSources(ctx, opts)
+}
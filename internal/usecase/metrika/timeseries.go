@@ -0,0 +1,152 @@
+package metrika
+
+import (
+	"context"
+	domain "metrika/internal/domain/analytics"
+	"time"
+)
+
+type TimeseriesUseCase struct {
+	timeseries domain.TimeseriesRepository
+	domains    domain.DomainRepository
+	rollups    domain.RollupRepository
+}
+
+func NewTimeseriesUseCase(timeseries domain.TimeseriesRepository, domains domain.DomainRepository, rollups domain.RollupRepository) *TimeseriesUseCase {
+	return &TimeseriesUseCase{timeseries, domains, rollups}
+}
+
+// Execute - ряды метрик домена с заполненными пропусками, интервалы режутся в часовом поясе домена.
+// с opts.Compare рядом отдается предыдущий период такой же длины и изменение итогов
+func (uc *TimeseriesUseCase) Execute(ctx context.Context, opts domain.TimeseriesOptions, start_date *time.Time, end_date *time.Time) (*domain.TimeseriesReport, error) {
+	dom, err := uc.domains.ByID(ctx, opts.DomainID)
+	if err != nil {
+		return nil, err
+	}
+	opts.Timezone = dom.SessionSettings.Timezone
+	if opts.Timezone == "" {
+		opts.Timezone = domain.DefaultSessionSettings().Timezone
+	}
+
+	if len(opts.Metrics) == 0 {
+		opts.Metrics = []string{domain.MetricVisits, domain.MetricUniques}
+	}
+	if opts.Granularity == "" {
+		opts.Granularity = domain.GranularityDay
+	}
+
+	opts.EndDate = time.Now()
+	if end_date != nil {
+		opts.EndDate = *end_date
+	}
+	//период по умолчанию начинается с полуночи, чтобы он читался из роллапов
+	loc := domain.RollupLocation(domain.RollupDay, opts.Timezone)
+	opts.StartDate = domain.TruncateRollup(opts.EndDate.Add(-defaultReportPeriod), domain.RollupDay, loc)
+	if start_date != nil {
+		opts.StartDate = *start_date
+	}
+
+	if err := opts.Validate(); err != nil {
+		return nil, err
+	}
+
+	current, err := uc.period(ctx, opts)
+	if err != nil {
+		return nil, err
+	}
+
+	var previous *domain.TimeseriesPeriod
+	if opts.Compare {
+		prev, err := uc.period(ctx, opts.Previous())
+		if err != nil {
+			return nil, err
+		}
+		previous = &prev
+	}
+
+	report := domain.BuildTimeseriesReport(opts, current, previous)
+	return &report, nil
+}
+
+// period - начало периода до отметки роллапов читается из них, остаток и текущий час - из сырых данных
+func (uc *TimeseriesUseCase) period(ctx context.Context, opts domain.TimeseriesOptions) (domain.TimeseriesPeriod, error) {
+	until, ok, err := uc.rollupUntil(ctx, opts)
+	if err != nil {
+		return domain.TimeseriesPeriod{}, err
+	}
+	if !ok {
+		rows, totals, err := uc.raw(ctx, opts)
+		if err != nil {
+			return domain.TimeseriesPeriod{}, err
+		}
+		return domain.BuildTimeseriesPeriod(opts, rows, totals), nil
+	}
+
+	granularity, _ := opts.RollupGranularity()
+	head := opts
+	head.EndDate = until
+
+	rows, err := uc.timeseries.RollupTimeseries(ctx, head, granularity)
+	if err != nil {
+		return domain.TimeseriesPeriod{}, err
+	}
+	totals, err := uc.timeseries.RollupTotals(ctx, head, granularity)
+	if err != nil {
+		return domain.TimeseriesPeriod{}, err
+	}
+
+	if until.Before(opts.EndDate) {
+		tail := opts
+		tail.StartDate = until
+
+		tail_rows, tail_totals, err := uc.raw(ctx, tail)
+		if err != nil {
+			return domain.TimeseriesPeriod{}, err
+		}
+		rows = domain.MergeTimeseriesRows(rows, tail_rows)
+		totals = totals.Add(tail_totals)
+	}
+
+	//уникальные за весь период не складываются из частей
+	if opts.Has(domain.MetricUniques) {
+		uniques := opts
+		uniques.Metrics = []string{domain.MetricUniques}
+
+		all, err := uc.timeseries.Totals(ctx, uniques)
+		if err != nil {
+			return domain.TimeseriesPeriod{}, err
+		}
+		totals.Uniques = all.Uniques
+	}
+
+	return domain.BuildTimeseriesPeriod(opts, rows, totals), nil
+}
+
+func (uc *TimeseriesUseCase) raw(ctx context.Context, opts domain.TimeseriesOptions) ([]domain.TimeseriesRow, domain.TimeseriesRow, error) {
+	rows, err := uc.timeseries.Timeseries(ctx, opts)
+	if err != nil {
+		return nil, domain.TimeseriesRow{}, err
+	}
+
+	totals, err := uc.timeseries.Totals(ctx, opts)
+	if err != nil {
+		return nil, domain.TimeseriesRow{}, err
+	}
+
+	return rows, totals, nil
+}
+
+func (uc *TimeseriesUseCase) rollupUntil(ctx context.Context, opts domain.TimeseriesOptions) (time.Time, bool, error) {
+	granularity, ok := opts.RollupGranularity()
+	if !ok {
+		return time.Time{}, false, nil
+	}
+
+	state, err := uc.rollups.State(ctx, opts.DomainID, granularity)
+	if err != nil {
+		return time.Time{}, false, err
+	}
+
+	until, ok := state.ReadableUntil(opts.StartDate, opts.EndDate, opts.Timezone)
+	return until, ok, nil
+}
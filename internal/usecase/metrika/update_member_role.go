@@ -0,0 +1,31 @@
+package metrika
+
+import (
+	"context"
+	domain "metrika/internal/domain/analytics"
+)
+
+type UpdateMemberRoleUseCase struct {
+	members domain.MembersRepository
+}
+
+func NewUpdateMemberRoleUseCase(members domain.MembersRepository) *UpdateMemberRoleUseCase {
+	return &UpdateMemberRoleUseCase{members}
+}
+
+func (uc *UpdateMemberRoleUseCase) Execute(ctx context.Context, domain_id uint, user_id uint, role domain.Role) error {
+	if !role.Valid() || role == domain.RoleOwner {
+		return domain.ErrInvalidRole
+	}
+
+	current, err := uc.members.Role(ctx, domain_id, user_id)
+	if err != nil {
+		return err
+	}
+
+	if current == domain.RoleOwner {
+		return domain.ErrOwnerRoleImmutable
+	}
+
+	return uc.members.UpdateRole(ctx, domain_id, user_id, role)
+}
@@ -20,6 +20,7 @@ const (
 	StatusBadFileSize       = "BadFileSize"
 	StatusNotFound          = "NotFound"
 	StatusBadRequest        = "BadRequest"
+	StatusSessionClosed     = "SessionClosed"
 )
 
 func OK() Response {